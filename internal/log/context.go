@@ -0,0 +1,44 @@
+package log
+
+import "context"
+
+// levelKey is the context key of the log level of an operation
+type levelKey struct{}
+
+// ContextWithLevel returns a copy of ctx carrying a log level, used by the loggers returned by
+// FromContext instead of the level of the global logger. It lets a single request or workflow
+// execution log more or less than the rest of the process.
+func ContextWithLevel(ctx context.Context, level LogLevel) context.Context {
+	return context.WithValue(ctx, levelKey{}, level)
+}
+
+// LevelFromContext returns the log level carried by ctx, if any
+func LevelFromContext(ctx context.Context) (LogLevel, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	level, ok := ctx.Value(levelKey{}).(LogLevel)
+	return level, ok
+}
+
+// FromContext returns the logger of an operation bound to ctx: the global logger, logging at
+// the level carried by ctx when it is a DefaultLogger. Custom loggers are returned as they are.
+func FromContext(ctx context.Context) Logger {
+	level, ok := LevelFromContext(ctx)
+	if !ok {
+		return GlobalLogger
+	}
+	return AtLevel(level)
+}
+
+// AtLevel returns the global logger logging at level when it is a DefaultLogger, without
+// changing the level of the global logger. Custom loggers are returned as they are.
+func AtLevel(level LogLevel) Logger {
+	l, ok := GlobalLogger.(*DefaultLogger)
+	if !ok {
+		return GlobalLogger
+	}
+	leveled := *l
+	leveled.level = level
+	return &leveled
+}
@@ -0,0 +1,124 @@
+package modularapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rrodriguez06/modular_api/internal/log"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/client"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/config"
+)
+
+// TokenRefreshFunc returns a new bearer token for a service whose token was rejected with a 401
+type TokenRefreshFunc func(serviceName string) (string, error)
+
+// tokenRefresher serializes the refreshes of a service token
+type tokenRefresher struct {
+	refresh TokenRefreshFunc
+	mu      sync.Mutex
+}
+
+// SetTokenRefresh sets the function called to get a new token when a request to the service is
+// rejected with a 401. The request is then retried once with the new token.
+func (s *ModularAPIService) SetTokenRefresh(serviceName string, refresh TokenRefreshFunc) {
+	if refresh == nil {
+		delete(s.tokenRefreshers, serviceName)
+		return
+	}
+	s.tokenRefreshers[serviceName] = &tokenRefresher{refresh: refresh}
+}
+
+// SetServiceToken replaces the token of a service in the active environment.
+// It is safe to call while requests are in flight.
+func (s *ModularAPIService) SetServiceToken(serviceName, token string) {
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	s.tokens[s.tokenKey(serviceName)] = token
+}
+
+// tokenKey identifies the token of a service in the active environment
+func (s *ModularAPIService) tokenKey(serviceName string) string {
+	return s.config.ActiveEnvironment + "/" + serviceName
+}
+
+// serviceToken returns the token to send to a service, preferring a token set with SetServiceToken
+func (s *ModularAPIService) serviceToken(serviceName string, cfg config.ApiConfig) string {
+	s.tokenMu.RLock()
+	defer s.tokenMu.RUnlock()
+	if token, ok := s.tokens[s.tokenKey(serviceName)]; ok {
+		return token
+	}
+	return cfg.ApiToken
+}
+
+// refreshToken gets a new token for the service, unless a concurrent request already replaced
+// staleToken, so a burst of 401s triggers a single refresh
+func (s *ModularAPIService) refreshToken(serviceName, staleToken string) (string, error) {
+	refresher := s.tokenRefreshers[serviceName]
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+
+	cfg, _ := s.config.GetServiceConfig(serviceName)
+	if current := s.serviceToken(serviceName, cfg); current != staleToken {
+		return current, nil
+	}
+
+	token, err := refresher.refresh(serviceName)
+	if err != nil {
+		return "", err
+	}
+	s.SetServiceToken(serviceName, token)
+	return token, nil
+}
+
+// retryWithRefreshedToken retries a request rejected with a 401 once with a refreshed token,
+// returning the retried request and its response. It returns req and requestErr unchanged when
+// the service has no refresh function or the request can't be sent again.
+func (s *ModularAPIService) retryWithRefreshedToken(serviceName string, req *http.Request, result interface{}, requestErr error) (*http.Request, *http.Response, error) {
+	retryReq, err := s.refreshedTokenRequest(serviceName, req, requestErr)
+	if retryReq == nil {
+		return req, nil, err
+	}
+	httpClient, _ := s.clientsFor(serviceName)
+	resp, err := httpClient.MakeRequestWithResponse(retryReq, result)
+	return retryReq, resp, err
+}
+
+// refreshedTokenRequest returns a copy of a request rejected with a 401, carrying a refreshed
+// token. When the request can't be retried, it returns nil and requestErr, or the refresh error.
+func (s *ModularAPIService) refreshedTokenRequest(serviceName string, req *http.Request, requestErr error) (*http.Request, error) {
+	var apiErr *client.APIError
+	if !errors.As(requestErr, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return nil, requestErr
+	}
+	if _, ok := s.tokenRefreshers[serviceName]; !ok {
+		return nil, requestErr
+	}
+
+	// A body that can't be rewound can't be sent again
+	retryReq := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			log.FromContext(req.Context()).Warnf("Not retrying request to %s after a 401: its body can't be sent again", serviceName)
+			return nil, requestErr
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, requestErr
+		}
+		retryReq.Body = body
+	}
+
+	staleToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	token, err := s.refreshToken(serviceName, staleToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token for service %s: %w", serviceName, err)
+	}
+
+	log.FromContext(req.Context()).Infof("Retrying request to %s with a refreshed token", serviceName)
+	retryReq.Header.Set("Authorization", "Bearer "+token)
+	return retryReq, nil
+}
@@ -0,0 +1,189 @@
+package modularapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/client"
+)
+
+// ErrOperationFailed is returned by PerformRequestAwait when the status of the operation
+// reports a failure
+var ErrOperationFailed = errors.New("asynchronous operation failed")
+
+// Defaults of a PollSpec
+const (
+	DefaultPollInterval = time.Second
+	DefaultPollTimeout  = 5 * time.Minute
+)
+
+// PollSpec configures how PerformRequestAwait follows an operation accepted with a 202
+type PollSpec struct {
+	LocationHeader string        // Header of the 202 response holding the status URL, "Location" by default
+	LocationField  string        // Field of the 202 body holding the status URL, such as "links.status", instead of the header
+	Interval       time.Duration // Wait between polls, DefaultPollInterval if zero
+	Timeout        time.Duration // Maximum wait for the operation to complete, DefaultPollTimeout if zero
+
+	// StatusField is the field of the status body reporting the state of the operation. When
+	// empty, the operation is complete as soon as the status URL answers with another status than 202.
+	StatusField    string
+	CompleteValues []string // Values of StatusField meaning the operation is complete
+	FailedValues   []string // Values of StatusField meaning the operation failed
+}
+
+// PerformRequestAwait performs a request and, when it is answered with 202 Accepted, polls the
+// status URL of the operation until it completes, then decodes the last status response into
+// the result. The request is sent like with PerformRequest, refreshing an expired token and
+// failing over to the fallbacks of the service. Polls are GET requests sent with the headers of
+// the request that was answered, its authentication included. Any other response is decoded into
+// the result right away.
+func (s *ModularAPIService) PerformRequestAwait(serviceName, action string, params map[string]interface{}, result interface{}, poll PollSpec, opts ...RequestOption) error {
+	cfg := &requestConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	timeout := poll.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPollTimeout
+	}
+	// The request and its polls log at the level of the request, if any
+	ctx, cancel := context.WithTimeout(s.withRequestLogLevel(context.Background(), serviceName, action, cfg), timeout)
+	defer cancel()
+
+	// The request is sent like any other, then followed when it is accepted. Polls go to the
+	// endpoint that answered, with its headers, a refreshed token included.
+	var body json.RawMessage
+	req, resp, err := s.sendRequest(ctx, serviceName, action, params, &body, cfg)
+	if err != nil {
+		return err
+	}
+	// Polls are signed and their responses handled like the request
+	ctx = req.Context()
+	httpClient, _ := s.clientsFor(serviceName)
+	if resp.StatusCode != http.StatusAccepted {
+		return decodeRaw(httpClient, body, result)
+	}
+
+	location, err := poll.location(resp, body)
+	if err != nil {
+		return err
+	}
+	statusURL, err := req.URL.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid status URL %q: %w", location, err)
+	}
+
+	interval := poll.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("operation of %s.%s did not complete within %v: %w", serviceName, action, timeout, ctx.Err())
+		case <-timer.C:
+		}
+
+		pollReq, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL.String(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to prepare status request: %w", err)
+		}
+		pollReq.Header = req.Header.Clone()
+		pollReq.Header.Del("Content-Type")
+
+		body = nil
+		resp, err := httpClient.MakeRequestWithResponse(pollReq, &body)
+		if err != nil {
+			return fmt.Errorf("failed to poll operation status: %w", err)
+		}
+		if resp.StatusCode == http.StatusAccepted {
+			continue
+		}
+
+		complete, err := poll.complete(body)
+		if err != nil {
+			return err
+		}
+		if complete {
+			return decodeRaw(httpClient, body, result)
+		}
+	}
+}
+
+// location returns the status URL of an accepted operation
+func (p PollSpec) location(resp *http.Response, body json.RawMessage) (string, error) {
+	if p.LocationField != "" {
+		value, ok := lookupField(body, p.LocationField)
+		if location, isString := value.(string); ok && isString && location != "" {
+			return location, nil
+		}
+		return "", fmt.Errorf("accepted response has no status URL in field %s", p.LocationField)
+	}
+
+	header := p.LocationHeader
+	if header == "" {
+		header = "Location"
+	}
+	if location := resp.Header.Get(header); location != "" {
+		return location, nil
+	}
+	return "", fmt.Errorf("accepted response has no status URL in header %s", header)
+}
+
+// complete reports whether a status body reports a completed operation
+func (p PollSpec) complete(body json.RawMessage) (bool, error) {
+	if p.StatusField == "" {
+		return true, nil
+	}
+
+	value, _ := lookupField(body, p.StatusField)
+	status := fmt.Sprint(value)
+	for _, failed := range p.FailedValues {
+		if status == failed {
+			return false, fmt.Errorf("%w: status %s", ErrOperationFailed, status)
+		}
+	}
+	for _, completed := range p.CompleteValues {
+		if status == completed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookupField returns the value of a dot-separated field of a JSON object
+func lookupField(body json.RawMessage, path string) (interface{}, bool) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, false
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// decodeRaw decodes a response body into the result, if any, the way httpClient does
+func decodeRaw(httpClient *client.Client, body json.RawMessage, result interface{}) error {
+	if result == nil || len(body) == 0 {
+		return nil
+	}
+	if err := httpClient.Decode(body, result); err != nil {
+		return &client.DecodeError{Body: body, Err: err}
+	}
+	return nil
+}
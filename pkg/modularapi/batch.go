@@ -0,0 +1,54 @@
+package modularapi
+
+import "sync"
+
+// BatchRequest describes one request of a batch
+type BatchRequest struct {
+	ServiceName string
+	Action      string
+	Params      map[string]interface{}
+}
+
+// BatchResult holds the outcome of one request of a batch
+type BatchResult struct {
+	Request BatchRequest
+	Result  interface{} // Decoded JSON response, nil if the request failed
+	Err     error
+}
+
+// PerformBatch performs independent requests concurrently and returns their results in the
+// order of reqs. At most concurrency requests run at the same time, 0 meaning no limit.
+// A failed request doesn't stop the others: its error is reported in its BatchResult.
+func (s *ModularAPIService) PerformBatch(reqs []BatchRequest, concurrency int) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+
+	// A nil semaphore means no concurrency limit
+	var sem chan struct{}
+	if concurrency > 0 && concurrency < len(reqs) {
+		sem = make(chan struct{}, concurrency)
+	}
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(i int, req BatchRequest) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+
+			var result interface{}
+			err := s.PerformRequest(req.ServiceName, req.Action, req.Params, &result)
+			if err != nil {
+				result = nil
+			}
+			results[i] = BatchResult{Request: req, Result: result, Err: err}
+		}(i, req)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// RecordedRequest is the request half of a recorded interaction
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is the response half of a recorded interaction
+type RecordedResponse struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+}
+
+// Interaction is a single request/response pair stored in a cassette file
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// key returns the identifier used to match a request against recorded interactions
+func (r RecordedRequest) key() string {
+	return r.Method + " " + r.URL + "\n" + r.Body
+}
+
+// readRequestBody reads the request body without consuming it: through GetBody when the request
+// has it, otherwise from a clone of the request holding a copy of the body, which is returned to
+// be sent in place of req. The request itself is never modified.
+func readRequestBody(req *http.Request) (string, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return "", req, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		defer body.Close()
+		bodyBytes, err := io.ReadAll(body)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		return string(bodyBytes), req, nil
+	}
+
+	bodyBytes, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+	}
+	return string(bodyBytes), clone, nil
+}
+
+// RecordingTransport is an http.RoundTripper that forwards requests to an underlying
+// transport and records every request/response pair to a cassette file
+type RecordingTransport struct {
+	transport    http.RoundTripper
+	cassettePath string
+	interactions []Interaction
+	mu           sync.Mutex
+}
+
+// NewRecordingTransport creates a transport recording to the given cassette file.
+// If transport is nil, http.DefaultTransport is used to perform the real requests.
+func NewRecordingTransport(cassettePath string, transport http.RoundTripper) *RecordingTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &RecordingTransport{
+		transport:    transport,
+		cassettePath: cassettePath,
+	}
+}
+
+// RoundTrip implements http.RoundTripper. The response body is recorded as it is read, so
+// streamed responses such as server-sent events are passed on without waiting for their end, and
+// the interaction is saved once the body is closed.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, sent, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := &recordingBody{body: resp.Body}
+	recorded.reader = io.TeeReader(resp.Body, &recorded.buf)
+	recorded.onClose = func(respBody []byte) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.interactions = append(t.interactions, Interaction{
+			Request: RecordedRequest{
+				Method: req.Method,
+				URL:    req.URL.String(),
+				Body:   reqBody,
+			},
+			Response: RecordedResponse{
+				StatusCode: resp.StatusCode,
+				Headers:    resp.Header,
+				Body:       string(respBody),
+			},
+		})
+
+		// Rewrite the cassette after every interaction so it is complete even if the process exits
+		return t.save()
+	}
+	resp.Body = recorded
+	return resp, nil
+}
+
+// recordingBody is a response body copying what is read from it, until it is closed
+type recordingBody struct {
+	body    io.ReadCloser
+	reader  io.Reader // Tees body into buf
+	buf     bytes.Buffer
+	onClose func(body []byte) error
+	once    sync.Once
+}
+
+// Read implements io.Reader
+func (b *recordingBody) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+// Close closes the body and records the part of it that was read
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		if saveErr := b.onClose(b.buf.Bytes()); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	})
+	return err
+}
+
+// Interactions returns a copy of the interactions recorded so far
+func (t *RecordingTransport) Interactions() []Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]Interaction, len(t.interactions))
+	copy(result, t.interactions)
+	return result
+}
+
+// save writes the recorded interactions to the cassette file
+func (t *RecordingTransport) save() error {
+	data, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.WriteFile(t.cassettePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette file: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayTransport is an http.RoundTripper that serves responses from a cassette file.
+// Requests are matched by method, URL and body. When the same request was recorded several
+// times, the recorded responses are served in order and the last one is repeated afterwards.
+type ReplayTransport struct {
+	interactions map[string][]RecordedResponse
+	served       map[string]int
+	mu           sync.Mutex
+}
+
+// NewReplayTransport creates a transport replaying the interactions stored in a cassette file
+func NewReplayTransport(cassettePath string) (*ReplayTransport, error) {
+	data, err := os.ReadFile(cassettePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette file: %w", err)
+	}
+
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cassette: %w", err)
+	}
+
+	return NewReplayTransportFromInteractions(interactions), nil
+}
+
+// NewReplayTransportFromInteractions creates a transport replaying the given interactions
+func NewReplayTransportFromInteractions(interactions []Interaction) *ReplayTransport {
+	t := &ReplayTransport{
+		interactions: make(map[string][]RecordedResponse),
+		served:       make(map[string]int),
+	}
+	for _, interaction := range interactions {
+		key := interaction.Request.key()
+		t.interactions[key] = append(t.interactions[key], interaction.Response)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, _, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	key := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Body:   reqBody,
+	}.key()
+
+	t.mu.Lock()
+	responses, ok := t.interactions[key]
+	if !ok || len(responses) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL.String())
+	}
+	index := t.served[key]
+	if index >= len(responses) {
+		index = len(responses) - 1
+	}
+	t.served[key]++
+	t.mu.Unlock()
+
+	recorded := responses[index]
+	header := make(http.Header)
+	for k, v := range recorded.Headers {
+		header[k] = append([]string(nil), v...)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recorded.Body))),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
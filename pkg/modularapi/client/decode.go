@@ -0,0 +1,21 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// SetUseNumber makes the client decode JSON numbers into json.Number instead of float64, so
+// large integers such as 64-bit IDs keep their precision when decoded into interface{} values
+func (c *Client) SetUseNumber(useNumber bool) {
+	c.useNumber = useNumber
+}
+
+// Decode decodes a JSON body into the result the way the client decodes responses
+func (c *Client) Decode(body []byte, result interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if c.useNumber {
+		decoder.UseNumber()
+	}
+	return decoder.Decode(result)
+}
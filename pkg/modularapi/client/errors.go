@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// APIError is returned by MakeRequest when the API responds with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API call error: %s, status code: %d", string(e.Body), e.StatusCode)
+}
+
+// HTTPStatusCode returns the status code of the response, letting callers that don't import
+// this package (such as the workflow executor) inspect it through an interface
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// decodeSnippetLength is the number of bytes of the body shown in the message of a DecodeError
+const decodeSnippetLength = 256
+
+// DecodeError is returned by MakeRequest when a successful response can't be decoded into the
+// result. Its message shows the start of the body, and Body holds all of it.
+type DecodeError struct {
+	Body []byte // Body that failed to decode, after preprocessing
+	Err  error  // Error of the JSON decoder
+}
+
+// Error implements the error interface
+func (e *DecodeError) Error() string {
+	snippet := e.Body
+	suffix := ""
+	if len(snippet) > decodeSnippetLength {
+		snippet = snippet[:decodeSnippetLength]
+		suffix = fmt.Sprintf("... (%d bytes)", len(e.Body))
+	}
+	return fmt.Sprintf("cannot decode response: %v, body: %q%s", e.Err, bytes.ToValidUTF8(snippet, nil), suffix)
+}
+
+// Unwrap returns the error of the JSON decoder
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
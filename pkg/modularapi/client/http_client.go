@@ -2,7 +2,6 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,6 +19,16 @@ type HTTPClient interface {
 type Client struct {
 	httpClient HTTPClient
 	timeout    time.Duration
+	transport  http.RoundTripper
+	jar        http.CookieJar
+	// retryPolicy retries failed requests, nil meaning no retries
+	retryPolicy *RetryPolicy
+	// preprocessor transforms response bodies before decoding, nil meaning none
+	preprocessor ResponsePreprocessor
+	// successStatuses are the status codes of successful responses, DefaultSuccessStatuses if empty
+	successStatuses []StatusRange
+	// useNumber decodes JSON numbers into json.Number instead of float64
+	useNumber bool
 }
 
 // NewClient creates a new HTTP client with the specified timeout
@@ -35,69 +44,114 @@ func NewClient(timeout time.Duration) *Client {
 // SetTimeout sets the client timeout
 func (c *Client) SetTimeout(timeout time.Duration) {
 	c.timeout = timeout
+	c.rebuild()
+}
+
+// SetTransport sets the transport used to perform requests (nil restores the default transport)
+func (c *Client) SetTransport(transport http.RoundTripper) {
+	c.transport = transport
+	c.rebuild()
+}
+
+// SetCookieJar sets the jar storing the cookies of responses and sending them with later
+// requests to matching hosts (nil disables cookie handling)
+func (c *Client) SetCookieJar(jar http.CookieJar) {
+	c.jar = jar
+	c.rebuild()
+}
+
+// rebuild recreates the underlying http.Client from the current settings
+func (c *Client) rebuild() {
 	c.httpClient = &http.Client{
-		Timeout: timeout,
+		Timeout:   c.timeout,
+		Transport: c.transport,
+		Jar:       c.jar,
 	}
 }
 
 // MakeRequest performs an HTTP request and unmarshals the response into the result
 func (c *Client) MakeRequest(req *http.Request, result interface{}) error {
-	// Log request details for debugging purposes
-	if req.Body != nil {
-		// Read the request body
-		bodyBytes, err := io.ReadAll(req.Body)
+	_, err := c.MakeRequestWithResponse(req, result)
+	return err
+}
+
+// MakeRequestWithResponse is MakeRequest also returning the response of a successful request,
+// to read its status and headers. Its body has already been consumed.
+func (c *Client) MakeRequestWithResponse(req *http.Request, result interface{}) (*http.Response, error) {
+	// Log at the level carried by the context of the request, if any
+	logger := log.FromContext(req.Context())
+
+	// Log request details for debugging purposes. Only bodies of a known size that can be
+	// re-read through GetBody are logged, so streamed bodies are never consumed early.
+	if req.Body != nil && req.GetBody != nil && req.ContentLength > 0 {
+		bodyCopy, err := req.GetBody()
 		if err != nil {
-			log.GlobalLogger.Errorf("Error reading request body: %v", err)
-			return fmt.Errorf("error reading request body: %w", err)
+			logger.Errorf("Error reading request body: %v", err)
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		bodyBytes, err := io.ReadAll(bodyCopy)
+		bodyCopy.Close()
+		if err != nil {
+			logger.Errorf("Error reading request body: %v", err)
+			return nil, fmt.Errorf("error reading request body: %w", err)
 		}
-
-		// Restore the body for the actual request
-		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 		// Log the request
-		log.GlobalLogger.Infof("API Request to %s: %s\nHeaders: %v\nBody: %s",
+		logger.Infof("API Request to %s: %s\nHeaders: %v\nBody: %s",
 			req.URL.String(), req.Method, req.Header, string(bodyBytes))
+	} else if req.Body != nil {
+		logger.Infof("API Request to %s: %s\nHeaders: %v\nStreamed Body",
+			req.URL.String(), req.Method, req.Header)
 	} else {
-		log.GlobalLogger.Infof("API Request to %s: %s\nHeaders: %v\nNo Body",
+		logger.Infof("API Request to %s: %s\nHeaders: %v\nNo Body",
 			req.URL.String(), req.Method, req.Header)
 	}
 
 	// Make the actual request
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("cannot perform request: %w", err)
+		return nil, fmt.Errorf("cannot perform request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	log.GlobalLogger.Infof("API Response Status: %d %s", resp.StatusCode, resp.Status)
-	log.GlobalLogger.Infof("API Response Headers: %v", resp.Header)
+	logger.Infof("API Response Status: %d %s", resp.StatusCode, resp.Status)
+	logger.Infof("API Response Headers: %v", resp.Header)
 
 	// Read the response body
 	respBodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("cannot read response body: %w", err)
+		return nil, fmt.Errorf("cannot read response body: %w", err)
 	}
 	// Put the body back
 	resp.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes))
 
 	// Log response body for all responses to help with debugging
-	log.GlobalLogger.Infof("API Response Body (raw): %s", string(respBodyBytes))
+	logger.Infof("API Response Body (raw): %s", string(respBodyBytes))
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.GlobalLogger.Errorf("API call error: %s", string(respBodyBytes))
-		return fmt.Errorf("API call error: %s, status code: %d", string(respBodyBytes), resp.StatusCode)
+	if !isSuccess(req, resp.StatusCode, c.successStatuses) {
+		logger.Errorf("API call error: %s", string(respBodyBytes))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: respBodyBytes}
+	}
+
+	if preprocessor := c.responsePreprocessor(req); preprocessor != nil && result != nil {
+		respBodyBytes, err = preprocessor(respBodyBytes)
+		if err != nil {
+			logger.Errorf("Cannot preprocess response: %v", err)
+			return nil, fmt.Errorf("cannot preprocess response: %w", err)
+		}
+		logger.Debugf("API Response Body (preprocessed): %s", string(respBodyBytes))
 	}
 
 	if result != nil && len(respBodyBytes) > 0 {
-		// Put the body back again for decoding
+		// Put the body back again for the caller
 		resp.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes))
 
-		err = json.NewDecoder(resp.Body).Decode(result)
+		err = c.Decode(respBodyBytes, result)
 		if err != nil {
-			log.GlobalLogger.Errorf("Cannot decode response: %v", err)
-			return fmt.Errorf("cannot decode response: %w", err)
+			logger.Errorf("Cannot decode response: %v", err)
+			return nil, &DecodeError{Body: respBodyBytes, Err: err}
 		}
 	}
 
-	return nil
+	return resp, nil
 }
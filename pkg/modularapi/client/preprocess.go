@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ResponsePreprocessor transforms the body of a successful response before it is decoded,
+// for example to strip a wrapper making it invalid JSON
+type ResponsePreprocessor func(body []byte) ([]byte, error)
+
+// preprocessorKey is the context key of the preprocessor of a request
+type preprocessorKey struct{}
+
+// WithResponsePreprocessor returns a copy of ctx whose requests have their response body
+// preprocessed by MakeRequest, instead of by the preprocessor of the client
+func WithResponsePreprocessor(ctx context.Context, preprocessor ResponsePreprocessor) context.Context {
+	return context.WithValue(ctx, preprocessorKey{}, preprocessor)
+}
+
+// SetResponsePreprocessor sets the preprocessor applied to response bodies before they are
+// decoded (nil disables preprocessing)
+func (c *Client) SetResponsePreprocessor(preprocessor ResponsePreprocessor) {
+	c.preprocessor = preprocessor
+}
+
+// responsePreprocessor returns the preprocessor of a request, preferring the one of its context
+func (c *Client) responsePreprocessor(req *http.Request) ResponsePreprocessor {
+	if preprocessor, ok := req.Context().Value(preprocessorKey{}).(ResponsePreprocessor); ok && preprocessor != nil {
+		return preprocessor
+	}
+	return c.preprocessor
+}
+
+// StripXSSIPrefix removes the first line of a body starting with an XSSI guard such as )]}'
+func StripXSSIPrefix(body []byte) ([]byte, error) {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte(")]}")) {
+		return body, nil
+	}
+	if i := bytes.IndexByte(trimmed, '\n'); i >= 0 {
+		return trimmed[i+1:], nil
+	}
+	return nil, nil
+}
+
+// StripJSONP removes the callback wrapping a JSONP body, such as callback({...});
+func StripJSONP(body []byte) ([]byte, error) {
+	trimmed := bytes.TrimRight(bytes.TrimSpace(body), ";")
+	open := bytes.IndexByte(trimmed, '(')
+	if open < 0 || !bytes.HasSuffix(trimmed, []byte(")")) {
+		return nil, fmt.Errorf("body is not a JSONP callback")
+	}
+	return bytes.TrimSpace(trimmed[open+1 : len(trimmed)-1]), nil
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rrodriguez06/modular_api/internal/log"
+)
+
+// BackoffFunc returns how long to wait before a retry. attempt is 1 for the first retry, and
+// resp is the response that triggered it, or nil when the request failed without a response.
+type BackoffFunc func(attempt int, resp *http.Response) time.Duration
+
+// DefaultRetryStatusCodes are the status codes retried when a RetryPolicy doesn't set any
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures the retries of failed requests. Requests failing without a response
+// or with one of the retried status codes are sent again up to MaxRetries times.
+// When the response has a Retry-After header, in seconds or as an HTTP date, the retry waits
+// exactly that long instead of the Backoff duration.
+type RetryPolicy struct {
+	MaxRetries  int         // Maximum number of retries after the first attempt
+	StatusCodes []int       // Status codes to retry, DefaultRetryStatusCodes if empty
+	Backoff     BackoffFunc // Wait before each retry, DefaultBackoff if nil
+}
+
+// DefaultBackoff is an exponential backoff starting at 200ms and capped at 30s
+var DefaultBackoff = ExponentialBackoff(200*time.Millisecond, 30*time.Second)
+
+// ExponentialBackoff waits base, then doubles the wait for each retry up to max
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int, _ *http.Response) time.Duration {
+		return exponentialDelay(base, max, attempt)
+	}
+}
+
+// JitteredBackoff is an exponential backoff randomized between half and all of the computed
+// wait, so parallel requests failing together don't retry at the same time
+func JitteredBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int, _ *http.Response) time.Duration {
+		delay := exponentialDelay(base, max, attempt)
+		half := delay / 2
+		return half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+}
+
+// exponentialDelay returns base * 2^(attempt-1), capped at max
+func exponentialDelay(base, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// SetRetryPolicy sets the retries of failed requests (nil disables retries)
+func (c *Client) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// do performs a request, retrying it according to the retry policy. Requests whose body
+// can't be rewound through GetBody are not retried. The request is signed before each attempt.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	policy := c.retryPolicy
+	for attempt := 1; ; attempt++ {
+		// Each attempt is signed again, so its signature is fresh
+		if err := signRequest(req); err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if policy == nil || attempt > policy.MaxRetries || !policy.shouldRetry(resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			log.FromContext(req.Context()).Warnf("Not retrying request to %s: its body can't be sent again", req.URL.Redacted())
+			return resp, err
+		}
+
+		delay, ok := retryAfter(resp)
+		if !ok {
+			backoff := policy.Backoff
+			if backoff == nil {
+				backoff = DefaultBackoff
+			}
+			delay = backoff(attempt, resp)
+		}
+		if resp != nil {
+			log.FromContext(req.Context()).Warnf("Request to %s failed with status %d, retry %d/%d in %v",
+				req.URL.Redacted(), resp.StatusCode, attempt, policy.MaxRetries, delay)
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.FromContext(req.Context()).Warnf("Request to %s failed: %v, retry %d/%d in %v",
+				req.URL.Redacted(), err, attempt, policy.MaxRetries, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// shouldRetry reports whether a request failing with this response or error is retried
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// A canceled request is not retried
+		return resp == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	statusCodes := p.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = DefaultRetryStatusCodes
+	}
+	for _, code := range statusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the Retry-After header of a response
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// StatusRange is an inclusive range of status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// DefaultSuccessStatuses are the status codes of successful responses when none are configured
+var DefaultSuccessStatuses = []StatusRange{{Min: 200, Max: 299}}
+
+// successStatusesKey is the context key of the success statuses of a request
+type successStatusesKey struct{}
+
+// ParseStatusRanges parses status codes written as a single code ("201"), a range
+// ("200-299") or a class ("3xx")
+func ParseStatusRanges(specs ...string) ([]StatusRange, error) {
+	ranges := make([]StatusRange, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		var r StatusRange
+		var err error
+		switch {
+		case len(spec) == 3 && strings.HasSuffix(strings.ToLower(spec), "xx"):
+			var class int
+			class, err = strconv.Atoi(spec[:1])
+			r = StatusRange{Min: class * 100, Max: class*100 + 99}
+		case strings.Contains(spec, "-"):
+			min, max, _ := strings.Cut(spec, "-")
+			if r.Min, err = strconv.Atoi(strings.TrimSpace(min)); err == nil {
+				r.Max, err = strconv.Atoi(strings.TrimSpace(max))
+			}
+		default:
+			r.Min, err = strconv.Atoi(spec)
+			r.Max = r.Min
+		}
+		if err != nil || r.Min < 100 || r.Max > 599 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid status code range: %q", spec)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// WithSuccessStatuses returns a copy of ctx whose requests succeed when their response status
+// is in one of the ranges, instead of in the success statuses of the client
+func WithSuccessStatuses(ctx context.Context, ranges []StatusRange) context.Context {
+	return context.WithValue(ctx, successStatusesKey{}, ranges)
+}
+
+// SetSuccessStatuses sets the status codes of successful responses, responses with other
+// status codes failing with an APIError (empty restores DefaultSuccessStatuses)
+func (c *Client) SetSuccessStatuses(ranges []StatusRange) {
+	c.successStatuses = ranges
+}
+
+// isSuccess reports whether a response status is a success for a request, according to the
+// ranges of its context, then to fallback, then to DefaultSuccessStatuses
+func isSuccess(req *http.Request, statusCode int, fallback []StatusRange) bool {
+	ranges, _ := req.Context().Value(successStatusesKey{}).([]StatusRange)
+	if len(ranges) == 0 {
+		ranges = fallback
+	}
+	if len(ranges) == 0 {
+		ranges = DefaultSuccessStatuses
+	}
+	for _, r := range ranges {
+		if statusCode >= r.Min && statusCode <= r.Max {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,9 @@ import (
 // StreamingClient handles streaming HTTP requests
 type StreamingClient struct {
 	httpClient HTTPClient
+	transport  http.RoundTripper
+	jar        http.CookieJar
+	shared     *Client // Client whose transport and cookie jar are used, if any
 }
 
 // NewStreamingClient creates a new streaming client
@@ -23,22 +27,181 @@ func NewStreamingClient() *StreamingClient {
 	}
 }
 
-// MakeStreamingRequest performs a streaming HTTP request
+// NewSharedStreamingClient creates a streaming client using the transport and cookie jar of
+// a regular client, including the ones set on it later, so streaming requests go through the
+// same proxy, TLS settings and connection pool
+func NewSharedStreamingClient(shared *Client) *StreamingClient {
+	return &StreamingClient{shared: shared}
+}
+
+// SetTransport sets the transport used to perform streaming requests (nil restores the default
+// transport). A shared client stops following the settings of the regular client.
+func (c *StreamingClient) SetTransport(transport http.RoundTripper) {
+	c.detach()
+	c.transport = transport
+	c.rebuild()
+}
+
+// SetCookieJar sets the jar storing and sending cookies for streaming requests (nil disables
+// cookie handling). A shared client stops following the settings of the regular client.
+func (c *StreamingClient) SetCookieJar(jar http.CookieJar) {
+	c.detach()
+	c.jar = jar
+	c.rebuild()
+}
+
+// detach copies the settings of the shared client so they can be changed independently
+func (c *StreamingClient) detach() {
+	if c.shared != nil {
+		c.transport = c.shared.transport
+		c.jar = c.shared.jar
+		c.shared = nil
+	}
+}
+
+// client returns the client performing streaming requests
+func (c *StreamingClient) client() HTTPClient {
+	if c.shared != nil {
+		return &http.Client{
+			Timeout:   0, // No timeout for streaming
+			Transport: c.shared.transport,
+			Jar:       c.shared.jar,
+		}
+	}
+	return c.httpClient
+}
+
+// successStatuses returns the success statuses of the shared client, if any
+func (c *StreamingClient) successStatuses() []StatusRange {
+	if c.shared != nil {
+		return c.shared.successStatuses
+	}
+	return nil
+}
+
+// rebuild recreates the underlying http.Client from the current settings
+func (c *StreamingClient) rebuild() {
+	c.httpClient = &http.Client{
+		Timeout:   0, // No timeout for streaming
+		Transport: c.transport,
+		Jar:       c.jar,
+	}
+}
+
+// MakeStreamingRequest performs a streaming HTTP request, bound to the request's context
 func (c *StreamingClient) MakeStreamingRequest(req *http.Request, w http.ResponseWriter) (string, error) {
-	log.GlobalLogger.Infof("API Streaming Request to %s: %s\nHeaders: %v", req.URL.String(), req.Method, req.Header)
+	return c.MakeStreamingRequestContext(req.Context(), req, w)
+}
+
+// MakeStreamingRequestContext performs a streaming HTTP request that stops when ctx is done,
+// for example when the client of an HTTP handler disconnects. The response received so far
+// is returned along with an error wrapping the context error.
+func (c *StreamingClient) MakeStreamingRequestContext(ctx context.Context, req *http.Request, w http.ResponseWriter) (string, error) {
+	stream := &streamState{}
+	_, err := c.streamOnce(ctx, req, w, stream)
+	return stream.response.String(), err
+}
 
-	resp, err := c.httpClient.Do(req)
+// MakeResumableStreamingRequest is MakeStreamingRequestContext reconnecting, up to
+// maxReconnects times, when an SSE stream drops before its end. Reconnections send the ID of
+// the last complete event received in the Last-Event-ID header, so the server can resume after
+// it, and keep writing to w. The endpoint must be idempotent, and a request body must be
+// re-readable through GetBody. Error statuses and cancellations are not retried.
+func (c *StreamingClient) MakeResumableStreamingRequest(ctx context.Context, req *http.Request, w http.ResponseWriter, maxReconnects int) (string, error) {
+	stream := &streamState{}
+	dropped, err := c.streamOnce(ctx, req, w, stream)
+	for attempt := 1; dropped && stream.connected && attempt <= maxReconnects; attempt++ {
+		resumeReq, reqErr := resumeRequest(ctx, req, stream.lastEventID)
+		if reqErr != nil {
+			return stream.response.String(), reqErr
+		}
+		log.FromContext(ctx).Warnf("Streaming response from %s dropped (%v), reconnecting from event %q (attempt %d/%d)",
+			req.URL.Redacted(), err, stream.lastEventID, attempt, maxReconnects)
+		dropped, err = c.streamOnce(ctx, resumeReq, w, stream)
+	}
+	return stream.response.String(), err
+}
+
+// resumeRequest returns a copy of req resuming an SSE stream after the given event ID
+func resumeRequest(ctx context.Context, req *http.Request, lastEventID string) (*http.Request, error) {
+	resumeReq := req.Clone(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("cannot resume streaming request: its body can't be sent again")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("cannot resume streaming request: %w", err)
+		}
+		resumeReq.Body = body
+	}
+	if lastEventID != "" {
+		resumeReq.Header.Set("Last-Event-ID", lastEventID)
+	}
+	return resumeReq, nil
+}
+
+// streamState is the progress of a streaming request, across reconnections
+type streamState struct {
+	response    bytes.Buffer // Response received so far
+	connected   bool         // A successful response was received
+	lastEventID string       // ID of the last complete SSE event
+	pendingID   string       // ID of the SSE event being received
+	hasPending  bool         // The event being received has an ID
+	line        []byte       // Incomplete SSE line
+}
+
+// track records a chunk of the response and follows the IDs of its SSE events. Per the SSE
+// specification, an ID only becomes the last event ID once its event is complete.
+func (s *streamState) track(chunk []byte) {
+	s.response.Write(chunk)
+	s.line = append(s.line, chunk...)
+	for {
+		end := bytes.IndexByte(s.line, '\n')
+		if end < 0 {
+			return
+		}
+		line := bytes.TrimSuffix(s.line[:end], []byte("\r"))
+		s.line = s.line[end+1:]
+
+		switch {
+		case len(line) == 0:
+			if s.hasPending {
+				s.lastEventID = s.pendingID
+				s.hasPending = false
+			}
+		case bytes.HasPrefix(line, []byte("id:")):
+			s.pendingID = string(bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("id:")), []byte(" ")))
+			s.hasPending = true
+		}
+	}
+}
+
+// streamOnce performs a streaming request, writing the response to w and recording it in
+// stream as it arrives. dropped reports a failure of the connection, such as an early
+// disconnect, after which the request may be resumed.
+func (c *StreamingClient) streamOnce(ctx context.Context, req *http.Request, w http.ResponseWriter, stream *streamState) (dropped bool, err error) {
+	req = req.WithContext(ctx)
+	logger := log.FromContext(ctx)
+	logger.Infof("API Streaming Request to %s: %s\nHeaders: %v", req.URL.String(), req.Method, req.Header)
+
+	if err := signRequest(req); err != nil {
+		return false, err
+	}
+
+	resp, err := c.client().Do(req)
 	if err != nil {
-		log.GlobalLogger.Errorf("Error performing streaming request: %v", err)
-		return "", fmt.Errorf("error performing streaming request: %w", err)
+		logger.Errorf("Error performing streaming request: %v", err)
+		return ctx.Err() == nil, fmt.Errorf("error performing streaming request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	if !isSuccess(req, resp.StatusCode, c.successStatuses()) {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.GlobalLogger.Errorf("Streaming API call error: %s", string(bodyBytes))
-		return "", fmt.Errorf("streaming API call error: %s, status code: %d", string(bodyBytes), resp.StatusCode)
+		logger.Errorf("Streaming API call error: %s", string(bodyBytes))
+		return false, fmt.Errorf("streaming %w", &APIError{StatusCode: resp.StatusCode, Body: bodyBytes})
 	}
+	stream.connected = true
 
 	// Set headers on our response to the client to indicate streaming
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -47,11 +210,10 @@ func (c *StreamingClient) MakeStreamingRequest(req *http.Request, w http.Respons
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		log.GlobalLogger.Error("Response writer does not support flushing")
-		return "", fmt.Errorf("response writer does not support flushing")
+		logger.Error("Response writer does not support flushing")
+		return false, fmt.Errorf("response writer does not support flushing")
 	}
 
-	var responseBuffer bytes.Buffer
 	buffer := make([]byte, 4096) // Use a fixed-size buffer to read chunks of data
 
 	for {
@@ -64,27 +226,29 @@ func (c *StreamingClient) MakeStreamingRequest(req *http.Request, w http.Respons
 
 			// Write chunk to the client
 			if _, writeErr := w.Write(chunk); writeErr != nil {
-				log.GlobalLogger.Errorf("Error writing to response: %v", writeErr)
-				return responseBuffer.String(), fmt.Errorf("error writing to response: %w", writeErr)
+				logger.Errorf("Error writing to response: %v", writeErr)
+				return false, fmt.Errorf("error writing to response: %w", writeErr)
 			}
 
 			// Flush to ensure data is sent to the client immediately
 			flusher.Flush()
 
 			// Store in our response buffer
-			responseBuffer.Write(chunk)
+			stream.track(chunk)
 		}
 
 		// Handle any errors after processing data
 		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				logger.Warnf("Streaming request canceled: %v", ctxErr)
+				return false, fmt.Errorf("streaming request canceled: %w", ctxErr)
+			}
 			if err == io.EOF {
-				log.GlobalLogger.Info("Streaming request completed")
-				break // End of stream
+				logger.Info("Streaming request completed")
+				return false, nil // End of stream
 			}
-			log.GlobalLogger.Errorf("Error reading from streaming response: %v", err)
-			return responseBuffer.String(), fmt.Errorf("error reading from streaming response: %w", err)
+			logger.Errorf("Error reading from streaming response: %v", err)
+			return true, fmt.Errorf("error reading from streaming response: %w", err)
 		}
 	}
-
-	return responseBuffer.String(), nil
 }
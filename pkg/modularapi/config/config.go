@@ -1,15 +1,40 @@
 package config
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
 // ApiConfig holds the configuration for an API service
 type ApiConfig struct {
 	ApiURL        string                 `json:"apiURL"`
 	ApiToken      string                 `json:"apiToken,omitempty"`
 	DefaultParams map[string]interface{} `json:"defaultParams,omitempty"`
+	// HealthCheckMethod and HealthCheckPath define the health check request (default GET /)
+	HealthCheckMethod string `json:"healthCheckMethod,omitempty"`
+	HealthCheckPath   string `json:"healthCheckPath,omitempty"`
+	// UserAgent is sent in the User-Agent header of the requests to the service, taking
+	// precedence over the user agent of the builder
+	UserAgent string `json:"userAgent,omitempty"`
+	// OmitEmpty drops the empty fields of the bodies of all the requests to the service, as
+	// the OmitEmpty flag of a template does
+	OmitEmpty bool `json:"omitEmpty,omitempty"`
+	// Fallback is the configuration of a backup endpoint, such as a read replica, tried when a
+	// request to this one fails with a connection error or a 5xx status. A fallback can have its
+	// own fallback, so endpoints are tried in order.
+	Fallback *ApiConfig `json:"fallback,omitempty"`
 }
 
 // Config holds the configuration for the modular API service
 type Config struct {
 	Services map[string]ApiConfig `json:"services"`
+	// Environments holds per-environment service configurations (e.g. "staging", "prod")
+	Environments map[string]map[string]ApiConfig `json:"environments,omitempty"`
+	// ActiveEnvironment is the environment services are resolved against, empty for none
+	ActiveEnvironment string `json:"activeEnvironment,omitempty"`
 }
 
 // NewConfig creates a new empty configuration
@@ -19,13 +44,97 @@ func NewConfig() *Config {
 	}
 }
 
-// SetServiceConfig sets the configuration for a specific service
+// LoadFromFile reads a configuration from a JSON file with the same layout as Config.
+// A missing file is an error, while an empty file yields an empty configuration.
+func LoadFromFile(filepath string) (*Config, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := NewConfig()
+	if len(bytes.TrimSpace(data)) == 0 {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config file %s: %w", filepath, err)
+	}
+	if cfg.Services == nil {
+		cfg.Services = make(map[string]ApiConfig)
+	}
+	return cfg, nil
+}
+
+// SetServiceConfig sets the configuration for a specific service.
+// When an environment is active, the configuration is set in that environment.
 func (c *Config) SetServiceConfig(serviceName string, config ApiConfig) {
+	if c.ActiveEnvironment != "" {
+		c.SetEnvironmentServiceConfig(c.ActiveEnvironment, serviceName, config)
+		return
+	}
 	c.Services[serviceName] = config
 }
 
-// GetServiceConfig returns the configuration for a specific service
+// SetEnvironmentServiceConfig sets the configuration of a service in a specific environment
+func (c *Config) SetEnvironmentServiceConfig(environment, serviceName string, config ApiConfig) {
+	if c.Environments == nil {
+		c.Environments = make(map[string]map[string]ApiConfig)
+	}
+	if c.Environments[environment] == nil {
+		c.Environments[environment] = make(map[string]ApiConfig)
+	}
+	c.Environments[environment][serviceName] = config
+}
+
+// UseEnvironment selects the environment services are resolved against.
+// An empty name deselects the environment so only the base services are used.
+func (c *Config) UseEnvironment(name string) error {
+	if name != "" {
+		if _, ok := c.Environments[name]; !ok {
+			return fmt.Errorf("unknown environment %s, available environments: %v", name, c.EnvironmentNames())
+		}
+	}
+	c.ActiveEnvironment = name
+	return nil
+}
+
+// EnvironmentNames returns the sorted names of the configured environments
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetServiceConfig returns the configuration for a specific service.
+// When an environment is active, its configuration of the service takes precedence over the base
+// one and inherits the base default parameters if it doesn't define any.
 func (c *Config) GetServiceConfig(serviceName string) (ApiConfig, bool) {
 	cfg, ok := c.Services[serviceName]
-	return cfg, ok
+	if c.ActiveEnvironment == "" {
+		return cfg, ok
+	}
+
+	envCfg, envOk := c.Environments[c.ActiveEnvironment][serviceName]
+	if !envOk {
+		return cfg, ok
+	}
+	if envCfg.DefaultParams == nil {
+		envCfg.DefaultParams = cfg.DefaultParams
+	}
+	return envCfg, true
+}
+
+// ActiveServices returns the configuration of every service as resolved against the active environment
+func (c *Config) ActiveServices() map[string]ApiConfig {
+	services := make(map[string]ApiConfig)
+	for name := range c.Services {
+		services[name], _ = c.GetServiceConfig(name)
+	}
+	for name := range c.Environments[c.ActiveEnvironment] {
+		services[name], _ = c.GetServiceConfig(name)
+	}
+	return services
 }
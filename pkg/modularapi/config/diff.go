@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfigDiff lists the services whose configuration differs between two configurations, as
+// sorted service names
+type ConfigDiff struct {
+	Added   []string // Services only in the other configuration
+	Removed []string // Services only in the configuration
+	Changed []string // Services in both configurations with different settings
+}
+
+// Empty reports whether the configurations resolve to the same services
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a summary of the differences with one service per line, prefixed with "+"
+// for added, "-" for removed and "~" for changed services. Settings are not included, so
+// tokens are never printed.
+func (d ConfigDiff) String() string {
+	var sb strings.Builder
+	for _, group := range []struct {
+		prefix   string
+		services []string
+	}{{"+", d.Added}, {"-", d.Removed}, {"~", d.Changed}} {
+		for _, service := range group.services {
+			sb.WriteString(group.prefix + " " + service + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// Diff compares the configuration, such as the one in use, with other, such as a configuration
+// about to be loaded. Services are compared as resolved against the active environment of each
+// configuration, on all their settings as saved to a file.
+func (c *Config) Diff(other *Config) ConfigDiff {
+	current := c.activeSnapshot()
+	next := other.activeSnapshot()
+
+	var diff ConfigDiff
+	for name, cfg := range next {
+		previous, ok := current[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case previous != cfg:
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range current {
+		if _, ok := next[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// activeSnapshot returns the JSON encoding of every active service configuration by service
+// name. A nil configuration has no services.
+func (c *Config) activeSnapshot() map[string]string {
+	snapshot := make(map[string]string)
+	if c == nil {
+		return snapshot
+	}
+	for name, cfg := range c.ActiveServices() {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			// Compare settings with values JSON can't encode on their Go representation
+			data = []byte(fmt.Sprintf("%#v", cfg))
+		}
+		snapshot[name] = string(data)
+	}
+	return snapshot
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"sort"
+)
+
+// envReference matches a "${NAME}" reference to an environment variable
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv returns a copy of the configuration with the "${NAME}" references in the string
+// default parameters of every service, base and environment ones, replaced with the values of
+// the environment variables, such as {"account": "${ACCOUNT_ID}"}. Other values are left
+// unchanged and c isn't modified. References to unset variables expand to an empty string, and
+// their names are returned sorted.
+func (c *Config) ExpandEnv() (*Config, []string) {
+	missing := make(map[string]bool)
+	expand := func(cfg ApiConfig) ApiConfig {
+		var unset []string
+		cfg.DefaultParams, unset = ExpandEnvParams(cfg.DefaultParams)
+		for _, name := range unset {
+			missing[name] = true
+		}
+		return cfg
+	}
+	expanded := &Config{
+		Services:          make(map[string]ApiConfig, len(c.Services)),
+		ActiveEnvironment: c.ActiveEnvironment,
+	}
+	for name, cfg := range c.Services {
+		expanded.Services[name] = expand(cfg)
+	}
+	if c.Environments != nil {
+		expanded.Environments = make(map[string]map[string]ApiConfig, len(c.Environments))
+	}
+	for env, services := range c.Environments {
+		expanded.Environments[env] = make(map[string]ApiConfig, len(services))
+		for name, cfg := range services {
+			expanded.Environments[env][name] = expand(cfg)
+		}
+	}
+	return expanded, sortedKeys(missing)
+}
+
+// ExpandEnvParams returns params with the "${NAME}" environment variable references of its
+// string values expanded, along with the sorted names of the unset variables. Params without
+// references are returned as they are, otherwise they are copied.
+func ExpandEnvParams(params map[string]interface{}) (map[string]interface{}, []string) {
+	var expanded map[string]interface{}
+	missing := make(map[string]bool)
+	for key, value := range params {
+		s, ok := value.(string)
+		if !ok || !envReference.MatchString(s) {
+			continue
+		}
+		if expanded == nil {
+			// Copy the params so maps shared with other configurations are left untouched
+			expanded = make(map[string]interface{}, len(params))
+			for k, v := range params {
+				expanded[k] = v
+			}
+		}
+		expanded[key] = envReference.ReplaceAllStringFunc(s, func(reference string) string {
+			name := envReference.FindStringSubmatch(reference)[1]
+			env, ok := os.LookupEnv(name)
+			if !ok {
+				missing[name] = true
+			}
+			return env
+		})
+	}
+	if expanded == nil {
+		return params, nil
+	}
+	return expanded, sortedKeys(missing)
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,32 @@
+package modularapi
+
+import "maps"
+
+// ActionSchema describes the request of a service action, for example to build a form for it
+type ActionSchema struct {
+	ServiceName    string
+	Action         string
+	Method         string
+	Endpoint       string
+	RequiredParams []string          // Parameters that must be provided, unless set as default or global service parameters
+	OptionalParams []string          // Parameters that are omitted from the request when not provided
+	ParamTypes     map[string]string // Declared types of parameters, such as "int", string inputs being converted to them
+}
+
+// DescribeAction returns the schema of a service action, or false if there is no template for it
+func (s *ModularAPIService) DescribeAction(serviceName, action string) (*ActionSchema, bool) {
+	tmpl, ok := s.templateStore.GetTemplate(serviceName, action)
+	if !ok {
+		return nil, false
+	}
+
+	return &ActionSchema{
+		ServiceName:    serviceName,
+		Action:         action,
+		Method:         tmpl.Method,
+		Endpoint:       tmpl.Endpoint,
+		RequiredParams: tmpl.RequiredParams(),
+		OptionalParams: tmpl.OptionalParamNames(),
+		ParamTypes:     maps.Clone(tmpl.ParamTypes),
+	}, true
+}
@@ -0,0 +1,132 @@
+package modularapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rrodriguez06/modular_api/internal/log"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/client"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/config"
+)
+
+// FailoverAttempt is a failed attempt of a request against one endpoint of a service
+type FailoverAttempt struct {
+	URL string // Base URL of the endpoint
+	Err error
+}
+
+// FailoverError is returned when a request failed against the endpoint of a service and every
+// fallback tried after it. It lists the attempts in order.
+type FailoverError struct {
+	Attempts []FailoverAttempt
+}
+
+// Error implements the error interface
+func (e *FailoverError) Error() string {
+	attempts := make([]string, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		attempts[i] = fmt.Sprintf("%s: %v", attempt.URL, attempt.Err)
+	}
+	return fmt.Sprintf("all %d endpoints failed: %s", len(e.Attempts), strings.Join(attempts, "; "))
+}
+
+// Unwrap returns the errors of the attempts, so errors.As finds a *client.APIError among them
+func (e *FailoverError) Unwrap() []error {
+	errs := make([]error, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		errs[i] = attempt.Err
+	}
+	return errs
+}
+
+// failOver sends a request that failed against the endpoint of a service to its fallbacks in
+// order, until one succeeds or fails with an error another endpoint wouldn't fix, returning the
+// request that succeeded and its response. It returns req and requestErr when the service has
+// no fallback, and a *FailoverError when every endpoint failed.
+func (s *ModularAPIService) failOver(ctx context.Context, serviceName, action string, params map[string]interface{}, result interface{},
+	reqCfg *requestConfig, req *http.Request, requestErr error) (*http.Request, *http.Response, error) {
+	cfg, _ := s.config.GetServiceConfig(serviceName)
+	if cfg.Fallback == nil || !shouldFailOver(ctx, requestErr) {
+		return req, nil, requestErr
+	}
+
+	// A streamed body can only be sent again when it can be rewound
+	seeker, seekable := params[BodyReaderParam].(io.Seeker)
+	if _, streamed := params[BodyReaderParam].(io.Reader); streamed && !seekable {
+		log.FromContext(ctx).Warnf("Not failing over request to %s: its body can't be sent again", serviceName)
+		return req, nil, requestErr
+	}
+
+	failure := &FailoverError{Attempts: []FailoverAttempt{{URL: cfg.ApiURL, Err: requestErr}}}
+	httpClient, _ := s.clientsFor(serviceName)
+	for fallback := cfg.Fallback; fallback != nil; fallback = fallback.Fallback {
+		log.FromContext(ctx).Warnf("Request %s.%s to %s failed, failing over to %s", serviceName, action, req.URL.Host, fallback.ApiURL)
+
+		fallbackReq, resp, err := s.sendToFallback(ctx, httpClient, serviceName, action, params, result, reqCfg, fallback, seeker)
+		if err == nil {
+			return fallbackReq, resp, nil
+		}
+		failure.Attempts = append(failure.Attempts, FailoverAttempt{URL: fallback.ApiURL, Err: err})
+		if !shouldFailOver(ctx, err) {
+			break
+		}
+	}
+	return req, nil, failure
+}
+
+// sendToFallback prepares and sends a request to a fallback endpoint of a service, returning
+// the request and its response
+func (s *ModularAPIService) sendToFallback(ctx context.Context, httpClient *client.Client, serviceName, action string, params map[string]interface{},
+	result interface{}, reqCfg *requestConfig, fallback *config.ApiConfig, seeker io.Seeker) (*http.Request, *http.Response, error) {
+	if seeker != nil {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("cannot rewind request body: %w", err)
+		}
+	}
+
+	fallbackCfg := *reqCfg
+	fallbackCfg.fallback = fallback
+	req, err := s.prepareRequest(ctx, serviceName, action, params, &fallbackCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	if reqCfg.onPrepared != nil {
+		reqCfg.onPrepared(req)
+	}
+	resp, err := httpClient.MakeRequestWithResponse(req, result)
+	return req, resp, err
+}
+
+// shouldFailOver reports whether a request failing with err might succeed against another
+// endpoint: it failed to connect or the endpoint responded with a 5xx status
+func shouldFailOver(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// fallbackConfig returns the configuration of a fallback endpoint of a service. It inherits the
+// default parameters and user agent of the service when it doesn't set its own, and drops
+// empty body fields when the service does.
+func fallbackConfig(cfg, fallback config.ApiConfig) config.ApiConfig {
+	if fallback.DefaultParams == nil {
+		fallback.DefaultParams = cfg.DefaultParams
+	}
+	if fallback.UserAgent == "" {
+		fallback.UserAgent = cfg.UserAgent
+	}
+	fallback.OmitEmpty = fallback.OmitEmpty || cfg.OmitEmpty
+	return fallback
+}
@@ -0,0 +1,76 @@
+package modularapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HealthCheck verifies that a service is reachable by sending its health check request,
+// GET / unless configured otherwise with WithServiceHealthCheck. It returns an error if the
+// service can't be reached or responds with a non-2xx status.
+func (s *ModularAPIService) HealthCheck(serviceName string) error {
+	cfg, ok := s.config.GetServiceConfig(serviceName)
+	if !ok {
+		return fmt.Errorf("no configuration found for service: %s", serviceName)
+	}
+
+	method := http.MethodGet
+	if cfg.HealthCheckMethod != "" {
+		method = strings.ToUpper(cfg.HealthCheckMethod)
+	}
+	path := "/"
+	if cfg.HealthCheckPath != "" {
+		path = cfg.HealthCheckPath
+	}
+
+	url, err := resolveServiceURL(cfg.ApiURL, path, s.mergeParams(serviceName, cfg, nil))
+	if err != nil {
+		return fmt.Errorf("health check of service %s failed: %w", serviceName, err)
+	}
+
+	req, err := http.NewRequestWithContext(s.signingContext(context.Background(), serviceName), method, url, nil)
+	if err != nil {
+		return fmt.Errorf("health check of service %s failed: %w", serviceName, err)
+	}
+
+	// Send the same headers and credentials as regular requests
+	for key, value := range s.serviceHeaders[serviceName] {
+		req.Header.Set(key, value)
+	}
+	if token := s.serviceToken(serviceName, cfg); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient, _ := s.clientsFor(serviceName)
+	if err := httpClient.MakeRequest(req, nil); err != nil {
+		return fmt.Errorf("health check of service %s failed: %w", serviceName, err)
+	}
+	return nil
+}
+
+// HealthCheckAll checks every configured service concurrently and returns the result per
+// service name, nil meaning healthy. It is suited for readiness probes.
+func (s *ModularAPIService) HealthCheckAll() map[string]error {
+	services := s.config.ActiveServices()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]error, len(services))
+	for name := range services {
+		wg.Add(1)
+		go func(serviceName string) {
+			defer wg.Done()
+			err := s.HealthCheck(serviceName)
+
+			mu.Lock()
+			results[serviceName] = err
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,260 @@
+// Package modularapitest provides an in-process mock API server for testing templates
+// and workflows built with the modularapi package.
+//
+// Each service registered on the Server is served under its own path prefix, and
+// requests are matched to a service action using the action's route template. Canned
+// responses are registered per service.action:
+//
+//	server := modularapitest.NewServer()
+//	defer server.Close()
+//
+//	server.WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+//		Respond("users", "get", http.StatusOK, map[string]interface{}{"name": "Jane"})
+//
+//	service := server.Service()
+package modularapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/rrodriguez06/modular_api/pkg/modularapi"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/template"
+)
+
+// ResponderFunc builds a response for a request matched to a service action
+type ResponderFunc func(r *http.Request) (status int, body interface{})
+
+// route holds the template and canned response of a single service action
+type route struct {
+	template  template.RouteTemplate
+	responder ResponderFunc
+	calls     int
+}
+
+// Server is an in-process mock API serving canned responses per service.action
+type Server struct {
+	server *httptest.Server
+	routes map[string]map[string]*route
+	mu     sync.Mutex
+}
+
+// NewServer starts a new mock server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		routes: make(map[string]map[string]*route),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL returns the base URL of the mock server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// ServiceURL returns the base URL under which a service is served
+func (s *Server) ServiceURL(serviceName string) string {
+	return s.server.URL + "/" + serviceName
+}
+
+// Close shuts down the mock server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// WithTemplate registers the route template of a service action
+func (s *Server) WithTemplate(serviceName, action string, tmpl template.RouteTemplate) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.getRoute(serviceName, action).template = tmpl
+	return s
+}
+
+// Respond registers a canned response for a service action.
+// The body is JSON encoded unless it is a string or a []byte, which are written as-is.
+func (s *Server) Respond(serviceName, action string, status int, body interface{}) *Server {
+	return s.RespondFunc(serviceName, action, func(r *http.Request) (int, interface{}) {
+		return status, body
+	})
+}
+
+// RespondFunc registers a function computing the response for a service action
+func (s *Server) RespondFunc(serviceName, action string, responder ResponderFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.getRoute(serviceName, action).responder = responder
+	return s
+}
+
+// Calls returns how many requests were served for a service action
+func (s *Server) Calls(serviceName, action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if actions, ok := s.routes[serviceName]; ok {
+		if r, ok := actions[action]; ok {
+			return r.calls
+		}
+	}
+	return 0
+}
+
+// Builder returns a service builder with every registered service pointed at the mock
+// server and every registered template added. Workflows and other options can then be
+// added before calling Build.
+func (s *Server) Builder() *modularapi.ServiceBuilder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	builder := modularapi.NewServiceBuilder()
+	for serviceName, actions := range s.routes {
+		builder.WithService(serviceName, s.ServiceURL(serviceName), "")
+		for action, r := range actions {
+			if r.template.Method != "" {
+				builder.WithTemplate(serviceName, action, r.template)
+			}
+		}
+	}
+	return builder
+}
+
+// Service returns a service wired to the mock server
+func (s *Server) Service() modularapi.Service {
+	return s.Builder().Build()
+}
+
+// getRoute returns the route of a service action, creating it if needed.
+// The caller must hold the lock.
+func (s *Server) getRoute(serviceName, action string) *route {
+	if s.routes[serviceName] == nil {
+		s.routes[serviceName] = make(map[string]*route)
+	}
+	r, ok := s.routes[serviceName][action]
+	if !ok {
+		r = &route{}
+		s.routes[serviceName][action] = r
+	}
+	return r
+}
+
+// handle dispatches a request to the matching service action
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	serviceName := parts[0]
+	path := "/"
+	if len(parts) == 2 {
+		path += parts[1]
+	}
+
+	// Pick the most specific matching action, breaking ties by action name
+	s.mu.Lock()
+	var matched *route
+	matchedAction := ""
+	bestScore := -1
+	for action, candidate := range s.routes[serviceName] {
+		if candidate.responder == nil || !strings.EqualFold(candidate.template.Method, r.Method) ||
+			!matchEndpoint(candidate.template.EndpointPath(), path) {
+			continue
+		}
+		score := specificity(candidate.template.EndpointPath())
+		if score > bestScore || (score == bestScore && action < matchedAction) {
+			matched, matchedAction, bestScore = candidate, action, score
+		}
+	}
+	if matched != nil {
+		matched.calls++
+	}
+	s.mu.Unlock()
+
+	if matched == nil {
+		writeResponse(w, http.StatusNotFound, map[string]interface{}{
+			"error": fmt.Sprintf("no mock response for %s %s", r.Method, r.URL.Path),
+		})
+		return
+	}
+
+	status, body := matched.responder(r)
+	writeResponse(w, status, body)
+}
+
+// matchEndpoint reports whether a request path matches the path of a template endpoint.
+// Placeholder segments match any value and optional placeholders may be absent.
+func matchEndpoint(endpoint, path string) bool {
+	return matchSegments(splitPath(endpoint), splitPath(path))
+}
+
+// matchSegments matches endpoint segments against path segments
+func matchSegments(endpointParts, pathParts []string) bool {
+	if len(endpointParts) == 0 {
+		return len(pathParts) == 0
+	}
+
+	part := endpointParts[0]
+	isPlaceholder := strings.HasPrefix(part, "{{") && strings.HasSuffix(part, "}}")
+	isOptional := isPlaceholder && strings.HasSuffix(strings.TrimSuffix(part, "}}"), "?")
+
+	// An optional placeholder may be skipped entirely
+	if isOptional && matchSegments(endpointParts[1:], pathParts) {
+		return true
+	}
+	if len(pathParts) == 0 {
+		return false
+	}
+	if !isPlaceholder && part != pathParts[0] {
+		return false
+	}
+	return matchSegments(endpointParts[1:], pathParts[1:])
+}
+
+// specificity ranks the path of an endpoint so literal segments win over placeholders
+// and required placeholders win over optional ones
+func specificity(endpoint string) int {
+	score := 0
+	for _, part := range splitPath(endpoint) {
+		switch {
+		case !strings.HasPrefix(part, "{{") || !strings.HasSuffix(part, "}}"):
+			score += 4
+		case strings.HasSuffix(strings.TrimSuffix(part, "}}"), "?"):
+			score++
+		default:
+			score += 2
+		}
+	}
+	return score
+}
+
+// splitPath splits a URL path into its non-empty segments
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// writeResponse writes a canned response body
+func writeResponse(w http.ResponseWriter, status int, body interface{}) {
+	switch b := body.(type) {
+	case nil:
+		w.WriteHeader(status)
+	case []byte:
+		w.WriteHeader(status)
+		w.Write(b)
+	case string:
+		w.WriteHeader(status)
+		w.Write([]byte(b))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(b)
+	}
+}
@@ -0,0 +1,78 @@
+package modularapitest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/rrodriguez06/modular_api/pkg/modularapi"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/modularapitest"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/template"
+)
+
+func TestServerServesCannedResponses(t *testing.T) {
+	server := modularapitest.NewServer()
+	defer server.Close()
+
+	server.WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		Respond("users", "get", http.StatusOK, map[string]interface{}{
+			"name": "Jane",
+		}).
+		WithTemplate("users", "list", *template.NewRouteTemplate("GET", "/users/{{group?}}")).
+		RespondFunc("users", "list", func(r *http.Request) (int, interface{}) {
+			return http.StatusOK, []interface{}{"jane", "john"}
+		})
+
+	service := server.Service()
+
+	var user map[string]interface{}
+	if err := service.PerformRequest("users", "get", map[string]interface{}{"id": "1"}, &user); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if user["name"] != "Jane" {
+		t.Errorf("Expected name Jane, got %v", user["name"])
+	}
+	var users []interface{}
+	if err := service.PerformRequest("users", "list", nil, &users); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("Expected 2 users, got %v", users)
+	}
+	if calls := server.Calls("users", "get"); calls != 1 {
+		t.Errorf("Expected 1 call to users.get, got %d", calls)
+	}
+}
+
+func TestServerRunsWorkflows(t *testing.T) {
+	server := modularapitest.NewServer()
+	defer server.Close()
+
+	server.WithTemplate("location", "geocode", *template.NewRouteTemplate("GET", "/geocode")).
+		Respond("location", "geocode", http.StatusOK, map[string]interface{}{"city": "Paris"}).
+		WithTemplate("weather", "current", *template.NewRouteTemplate("GET", "/weather/{{city}}")).
+		Respond("weather", "current", http.StatusOK, map[string]interface{}{"temperature": 21.5}).
+		WithTemplate("weather", "alerts", *template.NewRouteTemplate("GET", "/alerts"))
+
+	service := server.Builder().
+		WithWorkflow("forecast", "Weather for a location").
+		WithStep(modularapi.NewWorkflowStepTemplate("geocode", "Locate", "location", "geocode").
+			WithResultMap("city", "city")).
+		WithStep(modularapi.NewWorkflowStepTemplate("weather", "Get weather", "weather", "current").
+			WithDynamicParam("city", "city").
+			WithResultMap("temperature", "temperature")).
+		Build().
+		Build()
+
+	var vars map[string]interface{}
+	if err := service.ExecuteWorkflow("forecast", nil, nil, modularapi.WithWorkflowVars(&vars)); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if vars["temperature"] != 21.5 {
+		t.Errorf("Expected temperature 21.5, got %v", vars["temperature"])
+	}
+
+	// Requests without a canned response are answered with a 404
+	if err := service.PerformRequest("weather", "alerts", nil, nil); err == nil {
+		t.Errorf("Expected an error for an action without a canned response")
+	}
+}
@@ -1,7 +1,13 @@
 package modularapi
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	"github.com/rrodriguez06/modular_api/internal/log"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/config"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
 )
 
 // ExecutionOption defines a function type that configures execution
@@ -11,9 +17,48 @@ type ExecutionOption func(*executionConfig)
 type executionConfig struct {
 	WorkflowVars *map[string]interface{}
 	LogLevel     *log.LogLevel
+	ErrorHook    workflow.ErrorHookFunc
+	StreamWriter http.ResponseWriter
+	Context      context.Context
+	StepHook     workflow.StepHookFunc
+	ParentVars   map[string]interface{}
+	StepTimeout  time.Duration
 	// Other options could be added here in the future
 }
 
+// workflowOptions converts the configuration into options for the workflow executor
+func (c *executionConfig) workflowOptions() []workflow.ExecutionOption {
+	var opts []workflow.ExecutionOption
+	if c.ErrorHook != nil {
+		opts = append(opts, workflow.WithErrorHook(c.ErrorHook))
+	}
+	if c.StreamWriter != nil {
+		opts = append(opts, workflow.WithStreamWriter(c.StreamWriter))
+	}
+	// The log level of the execution is carried by its context, so it applies to its steps and
+	// their requests without changing the global level
+	ctx := c.Context
+	if c.LogLevel != nil {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx = log.ContextWithLevel(ctx, *c.LogLevel)
+	}
+	if ctx != nil {
+		opts = append(opts, workflow.WithContext(ctx))
+	}
+	if c.StepHook != nil {
+		opts = append(opts, workflow.WithStepHook(c.StepHook))
+	}
+	if c.ParentVars != nil {
+		opts = append(opts, workflow.WithParentVariables(c.ParentVars))
+	}
+	if c.StepTimeout > 0 {
+		opts = append(opts, workflow.WithStepTimeout(c.StepTimeout))
+	}
+	return opts
+}
+
 // WithWorkflowVars creates an option to capture workflow variables
 func WithWorkflowVars(vars *map[string]interface{}) ExecutionOption {
 	return func(c *executionConfig) {
@@ -28,12 +73,65 @@ func WithLogLevel(level log.LogLevel) ExecutionOption {
 	}
 }
 
+// WithErrorHook creates an option to set a function called when the workflow aborts.
+// It receives the workflow name, the ID of the step that failed and the error.
+func WithErrorHook(hook func(workflowName string, failedStepID string, err error)) ExecutionOption {
+	return func(c *executionConfig) {
+		c.ErrorHook = hook
+	}
+}
+
+// WithStepHook creates an option to set a function called with the result of each workflow step.
+// Skipped steps are reported with Skipped set and the reason in SkipReason.
+func WithStepHook(hook func(workflowName string, result workflow.StepResult)) ExecutionOption {
+	return func(c *executionConfig) {
+		c.StepHook = hook
+	}
+}
+
+// WithStreamWriter creates an option to set the writer that streaming workflow steps forward
+// their response to. The buffered response is still available to result mappings and the aggregator.
+func WithStreamWriter(w http.ResponseWriter) ExecutionOption {
+	return func(c *executionConfig) {
+		c.StreamWriter = w
+	}
+}
+
+// WithContext creates an option binding the workflow execution to ctx: when ctx is done,
+// running requests are aborted and no further step is started
+func WithContext(ctx context.Context) ExecutionOption {
+	return func(c *executionConfig) {
+		c.Context = ctx
+	}
+}
+
+// WithParentVariables creates an option setting variables shared by several workflow runs,
+// such as constants. They override the workflow Variables and are overridden by the params.
+func WithParentVariables(vars map[string]interface{}) ExecutionOption {
+	return func(c *executionConfig) {
+		c.ParentVars = vars
+	}
+}
+
+// WithStepTimeout creates an option bounding each request of the workflow steps, for example
+// shorter for interactive runs than for batch runs. The TimeoutMs of a step takes precedence.
+func WithStepTimeout(d time.Duration) ExecutionOption {
+	return func(c *executionConfig) {
+		c.StepTimeout = d
+	}
+}
+
 // RequestOption defines a function type that configures individual API requests
 type RequestOption func(*requestConfig)
 
 // requestConfig holds the internal configuration for API requests
 type requestConfig struct {
 	LogLevel *log.LogLevel
+	Method   string
+	// onPrepared is called with the request once it is prepared, before it is sent
+	onPrepared func(req *http.Request)
+	// fallback is the configuration the request is sent to instead of the one of its service
+	fallback *config.ApiConfig
 	// Other options could be added here in the future
 }
 
@@ -43,3 +141,11 @@ func WithRequestLogLevel(level log.LogLevel) RequestOption {
 		c.LogLevel = &level
 	}
 }
+
+// WithMethod creates an option overriding the template's HTTP method for a single request.
+// If the method doesn't allow a body (GET, HEAD, OPTIONS, TRACE), the template body is not sent.
+func WithMethod(method string) RequestOption {
+	return func(c *requestConfig) {
+		c.Method = method
+	}
+}
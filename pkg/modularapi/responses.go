@@ -0,0 +1,40 @@
+package modularapi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RegisterResponseType registers the type the responses of a service action decode into, given
+// as a value of that type such as UserResponse{} or []User{} (a pointer registers the type it
+// points to, nil removes the registration). PerformTyped then decodes responses into a new
+// value of that type, so call sites don't have to provide one.
+func (s *ModularAPIService) RegisterResponseType(serviceName, action string, proto interface{}) {
+	key := serviceName + "." + action
+	if proto == nil {
+		delete(s.responseTypes, key)
+		return
+	}
+
+	responseType := reflect.TypeOf(proto)
+	if responseType.Kind() == reflect.Pointer {
+		responseType = responseType.Elem()
+	}
+	s.responseTypes[key] = responseType
+}
+
+// PerformTyped performs a request for a service action and returns its response decoded into a
+// new value of the type registered with RegisterResponseType, as a pointer to it such as
+// *UserResponse. It fails without performing the request if no type is registered for the action.
+func (s *ModularAPIService) PerformTyped(serviceName, action string, params map[string]interface{}, opts ...RequestOption) (interface{}, error) {
+	responseType, ok := s.responseTypes[serviceName+"."+action]
+	if !ok {
+		return nil, fmt.Errorf("no response type registered for %s.%s", serviceName, action)
+	}
+
+	result := reflect.New(responseType).Interface()
+	if err := s.PerformRequest(serviceName, action, params, result, opts...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
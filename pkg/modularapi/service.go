@@ -2,10 +2,17 @@ package modularapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rrodriguez06/modular_api/internal/log"
@@ -15,44 +22,94 @@ import (
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
 )
 
+// BodyReaderParam is the request parameter holding an io.Reader to send as the raw request body.
+// The reader is used as-is instead of the template body, without buffering or JSON marshaling,
+// so large payloads can be uploaded without loading them into memory. The caller is responsible
+// for setting a matching Content-Type header (through template or service headers).
+//
+// A body that has been sent cannot be sent again unless the reader also implements io.Seeker,
+// in which case req.GetBody rewinds it. Anything resending a prepared request (such as retry
+// logic) must check req.GetBody and give up when it is nil.
+const BodyReaderParam = "_body"
+
+// ErrNoDefaultService is returned when an action is called without a service name and no default service is set
+var ErrNoDefaultService = fmt.Errorf("no default service set, call the action with a service name or set one with SetDefaultService")
+
 // Service is the main interface for the modular API service
 type Service interface {
 	// Request preparation and execution
 	PrepareRequest(serviceName, action string, params map[string]interface{}) (*http.Request, error)
+	DumpRequest(req *http.Request) (string, error)
+	ResolveParams(serviceName, action string, params map[string]interface{}) (map[string]interface{}, error)
 	MakeRequest(req *http.Request, result interface{}) error
 	MakeStreamingRequest(req *http.Request, w http.ResponseWriter) (string, error)
 	PerformRequest(serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error
+	PerformRequestContext(ctx context.Context, serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error
+	PerformRequestWithOptions(serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error
+	PerformRequestAwait(serviceName, action string, params map[string]interface{}, result interface{}, poll PollSpec, opts ...RequestOption) error
+	Perform(action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error
+	PerformBatch(reqs []BatchRequest, concurrency int) []BatchResult
+	PerformTyped(serviceName, action string, params map[string]interface{}, opts ...RequestOption) (interface{}, error)
 	PerformStreamingRequest(serviceName, action string, params map[string]interface{}, w http.ResponseWriter) (string, error)
+	PerformStreamingRequestContext(ctx context.Context, serviceName, action string, params map[string]interface{}, w http.ResponseWriter) (string, error)
+	PerformResumableStreamingRequest(ctx context.Context, serviceName, action string, params map[string]interface{}, w http.ResponseWriter, maxReconnects int) (string, error)
 	ExecuteRequestWithParams(templateID string, params map[string]interface{}) (json.RawMessage, error)
 
 	// Template management
 	AddRouteTemplate(serviceName, action string, route template.RouteTemplate)
 	SaveTemplates(filepath string) error
 	LoadTemplates(filepath string) error
+	MergeTemplates(store *template.TemplateStore)
+	ClearTemplates()
+	ExportOpenAPI() ([]byte, error)
+	DescribeAction(serviceName, action string) (*ActionSchema, bool)
+	ValidateTemplate(serviceName, action string, params map[string]interface{}, opts ...ValidateOption) error
+	RegisterResponseType(serviceName, action string, proto interface{})
 
 	// Service configuration
 	GetServiceURL(serviceName string) string
 	SetServiceURL(serviceName, url string)
 	GetServiceToken(serviceName string) string
+	SetServiceToken(serviceName, token string)
+	SetTokenRefresh(serviceName string, refresh TokenRefreshFunc)
+	SetResponsePreprocessor(serviceName string, preprocessor client.ResponsePreprocessor)
+	SetSuccessStatuses(serviceName string, statuses ...string) error
+	SetRequestSigner(serviceName string, signer client.RequestSigner, secret string)
+	UseEnvironment(name string) error
+	SetDefaultService(serviceName string)
+	GetDefaultService() string
+	HealthCheck(serviceName string) error
+	HealthCheckAll() map[string]error
 
 	// Headers management
 	SetServiceHeaders(serviceName string, headers map[string]string)
 	GetServiceHeaders(serviceName string) map[string]string
+	LookupServiceHeaders(serviceName string) (map[string]string, bool)
 	RemoveServiceHeader(serviceName string, headerName string)
 
 	// Parameters management
 	SetServiceParams(serviceName string, params map[string]interface{})
 	GetServiceParams(serviceName string) map[string]interface{}
+	LookupServiceParams(serviceName string) (map[string]interface{}, bool)
 	RemoveServiceParam(serviceName string, paramName string)
+	SetParamHook(serviceName string, hook ParamHookFunc)
 
 	// Workflow management
 	RegisterWorkflow(wf workflow.Workflow) error
 	AddWorkflowStep(workflowName string, step workflow.WorkflowStep) error
 	ExecuteWorkflow(name string, params map[string]interface{}, result interface{}, opts ...ExecutionOption) error
+	StartWorkflow(name string, params map[string]interface{}, result interface{}, opts ...ExecutionOption) (*workflow.Execution, error)
+	ExecuteWorkflowStreaming(name string, params map[string]interface{}, opts ...ExecutionOption) (<-chan workflow.StepUpdate, error)
+	CancelWorkflow(executionID string) error
 	GetWorkflow(name string) (workflow.Workflow, bool)
 	ListWorkflows() []string
 	SaveWorkflows(filepath string) error
 	LoadWorkflows(filepath string) error
+	MarshalWorkflow(name string) ([]byte, error)
+	RegisterWorkflowJSON(data []byte) error
+	ClearWorkflows()
+	WorkflowStats() workflow.Stats
+	RegisterExpressionFunc(name string, fn workflow.ExpressionFunc)
 }
 
 // ModularAPIService implements the Service interface
@@ -61,32 +118,222 @@ type ModularAPIService struct {
 	templateStore    *template.TemplateStore
 	httpClient       *client.Client
 	streamClient     *client.StreamingClient
-	serviceHeaders   map[string]map[string]string      // Service-level headers
-	serviceParams    map[string]map[string]interface{} // Service-level parameters
-	workflowExecutor *workflow.WorkflowExecutor        // Workflow executor
+	serviceHeaders   map[string]map[string]string           // Service-level headers
+	serviceParams    map[string]map[string]interface{}      // Service-level parameters
+	workflowExecutor *workflow.WorkflowExecutor             // Workflow executor
+	defaultService   string                                 // Service used when an action is called without one
+	tokenRefreshers  map[string]*tokenRefresher             // Token refresh functions per service
+	tokenMu          sync.RWMutex                           // Guards tokens
+	tokens           map[string]string                      // Tokens set at runtime, by environment and service
+	serviceClients   map[string]*serviceClients             // Clients of services using their own transport
+	preprocessors    map[string]client.ResponsePreprocessor // Response preprocessors per service
+	successStatuses  map[string][]client.StatusRange        // Status codes of successful responses per service
+	paramHooks       map[string]ParamHookFunc               // Functions adjusting the parameters of requests per service
+	signers          map[string]requestSigning              // Request signers per service
+	responseTypes    map[string]reflect.Type                // Types responses decode into, by "service.action"
+	userAgent        string                                 // User-Agent of the requests of services without their own
 }
 
-// NewService creates a new modular API service
+// serviceClients are the clients of a service whose requests use their own transport
+type serviceClients struct {
+	httpClient   *client.Client
+	streamClient *client.StreamingClient
+}
+
+// NewService creates a new modular API service. The environment variables referenced by the
+// default parameters of cfg are expanded on a copy of it, logging a warning for each unset one.
 func NewService(cfg *config.Config) Service {
+	service, unsetEnv := newModularAPIService(cfg)
+	for _, name := range unsetEnv {
+		log.GlobalLogger.Warnf("Environment variable %s referenced by default parameters is not set, using an empty value", name)
+	}
+	return service
+}
+
+// newModularAPIService creates the concrete service so the builder can configure its internals.
+// It uses a copy of cfg with the environment variables of default parameters expanded, and
+// returns the sorted names of the unset ones.
+func newModularAPIService(cfg *config.Config) (*ModularAPIService, []string) {
+	cfg, unsetEnv := cfg.ExpandEnv()
+	httpClient := client.NewClient(180 * time.Second) // Default timeout of 3 minutes
 	service := &ModularAPIService{
-		config:         cfg,
-		templateStore:  template.NewTemplateStore(),
-		httpClient:     client.NewClient(180 * time.Second), // Default timeout of 3 minutes
-		streamClient:   client.NewStreamingClient(),
-		serviceHeaders: make(map[string]map[string]string),
-		serviceParams:  make(map[string]map[string]interface{}),
+		config:          cfg,
+		templateStore:   template.NewTemplateStore(),
+		httpClient:      httpClient,
+		streamClient:    client.NewSharedStreamingClient(httpClient),
+		serviceHeaders:  make(map[string]map[string]string),
+		serviceParams:   make(map[string]map[string]interface{}),
+		tokenRefreshers: make(map[string]*tokenRefresher),
+		tokens:          make(map[string]string),
+		preprocessors:   make(map[string]client.ResponsePreprocessor),
+		successStatuses: make(map[string][]client.StatusRange),
+		paramHooks:      make(map[string]ParamHookFunc),
+		signers:         make(map[string]requestSigning),
+		responseTypes:   make(map[string]reflect.Type),
 	}
 
 	// Initialize workflow executor after the service is created
 	service.workflowExecutor = workflow.NewWorkflowExecutor(service)
 
-	return service
+	return service, unsetEnv
 }
 
 // PrepareRequest prepares a request using the template and provided parameters
 func (s *ModularAPIService) PrepareRequest(serviceName, action string, params map[string]interface{}) (*http.Request, error) {
-	tmpl, ok := s.templateStore.GetTemplate(serviceName, action)
-	if !ok {
+	cfg := &requestConfig{}
+	return s.prepareRequest(s.withRequestLogLevel(context.Background(), serviceName, action, cfg), serviceName, action, params, cfg)
+}
+
+// DumpRequest returns the request as it would be sent on the wire: request line, headers and
+// body. It doesn't send the request and leaves its body intact, so it can still be sent with
+// MakeRequest. A streamed body that can't be rewound is read into memory to be dumped.
+func (s *ModularAPIService) DumpRequest(req *http.Request) (string, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("cannot read request body: %w", err)
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+		req.Body, _ = req.GetBody()
+		req.ContentLength = int64(len(data))
+	}
+
+	dumped := req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", fmt.Errorf("cannot read request body: %w", err)
+		}
+		dumped = req.Clone(req.Context())
+		dumped.Body = body
+	}
+
+	dump, err := httputil.DumpRequestOut(dumped, true)
+	if err != nil {
+		return "", fmt.Errorf("cannot dump request: %w", err)
+	}
+
+	// A seekable body shares its reader with the dumped copy, rewind it
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return "", fmt.Errorf("cannot restore request body: %w", err)
+		}
+	}
+	return string(dump), nil
+}
+
+// urlPlaceholderPattern matches {{name}} and {{name?}} placeholders inside a service URL
+var urlPlaceholderPattern = regexp.MustCompile(`\{\{(\w+)(\?)?\}\}`)
+
+// urlHostValuePattern matches the values allowed for a placeholder in the host of a service URL:
+// dot-separated host labels, optionally followed by a port
+var urlHostValuePattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*(:[0-9]+)?$`)
+
+// resolveServiceURL replaces the placeholders of a service URL with the request parameters and
+// appends the endpoint. A missing required placeholder is an error. A missing optional one is
+// removed, along with its dot-separated label when it makes up a whole label of the host.
+// Host values must be host labels, path and query values are escaped.
+func resolveServiceURL(apiURL, endpoint string, params map[string]interface{}) (string, error) {
+	// Split the URL around its host, which follows the scheme and ends with the path. The
+	// placeholders are masked so the "?" of optional ones isn't taken for the query.
+	masked := urlPlaceholderPattern.ReplaceAllStringFunc(apiURL, func(placeholder string) string {
+		return strings.Repeat("x", len(placeholder))
+	})
+	hostStart := 0
+	if i := strings.Index(masked, "://"); i >= 0 {
+		hostStart = i + len("://")
+	}
+	hostEnd := len(apiURL)
+	if i := strings.IndexAny(masked[hostStart:], "/?#"); i >= 0 {
+		hostEnd = hostStart + i
+	}
+
+	labels := strings.Split(apiURL[hostStart:hostEnd], ".")
+	host := make([]string, 0, len(labels))
+	for _, label := range labels {
+		resolved, err := resolveURLPlaceholders(label, params, func(value string) (string, error) {
+			if !urlHostValuePattern.MatchString(value) {
+				return "", fmt.Errorf("%q is not a valid host", value)
+			}
+			return value, nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if resolved != "" || label == "" {
+			host = append(host, resolved)
+		}
+	}
+
+	path, query, hasQuery := apiURL[hostEnd:], "", false
+	if i := strings.Index(masked[hostEnd:], "?"); i >= 0 {
+		path, query, hasQuery = apiURL[hostEnd:hostEnd+i], apiURL[hostEnd+i+1:], true
+	}
+	path, err := resolveURLPlaceholders(path, params, escapeURLValue(url.PathEscape))
+	if err != nil {
+		return "", err
+	}
+	if hasQuery {
+		if query, err = resolveURLPlaceholders(query, params, escapeURLValue(url.QueryEscape)); err != nil {
+			return "", err
+		}
+		path += "?" + query
+	}
+	resolved := apiURL[:hostStart] + strings.Join(host, ".") + path
+
+	// Avoid a double slash between a base URL ending with "/" and the endpoint
+	if strings.HasSuffix(resolved, "/") && strings.HasPrefix(endpoint, "/") {
+		resolved = strings.TrimSuffix(resolved, "/")
+	}
+	return resolved + endpoint, nil
+}
+
+// resolveURLPlaceholders replaces the placeholders of part of a service URL with the request
+// parameters, formatted by value. A missing required placeholder is an error, a missing optional
+// one is replaced with an empty string.
+func resolveURLPlaceholders(part string, params map[string]interface{}, value func(string) (string, error)) (string, error) {
+	var err error
+	resolved := urlPlaceholderPattern.ReplaceAllStringFunc(part, func(placeholder string) string {
+		match := urlPlaceholderPattern.FindStringSubmatch(placeholder)
+		param, ok := params[match[1]]
+		if !ok || param == nil {
+			if match[2] == "" && err == nil {
+				err = fmt.Errorf("missing required service URL parameter: %s", match[1])
+			}
+			return ""
+		}
+		formatted, valueErr := value(fmt.Sprintf("%v", param))
+		if valueErr != nil && err == nil {
+			err = fmt.Errorf("invalid service URL parameter %s: %w", match[1], valueErr)
+		}
+		return formatted
+	})
+	return resolved, err
+}
+
+// escapeURLValue adapts an escaping function to resolveURLPlaceholders
+func escapeURLValue(escape func(string) string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		return escape(value), nil
+	}
+}
+
+// methodAllowsBody reports whether a request body is meaningful for an HTTP method
+func methodAllowsBody(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// ResolveParams returns the parameters a request for the action would use, after merging the
+// service default parameters, the global service parameters and params, without sending it
+func (s *ModularAPIService) ResolveParams(serviceName, action string, params map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := s.templateStore.GetTemplate(serviceName, action); !ok {
 		return nil, fmt.Errorf("no template found for action: %s in service %s", action, serviceName)
 	}
 
@@ -95,9 +342,11 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 		return nil, fmt.Errorf("no configuration found for service: %s", serviceName)
 	}
 
-	log.GlobalLogger.Infof("Preparing request from template: %s %s for action %s.%s\n", tmpl.Method, tmpl.Endpoint, serviceName, action)
+	return s.mergeParams(serviceName, cfg, params), nil
+}
 
-	// Prepare all parameters in the correct order of precedence:
+// mergeParams merges the parameters of a request in the correct order of precedence
+func (s *ModularAPIService) mergeParams(serviceName string, cfg config.ApiConfig, params map[string]interface{}) map[string]interface{} {
 	// 1. First add default parameters from service configuration
 	mergedParams := make(map[string]interface{})
 	if cfg.DefaultParams != nil {
@@ -118,12 +367,58 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 		mergedParams[k] = v
 	}
 
+	return mergedParams
+}
+
+// prepareRequest prepares a request, applying the per-request options. It logs at the level
+// carried by ctx, see withRequestLogLevel.
+func (s *ModularAPIService) prepareRequest(ctx context.Context, serviceName, action string, params map[string]interface{}, reqCfg *requestConfig) (*http.Request, error) {
+	logger := log.FromContext(ctx)
+	tmpl, ok := s.templateStore.GetTemplate(serviceName, action)
+	if !ok {
+		return nil, fmt.Errorf("no template found for action: %s in service %s", action, serviceName)
+	}
+
+	cfg, ok := s.config.GetServiceConfig(serviceName)
+	if !ok {
+		return nil, fmt.Errorf("no configuration found for service: %s", serviceName)
+	}
+	token := s.serviceToken(serviceName, cfg)
+	if reqCfg.fallback != nil {
+		// A fallback endpoint uses its own URL and token
+		cfg = fallbackConfig(cfg, *reqCfg.fallback)
+		token = cfg.ApiToken
+	}
+
+	// The template method is the default, a request option can override it
+	method := tmpl.Method
+	if reqCfg.Method != "" {
+		method = strings.ToUpper(reqCfg.Method)
+	}
+
+	logger.Infof("Preparing request from template: %s %s for action %s.%s\n", method, tmpl.Endpoint, serviceName, action)
+
+	mergedParams := s.mergeParams(serviceName, cfg, params)
+
+	// Convert string inputs, such as CLI or query values, to the declared parameter types
+	if err := tmpl.CoerceParams(mergedParams); err != nil {
+		return nil, err
+	}
+
+	// The hook sees the final parameters and can adjust them
+	if hook := s.paramHooks[serviceName]; hook != nil {
+		if err := hook(serviceName, action, mergedParams); err != nil {
+			return nil, fmt.Errorf("parameter hook failed for %s.%s: %w", serviceName, action, err)
+		}
+	}
+
 	// Log the final merged parameters for debugging
 	debugParamsJson, _ := json.MarshalIndent(mergedParams, "", "  ")
-	log.GlobalLogger.Infof("Merged parameters: %s", string(debugParamsJson))
+	logger.Infof("Merged parameters: %s", string(debugParamsJson))
 
-	// Build the URL with path parameters
-	endpoint := tmpl.Endpoint
+	// Build the URL with path parameters, the query string of the endpoint is added with the
+	// query parameters
+	endpoint := tmpl.EndpointPath()
 	for _, pathParam := range tmpl.PathParams {
 		// Check for both regular and optional placeholders for this param
 		regularPlaceholder := "{{" + pathParam + "}}"
@@ -133,35 +428,39 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 			// Replace both regular and optional placeholders with the value
 			endpoint = strings.ReplaceAll(endpoint, regularPlaceholder, fmt.Sprintf("%v", value))
 			endpoint = strings.ReplaceAll(endpoint, optionalPlaceholder, fmt.Sprintf("%v", value))
-		} else if strings.Contains(endpoint, optionalPlaceholder) {
-			// Handle optional path parameters that aren't provided
-			// We need to remove the entire segment from the URL path
+		} else if strings.Contains(endpoint, optionalPlaceholder) || tmpl.OptionalParams[pathParam] {
+			// Handle optional path parameters that aren't provided, marked with a "?" suffix
+			// or in our map. We need to remove the entire segment from the URL path
 			parts := strings.Split(endpoint, "/")
 			for i, part := range parts {
-				if part == optionalPlaceholder {
+				if part == optionalPlaceholder || part == regularPlaceholder {
 					// Remove this segment
 					parts = append(parts[:i], parts[i+1:]...)
 					break
 				}
 			}
 			endpoint = strings.Join(parts, "/")
-		} else if tmpl.OptionalParams[pathParam] {
-			// If parameter is marked as optional in our map, we can skip it
-			continue
 		} else {
 			return nil, fmt.Errorf("missing required path parameter: %s", pathParam)
 		}
 	}
 
-	url := cfg.ApiURL + endpoint
+	// Resolve host-level placeholders, such as a region or tenant subdomain, in the service URL
+	url, err := resolveServiceURL(cfg.ApiURL, endpoint, mergedParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// A reader passed as the body parameter replaces the template body
+	bodyReader, hasBodyReader := mergedParams[BodyReaderParam].(io.Reader)
 
 	// Prepare request body if template has one
 	var processedBody map[string]interface{}
-	if tmpl.Body != nil {
+	if tmpl.Body != nil && !hasBodyReader {
 		// Process body template values
 		processedBody = make(map[string]interface{})
 		for key, value := range tmpl.Body {
-			if processedValue, valid := template.ProcessTemplateValue(value, mergedParams, tmpl.OptionalParams); valid {
+			if processedValue, valid := tmpl.ProcessValue(value, mergedParams); valid {
 				processedBody[key] = processedValue
 			} else {
 				// Check if this is an optional parameter
@@ -176,42 +475,78 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 			}
 		}
 
+		// Drop the fields left empty when the template or the service asks for it
+		if tmpl.OmitEmpty || cfg.OmitEmpty {
+			processedBody = template.OmitEmptyFields(processedBody)
+		}
+
 		// Only include the body if we have parameters to send
 		if len(processedBody) > 0 {
 			// For debugging purposes only
 			debugJson, _ := json.MarshalIndent(processedBody, "", "  ")
-			log.GlobalLogger.Infof("Request body (debug): %s", string(debugJson))
+			logger.Infof("Request body (debug): %s", string(debugJson))
 		}
 	}
 
+	// An overriding method that doesn't take a body must not send the template body
+	if reqCfg.Method != "" && !methodAllowsBody(method) && (hasBodyReader || len(processedBody) > 0) {
+		logger.Warnf("Dropping request body for action %s.%s: method %s does not allow a body", serviceName, action, method)
+		hasBodyReader = false
+		processedBody = nil
+	}
+
 	// Create the request with the properly formatted JSON body
 	var req *http.Request
-	var err error
 
-	if len(processedBody) > 0 {
+	if hasBodyReader {
+		logger.Infof("Using streamed request body for action %s.%s", serviceName, action)
+		req, err = http.NewRequest(method, url, bodyReader)
+		if err == nil && req.GetBody == nil {
+			// Seekable readers can be rewound so the request can be sent again
+			if seeker, ok := bodyReader.(io.ReadSeeker); ok {
+				req.GetBody = func() (io.ReadCloser, error) {
+					if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+						return nil, err
+					}
+					return io.NopCloser(seeker), nil
+				}
+			}
+		}
+	} else if len(processedBody) > 0 {
 		// Use json.MarshalIndent to create a clean, formatted JSON string
 		formattedJSON, err := json.MarshalIndent(processedBody, "", "  ")
 		if err != nil {
-			log.GlobalLogger.Errorf("Failed to marshal request body: %v", err)
+			logger.Errorf("Failed to marshal request body: %v", err)
 			return nil, err
 		}
 
 		// Log the exact JSON that will be sent
-		log.GlobalLogger.Infof("Raw JSON body to be sent: %s", string(formattedJSON))
+		logger.Infof("Raw JSON body to be sent: %s", string(formattedJSON))
 
 		// Create the request with the formatted JSON
-		req, err = http.NewRequest(tmpl.Method, url, bytes.NewReader(formattedJSON))
+		req, err = http.NewRequest(method, url, bytes.NewReader(formattedJSON))
 	} else {
 		// Create request without body
-		req, err = http.NewRequest(tmpl.Method, url, nil)
+		req, err = http.NewRequest(method, url, nil)
 	}
 
 	if err != nil {
-		log.GlobalLogger.Errorf("Failed to create request: %v", err)
+		logger.Errorf("Failed to create request: %v", err)
 		return nil, err
 	}
 
 	// Add headers in the following order:
+	// 0. Content type of a JSON body built from the template, whatever the method (a few APIs,
+	// such as Elasticsearch, expect a body on GET), and user agent of the service, or of every service
+	if len(processedBody) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if userAgent := cfg.UserAgent; userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	} else if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+
 	// 1. Global headers for the service
 	if globalHeaders, ok := s.serviceHeaders[serviceName]; ok {
 		for key, value := range globalHeaders {
@@ -221,19 +556,37 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 
 	// 2. Route-specific headers (can override global headers)
 	for key, value := range tmpl.Headers {
-		req.Header.Set(key, value)
+		processedValue, valid := tmpl.ProcessValue(value, mergedParams)
+		if !valid {
+			// Optional headers whose parameter isn't provided are not sent at all
+			paramName := strings.TrimPrefix(strings.TrimSuffix(value, "}}"), "{{")
+			if strings.HasSuffix(paramName, "?") || tmpl.OptionalParams[paramName] {
+				continue
+			}
+
+			return nil, fmt.Errorf("missing required header parameter: %s", key)
+		}
+		if processedValue == nil {
+			// An explicit null is sent as an empty header value
+			processedValue = ""
+		}
+		req.Header.Set(key, fmt.Sprintf("%v", processedValue))
 	}
 
 	// 3. Authorization header if token is provided
-	if cfg.ApiToken != "" {
-		req.Header.Set("Authorization", "Bearer "+cfg.ApiToken)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	// Process query parameters from template only
-	if tmpl.QueryParams != nil {
+	if query := tmpl.Query(); query != nil {
 		q := req.URL.Query()
-		for key, value := range tmpl.QueryParams {
-			if processedValue, valid := template.ProcessTemplateValue(value, mergedParams, tmpl.OptionalParams); valid {
+		for key, value := range query {
+			if processedValue, valid := tmpl.ProcessValue(value, mergedParams); valid {
+				if processedValue == nil {
+					// An explicit null is sent as an empty query value
+					processedValue = ""
+				}
 				q.Set(key, fmt.Sprintf("%v", processedValue))
 			} else {
 				// Check if this is an optional parameter
@@ -253,7 +606,52 @@ func (s *ModularAPIService) PrepareRequest(serviceName, action string, params ma
 	return req, nil
 }
 
-// MakeRequest performs an HTTP request and unmarshals the response into the result
+// setServiceTransport makes the requests of a service use their own transport, for example to
+// route them through a proxy, along with the cookie jar shared by the service
+func (s *ModularAPIService) setServiceTransport(serviceName string, transport http.RoundTripper, jar http.CookieJar) {
+	httpClient := client.NewClient(180 * time.Second) // Same default timeout as the service client
+	httpClient.SetTransport(transport)
+	if jar != nil {
+		httpClient.SetCookieJar(jar)
+	}
+	clients := &serviceClients{
+		httpClient:   httpClient,
+		streamClient: client.NewSharedStreamingClient(httpClient),
+	}
+
+	if s.serviceClients == nil {
+		s.serviceClients = make(map[string]*serviceClients)
+	}
+	s.serviceClients[serviceName] = clients
+}
+
+// setRetryPolicy sets the retries of the regular requests of every service
+func (s *ModularAPIService) setRetryPolicy(policy *client.RetryPolicy) {
+	s.httpClient.SetRetryPolicy(policy)
+	for _, clients := range s.serviceClients {
+		clients.httpClient.SetRetryPolicy(policy)
+	}
+}
+
+// setUseNumber makes the clients of every service decode JSON numbers into json.Number
+func (s *ModularAPIService) setUseNumber(useNumber bool) {
+	s.httpClient.SetUseNumber(useNumber)
+	for _, clients := range s.serviceClients {
+		clients.httpClient.SetUseNumber(useNumber)
+	}
+}
+
+// clientsFor returns the clients performing the requests of a service
+func (s *ModularAPIService) clientsFor(serviceName string) (*client.Client, *client.StreamingClient) {
+	if clients, ok := s.serviceClients[serviceName]; ok {
+		return clients.httpClient, clients.streamClient
+	}
+	return s.httpClient, s.streamClient
+}
+
+// MakeRequest performs an HTTP request and unmarshals the response into the result.
+// It uses the service-wide client: transports set for a single service, such as a proxy,
+// only apply to requests performed by service name.
 func (s *ModularAPIService) MakeRequest(req *http.Request, result interface{}) error {
 	return s.httpClient.MakeRequest(req, result)
 }
@@ -265,47 +663,126 @@ func (s *ModularAPIService) MakeStreamingRequest(req *http.Request, w http.Respo
 
 // PerformRequest combines PrepareRequest and MakeRequest into a single function
 func (s *ModularAPIService) PerformRequest(serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error {
+	return s.PerformRequestContext(context.Background(), serviceName, action, params, result, opts...)
+}
+
+// PerformRequestContext is PerformRequest bound to ctx: the request is aborted when ctx is done
+func (s *ModularAPIService) PerformRequestContext(ctx context.Context, serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error {
 	// Process request options
 	cfg := &requestConfig{}
 	for _, opt := range opts {
 		opt(cfg)
 	}
 
-	// Set log level if provided
-	if cfg.LogLevel != nil {
-		// Store the original log level to restore later
-		var originalLogLevel log.LogLevel
-		if logger, ok := log.GlobalLogger.(*log.DefaultLogger); ok {
-			originalLogLevel = logger.GetLogLevel()
-			log.SetLogLevel(*cfg.LogLevel)
-			// Defer restoring the original log level
-			defer log.SetLogLevel(originalLogLevel)
-		}
-	}
+	// Log the request at its own level, if any, through its context
+	ctx = s.withRequestLogLevel(ctx, serviceName, action, cfg)
 
-	req, err := s.PrepareRequest(serviceName, action, params)
+	_, _, err := s.sendRequest(ctx, serviceName, action, params, result, cfg)
+	return err
+}
+
+// sendRequest prepares and sends a request, retrying it with a refreshed token after a 401 and
+// failing over to the fallbacks of the service. It returns the request that was answered, with
+// its response, whose body has been decoded into result.
+func (s *ModularAPIService) sendRequest(ctx context.Context, serviceName, action string, params map[string]interface{}, result interface{}, cfg *requestConfig) (*http.Request, *http.Response, error) {
+	req, err := s.prepareRequest(ctx, serviceName, action, params, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	ctx, err = s.requestContext(ctx, serviceName, action)
 	if err != nil {
-		return fmt.Errorf("failed to prepare request: %w", err)
+		return nil, nil, fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	if cfg.onPrepared != nil {
+		cfg.onPrepared(req)
 	}
 
-	err = s.MakeRequest(req, result)
+	httpClient, _ := s.clientsFor(serviceName)
+	resp, err := httpClient.MakeRequestWithResponse(req, result)
 	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+		req, resp, err = s.retryWithRefreshedToken(serviceName, req, result, err)
+	}
+	if err != nil {
+		req, resp, err = s.failOver(ctx, serviceName, action, params, result, cfg, req, err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
-	return nil
+	return req, resp, nil
+}
+
+// Perform performs a request for an action of the default service set with SetDefaultService.
+// It returns ErrNoDefaultService if no default service is set.
+func (s *ModularAPIService) Perform(action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error {
+	if s.defaultService == "" {
+		return ErrNoDefaultService
+	}
+	return s.PerformRequest(s.defaultService, action, params, result, opts...)
+}
+
+// PerformRequestWithOptions performs a request with per-request options such as WithRequestLogLevel
+// or WithMethod. The log level applies to the logs of this request only, the global level is left
+// unchanged.
+func (s *ModularAPIService) PerformRequestWithOptions(serviceName, action string, params map[string]interface{}, result interface{}, opts ...RequestOption) error {
+	return s.PerformRequest(serviceName, action, params, result, opts...)
 }
 
 // PerformStreamingRequest performs a streaming request using the template and parameters
 func (s *ModularAPIService) PerformStreamingRequest(serviceName, action string, params map[string]interface{}, w http.ResponseWriter) (string, error) {
-	req, err := s.PrepareRequest(serviceName, action, params)
+	return s.PerformStreamingRequestContext(context.Background(), serviceName, action, params, w)
+}
+
+// PerformStreamingRequestContext performs a streaming request that stops when ctx is done, such as
+// the request context of an HTTP handler whose client disconnected. On cancellation, the response
+// streamed so far is returned along with an error wrapping the context error.
+func (s *ModularAPIService) PerformStreamingRequestContext(ctx context.Context, serviceName, action string, params map[string]interface{}, w http.ResponseWriter) (string, error) {
+	_, streamClient := s.clientsFor(serviceName)
+	return s.performStreamingRequest(ctx, serviceName, action, params, w, &requestConfig{}, streamClient.MakeStreamingRequestContext)
+}
+
+// PerformResumableStreamingRequest is PerformStreamingRequestContext for idempotent SSE
+// endpoints: when the stream drops before its end, it reconnects up to maxReconnects times with
+// the ID of the last event received in the Last-Event-ID header, and keeps writing to w
+func (s *ModularAPIService) PerformResumableStreamingRequest(ctx context.Context, serviceName, action string, params map[string]interface{}, w http.ResponseWriter, maxReconnects int) (string, error) {
+	_, streamClient := s.clientsFor(serviceName)
+	return s.performStreamingRequest(ctx, serviceName, action, params, w, &requestConfig{},
+		func(ctx context.Context, req *http.Request, w http.ResponseWriter) (string, error) {
+			return streamClient.MakeResumableStreamingRequest(ctx, req, w, maxReconnects)
+		})
+}
+
+// performStreamingRequest prepares a streaming request with the per-request options of cfg and
+// performs it with stream, retrying it once with a refreshed token when it is rejected with a 401
+func (s *ModularAPIService) performStreamingRequest(ctx context.Context, serviceName, action string, params map[string]interface{}, w http.ResponseWriter,
+	cfg *requestConfig, stream func(ctx context.Context, req *http.Request, w http.ResponseWriter) (string, error)) (string, error) {
+	ctx = s.withRequestLogLevel(ctx, serviceName, action, cfg)
+
+	req, err := s.prepareRequest(ctx, serviceName, action, params, cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare streaming request: %w", err)
+	}
+	if cfg.onPrepared != nil {
+		cfg.onPrepared(req)
+	}
+
+	ctx, err = s.requestContext(ctx, serviceName, action)
 	if err != nil {
 		return "", fmt.Errorf("failed to prepare streaming request: %w", err)
 	}
 
-	response, err := s.MakeStreamingRequest(req, w)
+	response, err := stream(ctx, req, w)
+	if err != nil {
+		// A rejected token fails the request before anything is streamed, so it can be retried
+		if retryReq, retryErr := s.refreshedTokenRequest(serviceName, req, err); retryReq != nil {
+			response, err = stream(ctx, retryReq, w)
+		} else {
+			err = retryErr
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to make streaming request: %w", err)
+		return response, fmt.Errorf("failed to make streaming request: %w", err)
 	}
 
 	return response, nil
@@ -326,6 +803,26 @@ func (s *ModularAPIService) LoadTemplates(filepath string) error {
 	return s.templateStore.LoadFromFile(filepath)
 }
 
+// MergeTemplates copies the templates of store into the service, replacing the templates
+// for the same service and action, for example to layer overrides on a shared base
+func (s *ModularAPIService) MergeTemplates(store *template.TemplateStore) {
+	s.templateStore.Merge(store)
+}
+
+// ClearTemplates removes every template, for example before reloading them with LoadTemplates
+func (s *ModularAPIService) ClearTemplates() {
+	s.templateStore.Clear()
+}
+
+// ExportOpenAPI generates an OpenAPI 3 document describing all registered templates
+func (s *ModularAPIService) ExportOpenAPI() ([]byte, error) {
+	serverURLs := make(map[string]string)
+	for name, cfg := range s.config.ActiveServices() {
+		serverURLs[name] = cfg.ApiURL
+	}
+	return s.templateStore.ExportOpenAPI(serverURLs)
+}
+
 // GetServiceURL returns the URL for a specific service
 func (s *ModularAPIService) GetServiceURL(serviceName string) string {
 	if cfg, ok := s.config.GetServiceConfig(serviceName); ok {
@@ -345,11 +842,27 @@ func (s *ModularAPIService) SetServiceURL(serviceName, url string) {
 // GetServiceToken returns the token for a specific service
 func (s *ModularAPIService) GetServiceToken(serviceName string) string {
 	if cfg, ok := s.config.GetServiceConfig(serviceName); ok {
-		return cfg.ApiToken
+		return s.serviceToken(serviceName, cfg)
 	}
 	return ""
 }
 
+// UseEnvironment switches the service configurations to the given environment
+func (s *ModularAPIService) UseEnvironment(name string) error {
+	return s.config.UseEnvironment(name)
+}
+
+// SetDefaultService sets the service used by Perform and by ExecuteRequestWithParams when the
+// template ID is a bare action. An empty name unsets it.
+func (s *ModularAPIService) SetDefaultService(serviceName string) {
+	s.defaultService = serviceName
+}
+
+// GetDefaultService returns the default service, or an empty string if none is set
+func (s *ModularAPIService) GetDefaultService() string {
+	return s.defaultService
+}
+
 // SetServiceHeaders sets global headers for a specific service
 func (s *ModularAPIService) SetServiceHeaders(serviceName string, headers map[string]string) {
 	if s.serviceHeaders[serviceName] == nil {
@@ -373,6 +886,20 @@ func (s *ModularAPIService) GetServiceHeaders(serviceName string) map[string]str
 	return nil
 }
 
+// LookupServiceHeaders returns a copy of the global headers of a service and whether the service
+// is known. A known service without headers returns an empty map and true, while an unknown
+// service returns nil and false.
+func (s *ModularAPIService) LookupServiceHeaders(serviceName string) (map[string]string, bool) {
+	if !s.serviceKnown(serviceName) {
+		return nil, false
+	}
+	headers := s.GetServiceHeaders(serviceName)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	return headers, true
+}
+
 // RemoveServiceHeader removes a global header from a service
 func (s *ModularAPIService) RemoveServiceHeader(serviceName string, headerName string) {
 	if headers, ok := s.serviceHeaders[serviceName]; ok {
@@ -403,6 +930,30 @@ func (s *ModularAPIService) GetServiceParams(serviceName string) map[string]inte
 	return nil
 }
 
+// LookupServiceParams returns a copy of the global parameters of a service and whether the
+// service is known. A known service without parameters returns an empty map and true, while an
+// unknown service returns nil and false.
+func (s *ModularAPIService) LookupServiceParams(serviceName string) (map[string]interface{}, bool) {
+	if !s.serviceKnown(serviceName) {
+		return nil, false
+	}
+	params := s.GetServiceParams(serviceName)
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	return params, true
+}
+
+// serviceKnown reports whether a service is configured or has global headers or parameters
+func (s *ModularAPIService) serviceKnown(serviceName string) bool {
+	if _, ok := s.config.GetServiceConfig(serviceName); ok {
+		return true
+	}
+	_, hasHeaders := s.serviceHeaders[serviceName]
+	_, hasParams := s.serviceParams[serviceName]
+	return hasHeaders || hasParams
+}
+
 // RemoveServiceParam removes a global parameter from a service
 func (s *ModularAPIService) RemoveServiceParam(serviceName string, paramName string) {
 	if params, ok := s.serviceParams[serviceName]; ok {
@@ -410,10 +961,31 @@ func (s *ModularAPIService) RemoveServiceParam(serviceName string, paramName str
 	}
 }
 
+// ParamHookFunc adjusts the parameters of a request before it is built, for example to add a
+// timestamp or a signature. An error aborts the request.
+type ParamHookFunc func(serviceName, action string, params map[string]interface{}) error
+
+// SetParamHook sets the function called with the parameters of each request to the service,
+// once the default, global and request parameters are merged, right before the request is
+// built (nil removes it). The hook can add, modify or delete parameters.
+func (s *ModularAPIService) SetParamHook(serviceName string, hook ParamHookFunc) {
+	if hook == nil {
+		delete(s.paramHooks, serviceName)
+		return
+	}
+	s.paramHooks[serviceName] = hook
+}
+
 // ExecuteRequestWithParams is a helper method for executing a request with parameters
 func (s *ModularAPIService) ExecuteRequestWithParams(templateID string, params map[string]interface{}) (json.RawMessage, error) {
-	// Split template ID into service and action
+	// Split template ID into service and action, a bare action uses the default service
 	parts := workflow.SplitTemplateID(templateID)
+	if len(parts) == 1 && parts[0] != "" {
+		if s.defaultService == "" {
+			return nil, ErrNoDefaultService
+		}
+		parts = []string{s.defaultService, parts[0]}
+	}
 	if len(parts) != 2 {
 		return nil, workflow.ErrInvalidTemplateID
 	}
@@ -457,6 +1029,108 @@ func (s *ModularAPIService) AddWorkflowStep(workflowName string, step workflow.W
 	return s.RegisterWorkflow(existingWorkflow)
 }
 
+// requestLogLevel returns the log level of a request: the level of the request options if set,
+// otherwise debug for a verbose template, and nil to keep the global level
+func (s *ModularAPIService) requestLogLevel(serviceName, action string, cfg *requestConfig) *log.LogLevel {
+	if cfg.LogLevel != nil {
+		return cfg.LogLevel
+	}
+	if tmpl, ok := s.templateStore.GetTemplate(serviceName, action); ok && tmpl.Verbose {
+		level := log.DEBUG
+		return &level
+	}
+	return nil
+}
+
+// SetResponsePreprocessor sets the function transforming the response bodies of a service
+// before they are decoded (nil removes it). A template's own preprocessor takes precedence.
+func (s *ModularAPIService) SetResponsePreprocessor(serviceName string, preprocessor client.ResponsePreprocessor) {
+	if preprocessor == nil {
+		delete(s.preprocessors, serviceName)
+		return
+	}
+	s.preprocessors[serviceName] = preprocessor
+}
+
+// SetSuccessStatuses sets the status codes of the successful responses of a service, such as
+// "201", "200-299" or "3xx" (none restores the default 2xx). Responses with other status codes
+// fail with a client.APIError. A template's own success statuses take precedence.
+func (s *ModularAPIService) SetSuccessStatuses(serviceName string, statuses ...string) error {
+	ranges, err := client.ParseStatusRanges(statuses...)
+	if err != nil {
+		return fmt.Errorf("invalid success statuses for service %s: %w", serviceName, err)
+	}
+	if len(ranges) == 0 {
+		delete(s.successStatuses, serviceName)
+		return nil
+	}
+	s.successStatuses[serviceName] = ranges
+	return nil
+}
+
+// requestSigning is the signer of a service along with its secret
+type requestSigning struct {
+	signer client.RequestSigner
+	secret string
+}
+
+// SetRequestSigner sets the signer of the requests to a service, called with secret right
+// before each request is sent, retries included (nil removes it)
+func (s *ModularAPIService) SetRequestSigner(serviceName string, signer client.RequestSigner, secret string) {
+	if signer == nil {
+		delete(s.signers, serviceName)
+		return
+	}
+	s.signers[serviceName] = requestSigning{signer: signer, secret: secret}
+}
+
+// signingContext returns ctx carrying the signer of a service, if any
+func (s *ModularAPIService) signingContext(ctx context.Context, serviceName string) context.Context {
+	if signing, ok := s.signers[serviceName]; ok {
+		return client.WithRequestSigner(ctx, signing.signer, signing.secret)
+	}
+	return ctx
+}
+
+// requestContext returns ctx carrying how the requests of an action are signed and their
+// responses handled: their preprocessor and success statuses, set on its template or its service
+func (s *ModularAPIService) requestContext(ctx context.Context, serviceName, action string) (context.Context, error) {
+	ctx = s.signingContext(ctx, serviceName)
+
+	tmpl, _ := s.templateStore.GetTemplate(serviceName, action)
+	preprocessor := s.preprocessors[serviceName]
+	if tmpl.ResponsePreprocessor != nil {
+		preprocessor = tmpl.ResponsePreprocessor
+	}
+	if preprocessor != nil {
+		ctx = client.WithResponsePreprocessor(ctx, preprocessor)
+	}
+
+	successStatuses := s.successStatuses[serviceName]
+	if len(tmpl.SuccessStatuses) > 0 {
+		ranges, err := client.ParseStatusRanges(tmpl.SuccessStatuses...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid success statuses for %s.%s: %w", serviceName, action, err)
+		}
+		successStatuses = ranges
+	}
+	if len(successStatuses) > 0 {
+		ctx = client.WithSuccessStatuses(ctx, successStatuses)
+	}
+	return ctx, nil
+}
+
+// withRequestLogLevel returns ctx carrying the log level of a request, see requestLogLevel,
+// so the request and its client log at that level without changing the global one. Without a
+// level of its own, the request keeps the level already carried by ctx, such as the level of
+// its workflow execution.
+func (s *ModularAPIService) withRequestLogLevel(ctx context.Context, serviceName, action string, cfg *requestConfig) context.Context {
+	if level := s.requestLogLevel(serviceName, action, cfg); level != nil {
+		return log.ContextWithLevel(ctx, *level)
+	}
+	return ctx
+}
+
 // ExecuteWorkflow executes a workflow with the given parameters and options
 // If result is not nil, the response from the last step will be unmarshaled into it
 func (s *ModularAPIService) ExecuteWorkflow(name string, params map[string]interface{}, result interface{}, opts ...ExecutionOption) error {
@@ -466,29 +1140,64 @@ func (s *ModularAPIService) ExecuteWorkflow(name string, params map[string]inter
 		opt(cfg)
 	}
 
-	// Set log level if provided
-	if cfg.LogLevel != nil {
-		// Store the original log level to restore later
-		var originalLogLevel log.LogLevel
-		if logger, ok := log.GlobalLogger.(*log.DefaultLogger); ok {
-			originalLogLevel = logger.GetLogLevel()
-			log.SetLogLevel(*cfg.LogLevel)
-			// Defer restoring the original log level
-			defer log.SetLogLevel(originalLogLevel)
-		}
-	}
-
 	// Execute the workflow
-	workflowVars, err := s.workflowExecutor.ExecuteWorkflow(name, params, result)
+	workflowVars, err := s.workflowExecutor.ExecuteWorkflow(name, params, result, cfg.workflowOptions()...)
 
-	// If workflow vars option was provided, populate it
-	if err == nil && cfg.WorkflowVars != nil {
+	// If workflow vars option was provided, populate it, including with the partial variables of a failed workflow
+	if workflowVars != nil && cfg.WorkflowVars != nil {
 		*cfg.WorkflowVars = workflowVars
 	}
 
 	return err
 }
 
+// StartWorkflow starts a workflow in the background and returns its execution, whose ID can
+// be passed to CancelWorkflow. Execution.Wait returns the workflow variables and error, and
+// the variables are also stored with WithWorkflowVars before Wait returns.
+func (s *ModularAPIService) StartWorkflow(name string, params map[string]interface{}, result interface{}, opts ...ExecutionOption) (*workflow.Execution, error) {
+	cfg := &executionConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	workflowOpts := append(cfg.workflowOptions(), workflow.WithCompletionHook(func(workflowVars map[string]interface{}, err error) {
+		if workflowVars != nil && cfg.WorkflowVars != nil {
+			*cfg.WorkflowVars = workflowVars
+		}
+	}))
+
+	execution, err := s.workflowExecutor.StartWorkflow(name, params, result, workflowOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+// ExecuteWorkflowStreaming starts a workflow in the background and returns a channel receiving
+// the start and result of each step, then a final workflow.WorkflowFinished update before it is
+// closed. The execution doesn't wait for the caller, which can abandon the channel, see
+// workflow.WorkflowExecutor.ExecuteWorkflowStreaming.
+func (s *ModularAPIService) ExecuteWorkflowStreaming(name string, params map[string]interface{}, opts ...ExecutionOption) (<-chan workflow.StepUpdate, error) {
+	cfg := &executionConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	workflowOpts := append(cfg.workflowOptions(), workflow.WithCompletionHook(func(workflowVars map[string]interface{}, err error) {
+		if workflowVars != nil && cfg.WorkflowVars != nil {
+			*cfg.WorkflowVars = workflowVars
+		}
+	}))
+
+	return s.workflowExecutor.ExecuteWorkflowStreaming(name, params, workflowOpts...)
+}
+
+// CancelWorkflow cancels a running execution started with StartWorkflow. It returns an error
+// wrapping workflow.ErrExecutionNotFound if the execution is unknown or has already finished.
+func (s *ModularAPIService) CancelWorkflow(executionID string) error {
+	return s.workflowExecutor.CancelWorkflow(executionID)
+}
+
 // GetWorkflow returns a workflow by name
 func (s *ModularAPIService) GetWorkflow(name string) (workflow.Workflow, bool) {
 	return s.workflowExecutor.GetWorkflow(name)
@@ -499,6 +1208,23 @@ func (s *ModularAPIService) ListWorkflows() []string {
 	return s.workflowExecutor.ListWorkflows()
 }
 
+// ClearWorkflows removes every workflow, for example before reloading them with LoadWorkflows.
+// Running executions are not affected.
+func (s *ModularAPIService) ClearWorkflows() {
+	s.workflowExecutor.Clear()
+}
+
+// WorkflowStats returns the number of workflow executions and their outcome, along with the
+// outcome and average duration of each step that ran
+func (s *ModularAPIService) WorkflowStats() workflow.Stats {
+	return s.workflowExecutor.Stats()
+}
+
+// RegisterExpressionFunc registers a custom function callable from workflow expressions
+func (s *ModularAPIService) RegisterExpressionFunc(name string, fn workflow.ExpressionFunc) {
+	s.workflowExecutor.RegisterExpressionFunc(name, fn)
+}
+
 // SaveWorkflows saves all workflows to a file
 func (s *ModularAPIService) SaveWorkflows(filepath string) error {
 	return s.workflowExecutor.SaveWorkflows(filepath)
@@ -508,3 +1234,15 @@ func (s *ModularAPIService) SaveWorkflows(filepath string) error {
 func (s *ModularAPIService) LoadWorkflows(filepath string) error {
 	return s.workflowExecutor.LoadWorkflows(filepath)
 }
+
+// MarshalWorkflow returns the JSON encoding of a single workflow, for example to store it in a
+// database instead of a file
+func (s *ModularAPIService) MarshalWorkflow(name string) ([]byte, error) {
+	return s.workflowExecutor.MarshalWorkflow(name)
+}
+
+// RegisterWorkflowJSON registers a workflow from its JSON encoding, as returned by
+// MarshalWorkflow, validating it like LoadWorkflows
+func (s *ModularAPIService) RegisterWorkflowJSON(data []byte) error {
+	return s.workflowExecutor.RegisterWorkflowJSON(data)
+}
@@ -1,8 +1,12 @@
 package modularapi
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"net/http"
+
+	"github.com/rrodriguez06/modular_api/internal/log"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
 )
 
 // ProcessResponse is a helper function for the workflow executor to process responses
@@ -12,21 +16,40 @@ func (s *ModularAPIService) ProcessResponse(response json.RawMessage, result int
 
 // Implement the workflow.APIServiceExecutor interface for the ModularAPIService
 func (s *ModularAPIService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
-	// Convert any string parameters that look like they should be template values
-	// This fixes the issue where workflow parameters aren't properly processed as templates
-	processedParams := make(map[string]interface{})
+	return s.ExecuteServiceActionContext(context.Background(), serviceName, actionName, params, result)
+}
 
-	// Copy all parameters first
+// ExecuteServiceActionContext implements the workflow.APIServiceExecutor interface
+func (s *ModularAPIService) ExecuteServiceActionContext(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	// Copy the parameters so the request doesn't modify the workflow's
+	processedParams := make(map[string]interface{}, len(params))
 	for k, v := range params {
 		processedParams[k] = v
 	}
 
 	// Log the parameters we're using for debugging
-	log.Printf("Executing service action: %s.%s with params: %+v", serviceName, actionName, processedParams)
+	log.FromContext(ctx).Debugf("Executing service action: %s.%s with params: %+v", serviceName, actionName, processedParams)
 
-	// Use our standard PerformRequest method, but with a compatibility wrapper
+	// Use our standard PerformRequestContext method, but with a compatibility wrapper
 	// for the workflow executor which expects serviceName and actionName separately
-	return s.PerformRequest(serviceName, actionName, processedParams, result)
+	return s.PerformRequestContext(ctx, serviceName, actionName, processedParams, result)
+}
+
+// ExecuteServiceActionInfo implements the workflow.RequestInfoServiceExecutor interface, so
+// workflow step results list the method and URL of their requests
+func (s *ModularAPIService) ExecuteServiceActionInfo(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) (workflow.RequestInfo, error) {
+	processedParams := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		processedParams[k] = v
+	}
+
+	var request workflow.RequestInfo
+	err := s.PerformRequestContext(ctx, serviceName, actionName, processedParams, result, func(c *requestConfig) {
+		c.onPrepared = func(req *http.Request) {
+			request = workflow.RequestInfo{Method: req.Method, URL: req.URL.Redacted()}
+		}
+	})
+	return request, err
 }
 
 // ExecuteServiceActionWithOptions is an extended version that allows passing request options
@@ -40,9 +63,28 @@ func (s *ModularAPIService) ExecuteServiceActionWithOptions(serviceName, actionN
 		processedParams[k] = v
 	}
 
+	// Log at the request log level so this message follows it too
+	cfg := &requestConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	logger := log.FromContext(s.withRequestLogLevel(context.Background(), serviceName, actionName, cfg))
+
 	// Log the parameters we're using for debugging
-	log.Printf("Executing service action with options: %s.%s with params: %+v", serviceName, actionName, processedParams)
+	logger.Debugf("Executing service action with options: %s.%s with params: %+v", serviceName, actionName, processedParams)
+
+	return s.PerformRequestWithOptions(serviceName, actionName, processedParams, result, opts...)
+}
+
+// ExecuteStreamingServiceAction implements the workflow.StreamingServiceExecutor interface
+func (s *ModularAPIService) ExecuteStreamingServiceAction(ctx context.Context, serviceName, actionName string, params map[string]interface{}, w http.ResponseWriter) (workflow.RequestInfo, string, error) {
+	log.FromContext(ctx).Debugf("Executing streaming service action: %s.%s with params: %+v", serviceName, actionName, params)
 
-	// Use our standard PerformRequest method with options
-	return s.PerformRequest(serviceName, actionName, processedParams, result, opts...)
+	var request workflow.RequestInfo
+	cfg := &requestConfig{onPrepared: func(req *http.Request) {
+		request = workflow.RequestInfo{Method: req.Method, URL: req.URL.Redacted()}
+	}}
+	_, streamClient := s.clientsFor(serviceName)
+	response, err := s.performStreamingRequest(ctx, serviceName, actionName, params, w, cfg, streamClient.MakeStreamingRequestContext)
+	return request, response, err
 }
@@ -1,14 +1,45 @@
 package modularapi_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/rrodriguez06/modular_api/internal/log"
 	"github.com/rrodriguez06/modular_api/pkg/modularapi"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/client"
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/config"
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/template"
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
 )
 
 func TestModularAPIService(t *testing.T) {
@@ -79,3 +110,2981 @@ func TestModularAPIService(t *testing.T) {
 		t.Errorf("Expected email: test@example.com, got: %v", result["email"])
 	}
 }
+
+func TestRecordAndReplayTransport(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":   r.URL.Query().Get("id"),
+			"name": "Recorded User",
+		})
+	}))
+	serverURL := server.URL
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	tmpl := template.NewRouteTemplate("GET", "/users").
+		WithQueryParams(map[string]interface{}{"id": "{{user_id}}"})
+
+	// Record a real interaction
+	recorder := modularapi.NewServiceBuilder().
+		WithService("TestAPI", serverURL, "").
+		WithTemplate("TestAPI", "GetUser", *tmpl).
+		WithHTTPTransport(client.NewRecordingTransport(cassette, nil)).
+		Build()
+
+	var recorded map[string]interface{}
+	if err := recorder.PerformRequest("TestAPI", "GetUser", map[string]interface{}{"user_id": "42"}, &recorded); err != nil {
+		t.Fatalf("Expected no error while recording, got: %v", err)
+	}
+	server.Close()
+
+	// Replay it without the server
+	replay, err := client.NewReplayTransport(cassette)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+	replayer := modularapi.NewServiceBuilder().
+		WithService("TestAPI", serverURL, "").
+		WithTemplate("TestAPI", "GetUser", *tmpl).
+		WithHTTPTransport(replay).
+		Build()
+
+	var replayed map[string]interface{}
+	if err := replayer.PerformRequest("TestAPI", "GetUser", map[string]interface{}{"user_id": "42"}, &replayed); err != nil {
+		t.Fatalf("Expected no error while replaying, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the server to be called once, got %d", calls)
+	}
+	if replayed["id"] != "42" || replayed["name"] != "Recorded User" {
+		t.Errorf("Expected replayed response to match the recording, got %v", replayed)
+	}
+
+	// Unknown requests are not served
+	if err := replayer.PerformRequest("TestAPI", "GetUser", map[string]interface{}{"user_id": "7"}, &replayed); err == nil {
+		t.Errorf("Expected an error for a request missing from the cassette")
+	}
+}
+
+func TestRecordingTransportStreams(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", body)
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: done\n\n")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	recorder := client.NewRecordingTransport(filepath.Join(t.TempDir(), "cassette.json"), nil)
+	body := &struct{ io.Reader }{strings.NewReader("hello")}
+	req, _ := http.NewRequest("POST", server.URL+"/events", body)
+	reqBody := req.Body
+	resp, err := recorder.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if req.Body != reqBody || req.GetBody != nil {
+		t.Errorf("Expected the request to be left unchanged")
+	}
+
+	// The first event is received while the stream is still open
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: hello\n" {
+		t.Fatalf("Expected the first event, got %q, %v", line, err)
+	}
+	if len(recorder.Interactions()) != 0 {
+		t.Errorf("Expected the interaction to be recorded once the body is closed")
+	}
+	resp.Body.Close()
+
+	interactions := recorder.Interactions()
+	if len(interactions) != 1 || interactions[0].Request.Body != "hello" || !strings.HasPrefix(interactions[0].Response.Body, "data: hello\n") {
+		t.Errorf("Expected the streamed interaction to be recorded, got %+v", interactions)
+	}
+}
+
+func TestExportOpenAPI(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithService("users", "https://users.example.com", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{user_id}}").
+			WithQueryParams(map[string]interface{}{"fields": "{{fields?}}"})).
+		WithTemplate("users", "create", *template.NewRouteTemplate("POST", "/users").
+			WithBody(map[string]interface{}{
+				"name":  "{{name}}",
+				"email": "{{email?}}",
+			})).
+		Build()
+
+	data, err := service.ExportOpenAPI()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string   `json:"operationId"`
+			Tags        []string `json:"tags"`
+			Parameters  []struct {
+				Name     string `json:"name"`
+				In       string `json:"in"`
+				Required bool   `json:"required"`
+			} `json:"parameters"`
+			RequestBody *struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]interface{} `json:"properties"`
+						Required   []string               `json:"required"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Failed to parse exported document: %v", err)
+	}
+
+	if doc.OpenAPI == "" {
+		t.Errorf("Expected an openapi version")
+	}
+
+	get, ok := doc.Paths["/users/{user_id}"]["get"]
+	if !ok {
+		t.Fatalf("Expected GET /users/{user_id} in paths, got %v", doc.Paths)
+	}
+	if get.OperationID != "users.get" || len(get.Tags) != 1 || get.Tags[0] != "users" {
+		t.Errorf("Unexpected operation metadata: %+v", get)
+	}
+	if len(get.Parameters) != 2 || get.Parameters[0].In != "path" || !get.Parameters[0].Required ||
+		get.Parameters[1].In != "query" || get.Parameters[1].Required {
+		t.Errorf("Unexpected parameters: %+v", get.Parameters)
+	}
+
+	create := doc.Paths["/users"]["post"]
+	if create.RequestBody == nil {
+		t.Fatalf("Expected a request body for POST /users")
+	}
+	schema := create.RequestBody.Content["application/json"].Schema
+	if len(schema.Properties) != 2 || len(schema.Required) != 1 || schema.Required[0] != "name" {
+		t.Errorf("Unexpected body schema: %+v", schema)
+	}
+}
+
+func TestImportOpenAPI(t *testing.T) {
+	spec := []byte(`{
+		"openapi": "3.0.3",
+		"paths": {
+			"/users/{id}": {
+				"parameters": [{"name": "id", "in": "path", "required": true}],
+				"get": {
+					"operationId": "users.get",
+					"parameters": [{"name": "fields", "in": "query"}]
+				},
+				"patch": {
+					"tags": ["users"],
+					"operationId": "update",
+					"requestBody": {
+						"content": {
+							"application/json": {"schema": {"$ref": "#/components/schemas/UserUpdate"}}
+						}
+					}
+				}
+			},
+			"/health": {
+				"get": {"tags": ["status"]}
+			}
+		},
+		"components": {
+			"schemas": {
+				"UserUpdate": {
+					"type": "object",
+					"required": ["name"],
+					"properties": {"name": {"type": "string"}, "email": {"type": "string"}}
+				}
+			}
+		}
+	}`)
+
+	templates, err := template.ImportOpenAPI(spec)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	get, ok := templates["users"]["get"]
+	if !ok {
+		t.Fatalf("Expected users.get to be imported, got %v", templates)
+	}
+	if get.Method != "GET" || get.Endpoint != "/users/{{id}}" {
+		t.Errorf("Unexpected method or endpoint: %s %s", get.Method, get.Endpoint)
+	}
+	if get.QueryParams["fields"] != "{{fields?}}" || !get.OptionalParams["fields"] {
+		t.Errorf("Expected optional fields query param, got %v", get.QueryParams)
+	}
+
+	update := templates["users"]["update"]
+	if update.Body["name"] != "{{name}}" || update.Body["email"] != "{{email?}}" {
+		t.Errorf("Unexpected body from referenced schema: %v", update.Body)
+	}
+
+	if _, ok := templates["status"]["get_health"]; !ok {
+		t.Errorf("Expected an action derived from method and path, got %v", templates["status"])
+	}
+
+	// Imported templates are usable through the builder
+	specFile := filepath.Join(t.TempDir(), "openapi.json")
+	if err := os.WriteFile(specFile, spec, 0644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+	builder := modularapi.NewServiceBuilder().WithTemplatesFromOpenAPI(specFile)
+	if err := builder.Err(); err != nil {
+		t.Fatalf("Expected no builder error, got: %v", err)
+	}
+	if err := modularapi.NewServiceBuilder().WithTemplatesFromOpenAPI(filepath.Join(t.TempDir(), "missing.json")).Err(); err == nil {
+		t.Errorf("Expected an error for a missing OpenAPI document")
+	}
+}
+
+func TestStreamedRequestBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"size":         len(body),
+			"content_type": r.Header.Get("Content-Type"),
+		})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("files", server.URL, "").
+		WithTemplate("files", "upload", *template.NewRouteTemplate("PUT", "/files/{{name}}").
+			WithHeaders(map[string]string{"Content-Type": "application/octet-stream"}).
+			WithBody(map[string]interface{}{"ignored": "{{ignored}}"})).
+		Build()
+
+	// A reader that is neither seekable nor of a known size
+	payload := strings.Repeat("x", 64*1024)
+	reader := io.MultiReader(strings.NewReader(payload))
+
+	req, err := service.PrepareRequest("files", "upload", map[string]interface{}{
+		"name":                     "data.bin",
+		modularapi.BodyReaderParam: reader,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if req.GetBody != nil {
+		t.Errorf("Expected a non-seekable body not to be rewindable")
+	}
+
+	var result map[string]interface{}
+	if err := service.MakeRequest(req, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["size"] != float64(len(payload)) || result["content_type"] != "application/octet-stream" {
+		t.Errorf("Unexpected upload result: %v", result)
+	}
+}
+
+func TestRequestMethodOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method": r.Method,
+			"body":   string(body),
+		})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("proxy", server.URL, "").
+		WithTemplate("proxy", "resource", *template.NewRouteTemplate("POST", "/resource").
+			WithBody(map[string]interface{}{"value": "{{value}}"})).
+		Build()
+	params := map[string]interface{}{"value": "v"}
+
+	var result map[string]interface{}
+	if err := service.PerformRequest("proxy", "resource", params, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["method"] != "POST" || result["body"] == "" {
+		t.Errorf("Expected the template method and body by default, got %v", result)
+	}
+
+	if err := service.PerformRequest("proxy", "resource", params, &result, modularapi.WithMethod("put")); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["method"] != "PUT" || result["body"] == "" {
+		t.Errorf("Expected a PUT with a body, got %v", result)
+	}
+
+	if err := service.PerformRequest("proxy", "resource", params, &result, modularapi.WithMethod("GET")); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["method"] != "GET" || result["body"] != "" {
+		t.Errorf("Expected a GET without a body, got %v", result)
+	}
+}
+
+func TestSaveIsByteStable(t *testing.T) {
+	builder := modularapi.NewServiceBuilder().
+		WithService("users", "http://localhost", "").
+		WithService("orders", "http://localhost", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		WithTemplate("users", "list", *template.NewRouteTemplate("GET", "/users")).
+		WithTemplate("orders", "create", *template.NewRouteTemplate("POST", "/orders").
+			WithBody(map[string]interface{}{"user": "{{user}}", "amount": "{{amount}}"})).
+		WithFileIndent("\t")
+	builder.WithWorkflow("b_workflow", "Second").
+		WithStep(modularapi.NewWorkflowStepTemplate("get", "Get user", "users", "get").
+			WithParam("id", "1")).
+		Build()
+	builder.WithWorkflow("a_workflow", "First").
+		WithStep(modularapi.NewWorkflowStepTemplate("list", "List users", "users", "list")).
+		Build()
+	service := builder.Build()
+
+	dir := t.TempDir()
+	for _, save := range []struct {
+		name string
+		fn   func(string) error
+	}{
+		{"templates", service.SaveTemplates},
+		{"workflows", service.SaveWorkflows},
+	} {
+		first := filepath.Join(dir, save.name+"1.json")
+		second := filepath.Join(dir, save.name+"2.json")
+		if err := save.fn(first); err != nil {
+			t.Fatalf("Failed to save %s: %v", save.name, err)
+		}
+		if err := save.fn(second); err != nil {
+			t.Fatalf("Failed to save %s: %v", save.name, err)
+		}
+
+		firstData, _ := os.ReadFile(first)
+		secondData, _ := os.ReadFile(second)
+		if !bytes.Equal(firstData, secondData) {
+			t.Errorf("Expected saving %s twice to produce identical bytes", save.name)
+		}
+		if !strings.Contains(string(firstData), "\n\t\"") {
+			t.Errorf("Expected %s to be indented with tabs, got:\n%s", save.name, firstData)
+		}
+	}
+}
+
+func TestStreamingWorkflowStep(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/prompt":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"prompt": "Say hi"})
+		case "/complete":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, token := range []string{"Hi", " there"} {
+				io.WriteString(w, "data: "+token+"\n\n")
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer server.Close()
+
+	builder := modularapi.NewServiceBuilder().
+		WithService("llm", server.URL, "").
+		WithTemplate("llm", "prompt", *template.NewRouteTemplate("GET", "/prompt")).
+		WithTemplate("llm", "complete", *template.NewRouteTemplate("POST", "/complete").
+			WithBody(map[string]interface{}{"prompt": "{{prompt}}"}))
+	builder.WithWorkflow("chat", "Build a prompt and stream the completion").
+		WithStep(modularapi.NewWorkflowStepTemplate("prompt", "Build the prompt", "llm", "prompt").
+			WithResultMap("prompt", "prompt")).
+		WithStep(modularapi.NewWorkflowStepTemplate("complete", "Stream the completion", "llm", "complete").
+			WithDynamicParam("prompt", "prompt").
+			WithStreaming().
+			WithResultMap("_stream", "completion")).
+		WithAggregator(map[string]string{"completion": "completion"}).
+		Build()
+	service := builder.Build()
+
+	recorder := httptest.NewRecorder()
+	var vars map[string]interface{}
+	var result map[string]interface{}
+	err := service.ExecuteWorkflow("chat", nil, &result,
+		modularapi.WithStreamWriter(recorder), modularapi.WithWorkflowVars(&vars))
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	expected := "data: Hi\n\ndata:  there\n\n"
+	if recorder.Body.String() != expected {
+		t.Errorf("Expected the stream to be forwarded, got %q", recorder.Body.String())
+	}
+	if vars["completion"] != expected || result["completion"] != expected {
+		t.Errorf("Expected the buffered stream in the variables and result, got %v and %v", vars["completion"], result["completion"])
+	}
+
+	// Without a writer the streaming step fails
+	if err := service.ExecuteWorkflow("chat", nil, nil); err == nil {
+		t.Errorf("Expected an error when no stream writer is provided")
+	}
+}
+
+func TestStreamingWorkflowStepLikeOtherSteps(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hang":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: started\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/busy":
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("llm", server.URL, "").
+		WithTemplate("llm", "hang", *template.NewRouteTemplate("GET", "/hang")).
+		WithTemplate("llm", "busy", *template.NewRouteTemplate("GET", "/busy"))
+	builder.WithWorkflow("hanging", "Stream a response that never ends").
+		WithStep(modularapi.NewWorkflowStepTemplate("hang", "Stream", "llm", "hang").WithStreaming().WithTimeout(50)).
+		Build()
+	builder.WithWorkflow("busy", "Stream from a busy endpoint").
+		WithStep(modularapi.NewWorkflowStepTemplate("busy", "Stream", "llm", "busy").
+			WithStreaming().
+			WithAcceptStatusCodes(http.StatusConflict).
+			WithResultMap("_status", "status")).
+		Build()
+	service := builder.Build()
+
+	// The timeout of the step stops the stream
+	start := time.Now()
+	err := service.ExecuteWorkflow("hanging", nil, nil, modularapi.WithStreamWriter(httptest.NewRecorder()))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the stream to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the stream to stop after the step timeout, took %v", elapsed)
+	}
+
+	// So does a cancellation of the execution
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = service.ExecuteWorkflow("hanging", nil, nil, modularapi.WithStreamWriter(httptest.NewRecorder()),
+		modularapi.WithContext(ctx), modularapi.WithStepTimeout(time.Minute))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the stream to stop with the execution, got %v", err)
+	}
+
+	// Accepted status codes and requests are reported as for other steps
+	var vars map[string]interface{}
+	var reported workflow.StepResult
+	err = service.ExecuteWorkflow("busy", nil, nil, modularapi.WithStreamWriter(httptest.NewRecorder()),
+		modularapi.WithWorkflowVars(&vars),
+		modularapi.WithStepHook(func(_ string, result workflow.StepResult) { reported = result }))
+	if err != nil {
+		t.Fatalf("Expected the conflict to be accepted, got %v", err)
+	}
+	if vars["status"] != http.StatusConflict {
+		t.Errorf("Expected the accepted status in the variables, got %v", vars)
+	}
+	expected := []workflow.RequestInfo{{Method: "GET", URL: server.URL + "/busy"}}
+	if !reflect.DeepEqual(reported.Requests, expected) {
+		t.Errorf("Expected the requests %v, got %v", expected, reported.Requests)
+	}
+}
+
+func TestEnvironmentSelection(t *testing.T) {
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"env": name, "q": r.URL.Query().Get("q")})
+		}))
+	}
+	staging := newServer("staging")
+	defer staging.Close()
+	prod := newServer("prod")
+	defer prod.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("search", "http://localhost:1", "").
+		WithServiceDefaultParams("search", map[string]interface{}{"q": "default"}).
+		WithEnvironmentService("staging", "search", staging.URL, "").
+		WithEnvironmentService("prod", "search", prod.URL, "").
+		WithEnvironment("staging").
+		WithTemplate("search", "find", *template.NewRouteTemplate("GET", "/find").
+			WithQueryParams(map[string]interface{}{"q": "{{q}}"})).
+		Build()
+
+	var result map[string]interface{}
+	if err := service.PerformRequest("search", "find", nil, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["env"] != "staging" || result["q"] != "default" {
+		t.Errorf("Expected the staging service with inherited default params, got %v", result)
+	}
+
+	if err := service.UseEnvironment("prod"); err != nil {
+		t.Fatalf("Failed to switch environment: %v", err)
+	}
+	if err := service.PerformRequest("search", "find", nil, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["env"] != "prod" {
+		t.Errorf("Expected the prod service, got %v", result)
+	}
+
+	if err := service.UseEnvironment("qa"); err == nil {
+		t.Errorf("Expected an error for an unknown environment")
+	}
+	if service.GetServiceURL("search") != prod.URL {
+		t.Errorf("Expected a failed switch to keep the prod environment, got %s", service.GetServiceURL("search"))
+	}
+
+	builder := modularapi.NewServiceBuilder().
+		WithEnvironmentService("staging", "search", staging.URL, "").
+		WithEnvironment("unknown")
+	builder.Build()
+	if err := builder.Err(); err == nil || !strings.Contains(err.Error(), "unknown environment unknown") {
+		t.Errorf("Expected the unknown environment to be reported by Err, got: %v", err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/status" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("healthy", healthy.URL, "token").
+		WithServiceHealthCheck("healthy", "head", "/status").
+		WithService("failing", failing.URL, "").
+		WithService("unreachable", unreachable.URL, "").
+		Build()
+
+	if err := service.HealthCheck("healthy"); err != nil {
+		t.Errorf("Expected the healthy service to pass, got: %v", err)
+	}
+	if err := service.HealthCheck("unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown service")
+	}
+
+	results := service.HealthCheckAll()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %v", results)
+	}
+	if results["healthy"] != nil {
+		t.Errorf("Expected healthy to pass, got: %v", results["healthy"])
+	}
+	if results["failing"] == nil || !strings.Contains(results["failing"].Error(), "503") {
+		t.Errorf("Expected failing to report its status, got: %v", results["failing"])
+	}
+	if results["unreachable"] == nil {
+		t.Errorf("Expected unreachable to fail")
+	}
+}
+
+func TestArrayPlaceholderOmission(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+
+	body := map[string]interface{}{
+		"points": []interface{}{"{{first}}", "{{second?}}", "{{third}}"},
+		"rows": []interface{}{
+			[]interface{}{"{{first}}", "{{missing?}}"},
+		},
+	}
+	service := modularapi.NewServiceBuilder().
+		WithService("api", server.URL, "").
+		WithTemplate("api", "drop", *template.NewRouteTemplate("POST", "/drop").WithBody(body)).
+		WithTemplate("api", "keep", *template.NewRouteTemplate("POST", "/keep").WithBody(body).
+			WithArrayOmission(template.ArrayOmitNull)).
+		Build()
+	params := map[string]interface{}{"first": 1, "third": 3}
+
+	// By default omitted elements are dropped
+	var result map[string]interface{}
+	if err := service.PerformRequest("api", "drop", params, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if points, _ := json.Marshal(result["points"]); string(points) != "[1,3]" {
+		t.Errorf("Expected omitted elements to be dropped, got %s", points)
+	}
+	if rows, _ := json.Marshal(result["rows"]); string(rows) != "[[1]]" {
+		t.Errorf("Expected omitted nested elements to be dropped, got %s", rows)
+	}
+
+	// With ArrayOmitNull positions are preserved
+	if err := service.PerformRequest("api", "keep", params, &result); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if points, _ := json.Marshal(result["points"]); string(points) != "[1,null,3]" {
+		t.Errorf("Expected omitted elements to be null, got %s", points)
+	}
+	if rows, _ := json.Marshal(result["rows"]); string(rows) != "[[1,null]]" {
+		t.Errorf("Expected omitted nested elements to be null, got %s", rows)
+	}
+}
+
+func TestWorkflowExecutionOptions(t *testing.T) {
+	// Record the log level of the step request and the global level while the workflow runs
+	var levelDuringRun, globalLevel log.LogLevel
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		levelDuringRun = requestLogLevel(req)
+		globalLevel = log.GlobalLogger.(*log.DefaultLogger).GetLogLevel()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id": 42}`)),
+			Request:    req,
+		}, nil
+	})
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.WARN).
+		WithHTTPTransport(transport).
+		WithService("users", "http://users.test", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/user"))
+	builder.WithWorkflow("lookup", "Look up a user").
+		WithStep(modularapi.NewWorkflowStepTemplate("get", "Get the user", "users", "get").
+			WithResultMap("id", "user_id")).
+		Build()
+	service := builder.Build()
+
+	var vars map[string]interface{}
+	err := service.ExecuteWorkflow("lookup", nil, nil,
+		modularapi.WithLogLevel(log.DEBUG), modularapi.WithWorkflowVars(&vars))
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	if levelDuringRun != log.DEBUG {
+		t.Errorf("Expected the DEBUG level during the run, got %v", levelDuringRun)
+	}
+	if globalLevel != log.WARN {
+		t.Errorf("Expected the global WARN level to be left unchanged, got %v", globalLevel)
+	}
+	if vars["user_id"] != float64(42) {
+		t.Errorf("Expected the final variables to be captured, got %v", vars)
+	}
+
+	// A background execution doesn't change the global level either
+	execution, err := service.StartWorkflow("lookup", nil, nil, modularapi.WithLogLevel(log.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+	if level := log.GlobalLogger.(*log.DefaultLogger).GetLogLevel(); level != log.WARN {
+		t.Errorf("Expected the global WARN level while the execution runs, got %v", level)
+	}
+	if _, err := execution.Wait(); err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if levelDuringRun != log.ERROR {
+		t.Errorf("Expected the ERROR level during the background run, got %v", levelDuringRun)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// requestLogLevel returns the level a request logs at: the level carried by its context, or
+// else the level of the global logger
+func requestLogLevel(req *http.Request) log.LogLevel {
+	if level, ok := log.LevelFromContext(req.Context()); ok {
+		return level
+	}
+	return log.GlobalLogger.(*log.DefaultLogger).GetLogLevel()
+}
+
+func TestRequestLogLevel(t *testing.T) {
+	// Record the log level of the request and the global level while it is sent
+	var levelDuringRequest, globalLevel log.LogLevel
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		levelDuringRequest = requestLogLevel(req)
+		globalLevel = log.GlobalLogger.(*log.DefaultLogger).GetLogLevel()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id": 1}`)),
+			Request:    req,
+		}, nil
+	})
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("users", "http://users.test", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/user")).
+		Build()
+
+	var result map[string]interface{}
+	err := service.PerformRequestWithOptions("users", "get", nil, &result, modularapi.WithRequestLogLevel(log.DEBUG))
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+
+	if levelDuringRequest != log.DEBUG {
+		t.Errorf("Expected the DEBUG level while sending the request, got %v", levelDuringRequest)
+	}
+	if globalLevel != log.ERROR {
+		t.Errorf("Expected the global ERROR level to be left unchanged, got %v", globalLevel)
+	}
+	if result["id"] != float64(1) {
+		t.Errorf("Expected the response to be decoded, got %v", result)
+	}
+}
+
+func TestServicesFromConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "services.json")
+	content := `{
+  "services": {
+    "users": {"apiURL": "https://users.example.com", "apiToken": "file-token"},
+    "orders": {"apiURL": "https://orders.example.com"}
+  }
+}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	builder := modularapi.NewServiceBuilder().
+		WithServicesFromConfigFile(path).
+		WithService("orders", "https://orders.internal", "")
+	if err := builder.Err(); err != nil {
+		t.Fatalf("Expected no builder error, got: %v", err)
+	}
+	service := builder.Build()
+
+	if url := service.GetServiceURL("users"); url != "https://users.example.com" {
+		t.Errorf("Expected the users URL from the file, got %s", url)
+	}
+	if token := service.GetServiceToken("users"); token != "file-token" {
+		t.Errorf("Expected the users token from the file, got %s", token)
+	}
+	if url := service.GetServiceURL("orders"); url != "https://orders.internal" {
+		t.Errorf("Expected WithService to take precedence, got %s", url)
+	}
+
+	// An empty file adds no services
+	emptyPath := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(emptyPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	builder = modularapi.NewServiceBuilder().WithServicesFromConfigFile(emptyPath)
+	if err := builder.Err(); err != nil {
+		t.Errorf("Expected no error for an empty file, got: %v", err)
+	}
+	if url := builder.Build().GetServiceURL("users"); url != "" {
+		t.Errorf("Expected no services from an empty file, got %s", url)
+	}
+
+	// A missing file is an error
+	builder = modularapi.NewServiceBuilder().WithServicesFromConfigFile(filepath.Join(dir, "missing.json"))
+	if err := builder.Err(); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestDefaultService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"path": r.URL.Path})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		Build()
+
+	// The short forms fail until a default service is set
+	var result map[string]interface{}
+	if err := service.Perform("get", map[string]interface{}{"id": "1"}, &result); !errors.Is(err, modularapi.ErrNoDefaultService) {
+		t.Errorf("Expected ErrNoDefaultService, got: %v", err)
+	}
+	if _, err := service.ExecuteRequestWithParams("get", map[string]interface{}{"id": "1"}); !errors.Is(err, modularapi.ErrNoDefaultService) {
+		t.Errorf("Expected ErrNoDefaultService, got: %v", err)
+	}
+
+	service.SetDefaultService("users")
+	if err := service.Perform("get", map[string]interface{}{"id": "1"}, &result); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if result["path"] != "/users/1" {
+		t.Errorf("Expected a request to /users/1, got %v", result["path"])
+	}
+
+	raw, err := service.ExecuteRequestWithParams("get", map[string]interface{}{"id": "2"})
+	if err != nil {
+		t.Fatalf("Failed to execute request: %v", err)
+	}
+	if !strings.Contains(string(raw), "/users/2") {
+		t.Errorf("Expected a request to /users/2, got %s", raw)
+	}
+
+	// The explicit forms keep working
+	if _, err := service.ExecuteRequestWithParams("users.get", map[string]interface{}{"id": "3"}); err != nil {
+		t.Errorf("Expected the explicit form to work, got: %v", err)
+	}
+}
+
+func TestPerformBatch(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		if r.URL.Path == "/users/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"path": r.URL.Path})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		Build()
+
+	ids := []string{"1", "2", "missing", "3", "4"}
+	reqs := make([]modularapi.BatchRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = modularapi.BatchRequest{ServiceName: "users", Action: "get", Params: map[string]interface{}{"id": id}}
+	}
+
+	results := service.PerformBatch(reqs, 2)
+	if len(results) != len(ids) {
+		t.Fatalf("Expected %d results, got %d", len(ids), len(results))
+	}
+	for i, id := range ids {
+		if id == "missing" {
+			if results[i].Err == nil || results[i].Result != nil {
+				t.Errorf("Expected an error and no result for %s, got %v", id, results[i])
+			}
+			continue
+		}
+		if results[i].Err != nil {
+			t.Errorf("Unexpected error for %s: %v", id, results[i].Err)
+			continue
+		}
+		body, _ := results[i].Result.(map[string]interface{})
+		if body["path"] != "/users/"+id {
+			t.Errorf("Expected the result of /users/%s at index %d, got %v", id, i, results[i].Result)
+		}
+	}
+	if maxActive > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", maxActive)
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithService("users", "https://users.example.com", "").
+		WithServiceDefaultParams("users", map[string]interface{}{"version": "v1", "lang": "en"}).
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/{{version}}/users/{{id}}")).
+		Build()
+	service.SetServiceParams("users", map[string]interface{}{"lang": "fr"})
+
+	params, err := service.ResolveParams("users", "get", map[string]interface{}{"id": "7", "version": "v2"})
+	if err != nil {
+		t.Fatalf("Failed to resolve params: %v", err)
+	}
+	expected := map[string]interface{}{"id": "7", "version": "v2", "lang": "fr"}
+	if len(params) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, params)
+	}
+	for k, v := range expected {
+		if params[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, params[k])
+		}
+	}
+
+	if _, err := service.ResolveParams("users", "unknown", nil); err == nil {
+		t.Errorf("Expected an error for an unknown action")
+	}
+}
+
+func TestExplicitNullParam(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "update", *template.NewRouteTemplate("PATCH", "/users/1").
+			WithBody(map[string]interface{}{
+				"name":     "{{name?}}",
+				"nickname": "{{nickname?}}",
+				"email":    "{{email?}}",
+			})).
+		Build()
+
+	var result map[string]interface{}
+	err := service.PerformRequest("users", "update", map[string]interface{}{
+		"name":     "Jo",
+		"nickname": template.Null, // clear the field
+		"email":    nil,           // not provided
+	}, &result)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+
+	if body["name"] != "Jo" {
+		t.Errorf("Expected the name to be sent, got %v", body)
+	}
+	if value, ok := body["nickname"]; !ok || value != nil {
+		t.Errorf("Expected an explicit null nickname, got %v", body)
+	}
+	if _, ok := body["email"]; ok {
+		t.Errorf("Expected the email to be omitted, got %v", body)
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+
+	route := template.NewRouteTemplate("POST", "/users").
+		WithBody(map[string]interface{}{
+			"name": "{{name}}",
+			"bio":  "{{bio}}",
+			"tags": "{{tags}}",
+			"address": map[string]interface{}{
+				"city": "{{city}}",
+				"geo":  map[string]interface{}{"lat": "{{lat}}"},
+			},
+		})
+	params := map[string]interface{}{
+		"name": "Jo",
+		"bio":  "",
+		"tags": []string{},
+		"city": "",
+		"lat":  nil,
+	}
+
+	// Without the flag, empty values are sent as they are
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "create", *route.Clone()).
+		Build()
+	var result map[string]interface{}
+	if err := service.PerformRequest("users", "create", params, &result); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if _, ok := body["address"]; !ok {
+		t.Errorf("Expected empty fields to be sent without OmitEmpty, got %v", body)
+	}
+
+	// With the template flag, the nested empty object is removed along with the empty fields
+	service = modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "create", *route.Clone().WithOmitEmpty()).
+		Build()
+	if err := service.PerformRequest("users", "create", params, &result); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if !reflect.DeepEqual(body, map[string]interface{}{"name": "Jo"}) {
+		t.Errorf("Expected only the name to be sent, got %v", body)
+	}
+
+	// The service flag applies to all its templates, keeping the non-empty nested fields
+	service = modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithServiceOmitEmpty("users").
+		WithTemplate("users", "create", *route.Clone()).
+		Build()
+	params["city"] = "Lyon"
+	if err := service.PerformRequest("users", "create", params, &result); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name":    "Jo",
+		"address": map[string]interface{}{"city": "Lyon"},
+	}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("Expected %v, got %v", expected, body)
+	}
+}
+
+func TestLookupServiceHeadersAndParams(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithService("users", "https://users.example.com", "").
+		WithService("orders", "https://orders.example.com", "").
+		WithServiceHeaders("orders", map[string]string{"X-Tenant": "acme"}).
+		Build()
+
+	// A known service without headers or params
+	if headers, ok := service.LookupServiceHeaders("users"); !ok || headers == nil || len(headers) != 0 {
+		t.Errorf("Expected an empty map and true for users headers, got %v, %v", headers, ok)
+	}
+	if params, ok := service.LookupServiceParams("users"); !ok || params == nil || len(params) != 0 {
+		t.Errorf("Expected an empty map and true for users params, got %v, %v", params, ok)
+	}
+
+	if headers, ok := service.LookupServiceHeaders("orders"); !ok || headers["X-Tenant"] != "acme" {
+		t.Errorf("Expected the orders headers, got %v, %v", headers, ok)
+	}
+
+	// An unknown service
+	if headers, ok := service.LookupServiceHeaders("userz"); ok || headers != nil {
+		t.Errorf("Expected nil and false for an unknown service, got %v, %v", headers, ok)
+	}
+	if params, ok := service.LookupServiceParams("userz"); ok || params != nil {
+		t.Errorf("Expected nil and false for an unknown service, got %v, %v", params, ok)
+	}
+}
+
+func TestWorkflowAcceptStatusCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/profiles/2" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error": "not found"}`)
+			return
+		}
+		io.WriteString(w, `{"bio": "hello"}`)
+	}))
+	defer server.Close()
+
+	builder := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "profile", *template.NewRouteTemplate("GET", "/profiles/{{id}}"))
+	builder.WithWorkflow("profile", "Get an optional profile").
+		WithStep(modularapi.NewWorkflowStepTemplate("get_profile", "Get the profile", "users", "profile").
+			WithDynamicParam("id", "id").
+			WithAcceptStatusCodes(http.StatusNotFound).
+			WithResultMap("bio", "bio").
+			WithResultMap("_status", "profile_status")).
+		Build()
+	service := builder.Build()
+
+	var vars map[string]interface{}
+	err := service.ExecuteWorkflow("profile", map[string]interface{}{"id": "2"}, nil, modularapi.WithWorkflowVars(&vars))
+	if err != nil {
+		t.Fatalf("Expected the 404 to be accepted, got: %v", err)
+	}
+	if vars["profile_status"] != http.StatusNotFound {
+		t.Errorf("Expected the accepted status code in the variables, got %v", vars["profile_status"])
+	}
+	if _, ok := vars["bio"]; ok {
+		t.Errorf("Expected no bio for a missing profile, got %v", vars["bio"])
+	}
+
+	err = service.ExecuteWorkflow("profile", map[string]interface{}{"id": "1"}, nil, modularapi.WithWorkflowVars(&vars))
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["bio"] != "hello" {
+		t.Errorf("Expected the bio of an existing profile, got %v", vars["bio"])
+	}
+
+	// Outside workflows the status code is reported through an APIError
+	var result map[string]interface{}
+	var apiErr *client.APIError
+	err = service.PerformRequest("users", "profile", map[string]interface{}{"id": "2"}, &result)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an APIError with status 404 outside the workflow, got: %v", err)
+	}
+}
+
+// cancelingRecorder cancels a context once the first chunk has been flushed
+type cancelingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (r *cancelingRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	r.cancel()
+}
+
+func TestStreamingRequestCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// Hang until the client goes away
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("llm", server.URL, "").
+		WithTemplate("llm", "complete", *template.NewRouteTemplate("GET", "/complete")).
+		Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &cancelingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+
+	done := make(chan struct{})
+	var response string
+	var err error
+	go func() {
+		response, err = service.PerformStreamingRequestContext(ctx, "llm", "complete", nil, recorder)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the streaming request to stop when the context is canceled")
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a context.Canceled error, got: %v", err)
+	}
+	if response != "data: first\n\n" {
+		t.Errorf("Expected the partial response, got %q", response)
+	}
+}
+
+func TestTokenRefreshOnUnauthorized(t *testing.T) {
+	var validToken atomic.Value
+	validToken.Store("fresh")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+validToken.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok": true}`)
+	}))
+	defer server.Close()
+
+	var refreshes int32
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "expired").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/user")).
+		WithTokenRefresh("users", func(serviceName string) (string, error) {
+			atomic.AddInt32(&refreshes, 1)
+			time.Sleep(20 * time.Millisecond) // let the other requests hit the 401
+			return "fresh", nil
+		}).
+		Build()
+
+	// Concurrent 401s trigger a single refresh
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var result map[string]interface{}
+			errs[i] = service.PerformRequest("users", "get", nil, &result)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Request %d failed: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&refreshes); n != 1 {
+		t.Errorf("Expected a single refresh, got %d", n)
+	}
+	if token := service.GetServiceToken("users"); token != "fresh" {
+		t.Errorf("Expected the refreshed token to be stored, got %s", token)
+	}
+
+	// A refreshed token that is still rejected is not retried again
+	validToken.Store("rotated")
+	var result map[string]interface{}
+	err := service.PerformRequest("users", "get", nil, &result)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 after a single retry, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&refreshes); n != 2 {
+		t.Errorf("Expected one more refresh, got %d", n)
+	}
+}
+
+func TestDescribeAction(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithService("users", "https://users.example.com", "").
+		WithTemplate("users", "update", *template.NewRouteTemplate("PUT", "/orgs/{{org}}/users/{{id}}/{{section?}}").
+			WithQueryParams(map[string]interface{}{"notify": "{{notify?}}"}).
+			WithBody(map[string]interface{}{
+				"name":    "{{name}}",
+				"profile": map[string]interface{}{"bio": "{{bio?}}"},
+				"tags":    []interface{}{"{{tag}}"},
+			})).
+		Build()
+
+	schema, ok := service.DescribeAction("users", "update")
+	if !ok {
+		t.Fatal("Expected the action to be described")
+	}
+	if schema.Method != "PUT" || schema.Endpoint != "/orgs/{{org}}/users/{{id}}/{{section?}}" {
+		t.Errorf("Unexpected method or endpoint: %s %s", schema.Method, schema.Endpoint)
+	}
+	if got := strings.Join(schema.RequiredParams, ","); got != "id,name,org,tag" {
+		t.Errorf("Expected required params id,name,org,tag, got %s", got)
+	}
+	if got := strings.Join(schema.OptionalParams, ","); got != "bio,notify,section" {
+		t.Errorf("Expected optional params bio,notify,section, got %s", got)
+	}
+
+	if _, ok := service.DescribeAction("users", "unknown"); ok {
+		t.Errorf("Expected no schema for an unknown action")
+	}
+}
+
+func TestRegisterResponseType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users/1":
+			io.WriteString(w, `{"id": "1", "name": "Jo"}`)
+		default:
+			io.WriteString(w, `[{"id": "1", "name": "Jo"}, {"id": "2", "name": "Al"}]`)
+		}
+	}))
+	defer server.Close()
+
+	type user struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/1")).
+		WithTemplate("users", "list", *template.NewRouteTemplate("GET", "/users")).
+		Build()
+	service.RegisterResponseType("users", "get", user{})
+	service.RegisterResponseType("users", "list", &[]user{})
+
+	result, err := service.PerformTyped("users", "get", nil)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if got, ok := result.(*user); !ok || got.Name != "Jo" {
+		t.Errorf("Expected a *user named Jo, got %#v", result)
+	}
+
+	// Each call decodes into a fresh value
+	again, err := service.PerformTyped("users", "get", nil)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if again == result {
+		t.Error("Expected a new value for each response")
+	}
+
+	result, err = service.PerformTyped("users", "list", nil)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if got, ok := result.(*[]user); !ok || len(*got) != 2 || (*got)[1].Name != "Al" {
+		t.Errorf("Expected a *[]user with two users, got %#v", result)
+	}
+
+	// Without a registered type, nothing is performed
+	service.RegisterResponseType("users", "list", nil)
+	if _, err := service.PerformTyped("users", "list", nil); err == nil {
+		t.Error("Expected an error for an action without a response type")
+	}
+}
+
+func TestServiceURLPlaceholders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"path": r.URL.Path})
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	service := modularapi.NewServiceBuilder().
+		WithService("tenants", "http://{{host}}/{{tenant}}/", "").
+		WithTemplate("tenants", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		Build()
+
+	var result map[string]interface{}
+	err := service.PerformRequest("tenants", "get", map[string]interface{}{"host": host, "tenant": "acme", "id": "7"}, &result)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if result["path"] != "/acme/users/7" {
+		t.Errorf("Expected a request to /acme/users/7, got %v", result["path"])
+	}
+
+	// A missing URL parameter is an error
+	_, err = service.PrepareRequest("tenants", "get", map[string]interface{}{"host": host, "id": "7"})
+	if err == nil || !strings.Contains(err.Error(), "tenant") {
+		t.Errorf("Expected an error for the missing tenant, got: %v", err)
+	}
+
+	// Path values are escaped, host values must be host labels
+	req, err := service.PrepareRequest("tenants", "get", map[string]interface{}{"host": host, "tenant": "a/b#c", "id": "7"})
+	if err != nil {
+		t.Fatalf("Failed to prepare request: %v", err)
+	}
+	if req.URL.Host != host || req.URL.EscapedPath() != "/a%2Fb%23c/users/7" {
+		t.Errorf("Expected the tenant to be escaped, got %s", req.URL)
+	}
+	for _, value := range []string{"evil.test/x", "user@evil.test", "evil.test#"} {
+		_, err = service.PrepareRequest("tenants", "get", map[string]interface{}{"host": value, "tenant": "acme", "id": "7"})
+		if err == nil || !strings.Contains(err.Error(), "host") {
+			t.Errorf("Expected an error for the host %q, got: %v", value, err)
+		}
+	}
+
+	// A missing optional host placeholder is removed with its label
+	service = modularapi.NewServiceBuilder().
+		WithService("regional", "https://{{region?}}.api.example.com/v1", "").
+		WithTemplate("regional", "get", *template.NewRouteTemplate("GET", "/users")).
+		Build()
+	for region, expected := range map[string]string{"eu": "https://eu.api.example.com/v1/users", "": "https://api.example.com/v1/users"} {
+		params := map[string]interface{}{}
+		if region != "" {
+			params["region"] = region
+		}
+		req, err := service.PrepareRequest("regional", "get", params)
+		if err != nil {
+			t.Fatalf("Failed to prepare request: %v", err)
+		}
+		if req.URL.String() != expected {
+			t.Errorf("Expected %s, got %s", expected, req.URL)
+		}
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+			return
+		}
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"session": cookie.Value})
+	}))
+	defer server.Close()
+
+	newService := func(configure func(*modularapi.ServiceBuilder)) modularapi.Service {
+		builder := modularapi.NewServiceBuilder().
+			WithService("app", server.URL, "").
+			WithTemplate("app", "login", *template.NewRouteTemplate("POST", "/login")).
+			WithTemplate("app", "me", *template.NewRouteTemplate("GET", "/me"))
+		configure(builder)
+		return builder.Build()
+	}
+
+	// Without a jar, the session cookie isn't sent back
+	service := newService(func(*modularapi.ServiceBuilder) {})
+	service.PerformRequest("app", "login", nil, nil)
+	if err := service.PerformRequest("app", "me", nil, nil); err == nil {
+		t.Errorf("Expected the request to fail without a cookie jar")
+	}
+
+	// With a jar, the cookie set by the login is replayed
+	service = newService(func(b *modularapi.ServiceBuilder) { b.WithCookieJar() })
+	if err := service.PerformRequest("app", "login", nil, nil); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	var result map[string]interface{}
+	if err := service.PerformRequest("app", "me", nil, &result); err != nil || result["session"] != "s1" {
+		t.Errorf("Expected the session cookie to be sent, got %v, %v", result, err)
+	}
+
+	// A pre-seeded jar is used as-is
+	jar, _ := cookiejar.New(nil)
+	serverURL, _ := url.Parse(server.URL)
+	jar.SetCookies(serverURL, []*http.Cookie{{Name: "session", Value: "seeded"}})
+	service = newService(func(b *modularapi.ServiceBuilder) { b.WithCustomCookieJar(jar) })
+	result = nil
+	if err := service.PerformRequest("app", "me", nil, &result); err != nil || result["session"] != "seeded" {
+		t.Errorf("Expected the seeded cookie to be sent, got %v, %v", result, err)
+	}
+}
+
+func TestServiceProxy(t *testing.T) {
+	// The proxy receives requests with an absolute URL
+	var proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"via": "proxy", "url": r.URL.String()})
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"via": "direct"})
+	}))
+	defer direct.Close()
+
+	proxyURL := strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+	builder := modularapi.NewServiceBuilder().
+		WithService("partner", "http://partner.internal", "").
+		WithTemplate("partner", "get", *template.NewRouteTemplate("GET", "/items")).
+		WithServiceProxy("partner", proxyURL).
+		WithService("local", direct.URL, "").
+		WithTemplate("local", "get", *template.NewRouteTemplate("GET", "/items"))
+	if err := builder.Err(); err != nil {
+		t.Fatalf("Unexpected builder error: %v", err)
+	}
+	service := builder.Build()
+
+	var result map[string]interface{}
+	if err := service.PerformRequest("partner", "get", nil, &result); err != nil {
+		t.Fatalf("Failed to perform proxied request: %v", err)
+	}
+	if result["via"] != "proxy" || result["url"] != "http://partner.internal/items" {
+		t.Errorf("Expected the request to go through the proxy, got %v", result)
+	}
+	if proxyAuth != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+		t.Errorf("Expected proxy credentials, got %q", proxyAuth)
+	}
+
+	result = nil
+	if err := service.PerformRequest("local", "get", nil, &result); err != nil || result["via"] != "direct" {
+		t.Errorf("Expected other services to go direct, got %v, %v", result, err)
+	}
+
+	// Invalid proxy URLs are reported by the builder
+	for _, invalid := range []string{"ftp://proxy:21", "http://", "://bad"} {
+		if err := modularapi.NewServiceBuilder().WithProxy(invalid).Err(); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to PEM files
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	certTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSConfiguration(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"client_certs": len(r.TLS.PeerCertificates)})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	certFile, keyFile := writeTestCertificate(t, dir, "client")
+
+	newService := func(configure func(*modularapi.ServiceBuilder)) modularapi.Service {
+		builder := modularapi.NewServiceBuilder().
+			WithService("internal", server.URL, "").
+			WithTemplate("internal", "get", *template.NewRouteTemplate("GET", "/"))
+		configure(builder)
+		if err := builder.Err(); err != nil {
+			t.Fatalf("Unexpected builder error: %v", err)
+		}
+		return builder.Build()
+	}
+
+	// The self-signed server certificate isn't trusted by default
+	service := newService(func(*modularapi.ServiceBuilder) {})
+	if err := service.PerformRequest("internal", "get", nil, nil); err == nil {
+		t.Errorf("Expected an untrusted certificate error")
+	}
+
+	// Trusting the CA isn't enough when the server requires a client certificate
+	service = newService(func(b *modularapi.ServiceBuilder) { b.WithRootCAFile(caFile) })
+	if err := service.PerformRequest("internal", "get", nil, nil); err == nil {
+		t.Errorf("Expected the request without a client certificate to fail")
+	}
+
+	service = newService(func(b *modularapi.ServiceBuilder) {
+		b.WithRootCAFile(caFile).WithClientCertificate(certFile, keyFile)
+	})
+	var result map[string]interface{}
+	if err := service.PerformRequest("internal", "get", nil, &result); err != nil || result["client_certs"] != float64(1) {
+		t.Errorf("Expected a mutual TLS request, got %v, %v", result, err)
+	}
+
+	service = newService(func(b *modularapi.ServiceBuilder) {
+		b.WithInsecureSkipVerify().WithClientCertificate(certFile, keyFile)
+	})
+	if err := service.PerformRequest("internal", "get", nil, nil); err != nil {
+		t.Errorf("Expected skipping verification to accept the certificate, got %v", err)
+	}
+
+	// Files that can't be loaded are reported by the builder
+	if err := modularapi.NewServiceBuilder().WithRootCAFile(filepath.Join(dir, "missing.pem")).Err(); err == nil {
+		t.Errorf("Expected an error for a missing CA file")
+	}
+	if err := modularapi.NewServiceBuilder().WithRootCAFile(keyFile).Err(); err == nil {
+		t.Errorf("Expected an error for a file without certificates")
+	}
+	if err := modularapi.NewServiceBuilder().WithClientCertificate(certFile, caFile).Err(); err == nil {
+		t.Errorf("Expected an error for a mismatched key")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var calls int32
+	var bodies []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			// A past date means retrying right away
+			w.Header().Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+		}
+	}))
+	defer server.Close()
+
+	var backoffAttempts []int
+	backoff := func(attempt int, resp *http.Response) time.Duration {
+		backoffAttempts = append(backoffAttempts, attempt)
+		return time.Millisecond
+	}
+
+	tmpl := template.NewRouteTemplate("POST", "/orders")
+	tmpl.Body = map[string]interface{}{"id": "{{id}}"}
+	newService := func(maxRetries int) modularapi.Service {
+		return modularapi.NewServiceBuilder().
+			WithService("orders", server.URL, "").
+			WithTemplate("orders", "create", *tmpl).
+			WithRetryPolicy(client.RetryPolicy{MaxRetries: maxRetries, Backoff: backoff}).
+			Build()
+	}
+
+	var result map[string]interface{}
+	if err := newService(3).PerformRequest("orders", "create", map[string]interface{}{"id": "o1"}, &result); err != nil {
+		t.Fatalf("Expected the request to succeed after retries, got %v", err)
+	}
+	if result["ok"] != true || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Expected 3 calls and a decoded result, got %d calls and %v", calls, result)
+	}
+	// The Retry-After header replaces the backoff of the first retry
+	if len(backoffAttempts) != 1 || backoffAttempts[0] != 2 {
+		t.Errorf("Expected the backoff to be used for the second retry only, got %v", backoffAttempts)
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, `"o1"`) {
+			t.Errorf("Expected every attempt to send the body, got %q", body)
+		}
+	}
+
+	// The last error is returned once the retries are exhausted
+	atomic.StoreInt32(&calls, 0)
+	err := newService(1).PerformRequest("orders", "create", map[string]interface{}{"id": "o1"}, nil)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the 502 of the last attempt, got %v", err)
+	}
+
+	// Jittered backoff stays between half and all of the exponential wait
+	jittered := client.JitteredBackoff(100*time.Millisecond, time.Second)
+	for attempt := 1; attempt <= 6; attempt++ {
+		want := client.ExponentialBackoff(100*time.Millisecond, time.Second)(attempt, nil)
+		if got := jittered(attempt, nil); got < want/2 || got > want {
+			t.Errorf("Attempt %d: expected a wait between %v and %v, got %v", attempt, want/2, want, got)
+		}
+	}
+}
+
+func TestStreamingSharesTransportAndRefreshesToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "data: hello\n\n")
+	}))
+	defer server.Close()
+
+	var transportCalls int32
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&transportCalls, 1)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	service := modularapi.NewServiceBuilder().
+		WithService("events", server.URL, "expired").
+		WithTemplate("events", "stream", *template.NewRouteTemplate("GET", "/events")).
+		WithHTTPTransport(transport).
+		WithTokenRefresh("events", func(string) (string, error) { return "fresh", nil }).
+		Build()
+
+	recorder := httptest.NewRecorder()
+	response, err := service.PerformStreamingRequest("events", "stream", nil, recorder)
+	if err != nil {
+		t.Fatalf("Expected the stream to succeed after a token refresh, got %v", err)
+	}
+	if response != "data: hello\n\n" || recorder.Body.String() != response {
+		t.Errorf("Expected the stream to be forwarded once, got %q and %q", response, recorder.Body.String())
+	}
+	if n := atomic.LoadInt32(&transportCalls); n != 2 {
+		t.Errorf("Expected both attempts to use the configured transport, got %d calls", n)
+	}
+
+	// Without a refresh function, the 401 is reported as an APIError
+	service = modularapi.NewServiceBuilder().
+		WithService("events", server.URL, "expired").
+		WithTemplate("events", "stream", *template.NewRouteTemplate("GET", "/events")).
+		Build()
+	_, err = service.PerformStreamingRequest("events", "stream", nil, httptest.NewRecorder())
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 APIError, got %v", err)
+	}
+}
+
+func TestTransportOptions(t *testing.T) {
+	opts := client.TransportOptions{MaxIdleConnsPerHost: 5, IdleConnTimeout: 100 * time.Millisecond, ForceHTTP2: true}
+	transport := client.NewTransport(opts)
+	if transport.MaxIdleConnsPerHost != 5 || transport.IdleConnTimeout != 100*time.Millisecond || !transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected the options to be set on the transport, got %d, %v, %v",
+			transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.ForceAttemptHTTP2)
+	}
+
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			io.WriteString(w, "data: "+r.Proto+"\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"proto": r.Proto})
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	opts.TLSConfig = &tls.Config{RootCAs: roots}
+	newBuilder := func() *modularapi.ServiceBuilder {
+		return modularapi.NewServiceBuilder().
+			WithService("api", server.URL, "").
+			WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/items")).
+			WithTemplate("api", "stream", *template.NewRouteTemplate("GET", "/events")).
+			WithTransportOptions(opts)
+	}
+	service := newBuilder().Build()
+
+	// Regular and streaming requests share the tuned transport, and so its HTTP/2 connection
+	var result map[string]interface{}
+	if err := service.PerformRequest("api", "get", nil, &result); err != nil || result["proto"] != "HTTP/2.0" {
+		t.Fatalf("Expected an HTTP/2 request, got %v, %v", result, err)
+	}
+	response, err := service.PerformStreamingRequest("api", "stream", nil, httptest.NewRecorder())
+	if err != nil || response != "data: HTTP/2.0\n\n" {
+		t.Fatalf("Expected an HTTP/2 stream, got %q, %v", response, err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("Expected the stream to reuse the connection of the request, got %d connections", n)
+	}
+
+	// The idle connection is closed after IdleConnTimeout
+	time.Sleep(300 * time.Millisecond)
+	if err := service.PerformRequest("api", "get", nil, &result); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 2 {
+		t.Errorf("Expected a new connection once the idle one timed out, got %d connections", n)
+	}
+
+	// A custom transport is used as is, for both kinds of requests
+	var customCalls int32
+	custom := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&customCalls, 1)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"proto": "custom"}`)),
+			Request:    r,
+		}, nil
+	})
+	service = newBuilder().WithHTTPTransport(custom).Build()
+	if err := service.PerformRequest("api", "get", nil, &result); err != nil || result["proto"] != "custom" {
+		t.Errorf("Expected the custom transport to answer, got %v, %v", result, err)
+	}
+	if _, err := service.PerformStreamingRequest("api", "stream", nil, httptest.NewRecorder()); err != nil {
+		t.Errorf("Expected the custom transport to answer the stream, got %v", err)
+	}
+	if n := atomic.LoadInt32(&customCalls); n != 2 {
+		t.Errorf("Expected both requests to use the custom transport, got %d calls", n)
+	}
+	if n := atomic.LoadInt32(&connections); n != 2 {
+		t.Errorf("Expected the transport options to be ignored, got %d connections", n)
+	}
+}
+
+func TestDumpRequest(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("users", server.URL, "").
+		WithServiceHeaders("users", map[string]string{"X-Tenant": "acme"}).
+		WithTemplate("users", "create", *template.NewRouteTemplate("POST", "/users").
+			WithBody(map[string]interface{}{"name": "{{name}}"})).
+		WithTemplate("users", "upload", *template.NewRouteTemplate("PUT", "/users/avatar")).
+		Build()
+
+	req, err := service.PrepareRequest("users", "create", map[string]interface{}{"name": "Jo"})
+	if err != nil {
+		t.Fatalf("Failed to prepare request: %v", err)
+	}
+	dump, err := service.DumpRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to dump request: %v", err)
+	}
+	for _, expected := range []string{"POST /users HTTP/1.1", "X-Tenant: acme", `"name": "Jo"`} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Expected the dump to contain %q, got:\n%s", expected, dump)
+		}
+	}
+
+	// A streamed body is kept for the actual request
+	req, err = service.PrepareRequest("users", "upload", map[string]interface{}{
+		modularapi.BodyReaderParam: io.MultiReader(strings.NewReader("avatar-bytes")),
+	})
+	if err != nil {
+		t.Fatalf("Failed to prepare request: %v", err)
+	}
+	if dump, err = service.DumpRequest(req); err != nil || !strings.HasSuffix(dump, "avatar-bytes") {
+		t.Errorf("Expected the dump to end with the streamed body, got %q (%v)", dump, err)
+	}
+
+	// Both requests can still be sent with their body
+	created, _ := service.PrepareRequest("users", "create", map[string]interface{}{"name": "Jo"})
+	service.DumpRequest(created)
+	for _, dumped := range []*http.Request{created, req} {
+		if err := service.MakeRequest(dumped, &map[string]interface{}{}); err != nil {
+			t.Fatalf("Failed to send the dumped request: %v", err)
+		}
+	}
+	if len(received) != 2 || !strings.Contains(received[0], `"Jo"`) || received[1] != "avatar-bytes" {
+		t.Errorf("Expected the bodies to be sent after dumping, got %q", received)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.URL.Path != "/users/7/orders" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithService("shop", server.URL, "").
+		WithTemplate("shop", "orders", *template.NewRouteTemplate("POST", "/users/{{user_id}}/orders").
+			WithBody(map[string]interface{}{"sku": "{{sku}}", "note": "{{note?}}"})).
+		WithTemplate("shop", "typo", *template.NewRouteTemplate("GET", "/userz/{{user_id}}")).
+		Build()
+
+	// Every missing parameter is reported, without sending anything
+	err := service.ValidateTemplate("shop", "orders", nil, modularapi.WithPreflight("HEAD"))
+	if err == nil || !strings.Contains(err.Error(), "user_id") || !strings.Contains(err.Error(), "sku") {
+		t.Errorf("Expected both missing parameters to be reported, got: %v", err)
+	}
+	if len(methods) != 0 {
+		t.Errorf("Expected no preflight for an incomplete request, got %v", methods)
+	}
+
+	params := map[string]interface{}{"user_id": 7, "sku": "A1"}
+	if err := service.ValidateTemplate("shop", "orders", params); err != nil || len(methods) != 0 {
+		t.Errorf("Expected a valid template without preflight, got %v (requests: %v)", err, methods)
+	}
+
+	// A 405 to the preflight means the endpoint exists
+	if err := service.ValidateTemplate("shop", "orders", params, modularapi.WithPreflight("head")); err != nil {
+		t.Errorf("Expected the endpoint to be reachable, got: %v", err)
+	}
+	if len(methods) != 1 || methods[0] != http.MethodHead {
+		t.Errorf("Expected a single HEAD preflight, got %v", methods)
+	}
+
+	err = service.ValidateTemplate("shop", "typo", params, modularapi.WithPreflight("HEAD"))
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the preflight 404 to be reported, got: %v", err)
+	}
+}
+
+func TestClearTemplatesAndWorkflows(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithService("users", "https://users.example.com", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}")).
+		WithWorkflow("sync", "Sync users").
+		WithStep(modularapi.NewWorkflowStepTemplate("get", "Get user", "users", "get")).
+		Build().
+		Build()
+	if workflows := service.ListWorkflows(); len(workflows) != 1 {
+		t.Fatalf("Expected the workflow to be registered, got %v", workflows)
+	}
+
+	service.ClearTemplates()
+	if _, ok := service.DescribeAction("users", "get"); ok {
+		t.Errorf("Expected the template to be removed")
+	}
+	if _, err := service.PrepareRequest("users", "get", map[string]interface{}{"id": "1"}); err == nil {
+		t.Errorf("Expected a request for a cleared template to fail")
+	}
+
+	service.ClearWorkflows()
+	if workflows := service.ListWorkflows(); len(workflows) != 0 {
+		t.Errorf("Expected no workflows after ClearWorkflows, got %v", workflows)
+	}
+
+	// The service URL and other configuration are kept
+	if url := service.GetServiceURL("users"); url != "https://users.example.com" {
+		t.Errorf("Expected the service configuration to be kept, got %q", url)
+	}
+}
+
+func TestVerboseTemplateLogging(t *testing.T) {
+	var mu sync.Mutex
+	levels := make(map[string]log.LogLevel)
+	var globalLevels []log.LogLevel
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		levels[req.URL.Path] = requestLogLevel(req)
+		globalLevels = append(globalLevels, log.GlobalLogger.(*log.DefaultLogger).GetLogLevel())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("users", "http://users.test", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/user")).
+		WithTemplate("users", "flaky", *template.NewRouteTemplate("GET", "/flaky").WithVerboseLogging()).
+		Build()
+
+	var result map[string]interface{}
+	for _, action := range []string{"get", "flaky"} {
+		if err := service.PerformRequest("users", action, nil, &result); err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+	}
+	if levels["/user"] != log.ERROR || levels["/flaky"] != log.DEBUG {
+		t.Errorf("Expected only the verbose template to log at DEBUG level, got %v", levels)
+	}
+
+	// Concurrent verbose requests don't touch the global level
+	reqs := make([]modularapi.BatchRequest, 20)
+	for i := range reqs {
+		action := "flaky"
+		if i%2 == 1 {
+			action = "get"
+		}
+		reqs[i] = modularapi.BatchRequest{ServiceName: "users", Action: action}
+	}
+	for _, res := range service.PerformBatch(reqs, 10) {
+		if res.Err != nil {
+			t.Fatalf("Failed to perform batch request: %v", res.Err)
+		}
+	}
+	for _, level := range globalLevels {
+		if level != log.ERROR {
+			t.Fatalf("Expected the global ERROR level to be left unchanged, got %v", level)
+		}
+	}
+	if level := log.GlobalLogger.(*log.DefaultLogger).GetLogLevel(); level != log.ERROR {
+		t.Errorf("Expected the global ERROR level after the batch, got %v", level)
+	}
+
+	// A request option still takes precedence
+	err := service.PerformRequestWithOptions("users", "flaky", nil, &result, modularapi.WithRequestLogLevel(log.WARN))
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if levels["/flaky"] != log.WARN {
+		t.Errorf("Expected the request log level to override the template, got %v", levels["/flaky"])
+	}
+}
+
+func TestResponsePreprocessor(t *testing.T) {
+	bodies := map[string]string{
+		"/guarded": ")]}'\n{\"name\": \"guarded\"}",
+		"/jsonp":   `callback({"name": "jsonp"});`,
+	}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/javascript"}},
+			Body:       io.NopCloser(strings.NewReader(bodies[req.URL.Path])),
+			Request:    req,
+		}, nil
+	})
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithResponsePreprocessor("api", client.StripXSSIPrefix).
+		WithTemplate("api", "guarded", *template.NewRouteTemplate("GET", "/guarded")).
+		WithTemplate("api", "jsonp", *template.NewRouteTemplate("GET", "/jsonp").WithResponsePreprocessor(client.StripJSONP)).
+		Build()
+
+	for _, action := range []string{"guarded", "jsonp"} {
+		var result map[string]interface{}
+		if err := service.PerformRequest("api", action, nil, &result); err != nil {
+			t.Fatalf("Failed to perform request %s: %v", action, err)
+		}
+		if result["name"] != action {
+			t.Errorf("Expected the %s response to be decoded, got %v", action, result)
+		}
+	}
+
+	// Once the service preprocessor is removed, the guarded body can't be decoded
+	service.SetResponsePreprocessor("api", nil)
+	var result map[string]interface{}
+	if err := service.PerformRequest("api", "guarded", nil, &result); err == nil {
+		t.Error("Expected the guarded response to fail decoding without a preprocessor")
+	}
+}
+
+func TestSuccessStatuses(t *testing.T) {
+	statuses := map[string]int{"/created": http.StatusCreated, "/ok": http.StatusOK, "/cached": http.StatusNotModified}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: statuses[req.URL.Path],
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})
+
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithSuccessStatuses("api", "201").
+		WithTemplate("api", "created", *template.NewRouteTemplate("POST", "/created")).
+		WithTemplate("api", "ok", *template.NewRouteTemplate("GET", "/ok")).
+		WithTemplate("api", "cached", *template.NewRouteTemplate("GET", "/cached").WithSuccessStatuses("2xx", "300-304"))
+	service := builder.Build()
+	if err := builder.Err(); err != nil {
+		t.Fatalf("Unexpected configuration error: %v", err)
+	}
+
+	if err := service.PerformRequest("api", "created", nil, nil); err != nil {
+		t.Errorf("Expected 201 to be a success, got %v", err)
+	}
+	var apiErr *client.APIError
+	if err := service.PerformRequest("api", "ok", nil, nil); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 to fail when only 201 is a success, got %v", err)
+	}
+	if err := service.PerformRequest("api", "cached", nil, nil); err != nil {
+		t.Errorf("Expected the template statuses to accept 304, got %v", err)
+	}
+
+	// Clearing the service statuses restores the default 2xx range
+	service.SetSuccessStatuses("api")
+	if err := service.PerformRequest("api", "ok", nil, nil); err != nil {
+		t.Errorf("Expected 200 to be a success by default, got %v", err)
+	}
+
+	if err := modularapi.NewServiceBuilder().WithSuccessStatuses("api", "2xx", "abc").Err(); err == nil {
+		t.Error("Expected an invalid status range to be reported")
+	}
+}
+
+func TestPerformRequestAwait(t *testing.T) {
+	var polls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/exports":
+			w.Header().Set("Location", "/exports/1/status")
+			w.WriteHeader(http.StatusAccepted)
+		case "/imports":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"links": {"status": "/imports/1/status"}}`))
+		case "/exports/1/status":
+			if polls.Add(1) < 3 {
+				w.Write([]byte(`{"state": "running"}`))
+				return
+			}
+			w.Write([]byte(`{"state": "done", "url": "/files/1"}`))
+		case "/imports/1/status":
+			w.Write([]byte(`{"state": "error"}`))
+		}
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", server.URL, "secret").
+		WithTemplate("api", "export", *template.NewRouteTemplate("POST", "/exports")).
+		WithTemplate("api", "import", *template.NewRouteTemplate("POST", "/imports")).
+		Build()
+
+	poll := modularapi.PollSpec{
+		Interval:       time.Millisecond,
+		StatusField:    "state",
+		CompleteValues: []string{"done"},
+		FailedValues:   []string{"error"},
+	}
+	var result map[string]interface{}
+	if err := service.PerformRequestAwait("api", "export", nil, &result, poll); err != nil {
+		t.Fatalf("Failed to await the operation: %v", err)
+	}
+	if result["url"] != "/files/1" || polls.Load() != 3 {
+		t.Errorf("Expected the final status after 3 polls, got %v after %d polls", result, polls.Load())
+	}
+
+	poll.LocationField = "links.status"
+	err := service.PerformRequestAwait("api", "import", nil, &result, poll)
+	if !errors.Is(err, modularapi.ErrOperationFailed) {
+		t.Errorf("Expected the failed operation to be reported, got %v", err)
+	}
+
+	// The status never completes before the timeout
+	polls.Store(-1000)
+	poll.LocationField = ""
+	poll.Timeout = 20 * time.Millisecond
+	err = service.PerformRequestAwait("api", "export", nil, &result, poll)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the poll to time out, got %v", err)
+	}
+
+	// An expired token is refreshed as for any request, and the polls send the fresh one
+	polls.Store(0)
+	poll.Timeout = 0
+	service = modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", server.URL, "expired").
+		WithTemplate("api", "export", *template.NewRouteTemplate("POST", "/exports")).
+		WithTokenRefresh("api", func(string) (string, error) { return "secret", nil }).
+		Build()
+	result = nil
+	if err := service.PerformRequestAwait("api", "export", nil, &result, poll); err != nil {
+		t.Fatalf("Expected the token to be refreshed, got %v", err)
+	}
+	if result["url"] != "/files/1" {
+		t.Errorf("Expected the final status, got %v", result)
+	}
+}
+
+func TestParamHook(t *testing.T) {
+	var requests []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.URL.RawQuery)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithServiceParams("api", map[string]interface{}{"key": "k1"}).
+		WithParamHook("api", func(serviceName, action string, params map[string]interface{}) error {
+			if params["user"] == "blocked" {
+				return fmt.Errorf("user is blocked")
+			}
+			// The hook sees the merged parameters
+			params["signature"] = fmt.Sprintf("%s:%s:%v:%v", serviceName, action, params["key"], params["user"])
+			return nil
+		}).
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/users").
+			WithQueryParams(map[string]interface{}{"user": "{{user}}", "sig": "{{signature}}"})).
+		Build()
+
+	if err := service.PerformRequest("api", "get", map[string]interface{}{"user": "bob"}, nil); err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if len(requests) != 1 || requests[0] != "sig=api%3Aget%3Ak1%3Abob&user=bob" {
+		t.Errorf("Expected the hook to add the signature, got %v", requests)
+	}
+
+	err := service.PerformRequest("api", "get", map[string]interface{}{"user": "blocked"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "user is blocked") || len(requests) != 1 {
+		t.Errorf("Expected the hook error to abort the request, got %v", err)
+	}
+}
+
+func TestRequestSigner(t *testing.T) {
+	var timestamps []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		timestamp := req.Header.Get("X-Timestamp")
+		timestamps = append(timestamps, timestamp)
+
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", req.Method, req.URL.RequestURI(), timestamp, body)
+		status := http.StatusOK
+		if req.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			status = http.StatusUnauthorized
+		} else if len(timestamps) == 1 {
+			status = http.StatusServiceUnavailable
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	clock := time.Unix(1700000000, 0)
+	signer := client.HMACSigner{Now: func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}}
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithRetryPolicy(client.RetryPolicy{MaxRetries: 1, Backoff: func(int, *http.Response) time.Duration { return 0 }}).
+		WithService("api", "http://api.test", "").
+		WithRequestSigner("api", signer, "s3cret").
+		WithTemplate("api", "create", *template.NewRouteTemplate("POST", "/orders").
+			WithBody(map[string]interface{}{"item": "{{item}}"})).
+		Build()
+
+	if err := service.PerformRequest("api", "create", map[string]interface{}{"item": "book"}, nil); err != nil {
+		t.Fatalf("Failed to perform signed request: %v", err)
+	}
+	if len(timestamps) != 2 || timestamps[0] == timestamps[1] {
+		t.Errorf("Expected the retry to be signed again with a new timestamp, got %v", timestamps)
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	var requested []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"id": 1234567890123456789}`)),
+			Request:    req,
+		}, nil
+	})
+
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithUseNumber().
+		WithService("users", "http://api.test", "").
+		WithTemplate("users", "me", *template.NewRouteTemplate("GET", "/me")).
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}"))
+	builder.WithWorkflow("forward_id", "Forward a large ID").
+		WithStep(modularapi.NewWorkflowStepTemplate("me", "Get the current user", "users", "me").
+			WithResultMap("id", "user_id")).
+		WithStep(modularapi.NewWorkflowStepTemplate("get", "Get the user", "users", "get").
+			WithDynamicParam("id", "user_id").
+			WithConditionExpr("{{user_id == expected}}")).
+		Build()
+	service := builder.Build()
+
+	var result map[string]interface{}
+	if err := service.PerformRequest("users", "me", nil, &result); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if id, ok := result["id"].(json.Number); !ok || id.String() != "1234567890123456789" {
+		t.Errorf("Expected the ID to be decoded as a json.Number, got %#v", result["id"])
+	}
+
+	requested = nil
+	params := map[string]interface{}{"expected": json.Number("1234567890123456789")}
+	if err := service.ExecuteWorkflow("forward_id", params, nil); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	if len(requested) != 2 || requested[1] != "/users/1234567890123456789" {
+		t.Errorf("Expected the ID to be forwarded without losing precision, got %v", requested)
+	}
+
+	// IDs equal as float64 but not as integers are different
+	requested = nil
+	params = map[string]interface{}{"expected": json.Number("1234567890123456788")}
+	if err := service.ExecuteWorkflow("forward_id", params, nil); err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+	if len(requested) != 1 {
+		t.Errorf("Expected the condition comparing different IDs to skip the step, got %v", requested)
+	}
+}
+
+func TestMergeTemplates(t *testing.T) {
+	base := template.NewTemplateStore()
+	base.AddTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}"))
+	base.AddTemplate("users", "list", *template.NewRouteTemplate("GET", "/users"))
+
+	tenant := template.NewTemplateStore()
+	override := template.NewRouteTemplate("GET", "/tenants/acme/users/{{id}}").
+		WithQueryParams(map[string]interface{}{"fields": "{{fields?}}"})
+	tenant.AddTemplate("users", "get", *override)
+
+	merged := template.NewTemplateStore()
+	merged.Merge(base)
+	merged.Merge(tenant)
+
+	get, _ := merged.GetTemplate("users", "get")
+	if get.Endpoint != "/tenants/acme/users/{{id}}" || !get.OptionalParams["fields"] {
+		t.Errorf("Expected the tenant template to win with its optional params scanned, got %+v", get)
+	}
+	if list, ok := merged.GetTemplate("users", "list"); !ok || list.Endpoint != "/users" {
+		t.Errorf("Expected the base template to be kept, got %+v", list)
+	}
+
+	// Merged templates are copies
+	override.QueryParams["fields"] = "all"
+	tenant.AddTemplate("users", "get", *template.NewRouteTemplate("GET", "/changed"))
+	if get, _ := merged.GetTemplate("users", "get"); get.Endpoint != "/tenants/acme/users/{{id}}" || get.QueryParams["fields"] != "{{fields?}}" {
+		t.Errorf("Expected the merged template to be unaffected by later changes, got %+v", get)
+	}
+
+	service := modularapi.NewServiceBuilder().WithLogLevel(log.ERROR).Build()
+	service.MergeTemplates(merged)
+	if _, ok := service.DescribeAction("users", "list"); !ok {
+		t.Error("Expected the merged templates to be added to the service")
+	}
+}
+
+func TestStepRequestInfo(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		status := http.StatusOK
+		if req.URL.Path == "/fail" {
+			status = http.StatusInternalServerError
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("users", "http://api.test", "").
+		WithTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}").
+			WithQueryParams(map[string]interface{}{"fields": "{{fields}}"})).
+		WithTemplate("users", "fail", *template.NewRouteTemplate("DELETE", "/fail"))
+	builder.WithWorkflow("audited", "Audited workflow").
+		WithStep(modularapi.NewWorkflowStepTemplate("get", "Get the user", "users", "get").
+			WithParam("id", "42").WithParam("fields", "name")).
+		WithStep(modularapi.NewWorkflowStepTemplate("fail", "Fail", "users", "fail").
+			WithErrorHandling(workflow.ContinueOnError, 0)).
+		Build()
+	service := builder.Build()
+
+	requests := make(map[string][]workflow.RequestInfo)
+	err := service.ExecuteWorkflow("audited", nil, nil,
+		modularapi.WithStepHook(func(workflowName string, result workflow.StepResult) {
+			requests[result.StepID] = result.Requests
+		}))
+	if err != nil {
+		t.Fatalf("Workflow failed: %v", err)
+	}
+
+	expected := []workflow.RequestInfo{{Method: "GET", URL: "http://api.test/users/42?fields=name"}}
+	if !reflect.DeepEqual(requests["get"], expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests["get"])
+	}
+	// Failed requests are reported too
+	expected = []workflow.RequestInfo{{Method: "DELETE", URL: "http://api.test/fail"}}
+	if !reflect.DeepEqual(requests["fail"], expected) {
+		t.Errorf("Expected requests %v, got %v", expected, requests["fail"])
+	}
+}
+
+func TestEndpointQueryString(t *testing.T) {
+	var requested []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	search := template.NewRouteTemplate("GET", "/search/{{index?}}?type={{type}}&q={{query?}}&format=json").
+		WithQueryParams(map[string]interface{}{"format": "xml", "limit": "{{limit?}}"})
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithTemplate("api", "search", *search).
+		Build()
+
+	if err := service.PerformRequest("api", "search", map[string]interface{}{"type": "user", "query": "a&b c", "index": "people"}, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	// Explicit query parameters take precedence and values are encoded
+	if expected := "http://api.test/search/people?format=xml&q=a%26b+c&type=user"; requested[0] != expected {
+		t.Errorf("Expected %s, got %s", expected, requested[0])
+	}
+
+	if err := service.PerformRequest("api", "search", map[string]interface{}{"type": "user"}, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if expected := "http://api.test/search?format=xml&type=user"; requested[1] != expected {
+		t.Errorf("Expected optional placeholders to be dropped, got %s", requested[1])
+	}
+
+	if err := service.PerformRequest("api", "search", nil, nil); err == nil || !strings.Contains(err.Error(), "missing required query parameter: type") {
+		t.Errorf("Expected the required query placeholder to be enforced, got %v", err)
+	}
+	if search.Endpoint != "/search/{{index?}}?type={{type}}&q={{query?}}&format=json" || len(search.QueryParams) != 2 {
+		t.Errorf("Expected the template passed to the builder to be left untouched, got %+v", search)
+	}
+	// The stored template keeps its endpoint, and lists the parameters of its query string
+	schema, _ := service.DescribeAction("api", "search")
+	if schema.Endpoint != search.Endpoint || !reflect.DeepEqual(schema.OptionalParams, []string{"index", "limit", "query"}) ||
+		!reflect.DeepEqual(schema.RequiredParams, []string{"type"}) {
+		t.Errorf("Expected the stored template to keep its endpoint, got %+v", schema)
+	}
+}
+
+func TestDeclaredTemplateParams(t *testing.T) {
+	var requested []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	tmpl := template.NewRouteTemplate("GET", "/orgs/{{org}}/users/{{id}}").
+		WithQueryParams(map[string]interface{}{"limit": "{{limit}}"}).
+		WithPathParam("org", false).
+		WithPathParam("id", true).
+		WithOptionalParam("limit")
+	if optional := tmpl.OptionalParamNames(); !reflect.DeepEqual(optional, []string{"limit", "org"}) {
+		t.Errorf("Expected org and limit to be optional, got %v", optional)
+	}
+	if required := tmpl.RequiredParams(); !reflect.DeepEqual(required, []string{"id"}) {
+		t.Errorf("Expected id to be required, got %v", required)
+	}
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithTemplate("api", "get", *tmpl).
+		Build()
+
+	if err := service.PerformRequest("api", "get", map[string]interface{}{"id": "7"}, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if expected := "http://api.test/orgs/users/7"; requested[0] != expected {
+		t.Errorf("Expected the optional path segment and query parameter to be dropped, got %s", requested[0])
+	}
+	if err := service.PerformRequest("api", "get", map[string]interface{}{"org": "acme"}, nil); err == nil {
+		t.Error("Expected the required path parameter to be enforced")
+	}
+}
+
+func TestOptionalHeaders(t *testing.T) {
+	var headers []http.Header
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		headers = append(headers, req.Header.Clone())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	tmpl := template.NewRouteTemplate("POST", "/payments").
+		WithHeaders(map[string]string{
+			"X-Idempotency-Key": "{{idempotency_key?}}",
+			"X-Tenant":          "{{tenant}}",
+			"Accept":            "application/json",
+		})
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("api", "http://api.test", "").
+		WithTemplate("api", "pay", *tmpl).
+		Build()
+
+	if err := service.PerformRequest("api", "pay", map[string]interface{}{"tenant": "acme", "idempotency_key": "k1"}, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if headers[0].Get("X-Idempotency-Key") != "k1" || headers[0].Get("X-Tenant") != "acme" || headers[0].Get("Accept") != "application/json" {
+		t.Errorf("Expected the header placeholders to be replaced, got %v", headers[0])
+	}
+
+	if err := service.PerformRequest("api", "pay", map[string]interface{}{"tenant": "acme"}, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if _, sent := headers[1]["X-Idempotency-Key"]; sent {
+		t.Errorf("Expected the optional header to be omitted, got %v", headers[1])
+	}
+
+	if err := service.PerformRequest("api", "pay", nil, nil); err == nil {
+		t.Error("Expected the required header parameter to be enforced")
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_agent": r.UserAgent()})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithUserAgent("reports/1.0").
+		WithService("api", server.URL, "").
+		WithService("billing", server.URL, "").
+		WithServiceUserAgent("billing", "reports-billing/1.0").
+		WithService("legacy", server.URL, "").
+		WithServiceHeaders("legacy", map[string]string{"User-Agent": "legacy-client"}).
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/agent")).
+		WithTemplate("billing", "get", *template.NewRouteTemplate("GET", "/agent")).
+		WithTemplate("legacy", "get", *template.NewRouteTemplate("GET", "/agent")).
+		Build()
+
+	for serviceName, expected := range map[string]string{
+		"api":     "reports/1.0",
+		"billing": "reports-billing/1.0",
+		"legacy":  "legacy-client",
+	} {
+		var result map[string]interface{}
+		if err := service.PerformRequest(serviceName, "get", nil, &result); err != nil {
+			t.Fatalf("Request to %s failed: %v", serviceName, err)
+		}
+		if result["user_agent"] != expected {
+			t.Errorf("Expected %s to send the user agent %s, got %v", serviceName, expected, result["user_agent"])
+		}
+	}
+}
+
+func TestDecodeErrorBody(t *testing.T) {
+	body := "<html>" + strings.Repeat("maintenance ", 100) + "</html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", server.URL, "").
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/users")).
+		Build()
+
+	var result map[string]interface{}
+	err := service.PerformRequest("api", "get", nil, &result)
+	var decodeErr *client.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Expected a DecodeError, got %v", err)
+	}
+	if string(decodeErr.Body) != body {
+		t.Errorf("Expected the error to hold the whole body, got %q", decodeErr.Body)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("Expected the JSON error to be wrapped, got %v", err)
+	}
+	message := err.Error()
+	if !strings.Contains(message, "<html>maintenance") || strings.Contains(message, "</html>") {
+		t.Errorf("Expected a truncated body snippet in the message, got %s", message)
+	}
+}
+
+func TestResumableStreamingRequest(t *testing.T) {
+	var lastEventIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(lastEventIDs) > 1 {
+			io.WriteString(w, "id: 2\ndata: second\n\n")
+			return
+		}
+
+		// Send an event and the start of another, then drop the connection
+		io.WriteString(w, "id: 1\ndata: first\n\nid: 2\n")
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack the connection: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("events", server.URL, "").
+		WithTemplate("events", "stream", *template.NewRouteTemplate("GET", "/events")).
+		Build()
+
+	recorder := httptest.NewRecorder()
+	response, err := service.PerformResumableStreamingRequest(context.Background(), "events", "stream", nil, recorder, 2)
+	if err != nil {
+		t.Fatalf("Expected the stream to resume, got: %v", err)
+	}
+	if len(lastEventIDs) != 2 || lastEventIDs[0] != "" || lastEventIDs[1] != "1" {
+		t.Errorf("Expected a reconnection after event 1, got Last-Event-ID headers %q", lastEventIDs)
+	}
+	expected := "id: 1\ndata: first\n\nid: 2\nid: 2\ndata: second\n\n"
+	if response != expected || recorder.Body.String() != expected {
+		t.Errorf("Expected both connections to be streamed, got %q and %q", response, recorder.Body.String())
+	}
+
+	// Without reconnections, the drop is reported
+	lastEventIDs = nil
+	if _, err := service.PerformResumableStreamingRequest(context.Background(), "events", "stream", nil, httptest.NewRecorder(), 0); err == nil {
+		t.Error("Expected the dropped stream to fail without reconnections")
+	}
+}
+
+func TestVersionedTemplates(t *testing.T) {
+	var requested []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+
+	store := template.NewTemplateStore()
+	store.AddTemplateVersion("users", "get", "v2", *template.NewRouteTemplate("GET", "/v2/users"))
+	store.AddTemplateVersion("users", "get", "v10", *template.NewRouteTemplate("GET", "/v10/users"))
+	store.AddTemplateVersion("users", "get", "v1", *template.NewRouteTemplate("GET", "/v1/users"))
+	if tmpl, ok := store.GetTemplateVersion("users", "get", "v2"); !ok || tmpl.Endpoint != "/v2/users" {
+		t.Errorf("Expected the v2 template, got %+v", tmpl)
+	}
+	if tmpl, ok := store.GetTemplate("users", "get"); !ok || tmpl.Endpoint != "/v10/users" {
+		t.Errorf("Expected the latest version without an unversioned template, got %+v", tmpl)
+	}
+	if _, ok := store.GetTemplateVersion("users", "get", "v3"); ok {
+		t.Error("Expected an unknown version not to fall back")
+	}
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithHTTPTransport(transport).
+		WithService("users", "http://api.test", "").
+		Build()
+	service.MergeTemplates(store)
+
+	for _, action := range []string{"get@v1", "get"} {
+		if err := service.PerformRequest("users", action, nil, nil); err != nil {
+			t.Fatalf("Request for %s failed: %v", action, err)
+		}
+	}
+	service.AddRouteTemplate("users", "get", *template.NewRouteTemplate("GET", "/users"))
+	if err := service.PerformRequest("users", "get", nil, nil); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if expected := []string{"/v1/users", "/v10/users", "/users"}; !reflect.DeepEqual(requested, expected) {
+		t.Errorf("Expected pinned, latest then unversioned templates, got %v", requested)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current := template.NewTemplateStore()
+	current.AddTemplate("users", "get", *template.NewRouteTemplate("GET", "/users/{{id}}"))
+	current.AddTemplate("users", "list", *template.NewRouteTemplate("GET", "/users"))
+	current.AddTemplate("users", "legacy", *template.NewRouteTemplate("GET", "/v0/users"))
+
+	next := template.NewTemplateStore()
+	next.AddTemplate("users", "list", *template.NewRouteTemplate("GET", "/users"))
+	next.AddTemplate("users", "create", *template.NewRouteTemplate("POST", "/users"))
+	get := template.NewRouteTemplate("GET", "/users/{{id}}")
+	get.Headers["Accept"] = "application/json"
+	next.AddTemplate("users", "get", *get)
+
+	diff := current.Diff(next)
+	expected := template.TemplateDiff{
+		Added:   []string{"users.create"},
+		Removed: []string{"users.legacy"},
+		Changed: []string{"users.get"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diff)
+	}
+	if summary := diff.String(); summary != "+ users.create\n- users.legacy\n~ users.get\n" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+	if !current.Diff(current).Empty() {
+		t.Error("Expected no difference between a store and itself")
+	}
+
+	currentCfg := config.NewConfig()
+	currentCfg.SetServiceConfig("users", config.ApiConfig{ApiURL: "https://api.test", ApiToken: "old"})
+	currentCfg.SetServiceConfig("billing", config.ApiConfig{ApiURL: "https://billing.test"})
+	nextCfg := config.NewConfig()
+	nextCfg.SetServiceConfig("users", config.ApiConfig{ApiURL: "https://api.test", ApiToken: "new"})
+	nextCfg.SetServiceConfig("billing", config.ApiConfig{ApiURL: "https://billing.test"})
+	nextCfg.SetServiceConfig("reports", config.ApiConfig{ApiURL: "https://reports.test"})
+
+	cfgDiff := currentCfg.Diff(nextCfg)
+	if !reflect.DeepEqual(cfgDiff.Added, []string{"reports"}) || cfgDiff.Removed != nil || !reflect.DeepEqual(cfgDiff.Changed, []string{"users"}) {
+		t.Errorf("Unexpected config diff %+v", cfgDiff)
+	}
+	if strings.Contains(cfgDiff.String(), "new") {
+		t.Errorf("Expected the summary not to include settings, got %q", cfgDiff.String())
+	}
+}
+
+func TestServiceFallback(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer primary.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"authorization": r.Header.Get("Authorization")})
+	}))
+	defer replica.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", primary.URL, "primary-token").
+		WithServiceFallback("api", down.URL, "down-token").
+		WithServiceFallback("api", replica.URL, "replica-token").
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/users")).
+		Build()
+
+	// A 5xx and a connection error move on to the next endpoint, with its own token
+	var result map[string]interface{}
+	if err := service.PerformRequest("api", "get", nil, &result); err != nil {
+		t.Fatalf("Expected the request to fail over, got %v", err)
+	}
+	if result["authorization"] != "Bearer replica-token" {
+		t.Errorf("Expected the replica token, got %v", result["authorization"])
+	}
+
+	// Client errors are not failed over
+	status = http.StatusNotFound
+	err := service.PerformRequest("api", "get", nil, &result)
+	var failoverErr *modularapi.FailoverError
+	if errors.As(err, &failoverErr) {
+		t.Errorf("Expected a 404 not to fail over, got %v", err)
+	}
+
+	// Every attempt is reported when all endpoints fail
+	replica.Close()
+	status = http.StatusInternalServerError
+	err = service.PerformRequest("api", "get", nil, &result)
+	if !errors.As(err, &failoverErr) || len(failoverErr.Attempts) != 3 {
+		t.Fatalf("Expected a failover error with 3 attempts, got %v", err)
+	}
+	if failoverErr.Attempts[0].URL != primary.URL || failoverErr.Attempts[2].URL != replica.URL {
+		t.Errorf("Expected the attempts in order, got %+v", failoverErr.Attempts)
+	}
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected the primary API error to be found, got %v", err)
+	}
+}
+
+func TestDefaultParamsEnv(t *testing.T) {
+	t.Setenv("MODULAR_API_TEST_ACCOUNT", "acme")
+
+	builder := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", "http://api.test", "").
+		WithServiceDefaultParams("api", map[string]interface{}{
+			"account": "${MODULAR_API_TEST_ACCOUNT}",
+			"path":    "accounts/${MODULAR_API_TEST_ACCOUNT}/$id",
+			"limit":   10,
+		}).
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/items"))
+	service := builder.Build()
+	if err := builder.Err(); err != nil {
+		t.Fatalf("Unexpected builder error: %v", err)
+	}
+
+	params, err := service.ResolveParams("api", "get", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve params: %v", err)
+	}
+	if params["account"] != "acme" || params["path"] != "accounts/acme/$id" || params["limit"] != 10 {
+		t.Errorf("Expected the environment variables to be expanded, got %v", params)
+	}
+
+	// An unset variable is an error in strict mode
+	builder = modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithStrictEnv().
+		WithService("api", "http://api.test", "").
+		WithServiceDefaultParams("api", map[string]interface{}{"account": "${MODULAR_API_TEST_UNSET}"})
+	builder.Build()
+	if err := builder.Err(); err == nil || !strings.Contains(err.Error(), "MODULAR_API_TEST_UNSET") {
+		t.Errorf("Expected an error for the unset variable, got %v", err)
+	}
+
+	// Regular service parameters are used as they are
+	service = modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", "http://api.test", "").
+		WithServiceDefaultParams("api", map[string]interface{}{"account": "${MODULAR_API_TEST_ACCOUNT}"}).
+		WithServiceParams("api", map[string]interface{}{"pattern": "${MODULAR_API_TEST_ACCOUNT}"}).
+		WithTemplate("api", "get", *template.NewRouteTemplate("GET", "/items")).
+		Build()
+	params, err = service.ResolveParams("api", "get", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve params: %v", err)
+	}
+	if params["account"] != "acme" || params["pattern"] != "${MODULAR_API_TEST_ACCOUNT}" {
+		t.Errorf("Expected only the default parameters to be expanded, got %v", params)
+	}
+
+	// NewService expands a copy of the configuration
+	cfg := config.NewConfig()
+	cfg.SetServiceConfig("api", config.ApiConfig{
+		ApiURL:        "http://api.test",
+		DefaultParams: map[string]interface{}{"account": "${MODULAR_API_TEST_ACCOUNT}"},
+	})
+	service = modularapi.NewService(cfg)
+	service.AddRouteTemplate("api", "get", *template.NewRouteTemplate("GET", "/items"))
+	params, err = service.ResolveParams("api", "get", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve params: %v", err)
+	}
+	if params["account"] != "acme" {
+		t.Errorf("Expected NewService to expand the environment variables, got %v", params)
+	}
+	if account := cfg.Services["api"].DefaultParams["account"]; account != "${MODULAR_API_TEST_ACCOUNT}" {
+		t.Errorf("Expected the configuration to be left unchanged, got %v", account)
+	}
+}
+
+func TestParamTypes(t *testing.T) {
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithService("api", "http://api.test", "").
+		WithTemplate("api", "create", *template.NewRouteTemplate("POST", "/items/{{id}}").
+			WithBody(map[string]interface{}{
+				"count":  "{{count}}",
+				"price":  "{{price}}",
+				"active": "{{active}}",
+				"code":   "{{code}}",
+			}).
+			WithParamType("id", template.ParamTypeInt).
+			WithParamType("count", template.ParamTypeInt).
+			WithParamType("price", template.ParamTypeFloat).
+			WithParamType("active", template.ParamTypeBool).
+			WithParamType("code", template.ParamTypeString)).
+		Build()
+
+	req, err := service.PrepareRequest("api", "create", map[string]interface{}{
+		"id": "7", "count": "42", "price": " 9.5", "active": "true", "code": "007",
+	})
+	if err != nil {
+		t.Fatalf("Failed to prepare request: %v", err)
+	}
+	if req.URL.Path != "/items/7" {
+		t.Errorf("Expected the coerced path parameter, got %s", req.URL.Path)
+	}
+	body, _ := io.ReadAll(req.Body)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Invalid body %s: %v", body, err)
+	}
+	expected := map[string]interface{}{"count": 42.0, "price": 9.5, "active": true, "code": "007"}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected typed body values %v, got %v", expected, decoded)
+	}
+
+	// A value that doesn't convert names the parameter and the type
+	_, err = service.PrepareRequest("api", "create", map[string]interface{}{
+		"id": "7", "count": "many", "price": 1, "active": false, "code": "x",
+	})
+	if err == nil || !strings.Contains(err.Error(), `parameter count: cannot convert "many" to int`) {
+		t.Errorf("Expected a conversion error, got %v", err)
+	}
+}
+
+func TestGetWithBody(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// Fail the first attempt after reading the body, so the retry must send it again
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":       r.Method,
+			"content_type": r.Header.Get("Content-Type"),
+			"body":         string(body),
+		})
+	}))
+	defer server.Close()
+
+	service := modularapi.NewServiceBuilder().
+		WithLogLevel(log.ERROR).
+		WithRetryPolicy(client.RetryPolicy{MaxRetries: 1, Backoff: func(int, *http.Response) time.Duration { return 0 }}).
+		WithService("search", server.URL, "").
+		WithTemplate("search", "query", *template.NewRouteTemplate("GET", "/logs/_search").
+			WithBody(map[string]interface{}{"query": map[string]interface{}{"match": map[string]interface{}{"message": "{{text}}"}}})).
+		Build()
+
+	var result map[string]interface{}
+	if err := service.PerformRequest("search", "query", map[string]interface{}{"text": "timeout"}, &result); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected the request to be retried once, got %d attempts", attempts)
+	}
+	if result["method"] != http.MethodGet || result["content_type"] != "application/json" {
+		t.Errorf("Expected a JSON GET request, got %v", result)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(result["body"].(string)), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %q", result["body"])
+	}
+	expected := map[string]interface{}{"query": map[string]interface{}{"match": map[string]interface{}{"message": "timeout"}}}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("Expected the body %v to be sent again on retry, got %v", expected, body)
+	}
+}
+
+func TestStepTemplatePlaceholders(t *testing.T) {
+	step := modularapi.NewWorkflowStepTemplate("charge", "Charge the order", "payments", "charge").
+		WithParam("ref", "{{order-id}}").
+		WithParam("user", "{{user.id}}").
+		WithParam("amount", "{{price * qty}}").
+		WithParam("currency", "EUR")
+
+	// Variables are referenced by name, expressions keep their braces to be evaluated
+	expected := map[string]string{"ref": "order-id", "user": "user.id", "amount": "{{price * qty}}"}
+	if !reflect.DeepEqual(step.DynamicParams, expected) {
+		t.Errorf("Expected dynamic parameters %v, got %v", expected, step.DynamicParams)
+	}
+	if step.Parameters["currency"] != "EUR" {
+		t.Errorf("Expected a static currency parameter, got %v", step.Parameters)
+	}
+}
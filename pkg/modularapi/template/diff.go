@@ -0,0 +1,92 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemplateDiff lists the templates differing between two stores, as sorted "service.action"
+// entries
+type TemplateDiff struct {
+	Added   []string // Templates only in the other store
+	Removed []string // Templates only in the store
+	Changed []string // Templates in both stores with different contents
+}
+
+// Empty reports whether the stores have the same templates
+func (d TemplateDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a summary of the differences with one entry per line, prefixed with "+" for
+// added, "-" for removed and "~" for changed templates
+func (d TemplateDiff) String() string {
+	return formatDiff(d.Added, d.Removed, d.Changed)
+}
+
+// Diff compares the store, such as the templates in use, with other, such as templates about
+// to be loaded. Templates are compared on their full contents as saved to a file, so a response
+// preprocessor, which can't be saved, is ignored.
+func (ts *TemplateStore) Diff(other *TemplateStore) TemplateDiff {
+	current := ts.snapshot()
+	next := other.snapshot()
+
+	var diff TemplateDiff
+	for key, route := range next {
+		previous, ok := current[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case previous != route:
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range current {
+		if _, ok := next[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// snapshot returns the JSON encoding of every template of the store by "service.action" key.
+// A nil store has no templates.
+func (ts *TemplateStore) snapshot() map[string]string {
+	snapshot := make(map[string]string)
+	if ts == nil {
+		return snapshot
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for service, routes := range ts.templates {
+		for action, route := range routes {
+			data, err := json.Marshal(route)
+			if err != nil {
+				// Compare templates with values JSON can't encode on their Go representation
+				data = []byte(fmt.Sprintf("%#v", route))
+			}
+			snapshot[service+"."+action] = string(data)
+		}
+	}
+	return snapshot
+}
+
+// formatDiff formats added, removed and changed entries, one per line
+func formatDiff(added, removed, changed []string) string {
+	var sb strings.Builder
+	for _, group := range []struct {
+		prefix  string
+		entries []string
+	}{{"+", added}, {"-", removed}, {"~", changed}} {
+		for _, entry := range group.entries {
+			sb.WriteString(group.prefix + " " + entry + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,56 @@
+package template
+
+import "reflect"
+
+// WithOmitEmpty makes requests built from the template drop the body fields left empty once
+// the template is processed, see OmitEmptyFields
+func (rt *RouteTemplate) WithOmitEmpty() *RouteTemplate {
+	rt.OmitEmpty = true
+	return rt
+}
+
+// OmitEmptyFields returns a copy of a processed body without its empty fields, like the
+// omitempty option of encoding/json: nil values, empty strings and empty arrays and objects
+// are removed. Nested objects are processed as well, and removed when all their fields were,
+// while array elements are kept in place. An explicit Null is nil once processed, so it is
+// removed too.
+func OmitEmptyFields(body map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		if value = omitEmptyValue(value); !isEmptyValue(value) {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// omitEmptyValue removes the empty fields of the objects within a value
+func omitEmptyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return OmitEmptyFields(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = omitEmptyValue(item)
+		}
+		return items
+	default:
+		return value
+	}
+}
+
+// isEmptyValue reports whether a body value is nil, an empty string or an empty array or object
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,426 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// openAPIVersion is the OpenAPI version produced by ExportOpenAPI
+const openAPIVersion = "3.0.3"
+
+// openAPIDocument is the subset of an OpenAPI 3 document used for template import and export
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    openAPIInfo                            `json:"info"`
+	Servers []openAPIServer                        `json:"servers,omitempty"`
+	Tags    []openAPITag                           `json:"tags,omitempty"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+// openAPIImportDocument is the document shape read by ImportOpenAPI. Path items are kept
+// raw because they mix operations with path-level fields such as shared parameters.
+type openAPIImportDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPIInfo holds the document metadata
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// openAPIServer is a server the operations are served from
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+// openAPITag groups operations; each service becomes a tag
+type openAPITag struct {
+	Name string `json:"name"`
+}
+
+// openAPIOperation describes a single method on a path
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Summary     string                     `json:"summary,omitempty"`
+	Servers     []openAPIServer            `json:"servers,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses,omitempty"`
+}
+
+// openAPIParameter describes a path, query or header parameter
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema,omitempty"`
+}
+
+// openAPIRequestBody describes the body of an operation
+type openAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+// openAPIMediaType holds the schema of a body for a content type
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema,omitempty"`
+}
+
+// openAPIResponse describes an operation response
+type openAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// openAPISchema is a minimal JSON schema
+type openAPISchema struct {
+	Ref        string                    `json:"$ref,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	Properties map[string]*openAPISchema `json:"properties,omitempty"`
+	Items      *openAPISchema            `json:"items,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Default    interface{}               `json:"default,omitempty"`
+}
+
+// ExportOpenAPI generates an OpenAPI 3 document describing every template in the store.
+// Each service becomes a tag and each action an operation with the ID "service.action".
+// serverURLs maps service names to their base URL, which is set as the operation's server.
+// Placeholder types are unknown, so placeholders are described as string parameters.
+func (ts *TemplateStore) ExportOpenAPI(serverURLs map[string]string) ([]byte, error) {
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "Modular API",
+			Version: "1.0.0",
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+	}
+
+	// Track which operation owns each path and method to report conflicts
+	owners := make(map[string]string)
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, serviceName := range sortedKeys(ts.templates) {
+		doc.Tags = append(doc.Tags, openAPITag{Name: serviceName})
+
+		actions := ts.templates[serviceName]
+		for _, action := range sortedKeys(actions) {
+			tmpl := actions[action]
+			operationID := serviceName + "." + action
+			path, operation := exportOperation(tmpl)
+			operation.OperationID = operationID
+			operation.Tags = []string{serviceName}
+			if url := serverURLs[serviceName]; url != "" {
+				operation.Servers = []openAPIServer{{URL: url}}
+			}
+
+			method := strings.ToLower(tmpl.Method)
+			ownerKey := method + " " + path
+			if owner, exists := owners[ownerKey]; exists {
+				return nil, fmt.Errorf("operation %s conflicts with %s on %s %s",
+					operationID, owner, strings.ToUpper(method), path)
+			}
+			owners[ownerKey] = operationID
+
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = make(map[string]openAPIOperation)
+			}
+			doc.Paths[path][method] = operation
+		}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal OpenAPI document: %w", err)
+	}
+	return data, nil
+}
+
+// openAPIMethods lists the path item fields that hold operations
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// ImportOpenAPI builds route templates from an OpenAPI 3 document in JSON format.
+// The result is keyed by service name then action name:
+//   - an operationId of the form "service.action" is split into both names
+//   - otherwise the first tag (or "default") is the service and the operationId the action
+//   - without an operationId, the action is derived from the method and path
+//
+// Path parameters such as {id} become {{id}} placeholders, query parameters and top-level
+// JSON body properties become placeholders named after them, and parameters that are not
+// required are marked optional.
+func ImportOpenAPI(spec []byte) (map[string]map[string]RouteTemplate, error) {
+	var doc openAPIImportDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal OpenAPI document: %w", err)
+	}
+
+	templates := make(map[string]map[string]RouteTemplate)
+	for _, path := range sortedKeys(doc.Paths) {
+		pathItem := doc.Paths[path]
+
+		// Parameters shared by every operation on the path
+		var pathParameters []openAPIParameter
+		if raw, ok := pathItem["parameters"]; ok {
+			if err := json.Unmarshal(raw, &pathParameters); err != nil {
+				return nil, fmt.Errorf("invalid parameters for path %s: %w", path, err)
+			}
+		}
+
+		for _, method := range openAPIMethods {
+			raw, ok := pathItem[method]
+			if !ok {
+				continue
+			}
+
+			var operation openAPIOperation
+			if err := json.Unmarshal(raw, &operation); err != nil {
+				return nil, fmt.Errorf("invalid %s operation for path %s: %w", strings.ToUpper(method), path, err)
+			}
+
+			serviceName, action := operationNames(method, path, operation)
+			if _, exists := templates[serviceName][action]; exists {
+				return nil, fmt.Errorf("duplicate operation %s.%s", serviceName, action)
+			}
+
+			tmpl := importOperation(method, path, pathParameters, operation, doc.Components.Schemas)
+			if templates[serviceName] == nil {
+				templates[serviceName] = make(map[string]RouteTemplate)
+			}
+			templates[serviceName][action] = *tmpl
+		}
+	}
+
+	return templates, nil
+}
+
+// operationNames derives the service and action names of an operation
+func operationNames(method, path string, operation openAPIOperation) (string, string) {
+	serviceName := "default"
+	if len(operation.Tags) > 0 && operation.Tags[0] != "" {
+		serviceName = operation.Tags[0]
+	}
+
+	if operation.OperationID != "" {
+		if parts := strings.SplitN(operation.OperationID, ".", 2); len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+		return serviceName, operation.OperationID
+	}
+
+	// Derive an action name such as "get_users_id" from the method and path
+	name := strings.NewReplacer("{", "", "}", "", "-", "_").Replace(path)
+	parts := []string{method}
+	for _, part := range strings.Split(name, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return serviceName, strings.Join(parts, "_")
+}
+
+// importOperation converts an OpenAPI operation to a route template
+func importOperation(method, path string, pathParameters []openAPIParameter, operation openAPIOperation, schemas map[string]*openAPISchema) *RouteTemplate {
+	endpoint := strings.NewReplacer("{", "{{", "}", "}}").Replace(path)
+	tmpl := NewRouteTemplate(strings.ToUpper(method), endpoint)
+
+	// Operation parameters override path-level parameters with the same name and location
+	parameters := make(map[string]openAPIParameter)
+	var order []string
+	for _, parameter := range append(append([]openAPIParameter{}, pathParameters...), operation.Parameters...) {
+		key := parameter.In + ":" + parameter.Name
+		if _, exists := parameters[key]; !exists {
+			order = append(order, key)
+		}
+		parameters[key] = parameter
+	}
+
+	for _, key := range order {
+		parameter := parameters[key]
+		switch parameter.In {
+		case "path":
+			tmpl.PathParams = append(tmpl.PathParams, parameter.Name)
+		case "query":
+			tmpl.QueryParams[parameter.Name] = placeholder(parameter.Name, !parameter.Required)
+			if !parameter.Required {
+				tmpl.OptionalParams[parameter.Name] = true
+			}
+		}
+	}
+
+	if operation.RequestBody != nil {
+		var schema *openAPISchema
+		if media, ok := operation.RequestBody.Content["application/json"]; ok {
+			schema = media.Schema
+		} else {
+			for _, contentType := range sortedKeys(operation.RequestBody.Content) {
+				schema = operation.RequestBody.Content[contentType].Schema
+				break
+			}
+		}
+		schema = resolveSchema(schema, schemas)
+
+		if schema != nil {
+			required := make(map[string]bool)
+			for _, name := range schema.Required {
+				required[name] = true
+			}
+			for name := range schema.Properties {
+				tmpl.Body[name] = placeholder(name, !required[name])
+				if !required[name] {
+					tmpl.OptionalParams[name] = true
+				}
+			}
+		}
+	}
+
+	return tmpl
+}
+
+// resolveSchema follows a local "#/components/schemas/..." reference
+func resolveSchema(schema *openAPISchema, schemas map[string]*openAPISchema) *openAPISchema {
+	const prefix = "#/components/schemas/"
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 10; depth++ {
+		if !strings.HasPrefix(schema.Ref, prefix) {
+			return nil
+		}
+		schema = schemas[strings.TrimPrefix(schema.Ref, prefix)]
+	}
+	return schema
+}
+
+// placeholder builds a "{{name}}" or "{{name?}}" template value
+func placeholder(name string, optional bool) string {
+	if optional {
+		return "{{" + name + "?}}"
+	}
+	return "{{" + name + "}}"
+}
+
+// exportOperation converts a route template to an OpenAPI path and operation
+func exportOperation(tmpl RouteTemplate) (string, openAPIOperation) {
+	operation := openAPIOperation{
+		Summary: tmpl.Method + " " + tmpl.Endpoint,
+		Responses: map[string]openAPIResponse{
+			"default": {Description: "Response"},
+		},
+	}
+
+	// Convert endpoint placeholders to OpenAPI path parameters.
+	// OpenAPI path parameters are always required, even if the segment is optional here.
+	segments := strings.Split(tmpl.EndpointPath(), "/")
+	for i, segment := range segments {
+		if name, _, ok := parsePlaceholder(segment); ok {
+			segments[i] = "{" + name + "}"
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &openAPISchema{Type: "string"},
+			})
+		}
+	}
+	path := strings.Join(segments, "/")
+
+	query := tmpl.Query()
+	for _, key := range sortedKeys(query) {
+		parameter := openAPIParameter{
+			Name:   key,
+			In:     "query",
+			Schema: &openAPISchema{Type: "string"},
+		}
+		if name, optional, ok := parsePlaceholderValue(query[key]); ok {
+			parameter.Required = !optional && !tmpl.OptionalParams[name]
+		} else {
+			// Fixed query values are always sent
+			parameter.Schema.Default = query[key]
+		}
+		operation.Parameters = append(operation.Parameters, parameter)
+	}
+
+	if len(tmpl.Body) > 0 {
+		schema := exportSchema(tmpl.Body, tmpl.OptionalParams)
+		operation.RequestBody = &openAPIRequestBody{
+			Required: len(schema.Required) > 0,
+			Content: map[string]openAPIMediaType{
+				"application/json": {Schema: schema},
+			},
+		}
+	}
+
+	return path, operation
+}
+
+// exportSchema derives a best-effort schema from a template value
+func exportSchema(value interface{}, optionalParams map[string]bool) *openAPISchema {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		schema := &openAPISchema{
+			Type:       "object",
+			Properties: make(map[string]*openAPISchema),
+		}
+		for _, key := range sortedKeys(v) {
+			schema.Properties[key] = exportSchema(v[key], optionalParams)
+			if name, optional, ok := parsePlaceholderValue(v[key]); ok && !optional && !optionalParams[name] {
+				schema.Required = append(schema.Required, key)
+			}
+		}
+		return schema
+	case []interface{}:
+		schema := &openAPISchema{Type: "array"}
+		if len(v) > 0 {
+			schema.Items = exportSchema(v[0], optionalParams)
+		}
+		return schema
+	case bool:
+		return &openAPISchema{Type: "boolean", Default: v}
+	case int, int32, int64:
+		return &openAPISchema{Type: "integer", Default: v}
+	case float32, float64:
+		return &openAPISchema{Type: "number", Default: v}
+	case string:
+		if _, _, ok := parsePlaceholder(v); ok {
+			return &openAPISchema{Type: "string"}
+		}
+		return &openAPISchema{Type: "string", Default: v}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// parsePlaceholder extracts the parameter name from a "{{name}}" or "{{name?}}" string
+func parsePlaceholder(s string) (name string, optional bool, ok bool) {
+	if !strings.HasPrefix(s, "{{") || !strings.HasSuffix(s, "}}") {
+		return "", false, false
+	}
+	name = strings.TrimPrefix(strings.TrimSuffix(s, "}}"), "{{")
+	optional = strings.HasSuffix(name, "?")
+	return strings.TrimSuffix(name, "?"), optional, true
+}
+
+// parsePlaceholderValue is parsePlaceholder for template values of any type
+func parsePlaceholderValue(value interface{}) (string, bool, bool) {
+	s, isString := value.(string)
+	if !isString {
+		return "", false, false
+	}
+	return parsePlaceholder(s)
+}
+
+// sortedKeys returns the keys of a string-keyed map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,8 +5,26 @@ import (
 	"strings"
 )
 
-// processTemplateValue processes a template value, replacing any placeholders with actual values
+// Null is a parameter value sent as an explicit JSON null, for example to clear a field with a
+// PATCH request. A nil or empty value for an optional placeholder means "not provided" and is omitted.
+var Null = explicitNull{}
+
+// explicitNull is the type of Null, it marshals to a JSON null
+type explicitNull struct{}
+
+// MarshalJSON implements json.Marshaler
+func (explicitNull) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+// ProcessTemplateValue processes a template value, replacing any placeholders with actual values.
+// Omitted array elements are dropped; use RouteTemplate.ProcessValue to honor the template's ArrayOmission.
 func ProcessTemplateValue(value interface{}, params map[string]interface{}, optionalParams map[string]bool) (interface{}, bool) {
+	return processTemplateValue(value, params, optionalParams, ArrayOmitDrop)
+}
+
+// processTemplateValue processes a template value, handling omitted array elements according to arrayOmission
+func processTemplateValue(value interface{}, params map[string]interface{}, optionalParams map[string]bool, arrayOmission ArrayOmission) (interface{}, bool) {
 	switch v := value.(type) {
 	case string:
 		if strings.HasPrefix(v, "{{") && strings.HasSuffix(v, "}}") {
@@ -22,6 +40,11 @@ func ProcessTemplateValue(value interface{}, params map[string]interface{}, opti
 
 			// Check if the parameter is in the params map
 			if paramValue, exists := params[paramName]; exists {
+				// An explicit null is sent even for optional parameters
+				if paramValue == Null {
+					return nil, true
+				}
+
 				// For empty string or nil values in optional params, treat as not provided
 				if (paramValue == "" || paramValue == nil) && (isOptional || optionalParams[paramName]) {
 					return nil, false
@@ -70,7 +93,7 @@ func ProcessTemplateValue(value interface{}, params map[string]interface{}, opti
 	case map[string]interface{}:
 		processed := make(map[string]interface{})
 		for key, val := range v {
-			if processedVal, valid := ProcessTemplateValue(val, params, optionalParams); valid {
+			if processedVal, valid := processTemplateValue(val, params, optionalParams, arrayOmission); valid {
 				processed[key] = processedVal
 			}
 		}
@@ -78,8 +101,11 @@ func ProcessTemplateValue(value interface{}, params map[string]interface{}, opti
 	case []interface{}:
 		processed := make([]interface{}, 0, len(v))
 		for _, val := range v {
-			if processedVal, valid := ProcessTemplateValue(val, params, optionalParams); valid {
+			if processedVal, valid := processTemplateValue(val, params, optionalParams, arrayOmission); valid {
 				processed = append(processed, processedVal)
+			} else if arrayOmission == ArrayOmitNull {
+				// Keep the position of the omitted element
+				processed = append(processed, nil)
 			}
 		}
 		return processed, len(processed) > 0
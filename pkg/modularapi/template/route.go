@@ -1,5 +1,21 @@
 package template
 
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ArrayOmission defines what happens to array elements whose placeholder is omitted
+type ArrayOmission string
+
+const (
+	// ArrayOmitDrop removes omitted elements, shrinking the array (default)
+	ArrayOmitDrop ArrayOmission = "drop"
+	// ArrayOmitNull keeps omitted elements as null so positions are preserved
+	ArrayOmitNull ArrayOmission = "null"
+)
+
 // RouteTemplate defines a template for an API route
 type RouteTemplate struct {
 	Method         string                 `json:"method"`
@@ -8,7 +24,22 @@ type RouteTemplate struct {
 	PathParams     []string               `json:"pathParams,omitempty"`
 	QueryParams    map[string]interface{} `json:"queryParams,omitempty"`
 	Body           map[string]interface{} `json:"body,omitempty"`
-	OptionalParams map[string]bool        `json:"-"` // Tracks which parameters are optional
+	ArrayOmission  ArrayOmission          `json:"arrayOmission,omitempty"` // Handling of omitted array elements, drop by default
+	Verbose        bool                   `json:"verbose,omitempty"`       // Log requests in full, whatever the global log level
+	OptionalParams map[string]bool        `json:"-"`                       // Tracks which parameters are optional
+
+	// SuccessStatuses are the status codes of successful responses, such as "201", "200-299"
+	// or "3xx", taking precedence over the ones of the service. Default is 2xx.
+	SuccessStatuses []string `json:"successStatuses,omitempty"`
+	// ParamTypes declares the types of parameters, such as {"limit": "int"}: string values of
+	// these parameters are converted before the request is built, see CoerceParams
+	ParamTypes map[string]string `json:"paramTypes,omitempty"`
+	// OmitEmpty drops the body fields left empty once the template is processed, such as nil
+	// values, empty strings and empty arrays and objects, see OmitEmptyFields
+	OmitEmpty bool `json:"omitEmpty,omitempty"`
+	// ResponsePreprocessor transforms the body of a successful response before it is decoded,
+	// taking precedence over the preprocessor of the service. It can't be loaded from a file.
+	ResponsePreprocessor func(body []byte) ([]byte, error) `json:"-"`
 }
 
 // NewRouteTemplate creates a new route template with initialized maps
@@ -40,6 +71,91 @@ func (rt *RouteTemplate) WithQueryParams(params map[string]interface{}) *RouteTe
 	return rt
 }
 
+// EndpointPath returns the endpoint without the query string written in it, such as "/search"
+// for "/search?type={{type}}"
+func (rt *RouteTemplate) EndpointPath() string {
+	path, _, _ := SplitEndpointQuery(rt.Endpoint)
+	return path
+}
+
+// Query returns the query parameters of the template along with the ones written in the query
+// string of its endpoint, such as "/search?type={{type}}&q={{query?}}", which are processed and
+// encoded like any other. Query parameters set explicitly take precedence. The template is left
+// unchanged.
+func (rt *RouteTemplate) Query() map[string]interface{} {
+	_, query, found := SplitEndpointQuery(rt.Endpoint)
+	if !found {
+		return rt.QueryParams
+	}
+
+	params := make(map[string]interface{}, len(rt.QueryParams))
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		params[key] = value
+	}
+	for key, value := range rt.QueryParams {
+		params[key] = value
+	}
+	return params
+}
+
+// SplitEndpointQuery splits an endpoint at the "?" starting its query string, ignoring the
+// "?" marking optional placeholders such as "{{id?}}"
+func SplitEndpointQuery(endpoint string) (path, query string, found bool) {
+	depth := 0
+	for i := 0; i < len(endpoint); i++ {
+		switch {
+		case strings.HasPrefix(endpoint[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(endpoint[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case endpoint[i] == '?' && depth == 0:
+			return endpoint[:i], endpoint[i+1:], true
+		}
+	}
+	return endpoint, "", false
+}
+
+// WithPathParam declares a parameter of the endpoint, required or optional. An optional
+// parameter is handled like a "{{name?}}" placeholder: when it isn't provided, its path segment
+// is removed. Declaring it required removes an optional mark set in code, not a "?" suffix.
+func (rt *RouteTemplate) WithPathParam(name string, required bool) *RouteTemplate {
+	found := false
+	for _, param := range rt.PathParams {
+		found = found || param == name
+	}
+	if !found {
+		rt.PathParams = append(rt.PathParams, name)
+	}
+
+	if required {
+		delete(rt.OptionalParams, name)
+		return rt
+	}
+	return rt.WithOptionalParam(name)
+}
+
+// WithOptionalParam marks a parameter as optional wherever its placeholder appears, in the
+// endpoint, the query parameters, the headers or the body, as a "?" suffix would
+func (rt *RouteTemplate) WithOptionalParam(name string) *RouteTemplate {
+	if rt.OptionalParams == nil {
+		rt.OptionalParams = make(map[string]bool)
+	}
+	rt.OptionalParams[name] = true
+	return rt
+}
+
 // WithBody adds body parameters to the route template
 func (rt *RouteTemplate) WithBody(body map[string]interface{}) *RouteTemplate {
 	for k, v := range body {
@@ -48,9 +164,101 @@ func (rt *RouteTemplate) WithBody(body map[string]interface{}) *RouteTemplate {
 	return rt
 }
 
+// WithArrayOmission sets how array elements whose placeholder is omitted are handled
+func (rt *RouteTemplate) WithArrayOmission(mode ArrayOmission) *RouteTemplate {
+	rt.ArrayOmission = mode
+	return rt
+}
+
+// WithVerboseLogging logs the requests made with the template, and their responses, in full at
+// debug level whatever the global log level, to troubleshoot a single action
+func (rt *RouteTemplate) WithVerboseLogging() *RouteTemplate {
+	rt.Verbose = true
+	return rt
+}
+
+// WithSuccessStatuses sets the status codes of successful responses, such as "201",
+// "200-299" or "3xx"
+func (rt *RouteTemplate) WithSuccessStatuses(statuses ...string) *RouteTemplate {
+	rt.SuccessStatuses = append(rt.SuccessStatuses, statuses...)
+	return rt
+}
+
+// WithResponsePreprocessor sets the function transforming response bodies before they are
+// decoded, for example client.StripXSSIPrefix
+func (rt *RouteTemplate) WithResponsePreprocessor(preprocessor func(body []byte) ([]byte, error)) *RouteTemplate {
+	rt.ResponsePreprocessor = preprocessor
+	return rt
+}
+
+// ProcessValue processes a template value with the template's optional parameters and array omission mode
+func (rt *RouteTemplate) ProcessValue(value interface{}, params map[string]interface{}) (interface{}, bool) {
+	return processTemplateValue(value, params, rt.OptionalParams, rt.ArrayOmission)
+}
+
+// RequiredParams returns the sorted names of the parameters the template requires, from the
+// placeholders of its endpoint, query parameters, headers and body
+func (rt *RouteTemplate) RequiredParams() []string {
+	return rt.paramNames(false)
+}
+
+// OptionalParamNames returns the sorted names of the optional parameters of the template, marked
+// with a "?" suffix in its endpoint, query parameters, headers or body
+func (rt *RouteTemplate) OptionalParamNames() []string {
+	return rt.paramNames(true)
+}
+
+// paramNames returns the sorted names of the required or optional parameters of the template.
+// A parameter marked optional in one place is optional everywhere, as when processing a request.
+func (rt *RouteTemplate) paramNames(optional bool) []string {
+	params := make(map[string]bool)
+	for _, part := range strings.Split(rt.EndpointPath(), "/") {
+		if name, isOptional, ok := parsePlaceholder(part); ok {
+			params[name] = params[name] || isOptional
+		}
+	}
+	collectPlaceholders(rt.Query(), params)
+	collectPlaceholders(rt.Body, params)
+	for _, value := range rt.Headers {
+		collectPlaceholders(value, params)
+	}
+
+	names := make([]string, 0, len(params))
+	for name, isOptional := range params {
+		if (isOptional || rt.OptionalParams[name]) == optional {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectPlaceholders records the placeholders found in a template value, and whether they are optional
+func collectPlaceholders(value interface{}, params map[string]bool) {
+	switch v := value.(type) {
+	case string:
+		if name, isOptional, ok := parsePlaceholder(v); ok {
+			params[name] = params[name] || isOptional
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			collectPlaceholders(item, params)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectPlaceholders(item, params)
+		}
+	}
+}
+
 // Clone creates a deep copy of the route template
 func (rt *RouteTemplate) Clone() *RouteTemplate {
 	clone := NewRouteTemplate(rt.Method, rt.Endpoint)
+	clone.ArrayOmission = rt.ArrayOmission
+	clone.Verbose = rt.Verbose
+	clone.OmitEmpty = rt.OmitEmpty
+	clone.ResponsePreprocessor = rt.ResponsePreprocessor
+	clone.SuccessStatuses = append([]string(nil), rt.SuccessStatuses...)
 
 	// Copy headers
 	for k, v := range rt.Headers {
@@ -76,5 +284,10 @@ func (rt *RouteTemplate) Clone() *RouteTemplate {
 		clone.OptionalParams[k] = v
 	}
 
+	// Copy parameter types
+	for k, v := range rt.ParamTypes {
+		clone.WithParamType(k, v)
+	}
+
 	return clone
 }
@@ -5,17 +5,24 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 )
 
-// TemplateStore manages a collection of route templates
+// TemplateStore manages a collection of route templates. It is safe for concurrent use.
 type TemplateStore struct {
 	templates map[string]map[string]RouteTemplate
+	indent    string // Indentation used when saving templates
+	mu        sync.RWMutex
 }
 
+// DefaultIndent is the indentation used when saving templates to a file
+const DefaultIndent = "  "
+
 // NewTemplateStore creates a new template store
 func NewTemplateStore() *TemplateStore {
 	return &TemplateStore{
 		templates: make(map[string]map[string]RouteTemplate),
+		indent:    DefaultIndent,
 	}
 }
 
@@ -27,39 +34,68 @@ func (ts *TemplateStore) AddTemplate(serviceName, action string, route RouteTemp
 	}
 
 	// Extract path parameters from endpoint placeholders and identify optional params
-	route.PathParams = extractPathParams(route.Endpoint)
+	route.PathParams = extractPathParams(route.EndpointPath())
 
 	// Scan the template for optional parameters and populate the OptionalParams map
 	scanTemplateForOptionalParams(&route)
 
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
 	if ts.templates[serviceName] == nil {
 		ts.templates[serviceName] = make(map[string]RouteTemplate)
 	}
 	ts.templates[serviceName][action] = route
 }
 
-// GetTemplate returns a route template for a specific service and action
+// GetTemplate returns a route template for a specific service and action. An action without
+// a version, such as "get", falls back to its latest version, such as "get@v2", when it has
+// no unversioned template.
 func (ts *TemplateStore) GetTemplate(serviceName, action string) (RouteTemplate, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
 	if serviceTemplates, ok := ts.templates[serviceName]; ok {
-		if template, ok := serviceTemplates[action]; ok {
-			return template, true
-		}
+		return lookup(serviceTemplates, action)
 	}
 	return RouteTemplate{}, false
 }
 
-// HasTemplate checks if a template exists for a specific service and action
+// HasTemplate checks if a template exists for a specific service and action, following the
+// same version fallback as GetTemplate
 func (ts *TemplateStore) HasTemplate(serviceName, action string) bool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
 	if serviceTemplates, ok := ts.templates[serviceName]; ok {
-		_, ok := serviceTemplates[action]
+		_, ok := lookup(serviceTemplates, action)
 		return ok
 	}
 	return false
 }
 
-// SaveToFile saves all templates to a JSON file
+// Clear removes every template from the store, for example before reloading them
+func (ts *TemplateStore) Clear() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.templates = make(map[string]map[string]RouteTemplate)
+}
+
+// SetIndent sets the indentation used when saving templates to a file
+func (ts *TemplateStore) SetIndent(indent string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.indent = indent
+}
+
+// SaveToFile saves all templates to a JSON file.
+// Services and actions are written in sorted order so saving the same templates is byte-stable.
 func (ts *TemplateStore) SaveToFile(filepath string) error {
-	data, err := json.MarshalIndent(ts.templates, "", "    ")
+	ts.mu.RLock()
+	data, err := json.MarshalIndent(ts.templates, "", ts.indent)
+	ts.mu.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to marshal templates: %w", err)
 	}
@@ -84,6 +120,8 @@ func (ts *TemplateStore) LoadFromFile(filepath string) error {
 	}
 
 	// Merge with existing templates
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
 	for service, routes := range templates {
 		if ts.templates[service] == nil {
 			ts.templates[service] = make(map[string]RouteTemplate)
@@ -105,6 +143,33 @@ func (ts *TemplateStore) LoadFromFile(filepath string) error {
 	return nil
 }
 
+// Merge copies the templates of other into the store, templates of other replacing the ones
+// for the same service and action. Templates are copied, so later changes to either store
+// don't affect the other, and their optional parameters are scanned again.
+func (ts *TemplateStore) Merge(other *TemplateStore) {
+	if other == nil || other == ts {
+		return
+	}
+
+	// Copy the templates of other before locking the store, so merging two stores into each
+	// other concurrently can't deadlock
+	other.mu.RLock()
+	templates := make(map[string]map[string]RouteTemplate, len(other.templates))
+	for service, routes := range other.templates {
+		templates[service] = make(map[string]RouteTemplate, len(routes))
+		for action, route := range routes {
+			templates[service][action] = *route.Clone()
+		}
+	}
+	other.mu.RUnlock()
+
+	for service, routes := range templates {
+		for action, route := range routes {
+			ts.AddTemplate(service, action, route)
+		}
+	}
+}
+
 // extractPathParams extracts parameter names from placeholders in the endpoint
 func extractPathParams(endpoint string) []string {
 	var params []string
@@ -132,15 +197,13 @@ func scanTemplateForOptionalParams(route *RouteTemplate) {
 		scanMapForOptionalParams(route.Body, route.OptionalParams)
 	}
 
-	// Scan query parameters
-	if route.QueryParams != nil {
-		scanMapForOptionalParams(route.QueryParams, route.OptionalParams)
-	}
+	// Scan query parameters, including the ones of the endpoint query string
+	scanMapForOptionalParams(route.Query(), route.OptionalParams)
 }
 
 // scanEndpointForOptionalParams scans the endpoint URL for optional parameters
 func scanEndpointForOptionalParams(route *RouteTemplate) {
-	parts := strings.Split(route.Endpoint, "/")
+	parts := strings.Split(route.EndpointPath(), "/")
 	for _, part := range parts {
 		if strings.HasPrefix(part, "{{") && strings.HasSuffix(part, "}}") {
 			paramWithBraces := strings.TrimPrefix(strings.TrimSuffix(part, "}}"), "{{")
@@ -0,0 +1,67 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parameter types of RouteTemplate.ParamTypes
+const (
+	ParamTypeString = "string"
+	ParamTypeInt    = "int"
+	ParamTypeFloat  = "float"
+	ParamTypeBool   = "bool"
+)
+
+// WithParamType declares the type of a parameter, ParamTypeInt, ParamTypeFloat, ParamTypeBool
+// or ParamTypeString, so string inputs such as "42" or "true" are sent as that type
+func (rt *RouteTemplate) WithParamType(name, paramType string) *RouteTemplate {
+	if rt.ParamTypes == nil {
+		rt.ParamTypes = make(map[string]string)
+	}
+	rt.ParamTypes[name] = paramType
+	return rt
+}
+
+// CoerceParams converts the string values of params to the types declared in ParamTypes, in
+// place. Values that aren't strings and parameters without a declared type are left unchanged.
+func (rt *RouteTemplate) CoerceParams(params map[string]interface{}) error {
+	for name, paramType := range rt.ParamTypes {
+		value, ok := params[name].(string)
+		if !ok {
+			continue
+		}
+		coerced, err := coerceParam(value, paramType)
+		if errors.Is(err, errUnknownParamType) {
+			return fmt.Errorf("parameter %s: %w %q", name, err, paramType)
+		}
+		if err != nil {
+			return fmt.Errorf("parameter %s: cannot convert %q to %s", name, value, paramType)
+		}
+		params[name] = coerced
+	}
+	return nil
+}
+
+// errUnknownParamType is returned by coerceParam for a type it doesn't know
+var errUnknownParamType = errors.New("unknown parameter type")
+
+// coerceParam converts a string to a parameter type, ignoring the spaces around numbers and
+// booleans
+func coerceParam(value, paramType string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	switch paramType {
+	case ParamTypeString:
+		return value, nil
+	case ParamTypeInt:
+		return strconv.ParseInt(trimmed, 10, 64)
+	case ParamTypeFloat:
+		return strconv.ParseFloat(trimmed, 64)
+	case ParamTypeBool:
+		return strconv.ParseBool(trimmed)
+	default:
+		return nil, errUnknownParamType
+	}
+}
@@ -0,0 +1,77 @@
+package template
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VersionSeparator separates an action from its version in versioned action names, such as "get@v2"
+const VersionSeparator = "@"
+
+// VersionedAction returns the name of a version of an action, such as "get@v2". An empty
+// version gives the unversioned action.
+func VersionedAction(action, version string) string {
+	if version == "" {
+		return action
+	}
+	return action + VersionSeparator + version
+}
+
+// AddTemplateVersion adds a route template for a version of an action. It is stored under the
+// versioned action name, so requests and workflow steps can pin the version by using that name.
+func (ts *TemplateStore) AddTemplateVersion(serviceName, action, version string, route RouteTemplate) {
+	ts.AddTemplate(serviceName, VersionedAction(action, version), route)
+}
+
+// GetTemplateVersion returns the route template for a version of an action. An empty version
+// looks the action up like GetTemplate.
+func (ts *TemplateStore) GetTemplateVersion(serviceName, action, version string) (RouteTemplate, bool) {
+	return ts.GetTemplate(serviceName, VersionedAction(action, version))
+}
+
+// lookup returns the template of an action among the templates of a service. An action
+// without a version resolves to its unversioned template, or else to its latest version.
+// It must be called with ts.mu held.
+func lookup(serviceTemplates map[string]RouteTemplate, action string) (RouteTemplate, bool) {
+	if template, ok := serviceTemplates[action]; ok {
+		return template, true
+	}
+	if strings.Contains(action, VersionSeparator) {
+		return RouteTemplate{}, false
+	}
+
+	latest := ""
+	prefix := action + VersionSeparator
+	for name := range serviceTemplates {
+		version, isVersion := strings.CutPrefix(name, prefix)
+		if isVersion && (latest == "" || compareVersions(version, latest) > 0) {
+			latest = version
+		}
+	}
+	if latest == "" {
+		return RouteTemplate{}, false
+	}
+	return serviceTemplates[prefix+latest], true
+}
+
+// compareVersions compares two versions such as "v2" and "v10" or "1.4.2" and "1.10": a
+// leading "v" is ignored, and dot-separated parts are compared as numbers when both are
+// numeric, as strings otherwise
+func compareVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil && aNum != bNum:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && aParts[i] != bParts[i]:
+			return strings.Compare(aParts[i], bParts[i])
+		}
+	}
+	return len(aParts) - len(bParts)
+}
@@ -0,0 +1,93 @@
+package modularapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/client"
+)
+
+// ValidateOption configures ValidateTemplate
+type ValidateOption func(*validateConfig)
+
+// validateConfig holds the configuration of a template validation
+type validateConfig struct {
+	PreflightMethod string
+}
+
+// WithPreflight makes ValidateTemplate check that the endpoint is reachable by sending it a
+// request with the given method, usually HEAD or OPTIONS. The template body is never sent.
+func WithPreflight(method string) ValidateOption {
+	return func(c *validateConfig) {
+		c.PreflightMethod = strings.ToUpper(method)
+	}
+}
+
+// ValidateTemplate checks that a request for the action can be built with params, after
+// merging the service default and global parameters, without sending it. Every missing
+// required parameter is reported. With WithPreflight, the endpoint is then sent a preflight
+// request: a failure to connect or an error status is reported, except 405 and 501 which only
+// mean the endpoint doesn't support the preflight method.
+func (s *ModularAPIService) ValidateTemplate(serviceName, action string, params map[string]interface{}, opts ...ValidateOption) error {
+	cfg := &validateConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	schema, ok := s.DescribeAction(serviceName, action)
+	if !ok {
+		return fmt.Errorf("no template found for action: %s in service %s", action, serviceName)
+	}
+	serviceCfg, ok := s.config.GetServiceConfig(serviceName)
+	if !ok {
+		return fmt.Errorf("no configuration found for service: %s", serviceName)
+	}
+
+	var problems []error
+	mergedParams := s.mergeParams(serviceName, serviceCfg, params)
+	for _, name := range schema.RequiredParams {
+		if value, exists := mergedParams[name]; !exists || value == nil {
+			problems = append(problems, fmt.Errorf("missing required parameter: %s", name))
+		}
+	}
+
+	// Building the request catches the remaining problems, such as service URL placeholders
+	if len(problems) == 0 {
+		req, err := s.PrepareRequest(serviceName, action, params)
+		if err != nil {
+			problems = append(problems, err)
+		} else if cfg.PreflightMethod != "" {
+			if err := s.preflight(serviceName, cfg.PreflightMethod, req); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("template %s.%s is invalid: %w", serviceName, action, errors.Join(problems...))
+	}
+	return nil
+}
+
+// preflight sends a request with the given method to the URL and headers of a prepared request
+func (s *ModularAPIService) preflight(serviceName, method string, req *http.Request) error {
+	preflightReq, err := http.NewRequestWithContext(s.signingContext(req.Context(), serviceName), method, req.URL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("preflight request failed: %w", err)
+	}
+	preflightReq.Header = req.Header.Clone()
+	preflightReq.Header.Del("Content-Type")
+
+	httpClient, _ := s.clientsFor(serviceName)
+	err = httpClient.MakeRequest(preflightReq, nil)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusMethodNotAllowed || apiErr.StatusCode == http.StatusNotImplemented) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s preflight to %s failed: %w", method, req.URL.Redacted(), err)
+	}
+	return nil
+}
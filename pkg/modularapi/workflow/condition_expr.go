@@ -0,0 +1,552 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evaluateConditionExpr evaluates a boolean expression such as
+// `{{status == "active" && (age > 18 || admin)}}`. The surrounding braces are optional.
+// Operands are literals (strings, numbers, true, false, null), variables or dot-paths into
+// variables, and calls to registered functions, combined with arithmetic operators.
+// A missing variable evaluates to null.
+func evaluateConditionExpr(expr string, variables map[string]interface{}, funcs map[string]ExpressionFunc) (bool, error) {
+	node, err := parseConditionExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	value, err := node.eval(variables, funcs)
+	if err != nil {
+		return false, err
+	}
+	return isTruthy(value), nil
+}
+
+// parseConditionExpr parses a boolean expression into its syntax tree
+func parseConditionExpr(expr string) (exprNode, error) {
+	source := strings.TrimSpace(expr)
+	if strings.HasPrefix(source, "{{") && strings.HasSuffix(source, "}}") {
+		source = strings.TrimSpace(source[2 : len(source)-2])
+	}
+	if source == "" {
+		return nil, fmt.Errorf("empty condition expression")
+	}
+
+	node, err := parseExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition expression %q: %w", expr, err)
+	}
+	return node, nil
+}
+
+// callsFunction reports whether an expression calls one of the registered functions
+func callsFunction(expr string, funcs map[string]ExpressionFunc) bool {
+	source := strings.TrimSpace(expr)
+	if strings.HasPrefix(source, "{{") && strings.HasSuffix(source, "}}") {
+		source = source[2 : len(source)-2]
+	}
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return false
+	}
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].kind == tokenIdent && tokens[i+1].kind == tokenOperator && tokens[i+1].text == "(" {
+			if _, ok := funcs[tokens[i].text]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseExpr parses an expression, without its surrounding braces, into its syntax tree
+func parseExpr(source string) (exprNode, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+type exprTokenKind int
+
+const (
+	tokenIdent exprTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+// exprOperators lists the operators, longest first so "==" isn't read as "="
+var exprOperators = []string{"&&", "||", "==", "!=", ">=", "<=", ">", "<", "!", "(", ")", ",", "+", "-", "*", "/", "%"}
+
+// tokenizeExpr splits an expression into identifiers, literals and operators
+func tokenizeExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"' || r == '\'':
+			end := strings.IndexRune(s[i+1:], r)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: s[i+1 : i+1+end]})
+			i += end + 2
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: s[start:i]})
+
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(s) && (s[i] == '_' || s[i] == '.' || unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: s[start:i]})
+
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// exprParser is a recursive descent parser. From lowest to highest precedence:
+// "||", "&&", comparisons, "+" and "-", "*", "/" and "%", unary "!" and "-", and operands.
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// acceptOperator consumes the next token if it is one of the given operators
+func (p *exprParser) acceptOperator(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOperator("==", "!=", ">=", "<=", ">", "<")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return comparisonNode{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOperator("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOperator("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = arithmeticNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.acceptOperator("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return arithmeticNode{op: "-", left: literalNode{value: 0.0}, right: operand}, nil
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	if _, ok := p.acceptOperator("("); ok {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.acceptOperator(")"); !ok {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case tokenString:
+		return literalNode{value: tok.text}, nil
+
+	case tokenNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return literalNode{value: num}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null", "nil":
+			return literalNode{value: nil}, nil
+		}
+		if _, ok := p.acceptOperator("("); ok {
+			return p.parseCall(tok.text)
+		}
+		return variableNode{path: tok.text}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// parseCall parses the arguments of a function call, after its opening parenthesis
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	call := callNode{name: name}
+	if _, ok := p.acceptOperator(")"); ok {
+		return call, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		if _, ok := p.acceptOperator(","); ok {
+			continue
+		}
+		if _, ok := p.acceptOperator(")"); ok {
+			return call, nil
+		}
+		return nil, fmt.Errorf("missing closing parenthesis in call to %s", name)
+	}
+}
+
+// exprNode is a node of a parsed expression
+type exprNode interface {
+	eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}, map[string]ExpressionFunc) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	path string
+}
+
+func (n variableNode) eval(variables map[string]interface{}, _ map[string]ExpressionFunc) (interface{}, error) {
+	if value, exists := variables[n.path]; exists {
+		return value, nil
+	}
+	if strings.Contains(n.path, ".") {
+		if value, exists := extractValue(variables, n.path); exists {
+			return value, nil
+		}
+	}
+	return nil, nil
+}
+
+// isVariableNode reports whether an expression is a single variable or dot-path
+func isVariableNode(node exprNode) bool {
+	_, ok := node.(variableNode)
+	return ok
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n callNode) eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	fn, ok := funcs[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", n.name)
+	}
+
+	args := make([]interface{}, 0, len(n.args))
+	for _, argNode := range n.args {
+		arg, err := argNode.eval(variables, funcs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	value, err := fn(args...)
+	if err != nil {
+		return nil, fmt.Errorf("function %s failed: %w", n.name, err)
+	}
+	return value, nil
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n notNode) eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	value, err := n.operand.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return !isTruthy(value), nil
+}
+
+// logicalNode is a short-circuiting "&&" or "||"
+type logicalNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n logicalNode) eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	left, err := n.left.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+	if isTruthy(left) == (n.op == "||") {
+		return n.op == "||", nil
+	}
+
+	right, err := n.right.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return isTruthy(right), nil
+}
+
+type comparisonNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n comparisonNode) eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	left, err := n.left.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case ">":
+		return evaluateGreaterThan(left, right)
+	case "<":
+		return evaluateLessThan(left, right)
+	case ">=":
+		less, err := evaluateLessThan(left, right)
+		return !less, err
+	case "<=":
+		greater, err := evaluateGreaterThan(left, right)
+		return !greater, err
+	}
+	return nil, fmt.Errorf("unsupported operator %s", n.op)
+}
+
+// arithmeticNode is a "+", "-", "*", "/" or "%" operation. Numbers are computed as float64,
+// and "+" concatenates when either operand is a string.
+type arithmeticNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n arithmeticNode) eval(variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	left, err := n.left.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(variables, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, leftIsString := left.(string)
+	_, rightIsString := right.(string)
+	if n.op == "+" && (leftIsString || rightIsString) {
+		return fmt.Sprintf("%v%v", left, right), nil
+	}
+
+	a, aErr := toFloat64(left)
+	b, bErr := toFloat64(right)
+	if aErr != nil || bErr != nil {
+		return nil, fmt.Errorf("operator %s not supported for %T and %T", n.op, left, right)
+	}
+
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return a / b, nil
+		}
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", n.op)
+}
+
+// valuesEqual compares two values, treating numbers of different types (such as the int of
+// a workflow variable and the float64 of a literal) as equal when their values are
+func valuesEqual(a, b interface{}) bool {
+	// Integers compare exactly, so large IDs decoded as json.Number don't collide as float64
+	if aInt, ok := exactInt(a); ok {
+		if bInt, ok := exactInt(b); ok {
+			return aInt == bInt
+		}
+	}
+
+	_, aIsString := a.(string)
+	_, bIsString := b.(string)
+	if !aIsString && !bIsString {
+		aFloat, aErr := toFloat64(a)
+		bFloat, bErr := toFloat64(b)
+		if aErr == nil && bErr == nil {
+			return aFloat == bFloat
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// exactInt returns the value of an integer, or of a json.Number holding an integer
+func exactInt(v interface{}) (int64, bool) {
+	switch value := v.(type) {
+	case json.Number:
+		i, err := value.Int64()
+		return i, err == nil
+	case int, int8, int16, int32, int64:
+		return reflect.ValueOf(value).Int(), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,235 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// usesDependencies reports whether the steps of a workflow are scheduled by their DependsOn
+func usesDependencies(workflow Workflow) bool {
+	for _, step := range workflow.Steps {
+		if len(step.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDependencies checks that the dependencies of the steps reference known steps and
+// don't form a cycle, and that they aren't combined with ParallelWith
+func validateDependencies(workflow Workflow) error {
+	if !usesDependencies(workflow) {
+		return nil
+	}
+
+	steps := make(map[string]WorkflowStep, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		steps[step.ID] = step
+	}
+	for _, step := range workflow.Steps {
+		if len(step.ParallelWith) > 0 {
+			return fmt.Errorf("step %s in workflow %s cannot use parallel_with in a workflow whose steps declare depends_on",
+				step.ID, workflow.Name)
+		}
+		for _, dependency := range step.DependsOn {
+			if _, exists := steps[dependency]; !exists {
+				return fmt.Errorf("step %s in workflow %s depends on unknown step ID %s",
+					step.ID, workflow.Name, dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+
+	var visit func(id string, path []string) error
+	visit = func(id string, path []string) error {
+		switch state[id] {
+		case visited:
+			return nil
+		case visiting:
+			// Report the cycle starting from its first occurrence in the path
+			for i, step := range path {
+				if step == id {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("cyclic step dependency in workflow %s: %s", workflow.Name, strings.Join(append(path, id), " -> "))
+		}
+
+		state[id] = visiting
+		for _, dependency := range steps[id].DependsOn {
+			if err := visit(dependency, append(path, id)); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+
+	for _, step := range workflow.Steps {
+		if err := visit(step.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepCompletion is the outcome of a step run by executeStepGraph
+type stepCompletion struct {
+	index       int
+	result      stepExecutionResult   // Result of a regular step
+	loopResults []stepExecutionResult // Results of the iterations of a loop step
+	loopErr     error                 // Error of a loop step
+}
+
+// executeStepGraph runs each step once all the steps it depends on have finished, running
+// independent steps concurrently. At most MaxConcurrency steps run at once, ready steps
+// starting by descending Priority, then in definition order. A step that is skipped, or fails
+// without aborting the workflow, still lets its dependents run.
+//
+// Running steps see the variables and step results as they were when they started, and results are applied
+// one at a time as steps finish. Once a step aborts the workflow, no other step is started,
+// and with FailFast the running ones are canceled. It returns the ID of the step aborting the
+// workflow along with its error.
+func (we *WorkflowExecutor) executeStepGraph(workflow Workflow, state *executionState, options *executionOptions) (string, error) {
+	steps := workflow.Steps
+	indexes := make(map[string]int, len(steps))
+	for i, step := range steps {
+		indexes[step.ID] = i
+	}
+
+	// Count the dependencies left to each step, ignoring duplicates
+	remaining := make([]int, len(steps))
+	dependents := make([][]int, len(steps))
+	for i, step := range steps {
+		seen := make(map[string]bool)
+		for _, dependency := range step.DependsOn {
+			if seen[dependency] {
+				continue
+			}
+			seen[dependency] = true
+			remaining[i]++
+			dependents[indexes[dependency]] = append(dependents[indexes[dependency]], i)
+		}
+	}
+
+	var ready []int
+	for i := range steps {
+		if remaining[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	// Running steps share a context canceled by a fail-fast abort
+	stepOptions := *options
+	var cancel context.CancelFunc
+	stepOptions.ctx, cancel = context.WithCancel(options.ctx)
+	defer cancel()
+
+	completions := make(chan stepCompletion)
+	start := func(i int) {
+		step := steps[i]
+		variables := make(map[string]interface{}, len(state.variables))
+		for k, v := range state.variables {
+			variables[k] = v
+		}
+		stepResults := make(map[string]map[string]interface{}, len(state.stepResults))
+		for id, result := range state.stepResults {
+			stepResults[id] = result
+		}
+		runOptions := stepOptions
+		runOptions.stepResults = stepResults
+
+		go func() {
+			completion := stepCompletion{index: i}
+			if step.LoopOver != "" {
+				completion.loopResults, completion.loopErr = we.executeLoopStep(step, variables, &runOptions)
+			} else {
+				options.reportStart(step.ID)
+				completion.result = we.executeWithRetries(step, &runOptions, func() stepExecutionResult {
+					if step.Paginate != nil {
+						return we.executePaginatedStep(step, variables, &runOptions)
+					}
+					return we.executeStep(step, variables, &runOptions)
+				})
+				options.reportStep(step, completion.result)
+			}
+			completions <- completion
+		}()
+	}
+
+	var abortedStep string
+	var abortErr error
+	running := 0
+	finished := make([]bool, len(steps))
+	for {
+		// Start the ready steps, unless the workflow is aborting or canceled
+		if abortErr == nil && options.ctx.Err() == nil {
+			sort.Slice(ready, func(a, b int) bool {
+				if steps[ready[a]].Priority != steps[ready[b]].Priority {
+					return steps[ready[a]].Priority > steps[ready[b]].Priority
+				}
+				return ready[a] < ready[b]
+			})
+			for len(ready) > 0 && (workflow.MaxConcurrency <= 0 || running < workflow.MaxConcurrency) {
+				start(ready[0])
+				ready = ready[1:]
+				running++
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		completion := <-completions
+		running--
+		finished[completion.index] = true
+		if abortErr != nil {
+			// Steps finishing after an abort, canceled or not, are ignored
+			continue
+		}
+
+		step := steps[completion.index]
+		var err error
+		if step.LoopOver != "" {
+			err = we.applyLoopResults(step, completion.loopResults, completion.loopErr, state, options)
+		} else {
+			err = we.applyStepResult(step, completion.result, state, options)
+		}
+		if err != nil {
+			abortedStep, abortErr = step.ID, err
+			if workflow.FailFast {
+				cancel()
+			}
+			continue
+		}
+
+		for _, dependent := range dependents[completion.index] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if abortErr != nil {
+		return abortedStep, abortErr
+	}
+	// Report the first step left unstarted by a cancellation
+	if err := options.ctx.Err(); err != nil {
+		for i, done := range finished {
+			if !done {
+				return steps[i].ID, fmt.Errorf("workflow %s canceled: %w", options.workflowName, err)
+			}
+		}
+	}
+	return "", nil
+}
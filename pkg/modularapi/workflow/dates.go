@@ -0,0 +1,83 @@
+package workflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFuncs returns the built-in date functions, reading the current time from now:
+//
+//   - now() returns the current time
+//   - today() returns midnight of the current day
+//   - dateAdd(date, duration) adds a duration such as "90m", "-24h" or "-7d" to a date
+//   - formatDate(date, layout) formats a date with a Go layout such as "2006-01-02"
+//
+// Dates are returned as RFC3339 strings, and dates passed as arguments can be RFC3339 strings,
+// "2006-01-02" strings or time.Time values.
+func dateFuncs(now func() time.Time) map[string]ExpressionFunc {
+	return map[string]ExpressionFunc{
+		"now": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("now expects no argument, got %d", len(args))
+			}
+			return now().Format(time.RFC3339), nil
+		},
+		"today": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("today expects no argument, got %d", len(args))
+			}
+			t := now()
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Format(time.RFC3339), nil
+		},
+		"dateAdd": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("dateAdd expects 2 arguments, got %d", len(args))
+			}
+			date, err := parseDate(args[0])
+			if err != nil {
+				return nil, err
+			}
+			duration := fmt.Sprint(args[1])
+			if days, isDays := strings.CutSuffix(duration, "d"); isDays {
+				n, err := strconv.Atoi(days)
+				if err != nil {
+					return nil, fmt.Errorf("invalid duration %q", duration)
+				}
+				return date.AddDate(0, 0, n).Format(time.RFC3339), nil
+			}
+			d, err := time.ParseDuration(duration)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q", duration)
+			}
+			return date.Add(d).Format(time.RFC3339), nil
+		},
+		"formatDate": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("formatDate expects 2 arguments, got %d", len(args))
+			}
+			date, err := parseDate(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return date.Format(fmt.Sprint(args[1])), nil
+		},
+	}
+}
+
+// parseDate converts a date argument to a time.Time
+func parseDate(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse(time.DateOnly, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %v, expected an RFC3339 or YYYY-MM-DD date", value)
+}
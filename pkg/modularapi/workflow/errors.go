@@ -0,0 +1,44 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StepError is the failure of a single step, or of a single iteration of a loop step
+type StepError struct {
+	StepID string
+	Err    error
+}
+
+// Error implements the error interface
+func (e StepError) Error() string {
+	return fmt.Sprintf("step %s: %v", e.StepID, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e StepError) Unwrap() error {
+	return e.Err
+}
+
+// StepErrors is returned by ExecuteWorkflow when steps using CollectErrors failed.
+// It lists every failure in execution order.
+type StepErrors []StepError
+
+// Error implements the error interface
+func (e StepErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, stepErr := range e {
+		messages[i] = stepErr.Error()
+	}
+	return fmt.Sprintf("%d workflow steps failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the step errors so errors.Is and errors.As can match any of them
+func (e StepErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, stepErr := range e {
+		errs[i] = stepErr
+	}
+	return errs
+}
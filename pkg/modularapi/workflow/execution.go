@@ -0,0 +1,126 @@
+package workflow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrExecutionNotFound is returned by CancelWorkflow when no running execution has the given ID
+var ErrExecutionNotFound = errors.New("workflow execution not found")
+
+// Execution is a workflow execution started with StartWorkflow
+type Execution struct {
+	ID string // Identifies the execution for CancelWorkflow
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	variables map[string]interface{}
+	err       error
+}
+
+// Cancel stops the execution: running requests are aborted and no further step is started.
+// Wait then returns an error wrapping context.Canceled.
+func (e *Execution) Cancel() {
+	e.cancel()
+}
+
+// Done returns a channel closed when the execution has finished
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait blocks until the execution has finished and returns its variables and error, like
+// ExecuteWorkflow
+func (e *Execution) Wait() (map[string]interface{}, error) {
+	<-e.done
+	return e.variables, e.err
+}
+
+// StartWorkflow starts a workflow in the background and returns its execution right away.
+// The execution is tracked until it finishes, so it can be canceled by ID with CancelWorkflow,
+// for example when the user who started it navigates away. The result is filled in before
+// Wait returns.
+func (we *WorkflowExecutor) StartWorkflow(name string, initialParams map[string]interface{}, result interface{}, opts ...ExecutionOption) (*Execution, error) {
+	if _, exists := we.GetWorkflow(name); !exists {
+		return nil, fmt.Errorf("workflow %s not found", name)
+	}
+
+	id, err := newExecutionID()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start workflow %s: %w", name, err)
+	}
+
+	// The execution context derives from the one passed with WithContext, if any
+	options := newExecutionOptions(opts)
+	ctx, cancel := context.WithCancel(options.ctx)
+	execution := &Execution{
+		ID:     id,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	we.executionsMu.Lock()
+	if we.executions == nil {
+		we.executions = make(map[string]*Execution)
+	}
+	we.executions[id] = execution
+	we.executionsMu.Unlock()
+
+	onComplete := options.onComplete
+	go func() {
+		defer close(execution.done)
+		defer cancel()
+		executionOpts := append(append([]ExecutionOption{}, opts...), WithContext(ctx))
+		execution.variables, execution.err = we.ExecuteWorkflow(name, initialParams, result, executionOpts...)
+
+		we.executionsMu.Lock()
+		delete(we.executions, id)
+		we.executionsMu.Unlock()
+
+		if onComplete != nil {
+			onComplete(execution.variables, execution.err)
+		}
+	}()
+
+	return execution, nil
+}
+
+// CancelWorkflow cancels a running execution started with StartWorkflow. It returns
+// ErrExecutionNotFound if the execution is unknown or has already finished.
+func (we *WorkflowExecutor) CancelWorkflow(executionID string) error {
+	we.executionsMu.Lock()
+	execution, exists := we.executions[executionID]
+	we.executionsMu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, executionID)
+	}
+	execution.Cancel()
+	return nil
+}
+
+// RunningExecutions returns the IDs of the executions started with StartWorkflow that haven't finished
+func (we *WorkflowExecutor) RunningExecutions() []string {
+	we.executionsMu.Lock()
+	defer we.executionsMu.Unlock()
+
+	ids := make([]string, 0, len(we.executions))
+	for id := range we.executions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// newExecutionID returns a random execution ID
+func newExecutionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,16 +1,38 @@
 package workflow
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// arrayIndexPattern matches a path segment indexing an array, such as "items[0]"
+var arrayIndexPattern = regexp.MustCompile(`^(.*?)\[(\d+)\]$`)
+
+// regexCache holds the compiled regular expressions of ConditionRegexMatch, by pattern
+var regexCache sync.Map
+
+// compileCachedRegex compiles a pattern once and reuses it for later evaluations
+func compileCachedRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	cached, _ := regexCache.LoadOrStore(pattern, re)
+	return cached.(*regexp.Regexp), nil
+}
+
 // extractValue extracts a value from a nested map using dot notation
 // e.g. "user.profile.name" would extract data["user"]["profile"]["name"]
+// It doesn't log: a missing field can be expected (such as the cursor of a last page),
+// so callers report it at the appropriate level.
 func extractValue(data map[string]interface{}, path string) (interface{}, bool) {
 	parts := strings.Split(path, ".")
 
@@ -18,9 +40,9 @@ func extractValue(data map[string]interface{}, path string) (interface{}, bool)
 	var current interface{} = data
 
 	// Traverse the path
-	for i, part := range parts {
+	for _, part := range parts {
 		// Handle array indexing if the part is like "items[0]"
-		indexMatch := regexp.MustCompile(`^(.*?)\[(\d+)\]$`).FindStringSubmatch(part)
+		indexMatch := arrayIndexPattern.FindStringSubmatch(part)
 		if indexMatch != nil {
 			// We have an array index
 			fieldName := indexMatch[1]
@@ -33,25 +55,21 @@ func extractValue(data map[string]interface{}, path string) (interface{}, bool)
 			// First get the field value
 			fieldMap, ok := current.(map[string]interface{})
 			if !ok {
-				log.Printf("Failed to access array field %s: parent is not a map but %T", fieldName, current)
 				return nil, false
 			}
 
 			arrayField, exists := fieldMap[fieldName]
 			if !exists {
-				log.Printf("Array field %s not found in map", fieldName)
 				return nil, false
 			}
 
 			// Then get the array element
 			arrayValue, ok := arrayField.([]interface{})
 			if !ok {
-				log.Printf("Field %s is not an array but %T", fieldName, arrayField)
 				return nil, false
 			}
 
 			if index < 0 || index >= len(arrayValue) {
-				log.Printf("Array index %d is out of bounds for array of length %d", index, len(arrayValue))
 				return nil, false
 			}
 
@@ -60,16 +78,11 @@ func extractValue(data map[string]interface{}, path string) (interface{}, bool)
 			// Regular field access
 			currentMap, ok := current.(map[string]interface{})
 			if !ok {
-				// For debugging, print the current path we're trying to access
-				accessedPath := strings.Join(parts[:i], ".")
-				log.Printf("Failed to access field %s: parent path %s is not a map but %T",
-					part, accessedPath, current)
 				return nil, false
 			}
 
 			value, exists := currentMap[part]
 			if !exists {
-				log.Printf("Field %s not found in map with keys: %v", part, getMapKeys(currentMap))
 				return nil, false
 			}
 
@@ -122,6 +135,9 @@ func evaluateCondition(condition *StepCondition, variables map[string]interface{
 	case ConditionLessThan:
 		return evaluateLessThan(sourceValue, condition.Value)
 
+	case ConditionRegexMatch:
+		return evaluateRegexMatch(sourceValue, condition.Value)
+
 	default:
 		return false, fmt.Errorf("unsupported condition type: %s", condition.Type)
 	}
@@ -160,6 +176,24 @@ func evaluateContains(source, target interface{}) (bool, error) {
 	return false, fmt.Errorf("contains condition not supported for type %T", source)
 }
 
+// evaluateRegexMatch checks if a string matches a regular expression pattern
+func evaluateRegexMatch(source, pattern interface{}) (bool, error) {
+	patternStr, ok := pattern.(string)
+	if !ok {
+		return false, fmt.Errorf("matches condition requires a string pattern, got %T", pattern)
+	}
+	re, err := compileCachedRegex(patternStr)
+	if err != nil {
+		return false, fmt.Errorf("invalid matches pattern: %w", err)
+	}
+
+	sourceStr, ok := source.(string)
+	if !ok {
+		return false, fmt.Errorf("matches condition not supported for type %T", source)
+	}
+	return re.MatchString(sourceStr), nil
+}
+
 // evaluateGreaterThan checks if a value is greater than another value
 func evaluateGreaterThan(source, target interface{}) (bool, error) {
 	// Convert to float64 for numeric comparison
@@ -219,6 +253,8 @@ func toFloat64(v interface{}) (float64, error) {
 		return float64(value), nil
 	case uint32:
 		return float64(value), nil
+	case json.Number:
+		return value.Float64()
 	case string:
 		return strconv.ParseFloat(value, 64)
 	default:
@@ -234,10 +270,17 @@ func isExpression(s string) bool {
 	return expressionPattern.MatchString(s)
 }
 
-// evaluateExpression evaluates an expression and returns the result
-// For now, this is a simple implementation that handles variable substitution
-// In the future, this could be expanded to handle more complex expressions
-func evaluateExpression(expr string, variables map[string]interface{}) (interface{}, error) {
+// ExpressionFunc is a custom function callable from expressions, e.g. {{checksum(account_id)}}
+type ExpressionFunc func(args ...interface{}) (interface{}, error)
+
+// functionCallPattern matches a function call expression like "name(arg1, arg2)"
+var functionCallPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*\((.*)\)\s*$`)
+
+// evaluateExpression evaluates an expression and returns the result.
+// A string that is a single placeholder, like "{{price * qty}}", evaluates to the value of the
+// placeholder. Otherwise each placeholder is evaluated and formatted into the string, so
+// "total: {{price * qty}}" gives "total: 30".
+func evaluateExpression(expr string, variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
 	// Simple variable substitution
 	matches := expressionPattern.FindAllStringSubmatch(expr, -1)
 	if len(matches) == 0 {
@@ -246,41 +289,121 @@ func evaluateExpression(expr string, variables map[string]interface{}) (interfac
 
 	// If the entire string is an expression like "{{variable}}"
 	if len(matches) == 1 && matches[0][0] == expr {
-		varName := matches[0][1]
-
-		// Check for ternary operation
-		if strings.Contains(varName, "?") {
-			return evaluateTernary(varName, variables)
-		}
-
-		// Direct variable reference
-		if value, exists := variables[varName]; exists {
-			return value, nil
-		}
-		return nil, fmt.Errorf("variable %s not found", varName)
+		return evaluatePlaceholder(matches[0][1], variables, funcs)
 	}
 
 	// Handle multiple expressions within a string
 	result := expr
 	for _, match := range matches {
-		fullMatch := match[0]
-		varName := match[1]
-
-		// Get the variable value
-		var replaceValue string
-		if value, exists := variables[varName]; exists {
-			replaceValue = fmt.Sprintf("%v", value)
-		} else {
-			return nil, fmt.Errorf("variable %s not found", varName)
+		value, err := evaluatePlaceholder(match[1], variables, funcs)
+		if err != nil {
+			return nil, err
 		}
 
 		// Replace in the result
-		result = strings.Replace(result, fullMatch, replaceValue, 1)
+		result = strings.Replace(result, match[0], fmt.Sprintf("%v", value), 1)
 	}
 
 	return result, nil
 }
 
+// evaluatePlaceholder evaluates the content of a "{{...}}" placeholder. In order of precedence,
+// it is a function call, a ternary operation, a variable, or an expression with arithmetic,
+// comparison and logical operators such as "price * qty". A variable whose name contains an
+// operator character, like "order-id", is therefore looked up rather than computed.
+func evaluatePlaceholder(content string, variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	node, parseErr := parseExpr(content)
+
+	// Check for a function call, unless the call is only the start of an expression
+	if _, isCall := node.(callNode); functionCallPattern.MatchString(content) && (isCall || parseErr != nil) {
+		return evaluateFunctionCall(content, variables, funcs)
+	}
+
+	// Check for ternary operation
+	if strings.Contains(content, "?") {
+		return evaluateTernary(content, variables)
+	}
+
+	// Direct variable reference
+	if value, exists := variables[content]; exists {
+		return value, nil
+	}
+
+	// Compute expressions, a single name is a missing variable
+	if parseErr == nil && !isVariableNode(node) {
+		return node.eval(variables, funcs)
+	}
+	if tokens, err := tokenizeExpr(content); parseErr != nil && err == nil && len(tokens) > 1 {
+		return nil, fmt.Errorf("invalid expression %q: %w", content, parseErr)
+	}
+	return nil, fmt.Errorf("variable %s not found", content)
+}
+
+// evaluateFunctionCall calls a registered function. Arguments are literals, variable names
+// or nested function calls.
+func evaluateFunctionCall(expr string, variables map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	match := functionCallPattern.FindStringSubmatch(expr)
+	if match == nil {
+		return nil, fmt.Errorf("invalid function call: %s", expr)
+	}
+
+	name := match[1]
+	fn, ok := funcs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+
+	var args []interface{}
+	for _, arg := range splitArguments(match[2]) {
+		if functionCallPattern.MatchString(arg) {
+			value, err := evaluateFunctionCall(arg, variables, funcs)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+			continue
+		}
+		args = append(args, getValueForExpression(arg, variables))
+	}
+
+	value, err := fn(args...)
+	if err != nil {
+		return nil, fmt.Errorf("function %s failed: %w", name, err)
+	}
+	return value, nil
+}
+
+// splitArguments splits function arguments on top-level commas, ignoring commas inside
+// quoted strings and nested calls
+func splitArguments(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	var args []string
+	depth := 0
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
 // evaluateTernary handles simple ternary operations like "condition ? trueValue : falseValue"
 func evaluateTernary(expr string, variables map[string]interface{}) (interface{}, error) {
 	parts := strings.Split(expr, "?")
@@ -386,6 +509,9 @@ func isTruthy(v interface{}) bool {
 		return reflect.ValueOf(value).Uint() != 0
 	case float32, float64:
 		return reflect.ValueOf(value).Float() != 0
+	case json.Number:
+		number, err := value.Float64()
+		return err != nil || number != 0
 	case string:
 		return value != ""
 	case []interface{}:
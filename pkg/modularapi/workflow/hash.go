@@ -0,0 +1,20 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Hash returns the SHA-256 hex digest of the workflow serialized as JSON. Map keys are
+// serialized in sorted order, so equal workflows have the same hash whatever the order their
+// maps were filled in. It returns an empty string if the workflow holds a value that cannot
+// be serialized, such as a function in its variables.
+func (w Workflow) Hash() string {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,11 @@
 package workflow_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
@@ -141,3 +146,212 @@ func TestWorkflowLoopAndAggregator(t *testing.T) {
 		t.Errorf("Expected 3 items in aggregated items, got %d", len(items))
 	}
 }
+
+func TestLoopOverNestedPath(t *testing.T) {
+	mockService := NewMockAPIService()
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "nested_loop_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "get_order",
+				ServiceName: "orders",
+				ActionName:  "get",
+				DynamicParams: map[string]string{
+					"order_id": "order",
+				},
+				ResultMapping: map[string]string{
+					"_params": "order_params",
+				},
+				LoopOver: "user.orders",
+				LoopAs:   "order",
+			},
+		},
+		Variables: map[string]interface{}{
+			"user": map[string]interface{}{
+				"orders": []interface{}{"a", "b", "c"},
+				"name":   "Jane",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("nested_loop_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if params, ok := vars["order_params"].([]interface{}); !ok || len(params) != 3 {
+		t.Errorf("Expected 3 iterations over user.orders, got %v", vars["order_params"])
+	}
+
+	// A path that isn't an array is reported
+	wf, _ := executor.GetWorkflow("nested_loop_workflow")
+	wf.Steps[0].LoopOver = "user.name"
+	if err := executor.RegisterWorkflow(wf); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	if _, err := executor.ExecuteWorkflow("nested_loop_workflow", nil, nil); err == nil ||
+		!strings.Contains(err.Error(), "not an array") {
+		t.Errorf("Expected a not an array error, got %v", err)
+	}
+}
+
+// funcMockService computes each response with a function
+type funcMockService func(actionName string, params map[string]interface{}) (map[string]interface{}, error)
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (f funcMockService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	response, err := f(actionName, params)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (f funcMockService) ExecuteServiceActionContext(_ context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return f.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+func TestLoopPreservesAlignment(t *testing.T) {
+	mockService := funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		switch params["id"] {
+		case "b":
+			// No email for this item
+			return map[string]interface{}{"id": "b"}, nil
+		case "c":
+			return nil, fmt.Errorf("item c unavailable")
+		}
+		return map[string]interface{}{"id": params["id"], "email": params["id"].(string) + "@example.com"}, nil
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	loopWorkflow := workflow.Workflow{
+		Name: "aligned_loop_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:            "get_user",
+				ServiceName:   "users",
+				ActionName:    "get",
+				DynamicParams: map[string]string{"id": "user_id"},
+				ResultMapping: map[string]string{
+					"id":    "ids",
+					"email": "emails",
+				},
+				ErrorHandling: workflow.ContinueOnError,
+				LoopOver:      "user_ids",
+				LoopAs:        "user_id",
+			},
+		},
+		Variables: map[string]interface{}{
+			"user_ids": []interface{}{"a", "b", "c", "d"},
+		},
+	}
+	if err := executor.RegisterWorkflow(loopWorkflow); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	// By default missing values are skipped
+	vars, err := executor.ExecuteWorkflow("aligned_loop_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if emails, _ := vars["emails"].([]interface{}); len(emails) != 2 {
+		t.Errorf("Expected 2 emails without alignment, got %v", vars["emails"])
+	}
+
+	loopWorkflow.Steps[0].PreserveLoopAlignment = true
+	if err := executor.RegisterWorkflow(loopWorkflow); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	vars, err = executor.ExecuteWorkflow("aligned_loop_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	ids, _ := vars["ids"].([]interface{})
+	emails, _ := vars["emails"].([]interface{})
+	if len(ids) != 4 || len(emails) != 4 {
+		t.Fatalf("Expected 4 entries per array, got ids %v and emails %v", ids, emails)
+	}
+	if ids[1] != "b" || emails[1] != nil || ids[2] != nil || emails[3] != "d@example.com" {
+		t.Errorf("Expected positional alignment, got ids %v and emails %v", ids, emails)
+	}
+}
+
+func TestLoopMaxIterations(t *testing.T) {
+	calls := 0
+	executor := workflow.NewWorkflowExecutor(funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		calls++
+		return map[string]interface{}{"_params": params}, nil
+	}))
+
+	loopStep := workflow.WorkflowStep{
+		ID:          "get_item",
+		ServiceName: "items",
+		ActionName:  "get",
+		DynamicParams: map[string]string{
+			"item_id": "item",
+		},
+		ResultMapping: map[string]string{
+			"_params": "item_params",
+		},
+		LoopOver: "items",
+		LoopAs:   "item",
+	}
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:              "capped_loop_workflow",
+		Steps:             []workflow.WorkflowStep{loopStep},
+		Variables:         map[string]interface{}{"items": []interface{}{"a", "b", "c", "d"}},
+		MaxLoopIterations: 3,
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	// The cap of the workflow fails a loop over more items, before any request
+	_, err = executor.ExecuteWorkflow("capped_loop_workflow", nil, nil)
+	if !errors.Is(err, workflow.ErrMaxLoopIterations) {
+		t.Fatalf("Expected an error wrapping ErrMaxLoopIterations, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no request, got %d", calls)
+	}
+
+	// A loop within the cap runs every iteration
+	vars, err := executor.ExecuteWorkflow("capped_loop_workflow", map[string]interface{}{
+		"items": []interface{}{"a", "b", "c"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if params, ok := vars["item_params"].([]interface{}); !ok || len(params) != 3 {
+		t.Errorf("Expected 3 iterations, got %v", vars["item_params"])
+	}
+
+	// The cap of the step takes precedence and can truncate the loop instead
+	wf, _ := executor.GetWorkflow("capped_loop_workflow")
+	wf.Steps[0].MaxLoopIterations = 2
+	wf.Steps[0].TruncateLoop = true
+	if err := executor.RegisterWorkflow(wf); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	vars, err = executor.ExecuteWorkflow("capped_loop_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	params, ok := vars["item_params"].([]interface{})
+	if !ok || len(params) != 2 {
+		t.Fatalf("Expected the loop to be truncated to 2 iterations, got %v", vars["item_params"])
+	}
+	if first, _ := params[0].(map[string]interface{}); first["item_id"] != "a" {
+		t.Errorf("Expected the first items to be processed, got %v", params)
+	}
+}
@@ -0,0 +1,105 @@
+package workflow
+
+import "fmt"
+
+// Merge combines the workflow with an overlay and returns the result, leaving both unchanged.
+// The overlay's steps are appended after the workflow's steps, and a step ID defined in both
+// is an error. Variables, computed defaults, post-process and aggregator entries are merged
+// with the overlay winning, as do its name, description, max concurrency, max total retries
+// and max loop iterations when they are set. The result fails fast if either workflow does.
+func (w Workflow) Merge(other Workflow) (Workflow, error) {
+	merged := Workflow{
+		Name:           w.Name,
+		Description:    w.Description,
+		MaxConcurrency: w.MaxConcurrency,
+		FailFast:       w.FailFast || other.FailFast,
+
+		MaxTotalRetries:   w.MaxTotalRetries,
+		MaxLoopIterations: w.MaxLoopIterations,
+	}
+	if other.Name != "" {
+		merged.Name = other.Name
+	}
+	if other.Description != "" {
+		merged.Description = other.Description
+	}
+	if other.MaxConcurrency != 0 {
+		merged.MaxConcurrency = other.MaxConcurrency
+	}
+	if other.MaxTotalRetries != 0 {
+		merged.MaxTotalRetries = other.MaxTotalRetries
+	}
+	if other.MaxLoopIterations != 0 {
+		merged.MaxLoopIterations = other.MaxLoopIterations
+	}
+
+	// Append steps, rejecting duplicate IDs
+	stepIDs := make(map[string]bool)
+	merged.Steps = make([]WorkflowStep, 0, len(w.Steps)+len(other.Steps))
+	for _, step := range append(append([]WorkflowStep{}, w.Steps...), other.Steps...) {
+		if stepIDs[step.ID] {
+			return Workflow{}, fmt.Errorf("cannot merge workflows: duplicate step ID %s", step.ID)
+		}
+		stepIDs[step.ID] = true
+		merged.Steps = append(merged.Steps, step)
+	}
+
+	// Merge variables and aggregator entries, the overlay winning
+	if len(w.Variables) > 0 || len(other.Variables) > 0 {
+		merged.Variables = make(map[string]interface{})
+		for k, v := range w.Variables {
+			merged.Variables[k] = v
+		}
+		for k, v := range other.Variables {
+			merged.Variables[k] = v
+		}
+	}
+	if len(w.PostProcess) > 0 || len(other.PostProcess) > 0 {
+		merged.PostProcess = make(map[string]string)
+		for k, v := range w.PostProcess {
+			merged.PostProcess[k] = v
+		}
+		for k, v := range other.PostProcess {
+			merged.PostProcess[k] = v
+		}
+	}
+	if len(w.ComputedDefaults) > 0 || len(other.ComputedDefaults) > 0 {
+		merged.ComputedDefaults = make(map[string]string)
+		for k, v := range w.ComputedDefaults {
+			merged.ComputedDefaults[k] = v
+		}
+		for k, v := range other.ComputedDefaults {
+			merged.ComputedDefaults[k] = v
+		}
+	}
+	if len(w.Aggregator) > 0 || len(other.Aggregator) > 0 {
+		merged.Aggregator = make(map[string]string)
+		for k, v := range w.Aggregator {
+			merged.Aggregator[k] = v
+		}
+		for k, v := range other.Aggregator {
+			merged.Aggregator[k] = v
+		}
+	}
+
+	return merged, nil
+}
+
+// deepMerge returns a copy of dst with src merged into it: nested objects are merged
+// recursively while other values, including arrays, are overwritten by src
+func deepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := merged[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merged[k] = deepMerge(dstMap, srcMap)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ErrorHookFunc is called when a workflow execution aborts because of a failure.
+// failedStepID is empty when the failure isn't tied to a step.
+type ErrorHookFunc func(workflowName string, failedStepID string, err error)
+
+// ExecutionOption configures a single workflow execution
+type ExecutionOption func(*executionOptions)
+
+// executionOptions holds the configuration of a single workflow execution
+type executionOptions struct {
+	errorHook    ErrorHookFunc
+	streamWriter http.ResponseWriter
+	funcs        map[string]ExpressionFunc // Functions registered on the executor
+	ctx          context.Context           // Context of the requests, canceled to stop a fail-fast group
+	onComplete   CompletionHookFunc
+	retries      *retryBudget // Retries left to the steps of the execution, nil if unlimited
+	stepHook     StepHookFunc
+	onStepStart  func(stepID string) // Called when a step starts, used by ExecuteWorkflowStreaming
+	workflowName string
+	stats        *executorStats         // Counters of the executor, nil outside of ExecuteWorkflow
+	parentVars   map[string]interface{} // Variables shared across executions, see WithParentVariables
+	// stepResults holds the results of the steps finished before a step started, for the
+	// dynamic parameters referencing them through StepsNamespace
+	stepResults map[string]map[string]interface{}
+	// conditionTimeout bounds the evaluation of step conditions, set from the executor
+	conditionTimeout time.Duration
+	stepTimeout      time.Duration // Default timeout of the requests of the steps, see WithStepTimeout
+	// maxLoopIterations caps the iterations of the loop steps without their own cap, set from
+	// the workflow
+	maxLoopIterations int
+	retryable         RetryableFunc // Classifier of the errors of RetryOnError steps, set from the executor
+}
+
+// StepResult is the outcome of a step, or of a single iteration of a loop step such as "orders[2]"
+type StepResult struct {
+	StepID     string
+	Result     map[string]interface{}
+	Error      error
+	Skipped    bool          // The step condition wasn't met and no request was made
+	SkipReason string        // Why the step was skipped, e.g. "condition {{user.active}} not met"
+	Duration   time.Duration // Time taken by the step, retries included
+	// Requests lists the method and URL of the requests sent by the step, every page of a
+	// paginated step, when the service implements RequestInfoServiceExecutor. With retries,
+	// only the requests of the last attempt are listed.
+	Requests []RequestInfo
+}
+
+// StepHookFunc is called each time a step of a workflow execution finishes. Steps running in
+// parallel call it concurrently.
+type StepHookFunc func(workflowName string, result StepResult)
+
+// reportStep counts the result of a step, which may be an iteration of a loop step, in the
+// executor stats and passes it to the step hook, if any
+func (o *executionOptions) reportStep(step WorkflowStep, result stepExecutionResult) {
+	if o.stats != nil {
+		o.stats.recordStep(o.workflowName, step.ID, result)
+	}
+	if o.stepHook == nil {
+		return
+	}
+	o.stepHook(o.workflowName, StepResult{
+		StepID:     result.StepID,
+		Result:     result.Result,
+		Error:      result.Error,
+		Skipped:    result.Skipped,
+		SkipReason: result.SkipReason,
+		Duration:   result.Duration,
+		Requests:   result.Requests,
+	})
+}
+
+// reportStart reports the start of a step, if a start hook is set
+func (o *executionOptions) reportStart(stepID string) {
+	if o.onStepStart != nil {
+		o.onStepStart(stepID)
+	}
+}
+
+// withStepStartHook sets a function called when a step, or a loop iteration, starts
+func withStepStartHook(hook func(stepID string)) ExecutionOption {
+	return func(o *executionOptions) {
+		o.onStepStart = hook
+	}
+}
+
+// CompletionHookFunc is called when an execution started with StartWorkflow finishes, with
+// the values ExecuteWorkflow would have returned
+type CompletionHookFunc func(variables map[string]interface{}, err error)
+
+// newExecutionOptions applies the given options over the defaults
+func newExecutionOptions(opts []ExecutionOption) *executionOptions {
+	options := &executionOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithErrorHook sets a function called right before the execution returns an abort error,
+// whether it comes from a step, a loop iteration or a condition evaluation
+func WithErrorHook(hook ErrorHookFunc) ExecutionOption {
+	return func(o *executionOptions) {
+		o.errorHook = hook
+	}
+}
+
+// WithStepHook sets a function called with the result of each step once it has finished,
+// including steps skipped because their condition wasn't met
+func WithStepHook(hook StepHookFunc) ExecutionOption {
+	return func(o *executionOptions) {
+		o.stepHook = hook
+	}
+}
+
+// WithStreamWriter sets the writer that Streaming steps forward their response to.
+// The service must implement StreamingServiceExecutor.
+func WithStreamWriter(w http.ResponseWriter) ExecutionOption {
+	return func(o *executionOptions) {
+		o.streamWriter = w
+	}
+}
+
+// WithContext binds the execution to ctx: when ctx is done, running requests are aborted, no
+// further step is started and the execution returns an error wrapping the context error
+func WithContext(ctx context.Context) ExecutionOption {
+	return func(o *executionOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithStepTimeout bounds each request of the steps of the execution, every retry, page and
+// loop iteration getting its own timeout. The TimeoutMs of a step takes precedence over it, and
+// both are capped by the context of the execution and the timeout of the HTTP client. A zero
+// duration means no timeout. Streaming steps are not bounded.
+func WithStepTimeout(d time.Duration) ExecutionOption {
+	return func(o *executionOptions) {
+		o.stepTimeout = d
+	}
+}
+
+// WithParentVariables sets variables shared by several executions, such as an API version or
+// a region. They override the workflow Variables and are overridden by the initial parameters.
+func WithParentVariables(vars map[string]interface{}) ExecutionOption {
+	return func(o *executionOptions) {
+		o.parentVars = vars
+	}
+}
+
+// WithCompletionHook sets a function called when an execution started with StartWorkflow
+// finishes, before Wait returns. It is ignored by ExecuteWorkflow, which returns these values.
+func WithCompletionHook(hook CompletionHookFunc) ExecutionOption {
+	return func(o *executionOptions) {
+		o.onComplete = hook
+	}
+}
@@ -0,0 +1,32 @@
+package workflow
+
+import "sort"
+
+// OutputVariables returns the sorted names of the variables a workflow produces, without
+// running it: the targets of the result mappings, which collect an array for loop steps, the
+// MergeInto variables, the post-process variables and the aggregator fields. Steps skipped or
+// failing at run time may leave some of them unset.
+func (w Workflow) OutputVariables() []string {
+	outputs := make(map[string]bool)
+	for _, step := range w.Steps {
+		for _, variable := range step.ResultMapping {
+			outputs[variable] = true
+		}
+		if step.MergeInto != "" {
+			outputs[step.MergeInto] = true
+		}
+	}
+	for variable := range w.PostProcess {
+		outputs[variable] = true
+	}
+	for field := range w.Aggregator {
+		outputs[field] = true
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,157 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultMaxPages is the page guard used when a PaginationSpec does not set MaxPages
+const defaultMaxPages = 100
+
+// PaginationSpec describes how a workflow step follows a paginated endpoint.
+// Two modes are supported:
+//   - page number mode (CursorField empty): PageParam is set to StartPage, StartPage+1, ...
+//     until a page returns no items
+//   - cursor mode (CursorField set): the first request is sent without PageParam, then
+//     PageParam is set to the cursor found at CursorField until it is empty or missing
+type PaginationSpec struct {
+	ItemsField  string `json:"items_field"`            // Dot path to the items array in each page
+	PageParam   string `json:"page_param,omitempty"`   // Parameter carrying the page number or cursor (default "page")
+	StartPage   int    `json:"start_page,omitempty"`   // First page number in page number mode (default 1)
+	CursorField string `json:"cursor_field,omitempty"` // Dot path to the next page cursor (enables cursor mode)
+	MaxPages    int    `json:"max_pages,omitempty"`    // Maximum number of pages to fetch (default 100)
+}
+
+// executePaginatedStep executes a step repeatedly, following pages until they are exhausted.
+// The returned result is the last page's response with the items field replaced by the
+// items of every page, so the step's result mapping can store the combined array.
+func (we *WorkflowExecutor) executePaginatedStep(step WorkflowStep, variables map[string]interface{}, options *executionOptions) stepExecutionResult {
+	result := stepExecutionResult{
+		StepID: step.ID,
+	}
+	spec := step.Paginate
+
+	// Evaluate the condition, or the condition expression, once for the whole step rather than
+	// once per page
+	if step.ConditionExpr != "" || step.Condition != nil {
+		conditionMet, err := evaluateStepCondition(step, variables, options)
+		if err != nil {
+			result.Error = fmt.Errorf("error evaluating condition for step %s: %w", step.ID, err)
+			return result
+		}
+		if !conditionMet {
+			result.Result = make(map[string]interface{})
+			result.Skipped = true
+			result.SkipReason = skipReason(step)
+			we.getLogger(options.ctx).Infof("Skipping step %s: %s", step.ID, result.SkipReason)
+			return result
+		}
+	}
+
+	pageParam := spec.PageParam
+	if pageParam == "" {
+		pageParam = "page"
+	}
+	page := spec.StartPage
+	if page == 0 {
+		page = 1
+	}
+	maxPages := spec.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+	cursorMode := spec.CursorField != ""
+
+	allItems := make([]interface{}, 0)
+	var lastPage map[string]interface{}
+	var cursor interface{}
+
+	for fetched := 0; ; fetched++ {
+		if fetched >= maxPages {
+			we.getLogger(options.ctx).Warnf("Step %s reached the maximum of %d pages, stopping pagination", step.ID, maxPages)
+			break
+		}
+
+		// Build a copy of the step carrying the page parameter for this request
+		pageStep := step
+		pageStep.ID = fmt.Sprintf("%s[page %d]", step.ID, fetched+1)
+		pageStep.Condition = nil
+		pageStep.ConditionExpr = ""
+		pageStep.Paginate = nil
+		pageStep.Parameters = make(map[string]interface{}, len(step.Parameters)+1)
+		for k, v := range step.Parameters {
+			pageStep.Parameters[k] = v
+		}
+		if cursorMode {
+			if fetched > 0 {
+				pageStep.Parameters[pageParam] = cursor
+			}
+		} else {
+			pageStep.Parameters[pageParam] = page
+		}
+
+		pageResult := we.executeStep(pageStep, variables, options)
+		result.Requests = append(result.Requests, pageResult.Requests...)
+		if pageResult.Error != nil {
+			result.Error = fmt.Errorf("pagination failed on page %d: %w", fetched+1, pageResult.Error)
+			return result
+		}
+		lastPage = pageResult.Result
+
+		// Extract the items of this page; a missing or null field counts as an empty page
+		var items []interface{}
+		if rawItems, ok := extractValue(lastPage, spec.ItemsField); ok && rawItems != nil {
+			array, isArray := toArray(rawItems)
+			if !isArray {
+				result.Error = fmt.Errorf("pagination items field '%s' is not an array (type: %T)", spec.ItemsField, rawItems)
+				return result
+			}
+			items = array
+		}
+
+		if len(items) == 0 {
+			break
+		}
+		allItems = append(allItems, items...)
+
+		if cursorMode {
+			next, ok := extractValue(lastPage, spec.CursorField)
+			if !ok || next == nil || next == "" {
+				break
+			}
+			cursor = next
+		} else {
+			page++
+		}
+	}
+
+	we.getLogger(options.ctx).Debugf("Collected %d items across pages for step %s", len(allItems), step.ID)
+
+	if lastPage == nil {
+		lastPage = make(map[string]interface{})
+	}
+	result.Result = setValue(lastPage, spec.ItemsField, allItems)
+	return result
+}
+
+// setValue returns a copy of data with the value at the dot notation path replaced.
+// Intermediate objects are copied (or created) so the original map is left untouched.
+func setValue(data map[string]interface{}, path string, value interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		result[k] = v
+	}
+
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		result[path] = value
+		return result
+	}
+
+	child, _ := result[parts[0]].(map[string]interface{})
+	if child == nil {
+		child = make(map[string]interface{})
+	}
+	result[parts[0]] = setValue(child, parts[1], value)
+	return result
+}
@@ -0,0 +1,280 @@
+package workflow_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
+)
+
+// pagedMockService serves a fixed list of items split into pages of a given size
+type pagedMockService struct {
+	items    []interface{}
+	pageSize int
+	calls    int
+}
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (m *pagedMockService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	m.calls++
+
+	var response map[string]interface{}
+	switch actionName {
+	case "listPages":
+		page, _ := params["page"].(int)
+		start := (page - 1) * m.pageSize
+		end := start + m.pageSize
+		if start > len(m.items) {
+			start = len(m.items)
+		}
+		if end > len(m.items) {
+			end = len(m.items)
+		}
+		response = map[string]interface{}{
+			"data": map[string]interface{}{
+				"items": m.items[start:end],
+			},
+		}
+	case "listCursor":
+		offset := 0
+		if cursor, ok := params["cursor"].(string); ok {
+			fmt.Sscanf(cursor, "offset-%d", &offset)
+		}
+		end := offset + m.pageSize
+		if end > len(m.items) {
+			end = len(m.items)
+		}
+		response = map[string]interface{}{
+			"items": m.items[offset:end],
+		}
+		if end < len(m.items) {
+			response["next"] = fmt.Sprintf("offset-%d", end)
+		}
+	case "getDetails":
+		response = map[string]interface{}{
+			"id": params["id"],
+		}
+	}
+
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *pagedMockService) ExecuteServiceActionContext(_ context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+func TestPaginatedStepFeedsLoop(t *testing.T) {
+	mockService := &pagedMockService{
+		items:    []interface{}{"a", "b", "c", "d", "e"},
+		pageSize: 2,
+	}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "paginated_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "list",
+				ServiceName: "records",
+				ActionName:  "listPages",
+				Paginate: &workflow.PaginationSpec{
+					ItemsField: "data.items",
+				},
+				ResultMapping: map[string]string{
+					"data.items": "all_items",
+				},
+			},
+			{
+				ID:          "details",
+				ServiceName: "records",
+				ActionName:  "getDetails",
+				DynamicParams: map[string]string{
+					"id": "item",
+				},
+				ResultMapping: map[string]string{
+					"id": "detail_ids",
+				},
+				LoopOver: "all_items",
+				LoopAs:   "item",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("paginated_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	allItems, ok := vars["all_items"].([]interface{})
+	if !ok || len(allItems) != 5 {
+		t.Fatalf("Expected 5 combined items, got %v", vars["all_items"])
+	}
+
+	detailIDs, ok := vars["detail_ids"].([]interface{})
+	if !ok || len(detailIDs) != 5 {
+		t.Errorf("Expected loop to process 5 items, got %v", vars["detail_ids"])
+	}
+}
+
+func TestPaginatedStepCursorAndGuards(t *testing.T) {
+	mockService := &pagedMockService{
+		items:    []interface{}{1, 2, 3, 4, 5, 6, 7},
+		pageSize: 3,
+	}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "cursor_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "list",
+				ServiceName: "records",
+				ActionName:  "listCursor",
+				Paginate: &workflow.PaginationSpec{
+					ItemsField:  "items",
+					PageParam:   "cursor",
+					CursorField: "next",
+				},
+				ResultMapping: map[string]string{
+					"items": "all_items",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("cursor_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if items, ok := vars["all_items"].([]interface{}); !ok || len(items) != 7 {
+		t.Errorf("Expected 7 combined items, got %v", vars["all_items"])
+	}
+
+	// An empty first page still produces an empty array
+	mockService.items = []interface{}{}
+	vars, err = executor.ExecuteWorkflow("cursor_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if items, ok := vars["all_items"].([]interface{}); !ok || len(items) != 0 {
+		t.Errorf("Expected an empty array for an empty first page, got %v", vars["all_items"])
+	}
+
+	// The max page guard stops pagination early
+	mockService.items = []interface{}{1, 2, 3, 4, 5, 6, 7}
+	mockService.calls = 0
+	wf, _ := executor.GetWorkflow("cursor_workflow")
+	wf.Steps[0].Paginate.MaxPages = 2
+	if err := executor.RegisterWorkflow(wf); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	vars, err = executor.ExecuteWorkflow("cursor_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if items, ok := vars["all_items"].([]interface{}); !ok || len(items) != 6 {
+		t.Errorf("Expected 6 items with a 2 page guard, got %v", vars["all_items"])
+	}
+	if mockService.calls != 2 {
+		t.Errorf("Expected 2 page requests, got %d", mockService.calls)
+	}
+}
+
+func TestPaginatedStepConditionExprEvaluatedOnce(t *testing.T) {
+	mockService := &pagedMockService{
+		items:    []interface{}{"a", "b", "c", "d", "e"},
+		pageSize: 2,
+	}
+	executor := workflow.NewWorkflowExecutor(mockService)
+	evaluations := 0
+	executor.RegisterExpressionFunc("enabled", func(args ...interface{}) (interface{}, error) {
+		evaluations++
+		return true, nil
+	})
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "conditional_pages",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:            "list",
+				ServiceName:   "records",
+				ActionName:    "listPages",
+				ConditionExpr: "{{enabled()}}",
+				Paginate:      &workflow.PaginationSpec{ItemsField: "data.items"},
+				ResultMapping: map[string]string{"data.items": "all_items"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("conditional_pages", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if items, ok := vars["all_items"].([]interface{}); !ok || len(items) != 5 {
+		t.Errorf("Expected 5 combined items, got %v", vars["all_items"])
+	}
+	if mockService.calls != 4 || evaluations != 1 {
+		t.Errorf("Expected 4 pages and a single evaluation, got %d pages and %d evaluations", mockService.calls, evaluations)
+	}
+}
+
+func TestPaginatedStepSkipped(t *testing.T) {
+	conditions := map[string]workflow.WorkflowStep{
+		"condition": {
+			Condition: &workflow.StepCondition{Type: workflow.ConditionEquals, SourceVariable: "mode", Value: "full"},
+		},
+		"condition_expr": {ConditionExpr: `{{mode == "full"}}`},
+	}
+	expectedReasons := map[string]string{
+		"condition":      "condition not met: mode equals full",
+		"condition_expr": `condition {{mode == "full"}} not met`,
+	}
+	for name, step := range conditions {
+		t.Run(name, func(t *testing.T) {
+			mockService := &pagedMockService{items: []interface{}{"a", "b", "c"}, pageSize: 2}
+			executor := workflow.NewWorkflowExecutor(mockService)
+			step.ID = "list"
+			step.ServiceName = "records"
+			step.ActionName = "listPages"
+			step.Paginate = &workflow.PaginationSpec{ItemsField: "data.items"}
+			if err := executor.RegisterWorkflow(workflow.Workflow{Name: "skipped_pages", Steps: []workflow.WorkflowStep{step}}); err != nil {
+				t.Fatalf("Failed to register workflow: %v", err)
+			}
+
+			var reported workflow.StepResult
+			_, err := executor.ExecuteWorkflow("skipped_pages", map[string]interface{}{"mode": "quick"}, nil,
+				workflow.WithStepHook(func(_ string, result workflow.StepResult) {
+					reported = result
+				}))
+			if err != nil {
+				t.Fatalf("Failed to execute workflow: %v", err)
+			}
+			if !reported.Skipped || reported.SkipReason != expectedReasons[name] {
+				t.Errorf("Expected the step to be skipped with %q, got %+v", expectedReasons[name], reported)
+			}
+			if mockService.calls != 0 {
+				t.Errorf("Expected no page request, got %d", mockService.calls)
+			}
+			stats := executor.Stats().Steps[workflow.StepKey{Workflow: "skipped_pages", Step: "list"}]
+			if stats.Skipped != 1 || stats.Successes != 0 {
+				t.Errorf("Expected the step to count as skipped, got %+v", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// runPostProcess evaluates the PostProcess entries of a workflow once its steps have run and
+// stores each result in the variable named by its key. Entries use the aggregator expression
+// syntax and are evaluated in dependency order, so an entry can use the result of another:
+// entries referencing no other entry are evaluated in the order of their names.
+func runPostProcess(postProcess map[string]string, variables map[string]interface{}, stepResults map[string]map[string]interface{}, funcs map[string]ExpressionFunc) error {
+	if len(postProcess) == 0 {
+		return nil
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		resolved
+	)
+	state := make(map[string]int)
+
+	var evaluate func(name string, path []string) error
+	evaluate = func(name string, path []string) error {
+		switch state[name] {
+		case resolved:
+			return nil
+		case visiting:
+			return fmt.Errorf("cyclic post-process reference: %s", strings.Join(append(path, name), " -> "))
+		}
+
+		state[name] = visiting
+		for _, dependency := range postProcessDependencies(postProcess[name], postProcess) {
+			if dependency == name {
+				// An entry may transform the variable it replaces, e.g. "total": "round(total)"
+				continue
+			}
+			if err := evaluate(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		value, err := evaluateAggregatorExpression(postProcess[name], variables, stepResults, funcs)
+		if err != nil {
+			return fmt.Errorf("error evaluating post-process variable %s: %w", name, err)
+		}
+		variables[name] = value
+		state[name] = resolved
+		return nil
+	}
+
+	names := make([]string, 0, len(postProcess))
+	for name := range postProcess {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := evaluate(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postProcessDependencies returns the post-process entries referenced by an expression
+func postProcessDependencies(expr string, postProcess map[string]string) []string {
+	expr = quotedStringPattern.ReplaceAllString(expr, "")
+
+	seen := make(map[string]bool)
+	var dependencies []string
+	for _, identifier := range identifierPattern.FindAllString(expr, -1) {
+		if _, exists := postProcess[identifier]; exists && !seen[identifier] {
+			seen[identifier] = true
+			dependencies = append(dependencies, identifier)
+		}
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
@@ -0,0 +1,145 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is wrapped by the error of a step failing once a retry has been denied
+// because the MaxTotalRetries budget of its workflow was used up
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryableFunc decides whether the error of a failed RetryOnError step is worth a retry, so
+// permanent failures don't use up attempts
+type RetryableFunc func(err error) bool
+
+// DefaultRetryable is the RetryableFunc used unless the step or the executor set their own.
+// Errors carrying a 4xx status code, such as a client.APIError for a bad request, are permanent
+// and not retried, except 429 Too Many Requests. Server and network errors, and any error
+// without a status code, are retried.
+func DefaultRetryable(err error) bool {
+	var statusErr StatusCodeError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	status := statusErr.HTTPStatusCode()
+	return status < 400 || status >= 500 || status == http.StatusTooManyRequests
+}
+
+// isRetryable classifies the error of a step with the RetryableFunc of the step, else of the
+// execution, else DefaultRetryable
+func isRetryable(s WorkflowStep, options *executionOptions, err error) bool {
+	switch {
+	case s.Retryable != nil:
+		return s.Retryable(err)
+	case options.retryable != nil:
+		return options.retryable(err)
+	default:
+		return DefaultRetryable(err)
+	}
+}
+
+// retryBudget counts the retries left to an execution, shared by all of its steps.
+// A nil budget is unlimited.
+type retryBudget struct {
+	remaining atomic.Int64
+	denied    atomic.Bool // A retry was requested once none was left
+}
+
+// newRetryBudget returns the budget of an execution, nil when maxTotalRetries is not positive
+func newRetryBudget(maxTotalRetries int) *retryBudget {
+	if maxTotalRetries <= 0 {
+		return nil
+	}
+	budget := &retryBudget{}
+	budget.remaining.Store(int64(maxTotalRetries))
+	return budget
+}
+
+// take consumes a retry, reporting false and recording the denial when none is left
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.remaining.Add(-1) >= 0 {
+		return true
+	}
+	b.denied.Store(true)
+	return false
+}
+
+// exhausted reports whether a retry has been denied because every retry of the budget was
+// used. Using the last retry, even if it fails, doesn't exhaust the budget until another
+// retry is requested.
+func (b *retryBudget) exhausted() bool {
+	return b != nil && b.denied.Load()
+}
+
+// executeWithRetries runs a step with its retries, waiting DelayBeforeMs before it and
+// DelayAfterMs after it unless it was skipped. A cancellation of the execution interrupts the
+// delays, and fails the step when it happens before it runs.
+func (we *WorkflowExecutor) executeWithRetries(s WorkflowStep, options *executionOptions, run func() stepExecutionResult) stepExecutionResult {
+	if err := sleepContext(options.ctx, time.Duration(s.DelayBeforeMs)*time.Millisecond); err != nil {
+		return stepExecutionResult{StepID: s.ID, Error: fmt.Errorf("step %s canceled before it started: %w", s.ID, err)}
+	}
+	result := we.retry(s, options, run)
+	if !result.Skipped {
+		// The execution checks for a cancellation before starting the next step
+		_ = sleepContext(options.ctx, time.Duration(s.DelayAfterMs)*time.Millisecond)
+	}
+	return result
+}
+
+// retry runs a step, running it again up to MaxRetries times, RetryDelayMs apart, when it
+// fails with a retryable error and uses RetryOnError. Each retry is taken from the retry budget
+// of the execution and retries stop when the budget is exhausted or the execution canceled.
+func (we *WorkflowExecutor) retry(s WorkflowStep, options *executionOptions, run func() stepExecutionResult) (result stepExecutionResult) {
+	started := time.Now()
+	defer func() { result.Duration = time.Since(started) }()
+
+	result = run()
+	if s.ErrorHandling != RetryOnError {
+		return result
+	}
+
+	logger := we.getLogger(options.ctx)
+	for attempt := 1; result.Error != nil && attempt <= s.MaxRetries; attempt++ {
+		if options.ctx.Err() != nil {
+			break
+		}
+		if !isRetryable(s, options, result.Error) {
+			logger.Warnf("Step %s failed with a permanent error, not retrying: %v", s.ID, result.Error)
+			break
+		}
+		if !options.retries.take() {
+			logger.Warnf("Retry budget exhausted, not retrying step %s: %v", s.ID, result.Error)
+			break
+		}
+
+		logger.Warnf("Step %s failed: %v, retry %d/%d", s.ID, result.Error, attempt, s.MaxRetries)
+		if err := sleepContext(options.ctx, time.Duration(s.RetryDelayMs)*time.Millisecond); err != nil {
+			return result
+		}
+		result = run()
+	}
+	return result
+}
+
+// sleepContext waits for d, returning the error of ctx early if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
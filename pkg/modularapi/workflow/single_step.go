@@ -0,0 +1,45 @@
+package workflow
+
+import "fmt"
+
+// ExecuteSingleStep runs a single step outside of any workflow, for example to test its
+// parameters and result mapping while building a workflow. The step's condition, dynamic
+// parameters, pagination, retries and result mapping apply, but LoopOver and ParallelWith are
+// ignored: to test an iteration of a loop step, set its LoopAs variable in variables.
+//
+// It returns the result of the step, empty when its condition isn't met, and a copy of variables
+// updated with the mapped results. The variables passed are not modified. A failing step returns
+// its error whatever its ErrorHandling strategy.
+func (we *WorkflowExecutor) ExecuteSingleStep(step WorkflowStep, variables map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	options := newExecutionOptions(nil)
+	we.mu.RLock()
+	options.funcs = we.executionFuncs()
+	options.conditionTimeout = we.conditionTimeout
+	options.retryable = we.retryable
+	we.mu.RUnlock()
+
+	updatedVars := make(map[string]interface{}, len(variables))
+	for k, v := range variables {
+		updatedVars[k] = v
+	}
+	state := &executionState{
+		variables:     updatedVars,
+		stepResults:   make(map[string]map[string]interface{}),
+		executedSteps: make(map[string]bool),
+	}
+	options.stepResults = state.stepResults
+
+	result := we.executeWithRetries(step, options, func() stepExecutionResult {
+		if step.Paginate != nil {
+			return we.executePaginatedStep(step, updatedVars, options)
+		}
+		return we.executeStep(step, updatedVars, options)
+	})
+	if result.Error != nil {
+		return nil, updatedVars, fmt.Errorf("step %s failed: %w", step.ID, result.Error)
+	}
+	if err := we.applyStepResult(step, result, state, options); err != nil {
+		return nil, updatedVars, err
+	}
+	return result.Result, updatedVars, nil
+}
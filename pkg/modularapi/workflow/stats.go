@@ -0,0 +1,101 @@
+package workflow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the executions of a WorkflowExecutor since it was created
+type Stats struct {
+	Runs      int64 // Executions started, running ones included
+	Successes int64 // Executions that completed without error
+	Failures  int64 // Executions that returned an error, collected step errors included
+	Steps     map[StepKey]StepStats
+}
+
+// StepKey identifies a step in Stats
+type StepKey struct {
+	Workflow string
+	Step     string
+}
+
+// StepStats counts the runs of a step. Each iteration of a loop step counts as one run.
+type StepStats struct {
+	Successes int64
+	Failures  int64
+	Skipped   int64 // Runs whose condition wasn't met
+	// AverageDuration is the mean duration of the successful and failed runs, retries included
+	AverageDuration time.Duration
+}
+
+// executorStats holds the counters behind Stats, updated atomically by the executions
+type executorStats struct {
+	runs      atomic.Int64
+	successes atomic.Int64
+	failures  atomic.Int64
+	steps     sync.Map // StepKey to *stepCounters
+}
+
+// stepCounters holds the counters of a step
+type stepCounters struct {
+	successes atomic.Int64
+	failures  atomic.Int64
+	skipped   atomic.Int64
+	duration  atomic.Int64 // Total duration of the successful and failed runs, in nanoseconds
+}
+
+// recordRun counts the outcome of an execution
+func (s *executorStats) recordRun(err error) {
+	if err != nil {
+		s.failures.Add(1)
+	} else {
+		s.successes.Add(1)
+	}
+}
+
+// recordStep counts the outcome of a step, or of an iteration of a loop step
+func (s *executorStats) recordStep(workflowName, stepID string, result stepExecutionResult) {
+	key := StepKey{Workflow: workflowName, Step: stepID}
+	value, ok := s.steps.Load(key)
+	if !ok {
+		value, _ = s.steps.LoadOrStore(key, &stepCounters{})
+	}
+	counters := value.(*stepCounters)
+
+	switch {
+	case result.Skipped:
+		counters.skipped.Add(1)
+		return
+	case result.Error != nil:
+		counters.failures.Add(1)
+	default:
+		counters.successes.Add(1)
+	}
+	counters.duration.Add(int64(result.Duration))
+}
+
+// Stats returns the number of executions and their outcome, along with the outcome and
+// average duration of each step that ran
+func (we *WorkflowExecutor) Stats() Stats {
+	stats := Stats{
+		Runs:      we.stats.runs.Load(),
+		Successes: we.stats.successes.Load(),
+		Failures:  we.stats.failures.Load(),
+		Steps:     make(map[StepKey]StepStats),
+	}
+	we.stats.steps.Range(func(key, value interface{}) bool {
+		counters := value.(*stepCounters)
+		step := StepStats{
+			Successes: counters.successes.Load(),
+			Failures:  counters.failures.Load(),
+			Skipped:   counters.skipped.Load(),
+		}
+		if runs := step.Successes + step.Failures; runs > 0 {
+			step.AverageDuration = time.Duration(counters.duration.Load() / runs)
+		}
+		stats.Steps[key.(StepKey)] = step
+		return true
+	})
+	return stats
+}
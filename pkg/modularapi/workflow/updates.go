@@ -0,0 +1,99 @@
+package workflow
+
+import "sync"
+
+// StepUpdateType is the kind of a StepUpdate
+type StepUpdateType string
+
+const (
+	// StepStarted is sent when a step, or an iteration of a loop step, starts
+	StepStarted StepUpdateType = "step_started"
+	// StepFinished is sent when a step, or an iteration of a loop step, finishes or is skipped
+	StepFinished StepUpdateType = "step_finished"
+	// WorkflowFinished is the last update of an execution
+	WorkflowFinished StepUpdateType = "workflow_finished"
+)
+
+// StepUpdate reports the progress of an execution started with ExecuteWorkflowStreaming
+type StepUpdate struct {
+	Type      StepUpdateType
+	StepID    string                 // Step the update is about, empty for WorkflowFinished
+	Result    StepResult             // Outcome of the step, for StepFinished
+	Variables map[string]interface{} // Variables of the execution, for WorkflowFinished
+	Error     error                  // Error of the execution, for WorkflowFinished
+}
+
+// updateBufferSize is the number of updates ExecuteWorkflowStreaming buffers for a caller that
+// doesn't keep up, beyond which the oldest step updates are dropped
+const updateBufferSize = 64
+
+// ExecuteWorkflowStreaming starts a workflow in the background and returns a channel receiving
+// its progress: a StepStarted and a StepFinished update for each step, then a WorkflowFinished
+// update with the values ExecuteWorkflow would have returned, after which the channel is closed.
+//
+// The execution never waits for the caller to receive updates. They are buffered, and when a
+// caller falls behind by more than the buffer, the oldest updates are dropped to make room:
+// the WorkflowFinished update is always delivered. A caller can therefore abandon the channel
+// without blocking the execution or leaking it, and cancels the context passed with WithContext
+// to stop the execution as well.
+func (we *WorkflowExecutor) ExecuteWorkflowStreaming(name string, initialParams map[string]interface{}, opts ...ExecutionOption) (<-chan StepUpdate, error) {
+	options := newExecutionOptions(opts)
+	updates := &updateBuffer{ch: make(chan StepUpdate, updateBufferSize)}
+
+	stepHook := options.stepHook
+	onComplete := options.onComplete
+	executionOpts := append(append([]ExecutionOption{}, opts...),
+		withStepStartHook(func(stepID string) {
+			updates.push(StepUpdate{Type: StepStarted, StepID: stepID})
+		}),
+		WithStepHook(func(workflowName string, result StepResult) {
+			if stepHook != nil {
+				stepHook(workflowName, result)
+			}
+			updates.push(StepUpdate{Type: StepFinished, StepID: result.StepID, Result: result})
+		}),
+		WithCompletionHook(func(variables map[string]interface{}, err error) {
+			if onComplete != nil {
+				onComplete(variables, err)
+			}
+			updates.push(StepUpdate{Type: WorkflowFinished, Variables: variables, Error: err})
+			updates.close()
+		}),
+	)
+	if _, err := we.StartWorkflow(name, initialParams, nil, executionOpts...); err != nil {
+		return nil, err
+	}
+	return updates.ch, nil
+}
+
+// updateBuffer sends the updates of an execution to a buffered channel without blocking
+type updateBuffer struct {
+	mu sync.Mutex // Keeps the updates of parallel steps in order
+	ch chan StepUpdate
+}
+
+// push sends an update, dropping the oldest buffered one when the buffer is full. The
+// WorkflowFinished update is the last one, so it is never dropped.
+func (b *updateBuffer) push(update StepUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		select {
+		case b.ch <- update:
+			return
+		default:
+		}
+		// The caller may receive the oldest update first, in which case there is room again
+		select {
+		case <-b.ch:
+		default:
+		}
+	}
+}
+
+// close marks the end of the updates
+func (b *updateBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+}
@@ -0,0 +1,166 @@
+package workflow
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// identifierPattern matches identifiers that may reference variables inside an expression
+var identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// quotedStringPattern matches quoted string literals, which never reference variables
+var quotedStringPattern = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+
+// resolveVariableReferences evaluates variables whose value is a template expression, such as
+// "greeting": "Hello {{name}}", against the other variables. Variables are resolved in
+// dependency order so an expression can reference another expression, and cyclic references
+// are reported as an error.
+func resolveVariableReferences(variables map[string]interface{}, funcs map[string]ExpressionFunc) error {
+	// Collect the expression variables and the variables they reference
+	dependencies := make(map[string][]string)
+	for name, value := range variables {
+		strValue, isString := value.(string)
+		if !isString || !isExpression(strValue) {
+			continue
+		}
+		dependencies[name] = expressionDependencies(strValue, variables)
+	}
+	if len(dependencies) == 0 {
+		return nil
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		resolved
+	)
+	state := make(map[string]int)
+
+	var resolve func(name string, path []string) error
+	resolve = func(name string, path []string) error {
+		switch state[name] {
+		case resolved:
+			return nil
+		case visiting:
+			// Report the cycle starting from its first occurrence in the path
+			for i, visited := range path {
+				if visited == name {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("cyclic variable reference: %s", strings.Join(append(path, name), " -> "))
+		}
+
+		state[name] = visiting
+		for _, dependency := range dependencies[name] {
+			if _, isExpressionVar := dependencies[dependency]; !isExpressionVar {
+				continue
+			}
+			if err := resolve(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		value, err := evaluateExpression(variables[name].(string), variables, funcs)
+		if err != nil {
+			return fmt.Errorf("error resolving variable %s: %w", name, err)
+		}
+		variables[name] = value
+		state[name] = resolved
+		return nil
+	}
+
+	// Resolve in a stable order so errors are reproducible
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyComputedDefaults evaluates the computed defaults of the variables absent from variables
+// and stores their values. Defaults are evaluated in dependency order, so a default can use the
+// value of another, and defaults referencing no other pending default in the order of their names.
+func applyComputedDefaults(defaults map[string]string, variables map[string]interface{}, funcs map[string]ExpressionFunc) error {
+	pending := make(map[string]interface{})
+	for name := range defaults {
+		if _, exists := variables[name]; !exists {
+			pending[name] = nil
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		resolved
+	)
+	state := make(map[string]int)
+
+	var compute func(name string, path []string) error
+	compute = func(name string, path []string) error {
+		switch state[name] {
+		case resolved:
+			return nil
+		case visiting:
+			return fmt.Errorf("cyclic computed default reference: %s", strings.Join(append(path, name), " -> "))
+		}
+
+		state[name] = visiting
+		for _, dependency := range expressionDependencies(defaults[name], pending) {
+			if err := compute(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		value, err := evaluateExpression(defaults[name], variables, funcs)
+		if err != nil {
+			return fmt.Errorf("error computing default of variable %s: %w", name, err)
+		}
+		variables[name] = value
+		state[name] = resolved
+		return nil
+	}
+
+	names := make([]string, 0, len(pending))
+	for name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := compute(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expressionDependencies returns the known variables referenced by the expressions in a string
+func expressionDependencies(s string, variables map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var dependencies []string
+	for _, match := range expressionPattern.FindAllStringSubmatch(s, -1) {
+		inner := quotedStringPattern.ReplaceAllString(match[1], "")
+		for _, identifier := range identifierPattern.FindAllString(inner, -1) {
+			if _, exists := variables[identifier]; exists && !seen[identifier] {
+				seen[identifier] = true
+				dependencies = append(dependencies, identifier)
+			}
+		}
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
@@ -1,19 +1,57 @@
 package workflow
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"net/http"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/rrodriguez06/modular_api/internal/log"
 )
 
+// RawResultField is the result field holding the undecoded response body of a RawResult step
+const RawResultField = "_raw"
+
+// ArrayResultField is the result field holding a response whose body is a top-level JSON array
+const ArrayResultField = "_array"
+
+// StreamResultField is the result field holding the buffered response of a Streaming step
+const StreamResultField = "_stream"
+
+// StatusResultField is the result field holding the status code of a response accepted through AcceptStatusCodes
+const StatusResultField = "_status"
+
+// StepsNamespace prefixes aggregator expressions and dynamic parameters referencing step
+// results by step ID, e.g. "steps.get_user.email". Loop iterations are referenced as "steps.<id>[<index>]".
+const StepsNamespace = "steps"
+
+// WholeResultField is the result mapping source storing the entire step result in a variable.
+// "*" is accepted as well.
+const WholeResultField = "."
+
+// StatusCodeError is implemented by errors carrying the HTTP status code of a failed request,
+// such as client.APIError. It lets steps accept specific status codes with AcceptStatusCodes.
+type StatusCodeError interface {
+	error
+	HTTPStatusCode() int
+}
+
 // ErrInvalidTemplateID is returned when a template ID is not in the format "service.action"
 var ErrInvalidTemplateID = fmt.Errorf("invalid template ID, must be in format 'service.action'")
 
+// ErrMaxLoopIterations is wrapped by the error of a loop step over more items than its
+// MaxLoopIterations allows
+var ErrMaxLoopIterations = errors.New("too many loop iterations")
+
 // SplitTemplateID splits a template ID in the format "service.action" into its components
 func SplitTemplateID(templateID string) []string {
 	return strings.Split(templateID, ".")
@@ -33,6 +71,8 @@ const (
 	ConditionGreaterThan StepConditionType = "greater_than"
 	// ConditionLessThan checks if a variable is less than a value
 	ConditionLessThan StepConditionType = "less_than"
+	// ConditionRegexMatch checks if a string variable matches a regular expression
+	ConditionRegexMatch StepConditionType = "matches"
 )
 
 // ErrorHandlingStrategy defines how errors in workflow steps are handled
@@ -45,6 +85,9 @@ const (
 	AbortOnError ErrorHandlingStrategy = "abort"
 	// RetryOnError retries the step if it fails
 	RetryOnError ErrorHandlingStrategy = "retry"
+	// CollectErrors continues to the next step and reports the failure, along with the failures of
+	// other collecting steps, in the StepErrors returned at the end of the execution
+	CollectErrors ErrorHandlingStrategy = "collect"
 )
 
 // StepCondition defines a condition that must be met for a workflow step to execute
@@ -64,21 +107,76 @@ type WorkflowStep struct {
 	DynamicParams map[string]string      `json:"dynamic_params"`           // Parameters sourced from variables
 	ResultMapping map[string]string      `json:"result_mapping"`           // Map response fields to variables
 	Condition     *StepCondition         `json:"condition,omitempty"`      // Condition to execute this step
+	ConditionExpr string                 `json:"condition_expr,omitempty"` // Boolean expression to execute this step, takes precedence over Condition
 	ParallelWith  []string               `json:"parallel_with,omitempty"`  // IDs of steps to execute in parallel with
+	DependsOn     []string               `json:"depends_on,omitempty"`     // IDs of steps to finish before this one, see Workflow
 	ErrorHandling ErrorHandlingStrategy  `json:"error_handling,omitempty"` // How to handle errors
 	MaxRetries    int                    `json:"max_retries,omitempty"`    // Maximum number of retries (for retry strategy)
 	RetryDelayMs  int                    `json:"retry_delay_ms,omitempty"` // Delay between retries in milliseconds
-	LoopOver      string                 `json:"loop_over,omitempty"`      // Name of variable containing array to iterate over
+	TimeoutMs     int                    `json:"timeout_ms,omitempty"`     // Timeout of each request of the step in milliseconds, see WithStepTimeout
+	LoopOver      string                 `json:"loop_over,omitempty"`      // Variable or dot-path (e.g. "user.orders") of the array to iterate over
 	LoopAs        string                 `json:"loop_as,omitempty"`        // Name of the variable to store current item in the loop
+	Paginate      *PaginationSpec        `json:"paginate,omitempty"`       // Follow pages and combine their items
+	Priority      int                    `json:"priority,omitempty"`       // Start order among parallel steps when concurrency is limited (higher first)
+	RawResult     bool                   `json:"raw_result,omitempty"`     // Keep the raw response body under RawResultField instead of decoding it
+	Streaming     bool                   `json:"streaming,omitempty"`      // Forward the response to the execution's stream writer
+	// AcceptStatusCodes lists error status codes (e.g. 404) treated as a successful empty result
+	AcceptStatusCodes []int `json:"accept_status_codes,omitempty"`
+	// MergeInto deep-merges the step result into this object variable, creating it if absent.
+	// With a result mapping, only the mapped fields are merged, under their variable names.
+	MergeInto string `json:"merge_into,omitempty"`
+	// PreserveLoopAlignment collects nil for loop iterations missing a mapped field (or failing with
+	// ContinueOnError) so every collected array has one entry per iteration
+	PreserveLoopAlignment bool `json:"preserve_loop_alignment,omitempty"`
+	// GroupCondition makes the condition of the step gate its whole parallel group: when it isn't
+	// met, the steps declaring it in their ParallelWith are skipped along with it
+	GroupCondition bool `json:"group_condition,omitempty"`
+	// DelayBeforeMs and DelayAfterMs wait before and after running the step in milliseconds, for
+	// example to cool off a rate limit. A skipped step doesn't wait after running.
+	DelayBeforeMs int `json:"delay_before_ms,omitempty"`
+	DelayAfterMs  int `json:"delay_after_ms,omitempty"`
+	// MaxLoopIterations caps the iterations of a loop step, as a safety valve against an
+	// unexpectedly large array. A larger array fails the step with ErrMaxLoopIterations, or
+	// with TruncateLoop only its first MaxLoopIterations items are processed. 0 uses the
+	// MaxLoopIterations of the workflow.
+	MaxLoopIterations int  `json:"max_loop_iterations,omitempty"`
+	TruncateLoop      bool `json:"truncate_loop,omitempty"`
+	// Retryable decides which errors of a RetryOnError step are retried, taking precedence over
+	// the executor's, see SetRetryable. It can't be saved to a file.
+	Retryable RetryableFunc `json:"-"`
 }
 
-// Workflow defines a sequence of API calls with dependencies between them
+// Workflow defines a sequence of API calls with dependencies between them.
+//
+// By default, steps run in definition order, a step running along with the earlier step its
+// ParallelWith references. When steps declare DependsOn, the workflow is scheduled as a graph
+// instead: each step starts as soon as the steps it depends on have finished, and steps
+// without dependencies start right away. The two models can't be combined.
 type Workflow struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	Steps       []WorkflowStep         `json:"steps"`
 	Variables   map[string]interface{} `json:"variables,omitempty"`  // Default workflow variables
 	Aggregator  map[string]string      `json:"aggregator,omitempty"` // Mapping for result aggregation
+	// ComputedDefaults computes the variables absent from the initial parameters, such as a
+	// default date range. Keys are variable names and values template expressions like
+	// "{{today()}}", which can reference each other.
+	ComputedDefaults map[string]string `json:"computed_defaults,omitempty"`
+	// MaxConcurrency limits how many parallel steps run at once (0 means unlimited)
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// PostProcess computes variables once all steps have run, before the aggregator, so its
+	// results can be aggregated. Keys are variable names and values aggregator expressions.
+	PostProcess map[string]string `json:"post_process,omitempty"`
+	// FailFast cancels the other steps of a parallel group as soon as one of them fails with
+	// AbortOnError, instead of waiting for all of them to complete
+	FailFast bool `json:"fail_fast,omitempty"`
+	// MaxTotalRetries caps the retries of all RetryOnError steps of an execution (0 means
+	// unlimited). Once a step needs a retry after it is used up, that step and any later failing
+	// step abort the workflow, whatever their strategy.
+	MaxTotalRetries int `json:"max_total_retries,omitempty"`
+	// MaxLoopIterations caps the iterations of the loop steps without their own cap (0 means
+	// unlimited), see WorkflowStep
+	MaxLoopIterations int `json:"max_loop_iterations,omitempty"`
 }
 
 // WorkflowService defines the interface for working with workflows
@@ -88,7 +186,7 @@ type WorkflowService interface {
 
 	// ExecuteWorkflow runs a workflow with the given initial parameters
 	// If result is not nil, the response of the last step will be unmarshalled into it
-	ExecuteWorkflow(name string, initialParams map[string]interface{}, result interface{}) (map[string]interface{}, error)
+	ExecuteWorkflow(name string, initialParams map[string]interface{}, result interface{}, opts ...ExecutionOption) (map[string]interface{}, error)
 
 	// GetWorkflow returns a workflow by name
 	GetWorkflow(name string) (Workflow, bool)
@@ -105,22 +203,81 @@ type WorkflowService interface {
 
 // stepExecutionResult holds the result of a workflow step execution
 type stepExecutionResult struct {
-	StepID string
-	Result map[string]interface{}
-	Error  error
+	StepID     string
+	Result     map[string]interface{}
+	Error      error
+	Skipped    bool          // The step condition wasn't met and no request was made
+	SkipReason string        // Why the step was skipped
+	Duration   time.Duration // Time taken by the step, retries included
+	Requests   []RequestInfo // Requests sent by the last attempt, if the service reports them
 }
 
 // APIServiceExecutor defines the minimal interface that the workflow package needs from a service
 type APIServiceExecutor interface {
 	// ExecuteServiceAction executes an API request and unmarshals the result into the given interface
 	ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error
+	// ExecuteServiceActionContext is ExecuteServiceAction aborted when ctx is done. Workflows
+	// call it with the execution context, so canceling an execution or a fail-fast group
+	// cancels its running requests. ExecuteServiceAction usually delegates to it.
+	ExecuteServiceActionContext(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error
+}
+
+// ContextServiceExecutor is implemented by services that can bind a request to a context.
+//
+// Deprecated: ExecuteServiceActionContext is part of APIServiceExecutor.
+type ContextServiceExecutor interface {
+	// ExecuteServiceActionContext is ExecuteServiceAction aborted when ctx is done
+	ExecuteServiceActionContext(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error
+}
+
+// DefaultIndent is the indentation used when saving workflows to a file
+const DefaultIndent = "  "
+
+// DefaultConditionTimeout bounds the evaluation of a step condition calling custom functions
+const DefaultConditionTimeout = 5 * time.Second
+
+// StreamingServiceExecutor is implemented by services that can forward a streamed response.
+// It is required to execute steps marked as Streaming.
+type StreamingServiceExecutor interface {
+	// ExecuteStreamingServiceAction executes an API request aborted when ctx is done, forwards the
+	// streamed response to w and returns the request sent, or an empty RequestInfo if it couldn't
+	// be prepared, and the full buffered response. Like ExecuteServiceActionContext, it is called
+	// with the execution context, bounded by the timeout of the step.
+	ExecuteStreamingServiceAction(ctx context.Context, serviceName, actionName string, params map[string]interface{}, w http.ResponseWriter) (RequestInfo, string, error)
+}
+
+// RequestInfo describes an HTTP request sent by a step
+type RequestInfo struct {
+	Method string
+	URL    string // Final URL, with the path and query parameters resolved
+}
+
+// RequestInfoServiceExecutor is implemented by services that can report the request they
+// send. When the service implements it, step results list the requests of the step.
+type RequestInfoServiceExecutor interface {
+	// ExecuteServiceActionInfo is ExecuteServiceActionContext also returning the request sent,
+	// or an empty RequestInfo if the request couldn't be prepared
+	ExecuteServiceActionInfo(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) (RequestInfo, error)
 }
 
 // WorkflowExecutor executes workflows using a modular API service
 type WorkflowExecutor struct {
 	service   APIServiceExecutor
 	workflows map[string]Workflow
+	funcs     map[string]ExpressionFunc // Custom functions callable from expressions
+	indent    string                    // Indentation used when saving workflows
+	logger    log.Logger                // Logger used during executions, the global logger if nil
 	mu        sync.RWMutex
+
+	// conditionTimeout bounds the evaluation of step conditions (0 means unbounded)
+	conditionTimeout time.Duration
+	clock            func() time.Time // Current time of the date functions, time.Now if nil
+	retryable        RetryableFunc    // Classifies the errors of RetryOnError steps, DefaultRetryable if nil
+
+	executions   map[string]*Execution // Executions started with StartWorkflow, by ID
+	executionsMu sync.Mutex
+
+	stats executorStats // Counters returned by Stats
 }
 
 // NewWorkflowExecutor creates a new workflow executor
@@ -128,6 +285,10 @@ func NewWorkflowExecutor(service APIServiceExecutor) *WorkflowExecutor {
 	return &WorkflowExecutor{
 		service:   service,
 		workflows: make(map[string]Workflow),
+		funcs:     make(map[string]ExpressionFunc),
+		indent:    DefaultIndent,
+
+		conditionTimeout: DefaultConditionTimeout,
 	}
 }
 
@@ -141,6 +302,10 @@ func (we *WorkflowExecutor) RegisterWorkflow(workflow Workflow) error {
 		return fmt.Errorf("workflow must have a name")
 	}
 
+	if workflow.MaxConcurrency < 0 {
+		return fmt.Errorf("workflow %s cannot have a negative max concurrency", workflow.Name)
+	}
+
 	// Validate steps
 	stepIDs := make(map[string]bool)
 	for _, step := range workflow.Steps {
@@ -153,11 +318,38 @@ func (we *WorkflowExecutor) RegisterWorkflow(workflow Workflow) error {
 		}
 		stepIDs[step.ID] = true
 
+		// Names may be templates like "{{channel}}", resolved when the step runs
 		if step.ServiceName == "" || step.ActionName == "" {
 			return fmt.Errorf("step %s in workflow %s must have a service name and action name",
 				step.ID, workflow.Name)
 		}
 
+		if step.Paginate != nil {
+			if step.Paginate.ItemsField == "" {
+				return fmt.Errorf("step %s in workflow %s must set an items field for pagination",
+					step.ID, workflow.Name)
+			}
+			if step.LoopOver != "" {
+				return fmt.Errorf("step %s in workflow %s cannot combine pagination with a loop",
+					step.ID, workflow.Name)
+			}
+			if step.RawResult || step.Streaming {
+				return fmt.Errorf("step %s in workflow %s cannot combine pagination with a raw or streamed result",
+					step.ID, workflow.Name)
+			}
+		}
+
+		// A parameter both fixed and dynamic would have one value silently override the other
+		if param := collidingParam(step); param != "" {
+			return fmt.Errorf("step %s in workflow %s sets parameter %s in both parameters and dynamic_params",
+				step.ID, workflow.Name, param)
+		}
+
+		if step.MergeInto != "" && step.LoopOver != "" {
+			return fmt.Errorf("step %s in workflow %s cannot combine merging its result with a loop",
+				step.ID, workflow.Name)
+		}
+
 		// Validate parallel execution references
 		for _, parallelID := range step.ParallelWith {
 			if !stepIDs[parallelID] {
@@ -167,171 +359,98 @@ func (we *WorkflowExecutor) RegisterWorkflow(workflow Workflow) error {
 		}
 	}
 
+	if err := validateDependencies(workflow); err != nil {
+		return err
+	}
+
 	we.workflows[workflow.Name] = workflow
 	return nil
 }
 
 // ExecuteWorkflow implements WorkflowService
-func (we *WorkflowExecutor) ExecuteWorkflow(name string, initialParams map[string]interface{}, result interface{}) (map[string]interface{}, error) {
+func (we *WorkflowExecutor) ExecuteWorkflow(name string, initialParams map[string]interface{}, result interface{}, opts ...ExecutionOption) (_ map[string]interface{}, err error) {
+	options := newExecutionOptions(opts)
+	logger := we.getLogger(options.ctx)
+
+	// Variables of the execution, returned as they are when it aborts
+	var variables map[string]interface{}
+
+	// abort reports an execution failure to the error hook before returning it, along with
+	// the variables accumulated so far
+	abort := func(stepID string, err error) (map[string]interface{}, error) {
+		if options.errorHook != nil {
+			options.errorHook(name, stepID, err)
+		}
+		return variables, err
+	}
+
 	we.mu.RLock()
 	workflow, exists := we.workflows[name]
+	// Snapshot the registered functions so registrations don't race with the execution
+	options.funcs = we.executionFuncs()
+	options.conditionTimeout = we.conditionTimeout
+	options.retryable = we.retryable
 	we.mu.RUnlock()
 
 	if !exists {
 		return nil, fmt.Errorf("workflow %s not found", name)
 	}
+	options.retries = newRetryBudget(workflow.MaxTotalRetries)
+	options.maxLoopIterations = workflow.MaxLoopIterations
+	options.workflowName = name
+	options.stats = &we.stats
+
+	we.stats.runs.Add(1)
+	defer func() { we.stats.recordRun(err) }()
 
 	// Create workflow context with variables
-	variables := make(map[string]interface{})
+	variables = make(map[string]interface{})
 
 	// Add default workflow variables
 	for k, v := range workflow.Variables {
 		variables[k] = v
 	}
 
-	// Add initial parameters (override defaults)
-	for k, v := range initialParams {
+	// Add parent variables (override defaults)
+	for k, v := range options.parentVars {
 		variables[k] = v
 	}
 
-	// Track executed steps to manage dependencies
-	executedSteps := make(map[string]bool)
-	stepResults := make(map[string]map[string]interface{})
-
-	// Process steps
-	for i := 0; i < len(workflow.Steps); i++ {
-		step := workflow.Steps[i]
+	// Add initial parameters (override defaults and parent variables)
+	for k, v := range initialParams {
+		variables[k] = v
+	}
 
-		// Skip if this step was already executed in parallel
-		if executedSteps[step.ID] {
-			continue
-		}
+	// Resolve variables defined as expressions of other variables
+	if err := resolveVariableReferences(variables, options.funcs); err != nil {
+		return abort("", fmt.Errorf("workflow %s: %w", name, err))
+	}
 
-		// Check if this step should run in parallel with others
-		parallelSteps := []WorkflowStep{step}
-		for j := i + 1; j < len(workflow.Steps); j++ {
-			nextStep := workflow.Steps[j]
-			for _, parallelID := range nextStep.ParallelWith {
-				if parallelID == step.ID {
-					// This next step should run in parallel
-					parallelSteps = append(parallelSteps, nextStep)
-					// Mark this step as processed so we skip it in the main loop
-					executedSteps[nextStep.ID] = true
-				}
-			}
-		}
+	// Compute the defaults of the variables still absent
+	if err := applyComputedDefaults(workflow.ComputedDefaults, variables, options.funcs); err != nil {
+		return abort("", fmt.Errorf("workflow %s: %w", name, err))
+	}
 
-		// Execute steps (either normally or as loops)
-		for _, parallelStep := range parallelSteps {
-			if parallelStep.LoopOver != "" {
-				// Handle loop step
-				loopResults, err := we.executeLoopStep(parallelStep, variables)
-				if err != nil {
-					// Apply error handling strategy
-					// Default to abort on error if not specified
-					strategy := AbortOnError
-					if parallelStep.ErrorHandling != "" {
-						strategy = parallelStep.ErrorHandling
-					}
-
-					switch strategy {
-					case ContinueOnError:
-						// Just continue to next step
-						continue
-					case RetryOnError:
-						return nil, fmt.Errorf("retry strategy not implemented for loop steps")
-					case AbortOnError:
-						// Default behavior - abort workflow
-						return nil, fmt.Errorf("workflow loop step %s failed: %w", parallelStep.ID, err)
-					}
-				}
+	// Run the steps, following their dependencies when they declare some
+	state := &executionState{
+		variables:     variables,
+		stepResults:   make(map[string]map[string]interface{}),
+		executedSteps: make(map[string]bool),
+	}
+	options.stepResults = state.stepResults
+	run := we.executeStepGroups
+	if usesDependencies(workflow) {
+		run = we.executeStepGraph
+	}
+	if stepID, err := run(workflow, state, options); err != nil {
+		return abort(stepID, err)
+	}
+	stepResults := state.stepResults
+	collectedErrors := state.collectedErrors
 
-				// Process all loop iteration results
-				if len(loopResults) > 0 {
-					// Store the collective results in a variable with the same name as the result mapping
-					// This collects all iteration results into arrays
-					collectedResults := make(map[string][]interface{})
-
-					for _, loopResult := range loopResults {
-						executedSteps[loopResult.StepID] = true
-						stepResults[loopResult.StepID] = loopResult.Result
-
-						// For each result mapping, collect values into arrays
-						for responseField, variableName := range parallelStep.ResultMapping {
-							value, ok := extractValue(loopResult.Result, responseField)
-							if ok {
-								if collectedResults[variableName] == nil {
-									collectedResults[variableName] = make([]interface{}, 0)
-								}
-								collectedResults[variableName] = append(collectedResults[variableName], value)
-							}
-						}
-					}
-
-					// Store the collected arrays in the workflow variables
-					for variableName, collectedValues := range collectedResults {
-						variables[variableName] = collectedValues
-						log.Printf("Collected %d results for loop step %s in variable '%s'",
-							len(collectedValues), parallelStep.ID, variableName)
-					}
-				}
-			} else {
-				// Normal (non-loop) step execution
-				results := we.executeParallelSteps([]WorkflowStep{parallelStep}, variables)
-
-				// Process results
-				for _, stepResult := range results {
-					// Mark step as executed
-					executedSteps[stepResult.StepID] = true
-
-					// Handle errors based on strategy
-					if stepResult.Error != nil {
-						// Default to abort on error if not specified
-						strategy := AbortOnError
-						if parallelStep.ErrorHandling != "" {
-							strategy = parallelStep.ErrorHandling
-						}
-
-						// Handle error based on strategy
-						switch strategy {
-						case ContinueOnError:
-							// Just continue to next step
-							continue
-						case RetryOnError:
-							// Not implemented in this version
-							return nil, fmt.Errorf("retry strategy not implemented")
-						case AbortOnError:
-							// Default behavior - abort workflow
-							return nil, fmt.Errorf("workflow step %s failed: %w", stepResult.StepID, stepResult.Error)
-						}
-					}
-
-					// Store result for this step
-					stepResults[stepResult.StepID] = stepResult.Result
-
-					// Update variables based on result mapping
-					for responseField, variableName := range parallelStep.ResultMapping {
-						// Extract value using dot notation
-						value, ok := extractValue(stepResult.Result, responseField)
-						if ok {
-							variables[variableName] = value
-							log.Printf("Mapped result field '%s' to variable '%s' with value: %v",
-								responseField, variableName, value)
-						} else {
-							log.Printf("Warning: Could not extract field '%s' from response for step %s",
-								responseField, stepResult.StepID)
-
-							// Debug: print the available fields in the result
-							resultKeys := make([]string, 0)
-							for k := range stepResult.Result {
-								resultKeys = append(resultKeys, k)
-							}
-							log.Printf("Available fields in response: %v", resultKeys)
-						}
-					}
-				}
-			}
-		}
+	// Compute the post-process variables, which the aggregator can use
+	if err := runPostProcess(workflow.PostProcess, variables, stepResults, options.funcs); err != nil {
+		return abort("", fmt.Errorf("workflow %s: %w", name, err))
 	}
 
 	// Process result based on aggregator if defined
@@ -343,9 +462,9 @@ func (we *WorkflowExecutor) ExecuteWorkflow(name string, initialParams map[strin
 			// Apply each aggregator mapping
 			for resultField, variableExpr := range workflow.Aggregator {
 				// Check if this is a simple variable reference or an expression
-				value, err := evaluateAggregatorExpression(variableExpr, variables)
+				value, err := evaluateAggregatorExpression(variableExpr, variables, stepResults, options.funcs)
 				if err != nil {
-					log.Printf("Warning: Error evaluating aggregator expression '%s': %v", variableExpr, err)
+					logger.Warnf("Error evaluating aggregator expression '%s': %v", variableExpr, err)
 					continue
 				}
 
@@ -362,7 +481,7 @@ func (we *WorkflowExecutor) ExecuteWorkflow(name string, initialParams map[strin
 				return variables, fmt.Errorf("error unmarshaling aggregated result to provided result variable: %w", err)
 			}
 
-			log.Printf("Applied aggregator to create final result")
+			logger.Debugf("Applied aggregator to create final result")
 		} else {
 			// No aggregator defined - use the last step's result (original behavior)
 			// Find the last step that was executed
@@ -390,126 +509,609 @@ func (we *WorkflowExecutor) ExecuteWorkflow(name string, initialParams map[strin
 					return variables, fmt.Errorf("error unmarshaling last step result to provided result variable: %w", err)
 				}
 
-				log.Printf("Mapped last step (%s) response to result parameter", lastStepID)
+				logger.Debugf("Mapped last step (%s) response to result parameter", lastStepID)
 			}
 		}
 	}
 
+	if len(collectedErrors) > 0 {
+		return variables, collectedErrors
+	}
 	return variables, nil
 }
 
-// executeParallelSteps executes a set of steps in parallel
-func (we *WorkflowExecutor) executeParallelSteps(steps []WorkflowStep, variables map[string]interface{}) []stepExecutionResult {
-	var wg sync.WaitGroup
-	resultChan := make(chan stepExecutionResult, len(steps))
+// collidingParam returns the first parameter, in alphabetical order, set in both the
+// Parameters and the DynamicParams of a step, or an empty string if there is none
+func collidingParam(step WorkflowStep) string {
+	var collisions []string
+	for param := range step.DynamicParams {
+		if _, exists := step.Parameters[param]; exists {
+			collisions = append(collisions, param)
+		}
+	}
+	if len(collisions) == 0 {
+		return ""
+	}
+	sort.Strings(collisions)
+	return collisions[0]
+}
 
-	for _, step := range steps {
-		wg.Add(1)
-		go func(s WorkflowStep) {
-			defer wg.Done()
+// executionState holds the variables and step results of a workflow execution
+type executionState struct {
+	variables       map[string]interface{}
+	stepResults     map[string]map[string]interface{}
+	executedSteps   map[string]bool
+	collectedErrors StepErrors // Failures of steps using CollectErrors, returned at the end of the execution
+}
 
-			result := stepExecutionResult{
-				StepID: s.ID,
-			}
+// executeStepGroups runs the steps in definition order, each step with the later steps
+// declaring it in their ParallelWith. It returns the ID of the step aborting the workflow
+// along with its error.
+func (we *WorkflowExecutor) executeStepGroups(workflow Workflow, state *executionState, options *executionOptions) (string, error) {
+	// Process steps
+	for i := 0; i < len(workflow.Steps); i++ {
+		step := workflow.Steps[i]
 
-			// Check if condition is met
-			if s.Condition != nil {
-				conditionMet, err := evaluateCondition(s.Condition, variables)
-				if err != nil {
-					result.Error = fmt.Errorf("error evaluating condition for step %s: %w", s.ID, err)
-					resultChan <- result
-					return
-				}
+		// Skip if this step was already executed in parallel
+		if state.executedSteps[step.ID] {
+			continue
+		}
 
-				if !conditionMet {
-					// Condition not met, skip this step
-					result.Result = make(map[string]interface{})
-					resultChan <- result
-					return
+		// Stop starting steps once the execution is canceled
+		if err := options.ctx.Err(); err != nil {
+			return step.ID, fmt.Errorf("workflow %s canceled: %w", options.workflowName, err)
+		}
+
+		// Check if this step should run in parallel with others
+		parallelSteps := []WorkflowStep{step}
+		for j := i + 1; j < len(workflow.Steps); j++ {
+			nextStep := workflow.Steps[j]
+			for _, parallelID := range nextStep.ParallelWith {
+				if parallelID == step.ID {
+					// This next step should run in parallel
+					parallelSteps = append(parallelSteps, nextStep)
+					// Mark this step as processed so we skip it in the main loop
+					state.executedSteps[nextStep.ID] = true
 				}
 			}
+		}
+
+		// Skip the whole group when the condition of its lead step gates it and isn't met
+		if step.GroupCondition {
+			met, err := we.evaluateGroupCondition(step, parallelSteps, state, options)
+			if err != nil {
+				return step.ID, err
+			}
+			if !met {
+				continue
+			}
+		}
+
+		// Run the regular (non-loop) steps of the group concurrently
+		var regularSteps []WorkflowStep
+		for _, parallelStep := range parallelSteps {
+			if parallelStep.LoopOver == "" {
+				regularSteps = append(regularSteps, parallelStep)
+			}
+		}
+		groupResults, failed := we.executeParallelSteps(regularSteps, state.variables, workflow.MaxConcurrency, workflow.FailFast, options)
+		if failed >= 0 {
+			// A fail-fast group reports the failure that canceled it, not the canceled siblings
+			failedResult := groupResults[failed]
+			return failedResult.StepID, fmt.Errorf("workflow step %s failed: %w", failedResult.StepID, stepFailure(failedResult.Error, options))
+		}
+		regularResults := make(map[string]stepExecutionResult)
+		for _, stepResult := range groupResults {
+			regularResults[stepResult.StepID] = stepResult
+		}
 
-			// Prepare parameters
-			params := make(map[string]interface{})
-
-			// Process fixed parameters - check for template expressions
-			for k, v := range s.Parameters {
-				// If the parameter value is a string, check if it's a template expression
-				if strValue, isString := v.(string); isString && isExpression(strValue) {
-					evaluatedValue, err := evaluateExpression(strValue, variables)
-					if err != nil {
-						result.Error = fmt.Errorf("error evaluating expression for fixed parameter %s: %w", k, err)
-						resultChan <- result
-						return
-					}
-					params[k] = evaluatedValue
-					log.Printf("Processed template parameter %s: '%s' -> '%v'", k, strValue, evaluatedValue)
-				} else {
-					// Not a template expression, use as-is
-					params[k] = v
+		// Process the group's steps in definition order (loop steps run here)
+		for _, parallelStep := range parallelSteps {
+			if parallelStep.LoopOver != "" {
+				loopResults, err := we.executeLoopStep(parallelStep, state.variables, options)
+				if err := we.applyLoopResults(parallelStep, loopResults, err, state, options); err != nil {
+					return parallelStep.ID, err
 				}
+			} else if err := we.applyStepResult(parallelStep, regularResults[parallelStep.ID], state, options); err != nil {
+				return parallelStep.ID, err
+			}
+		}
+	}
+	return "", nil
+}
+
+// applyLoopResults records the iteration results of a loop step and collects their mapped
+// fields into array variables. It returns an error when the failure of the step aborts the workflow.
+func (we *WorkflowExecutor) applyLoopResults(step WorkflowStep, loopResults []stepExecutionResult, err error, state *executionState, options *executionOptions) error {
+	logger := we.getLogger(options.ctx)
+	if err != nil {
+		// Apply error handling strategy
+		switch errorStrategy(step, options) {
+		case ContinueOnError:
+			// Just continue to next step
+			return nil
+		case CollectErrors:
+			state.collectedErrors = append(state.collectedErrors, StepError{StepID: step.ID, Err: err})
+			return nil
+		case AbortOnError, RetryOnError:
+			// Iterations were already retried, abort workflow
+			return fmt.Errorf("workflow loop step %s failed: %w", step.ID, stepFailure(err, options))
+		}
+	}
+	if len(loopResults) == 0 {
+		return nil
+	}
+
+	// Store the collective results in a variable with the same name as the result mapping
+	// This collects all iteration results into arrays
+	collectedResults := make(map[string][]interface{})
+
+	// When preserving alignment, every mapped array exists even if no iteration has the field
+	if step.PreserveLoopAlignment {
+		for _, variableName := range step.ResultMapping {
+			collectedResults[variableName] = make([]interface{}, 0, len(loopResults))
+		}
+	}
+
+	for _, loopResult := range loopResults {
+		state.executedSteps[loopResult.StepID] = true
+		if loopResult.Error == nil {
+			state.stepResults[loopResult.StepID] = loopResult.Result
+		} else if step.ErrorHandling == CollectErrors {
+			state.collectedErrors = append(state.collectedErrors, StepError{StepID: loopResult.StepID, Err: loopResult.Error})
+		}
+
+		// For each result mapping, collect values into arrays
+		for responseField, variableName := range step.ResultMapping {
+			value, ok := extractResultField(loopResult.Result, responseField)
+			if !ok && !step.PreserveLoopAlignment {
+				continue
+			}
+			if collectedResults[variableName] == nil {
+				collectedResults[variableName] = make([]interface{}, 0)
+			}
+			// A missing field collects nil when preserving alignment
+			collectedResults[variableName] = append(collectedResults[variableName], value)
+		}
+	}
+
+	// Store the collected arrays in the workflow variables
+	for variableName, collectedValues := range collectedResults {
+		state.variables[variableName] = collectedValues
+		logger.Debugf("Collected %d results for loop step %s in variable '%s'",
+			len(collectedValues), step.ID, variableName)
+	}
+	return nil
+}
+
+// applyStepResult records the result of a regular step and maps its fields into the
+// variables. It returns an error when the failure of the step aborts the workflow.
+func (we *WorkflowExecutor) applyStepResult(step WorkflowStep, stepResult stepExecutionResult, state *executionState, options *executionOptions) error {
+	logger := we.getLogger(options.ctx)
+
+	// Mark step as executed
+	state.executedSteps[stepResult.StepID] = true
+
+	// Handle errors based on strategy
+	if stepResult.Error != nil {
+		switch errorStrategy(step, options) {
+		case ContinueOnError:
+			// Just continue to next step
+			return nil
+		case CollectErrors:
+			state.collectedErrors = append(state.collectedErrors, StepError{StepID: stepResult.StepID, Err: stepResult.Error})
+			return nil
+		case AbortOnError, RetryOnError:
+			// The step was already retried, abort workflow
+			return fmt.Errorf("workflow step %s failed: %w", stepResult.StepID, stepFailure(stepResult.Error, options))
+		}
+	}
+
+	// Store result for this step
+	state.stepResults[stepResult.StepID] = stepResult.Result
+
+	// Merge the result, or its mapped fields, into an object variable
+	if step.MergeInto != "" {
+		we.mergeStepResult(step, stepResult.Result, state.variables, options)
+		return nil
+	}
+
+	// Update variables based on result mapping
+	for responseField, variableName := range step.ResultMapping {
+		// Extract value using dot notation
+		value, ok := extractResultField(stepResult.Result, responseField)
+		if ok {
+			state.variables[variableName] = value
+			logger.Debugf("Mapped result field '%s' to variable '%s' with value: %v",
+				responseField, variableName, value)
+		} else {
+			logger.Warnf("Could not extract field '%s' from response for step %s",
+				responseField, stepResult.StepID)
+			logger.Debugf("Available fields in response: %v", getMapKeys(stepResult.Result))
+		}
+	}
+	return nil
+}
+
+// executeParallelSteps executes a set of steps in parallel and returns their results in the
+// order of the given steps. When maxConcurrency is positive and lower than the number of steps,
+// at most maxConcurrency steps run at once and steps are started by descending Priority.
+// This ordering is best-effort: it decides which steps start first, not when they finish.
+//
+// With failFast, the first step failing with AbortOnError, or with RetryOnError once retried,
+// cancels the others and its index is returned; steps not started yet are not run. Otherwise,
+// or if no such step fails, the returned index is -1.
+func (we *WorkflowExecutor) executeParallelSteps(steps []WorkflowStep, variables map[string]interface{}, maxConcurrency int, failFast bool, options *executionOptions) ([]stepExecutionResult, int) {
+	var wg sync.WaitGroup
+	results := make([]stepExecutionResult, len(steps))
+
+	// Steps of a fail-fast group share a context canceled by the first abort
+	failed := -1
+	var failOnce sync.Once
+	stepOptions := options
+	cancel := func() {}
+	if failFast {
+		groupOptions := *options
+		groupOptions.ctx, cancel = context.WithCancel(options.ctx)
+		stepOptions = &groupOptions
+	}
+	defer cancel()
+
+	// Launch order, sorted by priority only when a concurrency limit is in effect
+	order := make([]int, len(steps))
+	for i := range order {
+		order[i] = i
+	}
+
+	var semaphore chan struct{}
+	if maxConcurrency > 0 && maxConcurrency < len(steps) {
+		semaphore = make(chan struct{}, maxConcurrency)
+		sort.SliceStable(order, func(a, b int) bool {
+			return steps[order[a]].Priority > steps[order[b]].Priority
+		})
+	}
+
+	for _, index := range order {
+		// Acquire a slot before launching so steps start in priority order
+		if semaphore != nil {
+			semaphore <- struct{}{}
+		}
+
+		// Don't start steps of a group that already failed
+		if err := stepOptions.ctx.Err(); err != nil {
+			results[index] = stepExecutionResult{StepID: steps[index].ID, Error: err}
+			if semaphore != nil {
+				<-semaphore
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if semaphore != nil {
+				defer func() { <-semaphore }()
 			}
 
-			// Add dynamic parameters
-			for paramName, variableName := range s.DynamicParams {
-				// Check if we need to evaluate an expression
-				if isExpression(variableName) {
-					evaluatedValue, err := evaluateExpression(variableName, variables)
-					if err != nil {
-						result.Error = fmt.Errorf("error evaluating expression for parameter %s: %w", paramName, err)
-						resultChan <- result
-						return
-					}
-					params[paramName] = evaluatedValue
-					log.Printf("Processed dynamic parameter %s using expression '%s' -> '%v'",
-						paramName, variableName, evaluatedValue)
-				} else {
-					// Simple variable reference
-					if value, exists := variables[variableName]; exists {
-						params[paramName] = value
-						log.Printf("Set dynamic parameter %s from variable '%s' -> '%v'",
-							paramName, variableName, value)
-					} else {
-						// If variable doesn't exist, log a warning
-						log.Printf("Warning: Variable %s not found for parameter %s in step %s",
-							variableName, paramName, s.ID)
-					}
+			options.reportStart(steps[i].ID)
+			results[i] = we.executeWithRetries(steps[i], stepOptions, func() stepExecutionResult {
+				if steps[i].Paginate != nil {
+					return we.executePaginatedStep(steps[i], variables, stepOptions)
 				}
+				return we.executeStep(steps[i], variables, stepOptions)
+			})
+			options.reportStep(steps[i], results[i])
+
+			strategy := errorStrategy(steps[i], stepOptions)
+			if failFast && results[i].Error != nil && (strategy == AbortOnError || strategy == RetryOnError) {
+				failOnce.Do(func() {
+					failed = i
+					cancel()
+				})
+			}
+		}(index)
+	}
+
+	// Wait for all steps to complete, canceled steps return promptly
+	wg.Wait()
+
+	return results, failed
+}
+
+// mergeStepResult deep-merges a step result into the step's MergeInto variable. With a result
+// mapping, the mapped fields are merged under their variable names instead of the whole result.
+func (we *WorkflowExecutor) mergeStepResult(s WorkflowStep, result map[string]interface{}, variables map[string]interface{}, options *executionOptions) {
+	logger := we.getLogger(options.ctx)
+
+	patch := result
+	if len(s.ResultMapping) > 0 {
+		patch = make(map[string]interface{}, len(s.ResultMapping))
+		for responseField, key := range s.ResultMapping {
+			value, ok := extractResultField(result, responseField)
+			if !ok {
+				logger.Warnf("Could not extract field '%s' from response for step %s", responseField, s.ID)
+				continue
 			}
+			patch[key] = value
+		}
+	}
+
+	target, ok := variables[s.MergeInto].(map[string]interface{})
+	if !ok && variables[s.MergeInto] != nil {
+		logger.Warnf("Variable '%s' is not an object, step %s replaces it", s.MergeInto, s.ID)
+	}
+	variables[s.MergeInto] = deepMerge(target, patch)
+	logger.Debugf("Merged result of step %s into variable '%s'", s.ID, s.MergeInto)
+}
 
-			// Execute the API request
-			var apiResult map[string]interface{}
-			err := we.service.ExecuteServiceAction(s.ServiceName, s.ActionName, params, &apiResult)
+// extractResultField extracts a mapped field from a step result, WholeResultField and "*"
+// standing for the entire result
+func extractResultField(result map[string]interface{}, field string) (interface{}, bool) {
+	if field == WholeResultField || field == "*" {
+		return result, result != nil
+	}
+	return extractValue(result, field)
+}
+
+// resolveStepName evaluates the expressions of a service or action name against the variables.
+// The result must be a non-empty string.
+func resolveStepName(name string, variables map[string]interface{}, funcs map[string]ExpressionFunc) (string, error) {
+	if !isExpression(name) {
+		return name, nil
+	}
+	value, err := evaluateExpression(name, variables, funcs)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %q: %w", name, err)
+	}
+	resolved, ok := value.(string)
+	if !ok || resolved == "" {
+		return "", fmt.Errorf("%q resolved to %v, expected a non-empty string", name, value)
+	}
+	return resolved, nil
+}
+
+// executeServiceAction performs the request of a step, bound to the execution context and
+// to the timeout of the step
+func (we *WorkflowExecutor) executeServiceAction(options *executionOptions, s WorkflowStep, params map[string]interface{}, result interface{}) ([]RequestInfo, error) {
+	return we.sendStepRequest(options, s, func(ctx context.Context) (RequestInfo, error) {
+		if reporter, ok := we.service.(RequestInfoServiceExecutor); ok {
+			return reporter.ExecuteServiceActionInfo(ctx, s.ServiceName, s.ActionName, params, result)
+		}
+		return RequestInfo{}, we.service.ExecuteServiceActionContext(ctx, s.ServiceName, s.ActionName, params, result)
+	})
+}
+
+// executeStreamingAction performs the request of a streaming step like executeServiceAction,
+// forwarding the streamed response to the stream writer of the execution and storing the
+// buffered response in response
+func (we *WorkflowExecutor) executeStreamingAction(options *executionOptions, s WorkflowStep, streamer StreamingServiceExecutor, params map[string]interface{}, response *string) ([]RequestInfo, error) {
+	return we.sendStepRequest(options, s, func(ctx context.Context) (RequestInfo, error) {
+		request, streamed, err := streamer.ExecuteStreamingServiceAction(ctx, s.ServiceName, s.ActionName, params, options.streamWriter)
+		*response = streamed
+		return request, err
+	})
+}
+
+// sendStepRequest sends the request of a step with send, bound to the execution context and to
+// the timeout of the step. It returns the request sent, if send reports it.
+func (we *WorkflowExecutor) sendStepRequest(options *executionOptions, s WorkflowStep, send func(ctx context.Context) (RequestInfo, error)) ([]RequestInfo, error) {
+	if err := options.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx := options.ctx
+	timeout := options.stepTimeout
+	if s.TimeoutMs > 0 {
+		timeout = time.Duration(s.TimeoutMs) * time.Millisecond
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var requests []RequestInfo
+	request, err := send(ctx)
+	if request != (RequestInfo{}) {
+		requests = []RequestInfo{request}
+	}
+
+	// Tell a step timeout from a cancellation of the execution
+	if err != nil && options.ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("step %s timed out after %v: %w", s.ID, timeout, err)
+	}
+	return requests, err
+}
+
+// executeStep executes a single step: it evaluates its condition, resolves its parameters
+// and performs the API request
+func (we *WorkflowExecutor) executeStep(s WorkflowStep, variables map[string]interface{}, options *executionOptions) stepExecutionResult {
+	logger := we.getLogger(options.ctx)
+	result := stepExecutionResult{
+		StepID: s.ID,
+	}
+
+	// Check if condition is met, the expression taking precedence over the condition struct
+	if s.ConditionExpr != "" || s.Condition != nil {
+		conditionMet, err := evaluateStepCondition(s, variables, options)
+		if err != nil {
+			result.Error = fmt.Errorf("error evaluating condition for step %s: %w", s.ID, err)
+			return result
+		}
+
+		if !conditionMet {
+			// Condition not met, skip this step
+			result.Result = make(map[string]interface{})
+			result.Skipped = true
+			result.SkipReason = skipReason(s)
+			logger.Infof("Skipping step %s: %s", s.ID, result.SkipReason)
+			return result
+		}
+	}
+
+	// Resolve templated service and action names, e.g. "{{channel}}"
+	for _, name := range []*string{&s.ServiceName, &s.ActionName} {
+		resolved, err := resolveStepName(*name, variables, options.funcs)
+		if err != nil {
+			result.Error = fmt.Errorf("error resolving service or action name for step %s: %w", s.ID, err)
+			return result
+		}
+		*name = resolved
+	}
+
+	// Prepare parameters
+	params := make(map[string]interface{})
+
+	// Process fixed parameters - check for template expressions
+	for k, v := range s.Parameters {
+		// If the parameter value is a string, check if it's a template expression
+		if strValue, isString := v.(string); isString && isExpression(strValue) {
+			evaluatedValue, err := evaluateExpression(strValue, variables, options.funcs)
+			if err != nil {
+				result.Error = fmt.Errorf("error evaluating expression for fixed parameter %s: %w", k, err)
+				return result
+			}
+			params[k] = evaluatedValue
+			logger.Debugf("Processed template parameter %s: '%s' -> '%v'", k, strValue, evaluatedValue)
+		} else {
+			// Not a template expression, use as-is
+			params[k] = v
+		}
+	}
+
+	// Add dynamic parameters
+	for paramName, variableName := range s.DynamicParams {
+		// Check if we need to evaluate an expression
+		if strings.HasPrefix(variableName, StepsNamespace+".") {
+			// A field of the result of an earlier step, which must have run
+			value, err := resolveStepReference(variableName, options.stepResults)
+			if err != nil {
+				result.Error = fmt.Errorf("error resolving parameter %s: %w", paramName, err)
+				return result
+			}
+			params[paramName] = value
+			logger.Debugf("Set dynamic parameter %s from step result '%s' -> '%v'",
+				paramName, variableName, value)
+		} else if isExpression(variableName) {
+			evaluatedValue, err := evaluateExpression(variableName, variables, options.funcs)
 			if err != nil {
-				result.Error = err
-				resultChan <- result
-				return
+				result.Error = fmt.Errorf("error evaluating expression for parameter %s: %w", paramName, err)
+				return result
+			}
+			params[paramName] = evaluatedValue
+			logger.Debugf("Processed dynamic parameter %s using expression '%s' -> '%v'",
+				paramName, variableName, evaluatedValue)
+		} else {
+			// Simple variable reference
+			if value, exists := variables[variableName]; exists {
+				params[paramName] = value
+				logger.Debugf("Set dynamic parameter %s from variable '%s' -> '%v'",
+					paramName, variableName, value)
+			} else {
+				// If variable doesn't exist, log a warning
+				logger.Warnf("Variable %s not found for parameter %s in step %s",
+					variableName, paramName, s.ID)
 			}
+		}
+	}
 
-			result.Result = apiResult
-			resultChan <- result
+	// Forward streaming steps to the execution's writer, keeping the buffered response
+	if s.Streaming {
+		streamer, ok := we.service.(StreamingServiceExecutor)
+		if !ok {
+			result.Error = fmt.Errorf("step %s streams its response but the service does not support streaming", s.ID)
+			return result
+		}
+		if options.streamWriter == nil {
+			result.Error = fmt.Errorf("step %s streams its response but no stream writer was provided", s.ID)
+			return result
+		}
+		var response string
+		var err error
+		result.Requests, err = we.executeStreamingAction(options, s, streamer, params, &response)
+		if err != nil {
+			if status, ok := acceptedStatus(s, err); ok {
+				logger.Debugf("Step %s accepted status code %d", s.ID, status)
+				result.Result = map[string]interface{}{StatusResultField: status}
+				return result
+			}
+			result.Error = err
+			return result
+		}
+		result.Result = map[string]interface{}{StreamResultField: response}
+		return result
+	}
 
-		}(step)
+	// Execute the API request, keeping the body undecoded for raw result steps
+	if s.RawResult {
+		var rawResult json.RawMessage
+		var err error
+		result.Requests, err = we.executeServiceAction(options, s, params, &rawResult)
+		if err != nil {
+			if status, ok := acceptedStatus(s, err); ok {
+				logger.Debugf("Step %s accepted status code %d", s.ID, status)
+				result.Result = map[string]interface{}{StatusResultField: status}
+				return result
+			}
+			result.Error = err
+			return result
+		}
+		result.Result = map[string]interface{}{RawResultField: rawResult}
+		return result
 	}
 
-	// Wait for all steps to complete
-	wg.Wait()
-	close(resultChan)
+	var apiResult interface{}
+	var err error
+	result.Requests, err = we.executeServiceAction(options, s, params, &apiResult)
+	if err != nil {
+		if status, ok := acceptedStatus(s, err); ok {
+			logger.Debugf("Step %s accepted status code %d", s.ID, status)
+			result.Result = map[string]interface{}{StatusResultField: status}
+			return result
+		}
+		result.Error = err
+		return result
+	}
 
-	// Collect results
-	var results []stepExecutionResult
-	for result := range resultChan {
-		results = append(results, result)
+	// Wrap top-level arrays so they can be mapped and looped over like any other field
+	switch typedResult := apiResult.(type) {
+	case nil:
+		// Empty response, nothing to map
+	case map[string]interface{}:
+		result.Result = typedResult
+	case []interface{}:
+		result.Result = map[string]interface{}{ArrayResultField: typedResult}
+	default:
+		result.Error = fmt.Errorf("step %s returned an unsupported response of type %T, use RawResult to keep it as-is",
+			s.ID, apiResult)
 	}
+	return result
+}
 
-	return results
+// acceptedStatus reports whether err carries a status code the step accepts as a success
+func acceptedStatus(s WorkflowStep, err error) (int, bool) {
+	var statusErr StatusCodeError
+	if len(s.AcceptStatusCodes) == 0 || !errors.As(err, &statusErr) {
+		return 0, false
+	}
+	status := statusErr.HTTPStatusCode()
+	for _, code := range s.AcceptStatusCodes {
+		if code == status {
+			return status, true
+		}
+	}
+	return 0, false
 }
 
 // executeLoopStep executes a step for each item in an array variable.
 // It returns a result for each iteration.
-func (we *WorkflowExecutor) executeLoopStep(step WorkflowStep, variables map[string]interface{}) ([]stepExecutionResult, error) {
-	// Get the array to iterate over
+func (we *WorkflowExecutor) executeLoopStep(step WorkflowStep, variables map[string]interface{}, options *executionOptions) ([]stepExecutionResult, error) {
+	logger := we.getLogger(options.ctx)
+	// Get the array to iterate over, either a variable or a dot-path into one
 	arrayVar, exists := variables[step.LoopOver]
+	if !exists {
+		arrayVar, exists = extractValue(variables, step.LoopOver)
+	}
 	if !exists {
 		return nil, fmt.Errorf("loop variable '%s' not found in workflow variables", step.LoopOver)
 	}
@@ -521,10 +1123,24 @@ func (we *WorkflowExecutor) executeLoopStep(step WorkflowStep, variables map[str
 	}
 
 	if len(array) == 0 {
-		log.Printf("Loop variable '%s' is an empty array, skipping loop step", step.LoopOver)
+		logger.Infof("Loop variable '%s' is an empty array, skipping loop step", step.LoopOver)
 		return []stepExecutionResult{}, nil
 	}
 
+	// Guard against runaway loops over unexpectedly large arrays
+	maxIterations := step.MaxLoopIterations
+	if maxIterations <= 0 {
+		maxIterations = options.maxLoopIterations
+	}
+	if maxIterations > 0 && len(array) > maxIterations {
+		if !step.TruncateLoop {
+			return nil, fmt.Errorf("loop variable '%s' has %d items, more than the %d allowed: %w",
+				step.LoopOver, len(array), maxIterations, ErrMaxLoopIterations)
+		}
+		logger.Warnf("Loop variable '%s' has %d items, only the first %d are processed", step.LoopOver, len(array), maxIterations)
+		array = array[:maxIterations]
+	}
+
 	// Create a copy of the variables to avoid conflicts between iterations
 	var results []stepExecutionResult
 
@@ -549,25 +1165,35 @@ func (we *WorkflowExecutor) executeLoopStep(step WorkflowStep, variables map[str
 		iterationStep := step // Create a copy of the step
 		iterationStep.ID = iterationStepID
 
-		// Execute the step
-		stepResults := we.executeParallelSteps([]WorkflowStep{iterationStep}, iterationVars)
-		if len(stepResults) == 0 {
-			continue // Step was skipped (e.g., condition not met)
-		}
-
-		// Get the result for this iteration
-		iterationResult := stepResults[0]
+		// Execute the step, retrying the iteration with RetryOnError
+		options.reportStart(iterationStepID)
+		iterationResult := we.executeWithRetries(iterationStep, options, func() stepExecutionResult {
+			return we.executeStep(iterationStep, iterationVars, options)
+		})
+		options.reportStep(step, iterationResult)
 
 		// Check for errors
 		if iterationResult.Error != nil {
-			// If error strategy is to abort, return error immediately
-			if step.ErrorHandling == "" || step.ErrorHandling == AbortOnError {
+			// If error strategy is to abort, or retries didn't help, return error immediately
+			strategy := errorStrategy(step, options)
+			if strategy == AbortOnError || strategy == RetryOnError {
 				return results, fmt.Errorf("loop iteration %d failed: %w", i, iterationResult.Error)
 			}
 
-			// If continue on error, just log and skip this iteration
+			// When collecting errors, keep the failed result so the workflow can report it
+			if step.ErrorHandling == CollectErrors {
+				logger.Warnf("Loop iteration %d failed: %v (collected)", i, iterationResult.Error)
+				results = append(results, iterationResult)
+				continue
+			}
+
+			// If continue on error, just log and skip this iteration,
+			// keeping the failed result as a placeholder when preserving alignment
 			if step.ErrorHandling == ContinueOnError {
-				log.Printf("Warning: Loop iteration %d failed: %v (continuing)", i, iterationResult.Error)
+				logger.Warnf("Loop iteration %d failed: %v (continuing)", i, iterationResult.Error)
+				if step.PreserveLoopAlignment {
+					results = append(results, iterationResult)
+				}
 				continue
 			}
 		}
@@ -579,6 +1205,108 @@ func (we *WorkflowExecutor) executeLoopStep(step WorkflowStep, variables map[str
 	return results, nil
 }
 
+// evaluateGroupCondition evaluates the condition of the lead step of a parallel group once for
+// the whole group. When it isn't met, every step of the group is reported and applied as skipped.
+func (we *WorkflowExecutor) evaluateGroupCondition(lead WorkflowStep, group []WorkflowStep, state *executionState, options *executionOptions) (bool, error) {
+	if lead.ConditionExpr == "" && lead.Condition == nil {
+		return true, nil
+	}
+	met, err := evaluateStepCondition(lead, state.variables, options)
+	if err != nil {
+		return false, fmt.Errorf("error evaluating group condition for step %s: %w", lead.ID, err)
+	}
+	if met {
+		return true, nil
+	}
+
+	reason := skipReason(lead)
+	we.getLogger(options.ctx).Infof("Skipping parallel group of step %s: %s", lead.ID, reason)
+	for _, groupStep := range group {
+		result := stepExecutionResult{
+			StepID:     groupStep.ID,
+			Result:     make(map[string]interface{}),
+			Skipped:    true,
+			SkipReason: reason,
+		}
+		if groupStep.ID != lead.ID {
+			result.SkipReason = fmt.Sprintf("group condition of step %s not met", lead.ID)
+		}
+		options.reportStep(groupStep, result)
+		if err := we.applyStepResult(groupStep, result, state, options); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// evaluateStepCondition evaluates the condition of a step, the expression taking precedence
+// over the condition struct. An expression calling a registered function fails instead of
+// hanging when it takes longer than the condition timeout, or when the execution is canceled.
+// The function itself can't be interrupted and keeps running in the background.
+// Other conditions are evaluated in place: they only compare values and match regular
+// expressions, which run in linear time.
+func evaluateStepCondition(s WorkflowStep, variables map[string]interface{}, options *executionOptions) (bool, error) {
+	if s.ConditionExpr == "" {
+		return evaluateCondition(s.Condition, variables)
+	}
+	if options.conditionTimeout <= 0 || !callsFunction(s.ConditionExpr, options.funcs) {
+		return evaluateConditionExpr(s.ConditionExpr, variables, options.funcs)
+	}
+
+	type outcome struct {
+		met bool
+		err error
+	}
+	// The evaluation can outlive the timeout, so it reads a copy of the variables that the
+	// next steps don't write. Buffered so an evaluation finishing late doesn't block forever.
+	snapshot := maps.Clone(variables)
+	done := make(chan outcome, 1)
+	go func() {
+		met, err := evaluateConditionExpr(s.ConditionExpr, snapshot, options.funcs)
+		done <- outcome{met, err}
+	}()
+
+	timer := time.NewTimer(options.conditionTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result.met, result.err
+	case <-timer.C:
+		return false, fmt.Errorf("condition evaluation timed out after %v", options.conditionTimeout)
+	case <-options.ctx.Done():
+		return false, options.ctx.Err()
+	}
+}
+
+// skipReason describes the condition of a step that wasn't met
+func skipReason(s WorkflowStep) string {
+	if s.ConditionExpr != "" {
+		return fmt.Sprintf("condition %s not met", s.ConditionExpr)
+	}
+	if s.Condition.Type == ConditionExists {
+		return fmt.Sprintf("condition not met: variable %s doesn't exist", s.Condition.SourceVariable)
+	}
+	return fmt.Sprintf("condition not met: %s %s %v", s.Condition.SourceVariable, s.Condition.Type, s.Condition.Value)
+}
+
+// errorStrategy returns how a failure of a step is handled: its ErrorHandling, AbortOnError
+// if unset or once a retry has been denied by the retry budget of the execution
+func errorStrategy(s WorkflowStep, options *executionOptions) ErrorHandlingStrategy {
+	if s.ErrorHandling == "" || options.retries.exhausted() {
+		return AbortOnError
+	}
+	return s.ErrorHandling
+}
+
+// stepFailure wraps the error of a step aborting the workflow with ErrRetryBudgetExhausted
+// when the budget is the reason for the abort
+func stepFailure(err error, options *executionOptions) error {
+	if options.retries.exhausted() {
+		return fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+	}
+	return err
+}
+
 // toArray converts a value to an array if possible
 func toArray(value interface{}) ([]interface{}, bool) {
 	// If it's already a []interface{}
@@ -600,23 +1328,59 @@ func toArray(value interface{}) ([]interface{}, bool) {
 	return nil, false
 }
 
+// valueLength returns the length of an array, string or object
+func valueLength(value interface{}) (int, error) {
+	if array, ok := toArray(value); ok {
+		return len(array), nil
+	}
+	if str, ok := value.(string); ok {
+		return len(str), nil
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		return len(m), nil
+	}
+	return 0, fmt.Errorf("cannot get length of type %T", value)
+}
+
+// resolveStepReference resolves a reference to a step result, steps.<step ID>[.<field path>],
+// optionally followed by .length
+func resolveStepReference(expr string, stepResults map[string]map[string]interface{}) (interface{}, error) {
+	path := strings.TrimPrefix(expr, StepsNamespace+".")
+	lengthOf := strings.HasSuffix(path, ".length")
+	path = strings.TrimSuffix(path, ".length")
+
+	parts := strings.SplitN(path, ".", 2)
+	stepResult, exists := stepResults[parts[0]]
+	if !exists {
+		return nil, fmt.Errorf("no result for step '%s'", parts[0])
+	}
+	var value interface{} = stepResult
+	if len(parts) == 2 {
+		var ok bool
+		if value, ok = extractValue(stepResult, parts[1]); !ok {
+			return nil, fmt.Errorf("could not extract path '%s' from step '%s'", parts[1], parts[0])
+		}
+	}
+	if lengthOf {
+		return valueLength(value)
+	}
+	return value, nil
+}
+
 // evaluateAggregatorExpression evaluates an expression in the aggregator mapping.
-// It supports simple variable references, JSON path expressions, and special operations like .length
-func evaluateAggregatorExpression(expr string, variables map[string]interface{}) (interface{}, error) {
+// It supports simple variable references, JSON path expressions, step results under the
+// StepsNamespace, and special operations like .length
+func evaluateAggregatorExpression(expr string, variables map[string]interface{}, stepResults map[string]map[string]interface{}, funcs map[string]ExpressionFunc) (interface{}, error) {
+	// Reference a step result directly: steps.<step ID>[.<field path>]
+	if strings.HasPrefix(expr, StepsNamespace+".") {
+		return resolveStepReference(expr, stepResults)
+	}
+
 	// Handle special case for array length: variable.length
 	if strings.HasSuffix(expr, ".length") {
 		varName := strings.TrimSuffix(expr, ".length")
 		if value, exists := variables[varName]; exists {
-			if array, ok := toArray(value); ok {
-				return len(array), nil
-			}
-			if str, ok := value.(string); ok {
-				return len(str), nil
-			}
-			if m, ok := value.(map[string]interface{}); ok {
-				return len(m), nil
-			}
-			return 0, fmt.Errorf("cannot get length of type %T", value)
+			return valueLength(value)
 		}
 		return 0, fmt.Errorf("variable '%s' not found for length operation", varName)
 	}
@@ -663,7 +1427,12 @@ func evaluateAggregatorExpression(expr string, variables map[string]interface{})
 
 	// Check if this is a template expression
 	if isExpression(expr) {
-		return evaluateExpression(expr, variables)
+		return evaluateExpression(expr, variables, funcs)
+	}
+
+	// Check if this is a call to a registered function
+	if functionCallPattern.MatchString(expr) {
+		return evaluateFunctionCall(expr, variables, funcs)
 	}
 
 	// If it's a literal value (not a variable reference)
@@ -711,12 +1480,108 @@ func (we *WorkflowExecutor) ListWorkflows() []string {
 	return names
 }
 
-// SaveWorkflows implements WorkflowService
+// SetLogger sets the logger used during workflow executions.
+// A nil logger restores the default, the global logger, so its level applies to workflows.
+func (we *WorkflowExecutor) SetLogger(logger log.Logger) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.logger = logger
+}
+
+// getLogger returns the injected logger, or the current global logger at the log level
+// carried by the context of the execution, if any
+func (we *WorkflowExecutor) getLogger(ctx context.Context) log.Logger {
+	we.mu.RLock()
+	defer we.mu.RUnlock()
+
+	if we.logger != nil {
+		return we.logger
+	}
+	return log.FromContext(ctx)
+}
+
+// RegisterExpressionFunc registers a function callable from step parameters, variables and
+// aggregators, e.g. {{format_id(national_id, 'FR')}}. Arguments are quoted strings, numbers,
+// booleans, variable names or nested calls. Registering a name again replaces the function.
+func (we *WorkflowExecutor) RegisterExpressionFunc(name string, fn ExpressionFunc) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.funcs[name] = fn
+}
+
+// SetClock sets the function the built-in date functions, such as now() and today(), read the
+// current time from, for example a fixed time in tests. A nil function restores time.Now.
+func (we *WorkflowExecutor) SetClock(now func() time.Time) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.clock = now
+}
+
+// builtinFuncs returns the built-in expression functions. It must be called with we.mu held.
+func (we *WorkflowExecutor) builtinFuncs() map[string]ExpressionFunc {
+	now := we.clock
+	if now == nil {
+		now = time.Now
+	}
+	return dateFuncs(now)
+}
+
+// executionFuncs returns a snapshot of the functions available to an execution: the registered
+// functions, taking precedence over the built-in ones. It must be called with we.mu held.
+func (we *WorkflowExecutor) executionFuncs() map[string]ExpressionFunc {
+	funcs := we.builtinFuncs()
+	for name, fn := range we.funcs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// Clear removes every registered workflow, for example before reloading them. Running
+// executions are not affected.
+func (we *WorkflowExecutor) Clear() {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.workflows = make(map[string]Workflow)
+}
+
+// SetConditionTimeout sets how long a step condition calling custom functions may take to evaluate
+// before the step fails, DefaultConditionTimeout by default. A function still running then can't
+// be interrupted and keeps running in the background. A value of 0 removes the limit.
+func (we *WorkflowExecutor) SetConditionTimeout(timeout time.Duration) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.conditionTimeout = timeout
+}
+
+// SetRetryable sets the function deciding which errors of RetryOnError steps are retried, for
+// steps without their own (nil restores DefaultRetryable)
+func (we *WorkflowExecutor) SetRetryable(retryable RetryableFunc) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.retryable = retryable
+}
+
+// SetIndent sets the indentation used when saving workflows to a file
+func (we *WorkflowExecutor) SetIndent(indent string) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+
+	we.indent = indent
+}
+
+// SaveWorkflows implements WorkflowService.
+// Workflows are written in sorted order so saving the same workflows is byte-stable.
 func (we *WorkflowExecutor) SaveWorkflows(filepath string) error {
 	we.mu.RLock()
 	defer we.mu.RUnlock()
 
-	data, err := json.MarshalIndent(we.workflows, "", "  ")
+	data, err := json.MarshalIndent(we.workflows, "", we.indent)
 	if err != nil {
 		return fmt.Errorf("error marshaling workflows: %w", err)
 	}
@@ -729,7 +1594,8 @@ func (we *WorkflowExecutor) SaveWorkflows(filepath string) error {
 	return nil
 }
 
-// LoadWorkflows implements WorkflowService
+// LoadWorkflows implements WorkflowService. The file holds either a map of workflows, as
+// written by SaveWorkflows, or a single workflow object registered by its name.
 func (we *WorkflowExecutor) LoadWorkflows(filepath string) error {
 	data, err := os.ReadFile(filepath)
 	if err != nil {
@@ -739,7 +1605,12 @@ func (we *WorkflowExecutor) LoadWorkflows(filepath string) error {
 	var workflows map[string]Workflow
 	err = json.Unmarshal(data, &workflows)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling workflows: %w", err)
+		// Fall back to a file holding a single workflow
+		var single Workflow
+		if json.Unmarshal(data, &single) != nil || single.Name == "" {
+			return fmt.Errorf("error unmarshaling workflows: %w", err)
+		}
+		workflows = map[string]Workflow{single.Name: single}
 	}
 
 	// Register each workflow (which also validates it)
@@ -752,3 +1623,35 @@ func (we *WorkflowExecutor) LoadWorkflows(filepath string) error {
 
 	return nil
 }
+
+// MarshalWorkflow returns the JSON encoding of a registered workflow, for example to store it in
+// a database. It is the layout of a file holding a single workflow, as read by LoadWorkflows.
+func (we *WorkflowExecutor) MarshalWorkflow(name string) ([]byte, error) {
+	we.mu.RLock()
+	defer we.mu.RUnlock()
+
+	workflow, exists := we.workflows[name]
+	if !exists {
+		return nil, fmt.Errorf("workflow %s not found", name)
+	}
+
+	data, err := json.MarshalIndent(workflow, "", we.indent)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling workflow %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// RegisterWorkflowJSON registers a workflow from its JSON encoding, as returned by
+// MarshalWorkflow. The workflow is validated like the workflows of LoadWorkflows.
+func (we *WorkflowExecutor) RegisterWorkflowJSON(data []byte) error {
+	var workflow Workflow
+	if err := json.Unmarshal(data, &workflow); err != nil {
+		return fmt.Errorf("error unmarshaling workflow: %w", err)
+	}
+
+	if err := we.RegisterWorkflow(workflow); err != nil {
+		return fmt.Errorf("error registering workflow %s: %w", workflow.Name, err)
+	}
+	return nil
+}
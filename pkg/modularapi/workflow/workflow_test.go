@@ -1,8 +1,20 @@
 package workflow_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
 )
@@ -27,6 +39,15 @@ func (m *MockAPIService) AddMockResponse(serviceName, actionName string, respons
 
 // ExecuteServiceAction implements the APIServiceExecutor interface
 func (m *MockAPIService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceActionContext(context.Background(), serviceName, actionName, params, result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *MockAPIService) ExecuteServiceActionContext(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	key := serviceName + "." + actionName
 	response, ok := m.responses[key]
 	if !ok {
@@ -305,6 +326,151 @@ func TestParallelExecution(t *testing.T) {
 	}
 }
 
+// orderRecordingService records the order in which actions are called
+type orderRecordingService struct {
+	mu    sync.Mutex
+	order []string
+}
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (m *orderRecordingService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	m.mu.Lock()
+	m.order = append(m.order, actionName)
+	m.mu.Unlock()
+
+	return json.Unmarshal([]byte(`{"result": "`+actionName+`"}`), result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *orderRecordingService) ExecuteServiceActionContext(_ context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+func TestParallelPriorityWithConcurrencyLimit(t *testing.T) {
+	mockService := &orderRecordingService{}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:           "priority_workflow",
+		MaxConcurrency: 1,
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:            "low",
+				ServiceName:   "service",
+				ActionName:    "low",
+				ResultMapping: map[string]string{"result": "low_result"},
+			},
+			{
+				ID:            "high",
+				ServiceName:   "service",
+				ActionName:    "high",
+				ParallelWith:  []string{"low"},
+				Priority:      10,
+				ResultMapping: map[string]string{"result": "high_result"},
+			},
+			{
+				ID:            "medium",
+				ServiceName:   "service",
+				ActionName:    "medium",
+				ParallelWith:  []string{"low"},
+				Priority:      5,
+				ResultMapping: map[string]string{"result": "medium_result"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("priority_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	if got := strings.Join(mockService.order, ","); got != "high,medium,low" {
+		t.Errorf("Expected steps to start by priority, got %s", got)
+	}
+	for _, name := range []string{"low", "medium", "high"} {
+		if vars[name+"_result"] != name {
+			t.Errorf("Expected %s_result to be %q, got %v", name, name, vars[name+"_result"])
+		}
+	}
+
+	// A negative limit is rejected
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name:           "invalid_workflow",
+		MaxConcurrency: -1,
+		Steps: []workflow.WorkflowStep{
+			{ID: "step", ServiceName: "service", ActionName: "action"},
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a negative max concurrency")
+	}
+}
+
+func TestGroupCondition(t *testing.T) {
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "dashboard",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:             "posts",
+				ServiceName:    "api",
+				ActionName:     "posts",
+				ConditionExpr:  "{{include_social}}",
+				GroupCondition: true,
+				ResultMapping:  map[string]string{"_params": "posts"},
+			},
+			{ID: "followers", ServiceName: "api", ActionName: "followers", ParallelWith: []string{"posts"}, ResultMapping: map[string]string{"_params": "followers"}},
+			{ID: "likes", ServiceName: "api", ActionName: "likes", ParallelWith: []string{"posts"}, LoopOver: "ids", LoopAs: "id", ResultMapping: map[string]string{"_params": "likes"}},
+			{ID: "profile", ServiceName: "api", ActionName: "profile", ResultMapping: map[string]string{"_params": "profile"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var skipped []string
+	vars, err := executor.ExecuteWorkflow("dashboard", map[string]interface{}{"include_social": false, "ids": []interface{}{1, 2}}, nil,
+		workflow.WithStepHook(func(_ string, result workflow.StepResult) {
+			if result.Skipped {
+				skipped = append(skipped, result.StepID+": "+result.SkipReason)
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Workflow execution failed: %v", err)
+	}
+
+	// The whole group is skipped, the following step still runs
+	expected := "posts: condition {{include_social}} not met," +
+		"followers: group condition of step posts not met," +
+		"likes: group condition of step posts not met"
+	if got := strings.Join(skipped, ","); got != expected {
+		t.Errorf("Expected the whole group to be skipped, got %v", skipped)
+	}
+	for _, name := range []string{"posts", "followers", "likes"} {
+		if _, ok := vars[name]; ok {
+			t.Errorf("Expected skipped step to leave %s unset", name)
+		}
+	}
+	if _, ok := vars["profile"]; !ok {
+		t.Errorf("Expected the step after the group to run")
+	}
+
+	// A met condition runs the whole group
+	vars, err = executor.ExecuteWorkflow("dashboard", map[string]interface{}{"include_social": true, "ids": []interface{}{1, 2}}, nil)
+	if err != nil {
+		t.Fatalf("Workflow execution failed: %v", err)
+	}
+	for _, name := range []string{"posts", "followers", "likes"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("Expected the group to run and set %s", name)
+		}
+	}
+}
+
 func TestDynamicParameterSubstitution(t *testing.T) {
 	// Create mock API service
 	mockService := NewMockAPIService()
@@ -376,3 +542,2110 @@ func TestDynamicParameterSubstitution(t *testing.T) {
 	// We've already verified that patient_name and patient_status were correctly extracted,
 	// which means the API call must have been made with the correct ID parameter
 }
+
+func TestWorkflowVariableReferences(t *testing.T) {
+	mockService := NewMockAPIService()
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "greeting_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "greet",
+				ServiceName: "messages",
+				ActionName:  "send",
+				DynamicParams: map[string]string{
+					"text": "message",
+				},
+			},
+		},
+		Variables: map[string]interface{}{
+			"message":  "{{greeting}}, welcome to {{place}}",
+			"greeting": "Hello {{name}}",
+			"place":    "Paris",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("greeting_workflow", map[string]interface{}{
+		"name": "Jane",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["greeting"] != "Hello Jane" {
+		t.Errorf("Expected greeting = 'Hello Jane', got %v", vars["greeting"])
+	}
+	if vars["message"] != "Hello Jane, welcome to Paris" {
+		t.Errorf("Expected message resolved in dependency order, got %v", vars["message"])
+	}
+
+	// Cyclic references are reported
+	_, err = executor.ExecuteWorkflow("greeting_workflow", map[string]interface{}{
+		"name":  "{{place}}",
+		"place": "{{greeting}}",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cyclic variable reference") {
+		t.Errorf("Expected a cyclic variable reference error, got %v", err)
+	}
+}
+
+func TestComputedDefaults(t *testing.T) {
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+	executor.RegisterExpressionFunc("region_of", func(args ...interface{}) (interface{}, error) {
+		if args[0] == "FR" {
+			return "eu", nil
+		}
+		return "us", nil
+	})
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:  "regional",
+		Steps: []workflow.WorkflowStep{{ID: "list", ServiceName: "orders", ActionName: "list"}},
+		ComputedDefaults: map[string]string{
+			"host":   "{{region}}.api.test",
+			"region": "{{region_of(country)}}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("regional", map[string]interface{}{"country": "FR"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["region"] != "eu" || vars["host"] != "eu.api.test" {
+		t.Errorf("Expected the defaults to be computed in dependency order, got region %v and host %v", vars["region"], vars["host"])
+	}
+
+	// Provided variables are not recomputed, and defaults depending on them use them
+	vars, err = executor.ExecuteWorkflow("regional", map[string]interface{}{"country": "FR", "region": "ap"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["region"] != "ap" || vars["host"] != "ap.api.test" {
+		t.Errorf("Expected the provided region to be kept, got region %v and host %v", vars["region"], vars["host"])
+	}
+}
+
+func TestDateFunctions(t *testing.T) {
+	mockService := NewMockAPIService()
+	executor := workflow.NewWorkflowExecutor(mockService)
+	executor.SetClock(func() time.Time {
+		return time.Date(2024, time.March, 10, 15, 4, 5, 0, time.UTC)
+	})
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "report",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "fetch",
+				ServiceName: "reports",
+				ActionName:  "list",
+				Parameters: map[string]interface{}{
+					"from": "{{formatDate(start_date, '2006-01-02')}}",
+					"at":   "{{now()}}",
+				},
+				ResultMapping: map[string]string{"_params.from": "from", "_params.at": "at"},
+			},
+		},
+		ComputedDefaults: map[string]string{
+			"end_date":   "{{today()}}",
+			"start_date": "{{dateAdd(end_date, '-7d')}}",
+		},
+		Aggregator: map[string]string{"until": "dateAdd(end_date, '36h')"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var result map[string]interface{}
+	vars, err := executor.ExecuteWorkflow("report", nil, &result)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["end_date"] != "2024-03-10T00:00:00Z" || vars["start_date"] != "2024-03-03T00:00:00Z" {
+		t.Errorf("Expected a week ending today, got %v to %v", vars["start_date"], vars["end_date"])
+	}
+	if vars["from"] != "2024-03-03" || vars["at"] != "2024-03-10T15:04:05Z" {
+		t.Errorf("Expected formatted dates in the parameters, got from %v and at %v", vars["from"], vars["at"])
+	}
+	if result["until"] != "2024-03-11T12:00:00Z" {
+		t.Errorf("Expected the aggregator to add 36h, got %v", result["until"])
+	}
+
+	// Registered functions take precedence over the built-in ones
+	executor.RegisterExpressionFunc("today", func(args ...interface{}) (interface{}, error) {
+		return "2000-01-01", nil
+	})
+	vars, err = executor.ExecuteWorkflow("report", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if vars["start_date"] != "1999-12-25T00:00:00Z" {
+		t.Errorf("Expected the registered today to be used, got %v", vars["start_date"])
+	}
+}
+
+// failingMockService fails every call to the configured action
+type failingMockService struct {
+	*MockAPIService
+	failAction string
+}
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (m *failingMockService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	if actionName == m.failAction {
+		return fmt.Errorf("%s.%s failed", serviceName, actionName)
+	}
+	return m.MockAPIService.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *failingMockService) ExecuteServiceActionContext(_ context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+func TestExecuteSingleStep(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{
+		"user": map[string]interface{}{"name": "Ada"},
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	step := workflow.WorkflowStep{
+		ID:            "get_user",
+		ServiceName:   "users",
+		ActionName:    "get",
+		DynamicParams: map[string]string{"id": "user_id"},
+		ConditionExpr: "{{user_id != ''}}",
+		ResultMapping: map[string]string{"user.name": "name", "_params.id": "requested_id"},
+	}
+	variables := map[string]interface{}{"user_id": "42"}
+
+	result, vars, err := executor.ExecuteSingleStep(step, variables)
+	if err != nil {
+		t.Fatalf("Failed to execute step: %v", err)
+	}
+	if _, ok := result["user"]; !ok {
+		t.Errorf("Expected the step result, got %v", result)
+	}
+	if vars["name"] != "Ada" || vars["requested_id"] != "42" {
+		t.Errorf("Expected the mapped variables, got %v", vars)
+	}
+	if _, ok := variables["name"]; ok {
+		t.Errorf("Expected the variables passed not to be modified")
+	}
+
+	// A step whose condition isn't met has an empty result and maps nothing
+	result, vars, err = executor.ExecuteSingleStep(step, map[string]interface{}{"user_id": ""})
+	if err != nil {
+		t.Fatalf("Failed to execute step: %v", err)
+	}
+	if len(result) != 0 || vars["name"] != nil {
+		t.Errorf("Expected the step to be skipped, got result %v and variables %v", result, vars)
+	}
+
+	// Errors are returned whatever the error handling of the step
+	failing := workflow.NewWorkflowExecutor(&failingMockService{MockAPIService: mockService, failAction: "get"})
+	step.ErrorHandling = workflow.ContinueOnError
+	if _, _, err := failing.ExecuteSingleStep(step, variables); err == nil {
+		t.Errorf("Expected the step error to be returned")
+	}
+}
+
+func TestWorkflowErrorHook(t *testing.T) {
+	mockService := &failingMockService{MockAPIService: NewMockAPIService(), failAction: "broken"}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "failing_workflow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "ok", ServiceName: "svc", ActionName: "fine"},
+			{ID: "fails", ServiceName: "svc", ActionName: "broken"},
+			{ID: "never", ServiceName: "svc", ActionName: "fine"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var hookWorkflow, hookStep string
+	var hookErr error
+	calls := 0
+	_, err = executor.ExecuteWorkflow("failing_workflow", nil, nil,
+		workflow.WithErrorHook(func(workflowName, failedStepID string, err error) {
+			calls++
+			hookWorkflow, hookStep, hookErr = workflowName, failedStepID, err
+		}))
+
+	if err == nil {
+		t.Fatalf("Expected the workflow to fail")
+	}
+	if calls != 1 || hookWorkflow != "failing_workflow" || hookStep != "fails" || hookErr != err {
+		t.Errorf("Unexpected hook call: calls=%d workflow=%s step=%s err=%v", calls, hookWorkflow, hookStep, hookErr)
+	}
+}
+
+// rawMockService serves fixed raw JSON bodies per action and records the received params
+type rawMockService struct {
+	bodies map[string]string
+	params map[string]map[string]interface{}
+}
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (m *rawMockService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	if m.params == nil {
+		m.params = make(map[string]map[string]interface{})
+	}
+	m.params[actionName] = params
+
+	body, ok := m.bodies[actionName]
+	if !ok {
+		body = "{}"
+	}
+	return json.Unmarshal([]byte(body), result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *rawMockService) ExecuteServiceActionContext(_ context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceAction(serviceName, actionName, params, result)
+}
+
+func TestRawResultStep(t *testing.T) {
+	mockService := &rawMockService{
+		bodies: map[string]string{
+			"export": `[{"id": 1}, {"id": 2}]`,
+		},
+	}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "raw_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "export",
+				ServiceName: "reports",
+				ActionName:  "export",
+				RawResult:   true,
+				ResultMapping: map[string]string{
+					workflow.RawResultField: "report",
+				},
+			},
+			{
+				ID:          "upload",
+				ServiceName: "storage",
+				ActionName:  "upload",
+				DynamicParams: map[string]string{
+					"body": "report",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("raw_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	report, ok := vars["report"].(json.RawMessage)
+	if !ok || string(report) != `[{"id": 1}, {"id": 2}]` {
+		t.Errorf("Expected the raw array body, got %v", vars["report"])
+	}
+
+	forwarded, ok := mockService.params["upload"]["body"].(json.RawMessage)
+	if !ok || string(forwarded) != string(report) {
+		t.Errorf("Expected the raw body to be forwarded verbatim, got %v", mockService.params["upload"]["body"])
+	}
+}
+
+func TestTopLevelArrayResponse(t *testing.T) {
+	mockService := &rawMockService{
+		bodies: map[string]string{
+			"list":   `[{"id": "a"}, {"id": "b"}]`,
+			"detail": `{"status": "ok"}`,
+		},
+	}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "array_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "list",
+				ServiceName: "users",
+				ActionName:  "list",
+				ResultMapping: map[string]string{
+					workflow.ArrayResultField: "users",
+				},
+			},
+			{
+				ID:          "details",
+				ServiceName: "users",
+				ActionName:  "detail",
+				DynamicParams: map[string]string{
+					"user": "user",
+				},
+				ResultMapping: map[string]string{
+					"status": "statuses",
+				},
+				LoopOver: "users",
+				LoopAs:   "user",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("array_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	if users, ok := vars["users"].([]interface{}); !ok || len(users) != 2 {
+		t.Fatalf("Expected the array response to be mapped, got %v", vars["users"])
+	}
+	if statuses, ok := vars["statuses"].([]interface{}); !ok || len(statuses) != 2 {
+		t.Errorf("Expected the loop to run for each array item, got %v", vars["statuses"])
+	}
+	if user, _ := mockService.params["detail"]["user"].(map[string]interface{}); user["id"] != "b" {
+		t.Errorf("Expected the last iteration to use user b, got %v", mockService.params["detail"]["user"])
+	}
+}
+
+func TestWorkflowMerge(t *testing.T) {
+	base := workflow.Workflow{
+		Name: "base",
+		Steps: []workflow.WorkflowStep{
+			{ID: "fetch", ServiceName: "api", ActionName: "fetch"},
+		},
+		Variables:  map[string]interface{}{"region": "eu", "limit": 10},
+		Aggregator: map[string]string{"data": "fetched"},
+	}
+	overlay := workflow.Workflow{
+		Steps: []workflow.WorkflowStep{
+			{ID: "store", ServiceName: "api", ActionName: "store"},
+		},
+		Variables:  map[string]interface{}{"region": "us"},
+		Aggregator: map[string]string{"stored": "store_result"},
+	}
+
+	merged, err := base.Merge(overlay)
+	if err != nil {
+		t.Fatalf("Failed to merge workflows: %v", err)
+	}
+
+	if merged.Name != "base" {
+		t.Errorf("Expected the base name to be kept, got %s", merged.Name)
+	}
+	if len(merged.Steps) != 2 || merged.Steps[0].ID != "fetch" || merged.Steps[1].ID != "store" {
+		t.Errorf("Expected steps fetch then store, got %+v", merged.Steps)
+	}
+	if merged.Variables["region"] != "us" || merged.Variables["limit"] != 10 {
+		t.Errorf("Expected overlay variables to win, got %v", merged.Variables)
+	}
+	if len(merged.Aggregator) != 2 {
+		t.Errorf("Expected the aggregators to be combined, got %v", merged.Aggregator)
+	}
+	if base.Variables["region"] != "eu" || len(base.Steps) != 1 {
+		t.Errorf("Expected the base workflow to be unchanged")
+	}
+
+	// Duplicate step IDs are rejected
+	if _, err := base.Merge(base); err == nil {
+		t.Errorf("Expected an error for duplicate step IDs")
+	}
+}
+
+func TestOutputVariables(t *testing.T) {
+	wf := workflow.Workflow{
+		Name: "outputs",
+		Steps: []workflow.WorkflowStep{
+			{ID: "list", ServiceName: "api", ActionName: "list", ResultMapping: map[string]string{"_array": "users"}},
+			{ID: "detail", ServiceName: "api", ActionName: "detail", LoopOver: "users", LoopAs: "user",
+				ResultMapping: map[string]string{"email": "emails", "id": "users"}},
+			{ID: "prefs", ServiceName: "api", ActionName: "prefs", MergeInto: "profile"},
+		},
+		PostProcess: map[string]string{"email_count": "len(emails)"},
+		Aggregator:  map[string]string{"result": "emails"},
+	}
+
+	expected := []string{"email_count", "emails", "profile", "result", "users"}
+	if outputs := wf.OutputVariables(); strings.Join(outputs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected outputs %v, got %v", expected, outputs)
+	}
+}
+
+func TestWorkflowHash(t *testing.T) {
+	build := func(keys []string) workflow.Workflow {
+		params := make(map[string]interface{})
+		variables := make(map[string]interface{})
+		for i, key := range keys {
+			params[key] = i
+			variables[key] = map[string]interface{}{key: key}
+		}
+		return workflow.Workflow{
+			Name:      "hashed",
+			Steps:     []workflow.WorkflowStep{{ID: "fetch", ServiceName: "api", ActionName: "fetch", Parameters: params}},
+			Variables: variables,
+		}
+	}
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	reversed := make([]string, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	first, second := build(keys), build(reversed)
+	// Same keys with the same values, inserted in another order
+	for i, key := range keys {
+		second.Steps[0].Parameters[key] = i
+	}
+
+	hash := first.Hash()
+	if len(hash) != 64 {
+		t.Fatalf("Expected a SHA-256 hex digest, got %q", hash)
+	}
+	if second.Hash() != hash {
+		t.Errorf("Expected equal workflows to have the same hash")
+	}
+
+	second.Steps[0].ActionName = "store"
+	if second.Hash() == hash {
+		t.Errorf("Expected a changed workflow to have another hash")
+	}
+}
+
+func TestCustomExpressionFunctions(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("ids", "check", map[string]interface{}{"valid": true})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	executor.RegisterExpressionFunc("format_id", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected 2 arguments, got %d", len(args))
+		}
+		return fmt.Sprintf("%v-%v", args[1], args[0]), nil
+	})
+	executor.RegisterExpressionFunc("upper", func(args ...interface{}) (interface{}, error) {
+		return strings.ToUpper(fmt.Sprint(args...)), nil
+	})
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "functions_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "check",
+				ServiceName: "ids",
+				ActionName:  "check",
+				Parameters: map[string]interface{}{
+					"id":    "{{format_id(national_id, 'fr')}}",
+					"label": "ID {{upper(format_id(national_id, 'fr, be'))}}",
+				},
+				ResultMapping: map[string]string{
+					"_params": "params",
+				},
+			},
+		},
+		Aggregator: map[string]string{
+			"upper_id": "upper(national_id)",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var result map[string]interface{}
+	vars, err := executor.ExecuteWorkflow("functions_workflow", map[string]interface{}{"national_id": "ab12"}, &result)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	params, _ := vars["params"].(map[string]interface{})
+	if params["id"] != "fr-ab12" {
+		t.Errorf("Expected id fr-ab12, got %v", params["id"])
+	}
+	if params["label"] != "ID FR, BE-AB12" {
+		t.Errorf("Expected a nested call embedded in a string, got %v", params["label"])
+	}
+	if result["upper_id"] != "AB12" {
+		t.Errorf("Expected the aggregator to call the function, got %v", result["upper_id"])
+	}
+
+	// Unknown functions and function errors fail the step
+	wf, _ := executor.GetWorkflow("functions_workflow")
+	wf.Steps[0].Parameters = map[string]interface{}{"id": "{{missing(national_id)}}"}
+	executor.RegisterWorkflow(wf)
+	if _, err := executor.ExecuteWorkflow("functions_workflow", map[string]interface{}{"national_id": "ab12"}, nil); err == nil ||
+		!strings.Contains(err.Error(), "unknown function missing") {
+		t.Errorf("Expected an unknown function error, got %v", err)
+	}
+	wf.Steps[0].Parameters = map[string]interface{}{"id": "{{format_id(national_id)}}"}
+	executor.RegisterWorkflow(wf)
+	if _, err := executor.ExecuteWorkflow("functions_workflow", map[string]interface{}{"national_id": "ab12"}, nil); err == nil ||
+		!strings.Contains(err.Error(), "expected 2 arguments") {
+		t.Errorf("Expected the function error, got %v", err)
+	}
+}
+
+// recordingLogger records formatted messages per level
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (l *recordingLogger) record(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.messages == nil {
+		l.messages = make(map[string][]string)
+	}
+	l.messages[level] = append(l.messages[level], fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debug(args ...interface{}) { l.record("debug", "%s", fmt.Sprint(args...)) }
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+func (l *recordingLogger) Info(args ...interface{}) { l.record("info", "%s", fmt.Sprint(args...)) }
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.record("info", format, args...)
+}
+func (l *recordingLogger) Warn(args ...interface{}) { l.record("warn", "%s", fmt.Sprint(args...)) }
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.record("warn", format, args...)
+}
+func (l *recordingLogger) Error(args ...interface{}) { l.record("error", "%s", fmt.Sprint(args...)) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+func (l *recordingLogger) Fatal(args ...interface{}) { l.record("fatal", "%s", fmt.Sprint(args...)) }
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {
+	l.record("fatal", format, args...)
+}
+
+func TestWorkflowInjectedLogger(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{"name": "Jane"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	logger := &recordingLogger{}
+	executor.SetLogger(logger)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "logged_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "get_user",
+				ServiceName: "users",
+				ActionName:  "get",
+				ResultMapping: map[string]string{
+					"name":  "user_name",
+					"email": "user_email",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	if _, err := executor.ExecuteWorkflow("logged_workflow", nil, nil); err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	debug := strings.Join(logger.messages["debug"], "\n")
+	if !strings.Contains(debug, "Mapped result field 'name' to variable 'user_name'") {
+		t.Errorf("Expected mapping details at debug level, got:\n%s", debug)
+	}
+	warn := strings.Join(logger.messages["warn"], "\n")
+	if !strings.Contains(warn, "Could not extract field 'email'") {
+		t.Errorf("Expected the missing field at warn level, got:\n%s", warn)
+	}
+}
+
+// ctxMockService fails the "fail" action and blocks the others until their context is done
+type ctxMockService struct {
+	completed int32
+}
+
+// ExecuteServiceAction implements the APIServiceExecutor interface
+func (m *ctxMockService) ExecuteServiceAction(serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	return m.ExecuteServiceActionContext(context.Background(), serviceName, actionName, params, result)
+}
+
+// ExecuteServiceActionContext implements the APIServiceExecutor interface
+func (m *ctxMockService) ExecuteServiceActionContext(ctx context.Context, serviceName, actionName string, params map[string]interface{}, result interface{}) error {
+	if actionName == "fail" {
+		return fmt.Errorf("upstream unavailable")
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(500 * time.Millisecond):
+		atomic.AddInt32(&m.completed, 1)
+		return json.Unmarshal([]byte(`{}`), result)
+	}
+}
+
+func TestParallelFailFast(t *testing.T) {
+	newWorkflow := func(failFast bool) workflow.Workflow {
+		return workflow.Workflow{
+			Name:     "dashboard",
+			FailFast: failFast,
+			Steps: []workflow.WorkflowStep{
+				{ID: "slow_posts", ServiceName: "api", ActionName: "posts"},
+				{ID: "broken", ServiceName: "api", ActionName: "fail", ParallelWith: []string{"slow_posts"}},
+				{ID: "slow_followers", ServiceName: "api", ActionName: "followers", ParallelWith: []string{"slow_posts"}},
+			},
+		}
+	}
+
+	mockService := &ctxMockService{}
+	executor := workflow.NewWorkflowExecutor(mockService)
+	if err := executor.RegisterWorkflow(newWorkflow(true)); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	start := time.Now()
+	_, err := executor.ExecuteWorkflow("dashboard", nil, nil)
+	elapsed := time.Since(start)
+
+	if err == nil || !strings.Contains(err.Error(), "broken") || !strings.Contains(err.Error(), "upstream unavailable") {
+		t.Errorf("Expected the failure of the broken step, got: %v", err)
+	}
+	if elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected the workflow to return before the slow steps complete, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&mockService.completed); n != 0 {
+		t.Errorf("Expected the slow steps to be canceled, %d completed", n)
+	}
+
+	// Without fail-fast, the group runs to completion
+	mockService = &ctxMockService{}
+	executor = workflow.NewWorkflowExecutor(mockService)
+	if err := executor.RegisterWorkflow(newWorkflow(false)); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	start = time.Now()
+	if _, err := executor.ExecuteWorkflow("dashboard", nil, nil); err == nil {
+		t.Errorf("Expected the workflow to fail")
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Expected the slow steps to complete, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&mockService.completed); n != 2 {
+		t.Errorf("Expected the 2 slow steps to complete, got %d", n)
+	}
+}
+
+func TestStepDelay(t *testing.T) {
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "polling",
+		Steps: []workflow.WorkflowStep{
+			{ID: "submit", ServiceName: "jobs", ActionName: "submit", DelayAfterMs: 50},
+			{ID: "skipped", ServiceName: "jobs", ActionName: "noop", ConditionExpr: "{{false}}", DelayAfterMs: 1000},
+			{ID: "status", ServiceName: "jobs", ActionName: "status", DelayBeforeMs: 50},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	started := time.Now()
+	if _, err := executor.ExecuteWorkflow("polling", nil, nil); err != nil {
+		t.Fatalf("Workflow execution failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("Expected the delays of the steps that ran to be waited, took %v", elapsed)
+	}
+
+	// Canceling the execution interrupts a delay
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name:  "cooling_off",
+		Steps: []workflow.WorkflowStep{{ID: "wait", ServiceName: "jobs", ActionName: "status", DelayBeforeMs: 5000}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	started = time.Now()
+	_, err = executor.ExecuteWorkflow("cooling_off", nil, nil, workflow.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the execution to be canceled during the delay, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the delay to be interrupted, took %v", elapsed)
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	mockService := &ctxMockService{}
+	executor := workflow.NewWorkflowExecutor(mockService)
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "timed",
+		Steps: []workflow.WorkflowStep{
+			{ID: "patient", ServiceName: "api", ActionName: "posts", TimeoutMs: 2000, ErrorHandling: workflow.ContinueOnError},
+			{ID: "hasty", ServiceName: "api", ActionName: "followers", ErrorHandling: workflow.ContinueOnError},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var failures []string
+	_, err = executor.ExecuteWorkflow("timed", nil, nil,
+		workflow.WithStepTimeout(50*time.Millisecond),
+		workflow.WithStepHook(func(_ string, result workflow.StepResult) {
+			if result.Error != nil {
+				failures = append(failures, result.StepID+": "+result.Error.Error())
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Workflow execution failed: %v", err)
+	}
+
+	// The step timeout overrides the execution default
+	if len(failures) != 1 || !strings.Contains(failures[0], "hasty") || !strings.Contains(failures[0], "timed out after 50ms") {
+		t.Errorf("Expected only the step without a timeout to time out, got %v", failures)
+	}
+	if n := atomic.LoadInt32(&mockService.completed); n != 1 {
+		t.Errorf("Expected the step with its own timeout to complete, %d completed", n)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+	mockService := funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		switch {
+		case actionName == "profile":
+			return nil, errUnavailable
+		case actionName == "order" && params["id"] == "o2":
+			return nil, fmt.Errorf("order o2 not found")
+		}
+		return map[string]interface{}{"ok": true, "id": params["id"]}, nil
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "sync",
+		Variables: map[string]interface{}{
+			"orders": []interface{}{"o1", "o2", "o3"},
+		},
+		Steps: []workflow.WorkflowStep{
+			{ID: "profile", ServiceName: "api", ActionName: "profile", ErrorHandling: workflow.CollectErrors},
+			{
+				ID: "orders", ServiceName: "api", ActionName: "order",
+				DynamicParams: map[string]string{"id": "order_id"},
+				LoopOver:      "orders", LoopAs: "order_id",
+				ResultMapping: map[string]string{"id": "synced_orders"},
+				ErrorHandling: workflow.CollectErrors,
+			},
+			{ID: "settings", ServiceName: "api", ActionName: "settings", ResultMapping: map[string]string{"ok": "settings_ok"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	variables, err := executor.ExecuteWorkflow("sync", nil, nil)
+
+	var stepErrs workflow.StepErrors
+	if !errors.As(err, &stepErrs) {
+		t.Fatalf("Expected StepErrors, got: %v", err)
+	}
+	if len(stepErrs) != 2 || stepErrs[0].StepID != "profile" || stepErrs[1].StepID != "orders[1]" {
+		t.Errorf("Expected the failures of profile and orders[1], got %v", stepErrs)
+	}
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("Expected the collected errors to wrap the step errors")
+	}
+
+	// Every step still ran
+	if variables["settings_ok"] != true {
+		t.Errorf("Expected the steps after the failures to run, got %v", variables)
+	}
+	if synced, _ := variables["synced_orders"].([]interface{}); len(synced) != 2 {
+		t.Errorf("Expected the successful loop iterations to be collected, got %v", variables["synced_orders"])
+	}
+}
+
+func TestConditionExpr(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("mail", "send", map[string]interface{}{"sent": true})
+	executor := workflow.NewWorkflowExecutor(mockService)
+	executor.RegisterExpressionFunc("len", func(args ...interface{}) (interface{}, error) {
+		return len(fmt.Sprint(args[0])), nil
+	})
+
+	initialParams := map[string]interface{}{
+		"status": "active",
+		"age":    21,
+		"user":   map[string]interface{}{"is_admin": false, "name": "Jane"},
+	}
+
+	tests := []struct {
+		expr    string
+		wantRun bool
+	}{
+		{`{{status == "active" && age > 18}}`, true},
+		{`{{status == "active" && age >= 30}}`, false},
+		{`status != 'active' || (age == 21 && !user.is_admin)`, true},
+		{`{{user.is_admin}}`, false},
+		{`{{coupon == null && len(user.name) <= 4}}`, true},
+		{`{{coupon}}`, false},
+	}
+
+	for _, tt := range tests {
+		err := executor.RegisterWorkflow(workflow.Workflow{
+			Name: "gated",
+			Steps: []workflow.WorkflowStep{
+				{
+					ID:            "notify",
+					ServiceName:   "mail",
+					ActionName:    "send",
+					ConditionExpr: tt.expr,
+					// The expression takes precedence over the condition struct
+					Condition:     &workflow.StepCondition{Type: workflow.ConditionExists, SourceVariable: "unset"},
+					ResultMapping: map[string]string{"sent": "sent"},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to register workflow: %v", err)
+		}
+
+		vars, err := executor.ExecuteWorkflow("gated", initialParams, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to execute workflow: %v", tt.expr, err)
+		}
+		if _, ran := vars["sent"]; ran != tt.wantRun {
+			t.Errorf("%s: expected the step to run: %v, got %v", tt.expr, tt.wantRun, ran)
+		}
+	}
+
+	// An invalid expression fails the step
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:  "gated",
+		Steps: []workflow.WorkflowStep{{ID: "notify", ServiceName: "mail", ActionName: "send", ConditionExpr: `{{(age > 18}}`}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	if _, err := executor.ExecuteWorkflow("gated", initialParams, nil); err == nil || !strings.Contains(err.Error(), "missing closing parenthesis") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
+
+func TestTemplatedServiceAndAction(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("email", "send_welcome", map[string]interface{}{"via": "email"})
+	mockService.AddMockResponse("sms", "send_welcome", map[string]interface{}{"via": "sms"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "dispatch",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:            "notify",
+				ServiceName:   "{{channel}}",
+				ActionName:    "send_{{message_type}}",
+				ResultMapping: map[string]string{"via": "via"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Templated names should be accepted at registration: %v", err)
+	}
+
+	for _, channel := range []string{"email", "sms"} {
+		vars, err := executor.ExecuteWorkflow("dispatch", map[string]interface{}{"channel": channel, "message_type": "welcome"}, nil)
+		if err != nil {
+			t.Fatalf("Failed to execute workflow: %v", err)
+		}
+		if vars["via"] != channel {
+			t.Errorf("Expected the step to call %s, got %v", channel, vars["via"])
+		}
+	}
+
+	_, err = executor.ExecuteWorkflow("dispatch", map[string]interface{}{"message_type": "welcome"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "variable channel not found") {
+		t.Errorf("Expected a resolution error for the missing variable, got %v", err)
+	}
+	_, err = executor.ExecuteWorkflow("dispatch", map[string]interface{}{"channel": "", "message_type": "welcome"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "expected a non-empty string") {
+		t.Errorf("Expected a resolution error for the empty name, got %v", err)
+	}
+}
+
+func TestWholeResultMapping(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{
+		"id":      "u1",
+		"profile": map[string]interface{}{"name": "Jane"},
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "whole_result",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "get_user",
+				ServiceName: "users",
+				ActionName:  "get",
+				ResultMapping: map[string]string{
+					workflow.WholeResultField: "user",
+					"*":                       "user_copy",
+					"id":                      "user_id",
+				},
+			},
+			{
+				ID:            "get_all",
+				ServiceName:   "users",
+				ActionName:    "get",
+				LoopOver:      "ids",
+				LoopAs:        "id",
+				ResultMapping: map[string]string{".": "users"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("whole_result", map[string]interface{}{"ids": []interface{}{"u1", "u2"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	user, ok := vars["user"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected the whole result in user, got %v", vars["user"])
+	}
+	if profile, _ := user["profile"].(map[string]interface{}); profile["name"] != "Jane" || user["id"] != "u1" {
+		t.Errorf("Unexpected whole result: %v", user)
+	}
+	if _, ok := vars["user_copy"].(map[string]interface{}); !ok {
+		t.Errorf("Expected * to map the whole result, got %v", vars["user_copy"])
+	}
+	if vars["user_id"] != "u1" {
+		t.Errorf("Expected field mappings alongside the whole result, got %v", vars["user_id"])
+	}
+	if users, _ := vars["users"].([]interface{}); len(users) != 2 {
+		t.Errorf("Expected a loop to collect one whole result per iteration, got %v", vars["users"])
+	}
+}
+
+func TestMergeIntoVariable(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("accounts", "get", map[string]interface{}{
+		"id":      "a1",
+		"address": map[string]interface{}{"city": "Lyon", "zip": "69001"},
+		"tags":    []interface{}{"old"},
+	})
+	mockService.AddMockResponse("billing", "get", map[string]interface{}{
+		"address": map[string]interface{}{"city": "Paris"},
+		"tags":    []interface{}{"premium"},
+		"plan":    "pro",
+	})
+	mockService.AddMockResponse("settings", "get", map[string]interface{}{
+		"prefs": map[string]interface{}{"theme": "dark"},
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "profile",
+		Steps: []workflow.WorkflowStep{
+			{ID: "account", ServiceName: "accounts", ActionName: "get", MergeInto: "profile"},
+			{ID: "billing", ServiceName: "billing", ActionName: "get", MergeInto: "profile"},
+			{
+				ID:            "settings",
+				ServiceName:   "settings",
+				ActionName:    "get",
+				MergeInto:     "profile",
+				ResultMapping: map[string]string{"prefs.theme": "theme"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("profile", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	profile, ok := vars["profile"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected a profile object, got %v", vars["profile"])
+	}
+	address, _ := profile["address"].(map[string]interface{})
+	if address["city"] != "Paris" || address["zip"] != "69001" {
+		t.Errorf("Expected nested objects to be merged, got %v", address)
+	}
+	if tags, _ := profile["tags"].([]interface{}); len(tags) != 1 || tags[0] != "premium" {
+		t.Errorf("Expected arrays to be overwritten, got %v", profile["tags"])
+	}
+	if profile["id"] != "a1" || profile["plan"] != "pro" || profile["theme"] != "dark" {
+		t.Errorf("Expected fields of all three steps, got %v", profile)
+	}
+	if _, exists := vars["theme"]; exists {
+		t.Errorf("Expected mapped fields to be merged instead of set as variables")
+	}
+
+	// Merging cannot be combined with a loop
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name:  "loop_merge",
+		Steps: []workflow.WorkflowStep{{ID: "all", ServiceName: "accounts", ActionName: "get", LoopOver: "ids", LoopAs: "id", MergeInto: "profile"}},
+	})
+	if err == nil {
+		t.Errorf("Expected a loop step merging its result to be rejected")
+	}
+}
+
+func TestAggregatorStepResults(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{
+		"email":  "jane@example.com",
+		"orders": []interface{}{"o1", "o2"},
+	})
+	mockService.AddMockResponse("orders", "get", map[string]interface{}{"total": 12.5})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "summary",
+		Steps: []workflow.WorkflowStep{
+			{ID: "get_user", ServiceName: "users", ActionName: "get", ResultMapping: map[string]string{"orders": "order_ids"}},
+			{ID: "get_order", ServiceName: "orders", ActionName: "get", LoopOver: "order_ids", LoopAs: "order_id"},
+		},
+		Aggregator: map[string]string{
+			"email":       "steps.get_user.email",
+			"order_count": "steps.get_user.orders.length",
+			"first_total": "steps.get_order[0].total",
+			"user":        "steps.get_user",
+			"missing":     "steps.unknown.email",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var result map[string]interface{}
+	if _, err := executor.ExecuteWorkflow("summary", nil, &result); err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	if result["email"] != "jane@example.com" {
+		t.Errorf("Expected the email of the get_user step, got %v", result["email"])
+	}
+	if result["order_count"] != float64(2) {
+		t.Errorf("Expected the length of a step field, got %v", result["order_count"])
+	}
+	if result["first_total"] != 12.5 {
+		t.Errorf("Expected the result of the first loop iteration, got %v", result["first_total"])
+	}
+	if user, _ := result["user"].(map[string]interface{}); user["email"] != "jane@example.com" {
+		t.Errorf("Expected the whole step result, got %v", result["user"])
+	}
+	if _, exists := result["missing"]; exists {
+		t.Errorf("Expected an unknown step to be skipped, got %v", result["missing"])
+	}
+}
+
+func TestWorkflowPostProcess(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("orders", "list", map[string]interface{}{
+		"orders": []interface{}{"o1", "o2", "o3"},
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+	executor.RegisterExpressionFunc("double", func(args ...interface{}) (interface{}, error) {
+		n, ok := args[0].(int)
+		if !ok {
+			return nil, fmt.Errorf("expected an int, got %T", args[0])
+		}
+		return n * 2, nil
+	})
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "report",
+		Steps: []workflow.WorkflowStep{
+			{ID: "list", ServiceName: "orders", ActionName: "list", ResultMapping: map[string]string{"orders": "orders"}},
+		},
+		PostProcess: map[string]string{
+			// Entries reference each other regardless of their names
+			"a_summary":    "{{doubled}} units for {{customer}}",
+			"doubled":      "double(order_count)",
+			"order_count":  "orders.length",
+			"first_status": "steps.list.orders[0]",
+		},
+		Aggregator: map[string]string{"summary": "a_summary", "count": "order_count"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var result map[string]interface{}
+	vars, err := executor.ExecuteWorkflow("report", map[string]interface{}{"customer": "Jane"}, &result)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if result["summary"] != "6 units for Jane" || result["count"] != float64(3) {
+		t.Errorf("Expected the aggregator to use post-processed variables, got %v", result)
+	}
+	if vars["first_status"] != "o1" {
+		t.Errorf("Expected post-processing to reference step results, got %v", vars["first_status"])
+	}
+
+	// Cyclic references fail the workflow
+	wf, _ := executor.GetWorkflow("report")
+	wf.PostProcess = map[string]string{"a": "{{b}}", "b": "{{a}}"}
+	executor.RegisterWorkflow(wf)
+	if _, err := executor.ExecuteWorkflow("report", nil, nil); err == nil || !strings.Contains(err.Error(), "cyclic post-process reference") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+}
+
+func TestCancelWorkflowByID(t *testing.T) {
+	mockService := &ctxMockService{}
+	executor := workflow.NewWorkflowExecutor(mockService)
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "long",
+		Steps: []workflow.WorkflowStep{
+			{ID: "first", ServiceName: "api", ActionName: "slow"},
+			{ID: "second", ServiceName: "api", ActionName: "slow"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var hookErr error
+	execution, err := executor.StartWorkflow("long", nil, nil, workflow.WithCompletionHook(func(_ map[string]interface{}, err error) {
+		hookErr = err
+	}))
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+	if running := executor.RunningExecutions(); len(running) != 1 || running[0] != execution.ID {
+		t.Errorf("Expected the execution to be tracked, got %v", running)
+	}
+
+	start := time.Now()
+	if err := executor.CancelWorkflow(execution.ID); err != nil {
+		t.Fatalf("Failed to cancel workflow: %v", err)
+	}
+	_, err = execution.Wait()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("Expected the running request to be aborted, took %v", elapsed)
+	}
+	if hookErr != err {
+		t.Errorf("Expected the completion hook to receive the error before Wait returns, got %v", hookErr)
+	}
+	if n := atomic.LoadInt32(&mockService.completed); n != 0 {
+		t.Errorf("Expected no step to complete, got %d", n)
+	}
+
+	// Finished executions are no longer tracked
+	if running := executor.RunningExecutions(); len(running) != 0 {
+		t.Errorf("Expected no running execution, got %v", running)
+	}
+	if err := executor.CancelWorkflow(execution.ID); !errors.Is(err, workflow.ErrExecutionNotFound) {
+		t.Errorf("Expected ErrExecutionNotFound, got %v", err)
+	}
+
+	// An execution that isn't canceled completes normally
+	execution, err = executor.StartWorkflow("long", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+	<-execution.Done()
+	if _, err := execution.Wait(); err != nil {
+		t.Errorf("Expected the execution to succeed, got %v", err)
+	}
+	if _, err := executor.StartWorkflow("unknown", nil, nil); err == nil {
+		t.Errorf("Expected an error for an unknown workflow")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	calls := make(map[string]int)
+	mockService := funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		calls[actionName]++
+		switch {
+		case actionName == "flaky" && calls[actionName] <= 2:
+			return nil, errFlaky
+		case actionName == "broken" || actionName == "optional":
+			return nil, errFlaky
+		}
+		return map[string]interface{}{"ok": true}, nil
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:            "budget",
+		MaxTotalRetries: 3,
+		Steps: []workflow.WorkflowStep{
+			{ID: "flaky", ServiceName: "api", ActionName: "flaky", ErrorHandling: workflow.RetryOnError, MaxRetries: 3, RetryDelayMs: 1},
+			{ID: "broken", ServiceName: "api", ActionName: "broken", ErrorHandling: workflow.RetryOnError, MaxRetries: 5},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	_, err = executor.ExecuteWorkflow("budget", nil, nil)
+	if !errors.Is(err, workflow.ErrRetryBudgetExhausted) || !errors.Is(err, errFlaky) {
+		t.Fatalf("Expected the budget error wrapping the step error, got: %v", err)
+	}
+	// flaky used 2 retries, leaving broken a single one
+	if calls["flaky"] != 3 || calls["broken"] != 2 {
+		t.Errorf("Expected 3 calls to flaky and 2 to broken, got %v", calls)
+	}
+
+	// Using the last retry successfully denies none, so later steps keep their own strategy
+	calls = make(map[string]int)
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name:            "used_up",
+		MaxTotalRetries: 2,
+		Steps: []workflow.WorkflowStep{
+			{ID: "flaky", ServiceName: "api", ActionName: "flaky", ErrorHandling: workflow.RetryOnError, MaxRetries: 2},
+			{ID: "optional", ServiceName: "api", ActionName: "optional", ErrorHandling: workflow.ContinueOnError},
+			{ID: "final", ServiceName: "api", ActionName: "final"},
+			{ID: "broken", ServiceName: "api", ActionName: "broken"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	_, err = executor.ExecuteWorkflow("used_up", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Expected the broken step to abort the workflow, got: %v", err)
+	}
+	if errors.Is(err, workflow.ErrRetryBudgetExhausted) {
+		t.Errorf("Expected a step that never asked for a retry not to blame the budget, got: %v", err)
+	}
+	if calls["final"] != 1 {
+		t.Errorf("Expected the optional step to continue on error, got %v", calls)
+	}
+}
+
+// statusError is a failed request carrying its HTTP status code, like client.APIError
+type statusError int
+
+// Error implements the error interface
+func (e statusError) Error() string {
+	return fmt.Sprintf("status code: %d", int(e))
+}
+
+// HTTPStatusCode implements the StatusCodeError interface
+func (e statusError) HTTPStatusCode() int {
+	return int(e)
+}
+
+func TestRetryable(t *testing.T) {
+	errNetwork := errors.New("connection refused")
+	calls := make(map[string]int)
+	mockService := funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		calls[actionName]++
+		switch actionName {
+		case "bad_request":
+			return nil, statusError(http.StatusBadRequest)
+		case "rate_limited":
+			return nil, statusError(http.StatusTooManyRequests)
+		case "unavailable":
+			return nil, statusError(http.StatusServiceUnavailable)
+		default:
+			return nil, errNetwork
+		}
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	var steps []workflow.WorkflowStep
+	for _, action := range []string{"bad_request", "rate_limited", "unavailable", "network"} {
+		steps = append(steps, workflow.WorkflowStep{
+			ID: action, ServiceName: "api", ActionName: action,
+			ErrorHandling: workflow.RetryOnError, MaxRetries: 2,
+		})
+	}
+	steps = append(steps, workflow.WorkflowStep{
+		ID: "custom", ServiceName: "api", ActionName: "custom",
+		ErrorHandling: workflow.RetryOnError, MaxRetries: 2,
+		Retryable: func(err error) bool { return !errors.Is(err, errNetwork) },
+	})
+	for i := range steps {
+		if err := executor.RegisterWorkflow(workflow.Workflow{Name: steps[i].ID, Steps: steps[i : i+1]}); err != nil {
+			t.Fatalf("Failed to register workflow: %v", err)
+		}
+	}
+
+	// By default, client errors other than 429 are permanent
+	for _, name := range []string{"bad_request", "rate_limited", "unavailable", "network", "custom"} {
+		if _, err := executor.ExecuteWorkflow(name, nil, nil); err == nil {
+			t.Errorf("Expected step %s to fail", name)
+		}
+	}
+	expected := map[string]int{"bad_request": 1, "rate_limited": 3, "unavailable": 3, "network": 3, "custom": 1}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+
+	// The classifier of the executor applies to steps without their own
+	executor.SetRetryable(func(err error) bool { return false })
+	calls = make(map[string]int)
+	executor.ExecuteWorkflow("unavailable", nil, nil)
+	executor.ExecuteWorkflow("custom", nil, nil)
+	if calls["unavailable"] != 1 || calls["custom"] != 1 {
+		t.Errorf("Expected no retry with the executor classifier, got %v", calls)
+	}
+
+	// ...which the step classifier overrides
+	steps[4].Retryable = func(err error) bool { return true }
+	if err := executor.RegisterWorkflow(workflow.Workflow{Name: "custom", Steps: steps[4:5]}); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	calls = make(map[string]int)
+	executor.ExecuteWorkflow("custom", nil, nil)
+	if calls["custom"] != 3 {
+		t.Errorf("Expected the step classifier to retry, got %v", calls)
+	}
+}
+
+func TestSkippedStepReason(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("api", "profile", map[string]interface{}{"name": "Jo"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "profile",
+		Steps: []workflow.WorkflowStep{
+			{ID: "profile", ServiceName: "api", ActionName: "profile"},
+			{ID: "admin", ServiceName: "api", ActionName: "admin", ConditionExpr: "{{role == \"admin\"}}"},
+			{
+				ID: "billing", ServiceName: "api", ActionName: "billing",
+				Condition: &workflow.StepCondition{Type: workflow.ConditionExists, SourceVariable: "account_id"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]workflow.StepResult)
+	_, err = executor.ExecuteWorkflow("profile", map[string]interface{}{"role": "user"}, nil,
+		workflow.WithStepHook(func(workflowName string, result workflow.StepResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			if workflowName != "profile" {
+				t.Errorf("Expected the workflow name, got %s", workflowName)
+			}
+			results[result.StepID] = result
+		}))
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected every step to be reported, got %v", results)
+	}
+	if profile := results["profile"]; profile.Skipped || profile.Result["name"] != "Jo" {
+		t.Errorf("Expected the profile step to run, got %+v", profile)
+	}
+	if admin := results["admin"]; !admin.Skipped || !strings.Contains(admin.SkipReason, `role == "admin"`) {
+		t.Errorf("Expected the admin step to be skipped with its condition, got %+v", admin)
+	}
+	if billing := results["billing"]; !billing.Skipped || !strings.Contains(billing.SkipReason, "account_id") {
+		t.Errorf("Expected the billing step to be skipped with its condition, got %+v", billing)
+	}
+}
+
+func TestArithmeticInParameters(t *testing.T) {
+	mockService := NewMockAPIService()
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "checkout",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:          "charge",
+				ServiceName: "payments",
+				ActionName:  "charge",
+				Parameters: map[string]interface{}{
+					"amount":      "{{price * qty - discount}}",
+					"description": "total: {{price * qty}} for {{qty}} items",
+					"next_page":   "{{page + 1}}",
+					"order_ref":   "{{order-id}}",
+					"label":       "{{name + \" (\" + qty + \")\"}}",
+				},
+				// Set by the builder for WithParam("units", "{{qty * 2}}") and WithParam("ref", "{{ref-id}}")
+				DynamicParams: map[string]string{"units": "{{qty * 2}}", "ref": "ref-id"},
+				ResultMapping: map[string]string{"_params": "params"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	vars, err := executor.ExecuteWorkflow("checkout", map[string]interface{}{
+		"price": 2.5, "qty": 4, "discount": 1, "page": 1, "order-id": "o-7", "name": "pens",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+
+	params, _ := vars["params"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"amount":      float64(9),
+		"description": "total: 10 for 4 items",
+		"next_page":   float64(2),
+		"order_ref":   "o-7",
+		"label":       "pens (4)",
+		"units":       float64(8),
+	}
+	for name, value := range expected {
+		if params[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, params[name])
+		}
+	}
+	// A missing hyphenated variable is left out, not computed as ref - id
+	if value, exists := params["ref"]; exists {
+		t.Errorf("Expected the missing ref-id variable to be left out, got %v", value)
+	}
+
+	_, err = executor.ExecuteWorkflow("checkout", map[string]interface{}{
+		"price": "free", "qty": 4, "discount": 1, "page": 1, "order-id": "o-7", "name": "pens",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "operator *") {
+		t.Errorf("Expected an error multiplying a string, got: %v", err)
+	}
+}
+
+func TestConditionRegexMatchAndTimeout(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("api", "notify", map[string]interface{}{"sent": true})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	release := make(chan struct{})
+	defer close(release)
+	executor.RegisterExpressionFunc("hang", func(args ...interface{}) (interface{}, error) {
+		<-release
+		return true, nil
+	})
+	executor.SetConditionTimeout(50 * time.Millisecond)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "notify",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID: "notify", ServiceName: "api", ActionName: "notify",
+				Condition:     &workflow.StepCondition{Type: workflow.ConditionRegexMatch, SourceVariable: "email", Value: `@example\.com$`},
+				ResultMapping: map[string]string{"sent": "sent"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	for email, expected := range map[string]bool{"jo@example.com": true, "jo@example.org": false} {
+		vars, err := executor.ExecuteWorkflow("notify", map[string]interface{}{"email": email}, nil)
+		if err != nil {
+			t.Fatalf("Failed to execute workflow: %v", err)
+		}
+		if (vars["sent"] == true) != expected {
+			t.Errorf("Expected the step to run for %s: %v, got %v", email, expected, vars)
+		}
+	}
+
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name: "hanging",
+		Steps: []workflow.WorkflowStep{
+			{ID: "notify", ServiceName: "api", ActionName: "notify", ConditionExpr: "{{hang()}}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	start := time.Now()
+	_, err = executor.ExecuteWorkflow("hanging", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the condition to time out, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the evaluation to stop after the timeout, took %v", elapsed)
+	}
+
+	// A timed out evaluation keeps running, reading a copy of the variables the next steps write
+	returned := make(chan struct{})
+	executor.RegisterExpressionFunc("slow", func(args ...interface{}) (interface{}, error) {
+		time.Sleep(100 * time.Millisecond)
+		close(returned)
+		return true, nil
+	})
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name: "slow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "check", ServiceName: "api", ActionName: "notify", ConditionExpr: `{{slow() && email != ""}}`, ErrorHandling: workflow.ContinueOnError},
+			{ID: "notify", ServiceName: "api", ActionName: "notify", ResultMapping: map[string]string{"sent": "email"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	vars, err := executor.ExecuteWorkflow("slow", map[string]interface{}{"email": "jo@example.com"}, nil)
+	if err != nil || vars["email"] != true {
+		t.Errorf("Expected the next step to run, got %v, %v", vars, err)
+	}
+	<-returned
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestClearWorkflows(t *testing.T) {
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+	for _, name := range []string{"first", "second"} {
+		if err := executor.RegisterWorkflow(workflow.Workflow{Name: name}); err != nil {
+			t.Fatalf("Failed to register workflow: %v", err)
+		}
+	}
+
+	executor.Clear()
+	if workflows := executor.ListWorkflows(); len(workflows) != 0 {
+		t.Errorf("Expected no workflows after Clear, got %v", workflows)
+	}
+	if _, err := executor.ExecuteWorkflow("first", nil, nil); err == nil {
+		t.Errorf("Expected a cleared workflow not to run")
+	}
+
+	// The executor can be reused
+	if err := executor.RegisterWorkflow(workflow.Workflow{Name: "third"}); err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	if workflows := executor.ListWorkflows(); len(workflows) != 1 || workflows[0] != "third" {
+		t.Errorf("Expected only the new workflow, got %v", workflows)
+	}
+}
+
+func TestLoadWorkflows(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// A map of workflows, as written by SaveWorkflows
+		"all.json": `{"list_users": {"name": "list_users", "steps": [{"id": "list", "service_name": "users", "action_name": "list"}]}}`,
+		// A single workflow per file
+		"get_user.json": `{"name": "get_user", "steps": [{"id": "get", "service_name": "users", "action_name": "get"}]}`,
+		"invalid.json":  `{"name": ["get_user"]}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+	for _, name := range []string{"all.json", "get_user.json"} {
+		if err := executor.LoadWorkflows(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+	}
+	workflows := executor.ListWorkflows()
+	sort.Strings(workflows)
+	if strings.Join(workflows, ",") != "get_user,list_users" {
+		t.Errorf("Expected the workflows of both file shapes, got %v", workflows)
+	}
+	if wf, _ := executor.GetWorkflow("get_user"); len(wf.Steps) != 1 || wf.Steps[0].ActionName != "get" {
+		t.Errorf("Expected the single workflow to be loaded with its steps, got %+v", wf)
+	}
+
+	if err := executor.LoadWorkflows(filepath.Join(dir, "invalid.json")); err == nil {
+		t.Errorf("Expected an error for a file that is neither shape")
+	}
+}
+
+func TestWorkflowJSON(t *testing.T) {
+	source := workflow.NewWorkflowExecutor(NewMockAPIService())
+	err := source.RegisterWorkflow(workflow.Workflow{
+		Name:      "get_user",
+		Variables: map[string]interface{}{"user_id": "42"},
+		Steps: []workflow.WorkflowStep{
+			{ID: "get", ServiceName: "users", ActionName: "get", DynamicParams: map[string]string{"id": "user_id"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	data, err := source.MarshalWorkflow("get_user")
+	if err != nil {
+		t.Fatalf("Failed to marshal workflow: %v", err)
+	}
+	if _, err := source.MarshalWorkflow("unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown workflow")
+	}
+
+	// The encoding round-trips through another executor
+	target := workflow.NewWorkflowExecutor(NewMockAPIService())
+	if err := target.RegisterWorkflowJSON(data); err != nil {
+		t.Fatalf("Failed to register workflow JSON: %v", err)
+	}
+	original, _ := source.GetWorkflow("get_user")
+	if registered, ok := target.GetWorkflow("get_user"); !ok || registered.Hash() != original.Hash() {
+		t.Errorf("Expected the registered workflow to match the original, got %+v", registered)
+	}
+
+	// Invalid JSON and invalid workflows are rejected like in LoadWorkflows
+	for name, data := range map[string]string{
+		"malformed":    `{"name": `,
+		"unnamed":      `{"steps": [{"id": "get", "service_name": "users", "action_name": "get"}]}`,
+		"duplicate id": `{"name": "dup", "steps": [{"id": "a", "service_name": "s", "action_name": "x"}, {"id": "a", "service_name": "s", "action_name": "y"}]}`,
+	} {
+		if err := target.RegisterWorkflowJSON([]byte(data)); err == nil {
+			t.Errorf("Expected an error for the %s workflow", name)
+		}
+	}
+	if workflows := target.ListWorkflows(); len(workflows) != 1 {
+		t.Errorf("Expected only the valid workflow to be registered, got %v", workflows)
+	}
+}
+
+func TestStepDependencies(t *testing.T) {
+	// a and b only finish once both have started, so they must run concurrently
+	var started sync.WaitGroup
+	started.Add(2)
+	mockService := funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		switch actionName {
+		case "a", "b":
+			started.Done()
+			done := make(chan struct{})
+			go func() { started.Wait(); close(done) }()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				return nil, fmt.Errorf("step %s ran alone", actionName)
+			}
+			return map[string]interface{}{"value": actionName}, nil
+		case "c":
+			return map[string]interface{}{"value": fmt.Sprint(params["a"], params["b"])}, nil
+		}
+		return map[string]interface{}{"value": fmt.Sprint(params["c"], "d")}, nil
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "graph_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID:            "d",
+				ServiceName:   "service",
+				ActionName:    "d",
+				DynamicParams: map[string]string{"c": "c_value"},
+				DependsOn:     []string{"c"},
+				ResultMapping: map[string]string{"value": "d_value"},
+			},
+			{
+				ID:            "c",
+				ServiceName:   "service",
+				ActionName:    "c",
+				DynamicParams: map[string]string{"a": "a_value", "b": "b_value"},
+				DependsOn:     []string{"a", "b"},
+				ResultMapping: map[string]string{"value": "c_value"},
+			},
+			{ID: "a", ServiceName: "service", ActionName: "a", ResultMapping: map[string]string{"value": "a_value"}},
+			{ID: "b", ServiceName: "service", ActionName: "b", ResultMapping: map[string]string{"value": "b_value"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	variables, err := executor.ExecuteWorkflow("graph_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if variables["c_value"] != "ab" || variables["d_value"] != "abd" {
+		t.Errorf("Expected steps to run after their dependencies, got %v", variables)
+	}
+
+	invalid := map[string][]workflow.WorkflowStep{
+		"cyclic step dependency in workflow invalid: a -> c -> b -> a": {
+			{ID: "a", ServiceName: "service", ActionName: "a", DependsOn: []string{"c"}},
+			{ID: "b", ServiceName: "service", ActionName: "b", DependsOn: []string{"a"}},
+			{ID: "c", ServiceName: "service", ActionName: "c", DependsOn: []string{"b"}},
+		},
+		"step a in workflow invalid depends on unknown step ID missing": {
+			{ID: "a", ServiceName: "service", ActionName: "a", DependsOn: []string{"missing"}},
+		},
+		"step b in workflow invalid cannot use parallel_with in a workflow whose steps declare depends_on": {
+			{ID: "a", ServiceName: "service", ActionName: "a"},
+			{ID: "b", ServiceName: "service", ActionName: "b", ParallelWith: []string{"a"}},
+			{ID: "c", ServiceName: "service", ActionName: "c", DependsOn: []string{"a"}},
+		},
+	}
+	for expected, steps := range invalid {
+		err := executor.RegisterWorkflow(workflow.Workflow{Name: "invalid", Steps: steps})
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestStepDependenciesStartOrder(t *testing.T) {
+	mockService := &orderRecordingService{}
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	// Ready steps start by descending priority, then in definition order, whichever
+	// dependency finished first
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:           "ordered_graph",
+		MaxConcurrency: 1,
+		Steps: []workflow.WorkflowStep{
+			{ID: "r1", ServiceName: "service", ActionName: "r1"},
+			{ID: "r2", ServiceName: "service", ActionName: "r2"},
+			{ID: "x", ServiceName: "service", ActionName: "x", DependsOn: []string{"r2"}},
+			{ID: "y", ServiceName: "service", ActionName: "y", DependsOn: []string{"r1"}},
+			{ID: "z", ServiceName: "service", ActionName: "z", DependsOn: []string{"r2"}, Priority: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	if _, err := executor.ExecuteWorkflow("ordered_graph", nil, nil); err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if got := strings.Join(mockService.order, ","); got != "r1,r2,z,x,y" {
+		t.Errorf("Expected steps to start by priority then definition order, got %s", got)
+	}
+}
+
+func TestExecuteWorkflowStreaming(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{"name": "Ada"})
+	mockService.AddMockResponse("users", "greet", map[string]interface{}{"message": "hello"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "streamed_workflow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "get", ServiceName: "users", ActionName: "get", ResultMapping: map[string]string{"name": "name"}},
+			{ID: "greet", ServiceName: "users", ActionName: "greet", ResultMapping: map[string]string{"message": "message"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	updates, err := executor.ExecuteWorkflowStreaming("streamed_workflow", nil)
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+	var received []string
+	var final workflow.StepUpdate
+	for update := range updates {
+		received = append(received, string(update.Type)+":"+update.StepID)
+		final = update
+	}
+	expected := "step_started:get step_finished:get step_started:greet step_finished:greet workflow_finished:"
+	if strings.Join(received, " ") != expected {
+		t.Errorf("Expected updates %q, got %q", expected, strings.Join(received, " "))
+	}
+	if final.Error != nil || final.Variables["name"] != "Ada" || final.Variables["message"] != "hello" {
+		t.Errorf("Expected the final update to hold the variables, got %+v", final)
+	}
+
+	if _, err := executor.ExecuteWorkflowStreaming("missing", nil); err == nil {
+		t.Error("Expected an unknown workflow to be reported")
+	}
+
+	// A caller abandoning the channel cancels its context, which closes the channel
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err = executor.ExecuteWorkflowStreaming("streamed_workflow", nil, workflow.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+	<-updates
+	cancel()
+	expectClosed(t, updates)
+}
+
+func TestExecuteWorkflowStreamingReaderQuitsEarly(t *testing.T) {
+	items := make([]interface{}, 100)
+	for i := range items {
+		items[i] = i
+	}
+	executor := workflow.NewWorkflowExecutor(funcMockService(func(actionName string, params map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}))
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "abandoned_workflow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "each", ServiceName: "svc", ActionName: "each", LoopOver: "items", LoopAs: "item"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	done := make(chan struct{})
+	updates, err := executor.ExecuteWorkflowStreaming("abandoned_workflow", map[string]interface{}{"items": items},
+		workflow.WithCompletionHook(func(map[string]interface{}, error) { close(done) }))
+	if err != nil {
+		t.Fatalf("Failed to start workflow: %v", err)
+	}
+
+	// The reader quits after the first update: the execution still finishes
+	if update := <-updates; update.Type != workflow.StepStarted {
+		t.Fatalf("Expected a step to start, got %+v", update)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the execution to finish without a reader")
+	}
+
+	// The oldest updates were dropped, the final one is kept
+	var buffered []workflow.StepUpdate
+	for update := range updates {
+		buffered = append(buffered, update)
+	}
+	if len(buffered) == 0 || len(buffered) >= 200 {
+		t.Fatalf("Expected a bounded number of buffered updates, got %d", len(buffered))
+	}
+	if final := buffered[len(buffered)-1]; final.Type != workflow.WorkflowFinished || final.Error != nil {
+		t.Errorf("Expected the last update to be the WorkflowFinished one, got %+v", final)
+	}
+}
+
+// expectClosed drains updates, failing if the channel is not closed within a second
+func expectClosed(t *testing.T, updates <-chan workflow.StepUpdate) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected the channel to be closed")
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	mockService := &failingMockService{MockAPIService: NewMockAPIService(), failAction: "missing"}
+	mockService.AddMockResponse("users", "get", map[string]interface{}{"name": "Ada"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "counted_workflow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "get", ServiceName: "users", ActionName: "get"},
+			{ID: "admin_only", ServiceName: "users", ActionName: "get", Condition: &workflow.StepCondition{Type: workflow.ConditionExists, SourceVariable: "admin"}},
+			{ID: "missing", ServiceName: "users", ActionName: "missing", ErrorHandling: workflow.ContinueOnError},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name:  "failing_workflow",
+		Steps: []workflow.WorkflowStep{{ID: "missing", ServiceName: "users", ActionName: "missing"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := executor.ExecuteWorkflow("counted_workflow", nil, nil); err != nil {
+			t.Fatalf("Failed to execute workflow: %v", err)
+		}
+	}
+	if _, err := executor.ExecuteWorkflow("failing_workflow", nil, nil); err == nil {
+		t.Fatal("Expected the failing workflow to fail")
+	}
+	if _, err := executor.ExecuteWorkflow("unknown", nil, nil); err == nil {
+		t.Fatal("Expected an unknown workflow to fail")
+	}
+
+	stats := executor.Stats()
+	if stats.Runs != 6 || stats.Successes != 5 || stats.Failures != 1 {
+		t.Errorf("Expected 6 runs, 5 successes and 1 failure, got %+v", stats)
+	}
+	get := stats.Steps[workflow.StepKey{Workflow: "counted_workflow", Step: "get"}]
+	if get.Successes != 5 || get.Failures != 0 || get.AverageDuration <= 0 {
+		t.Errorf("Unexpected stats for step get: %+v", get)
+	}
+	if skipped := stats.Steps[workflow.StepKey{Workflow: "counted_workflow", Step: "admin_only"}]; skipped.Skipped != 5 || skipped.Successes != 0 {
+		t.Errorf("Unexpected stats for step admin_only: %+v", skipped)
+	}
+	if missing := stats.Steps[workflow.StepKey{Workflow: "counted_workflow", Step: "missing"}]; missing.Failures != 5 {
+		t.Errorf("Unexpected stats for step missing: %+v", missing)
+	}
+	if failed := stats.Steps[workflow.StepKey{Workflow: "failing_workflow", Step: "missing"}]; failed.Failures != 1 {
+		t.Errorf("Unexpected stats for the failing workflow: %+v", failed)
+	}
+}
+
+func TestParentVariables(t *testing.T) {
+	mockService := NewMockAPIService()
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:      "shared_workflow",
+		Variables: map[string]interface{}{"region": "us-east-1", "version": "v1", "format": "json"},
+		Steps:     []workflow.WorkflowStep{{ID: "get", ServiceName: "users", ActionName: "get"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	parent := map[string]interface{}{"region": "eu-west-1", "version": "v2"}
+	variables, err := executor.ExecuteWorkflow("shared_workflow", map[string]interface{}{"version": "v3"}, nil,
+		workflow.WithParentVariables(parent))
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	if variables["format"] != "json" || variables["region"] != "eu-west-1" || variables["version"] != "v3" {
+		t.Errorf("Expected defaults < parent variables < params, got %v", variables)
+	}
+	if parent["version"] != "v2" {
+		t.Errorf("Expected the parent variables to be left untouched, got %v", parent)
+	}
+}
+
+func TestParamCollision(t *testing.T) {
+	executor := workflow.NewWorkflowExecutor(NewMockAPIService())
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "colliding_workflow",
+		Steps: []workflow.WorkflowStep{{
+			ID: "get", ServiceName: "users", ActionName: "get",
+			Parameters:    map[string]interface{}{"id": "42", "limit": 10, "format": "json"},
+			DynamicParams: map[string]string{"limit": "page_size", "id": "user_id"},
+		}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "parameter id in both parameters and dynamic_params") {
+		t.Errorf("Expected the first colliding parameter to be reported, got %v", err)
+	}
+	if _, exists := executor.GetWorkflow("colliding_workflow"); exists {
+		t.Error("Expected the colliding workflow not to be registered")
+	}
+
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name: "distinct_workflow",
+		Steps: []workflow.WorkflowStep{{
+			ID: "get", ServiceName: "users", ActionName: "get",
+			Parameters:    map[string]interface{}{"format": "json"},
+			DynamicParams: map[string]string{"id": "user_id"},
+		}},
+	})
+	if err != nil {
+		t.Errorf("Expected distinct parameters to be accepted, got %v", err)
+	}
+}
+
+func TestStepReferenceParams(t *testing.T) {
+	mockService := NewMockAPIService()
+	mockService.AddMockResponse("users", "get", map[string]interface{}{
+		"manager": map[string]interface{}{"id": "m-7"},
+	})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name: "referencing_workflow",
+		Steps: []workflow.WorkflowStep{
+			{ID: "get_user", ServiceName: "users", ActionName: "get"},
+			{
+				ID: "get_manager", ServiceName: "users", ActionName: "get_manager",
+				DynamicParams: map[string]string{"id": "steps.get_user.manager.id"},
+				ResultMapping: map[string]string{"_params": "manager_params"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	variables, err := executor.ExecuteWorkflow("referencing_workflow", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute workflow: %v", err)
+	}
+	params, _ := variables["manager_params"].(map[string]interface{})
+	if params["id"] != "m-7" {
+		t.Errorf("Expected the parameter to be read from the get_user result, got %v", variables["manager_params"])
+	}
+
+	// Referencing a step that hasn't run fails the step
+	err = executor.RegisterWorkflow(workflow.Workflow{
+		Name: "early_reference_workflow",
+		Steps: []workflow.WorkflowStep{
+			{
+				ID: "get_manager", ServiceName: "users", ActionName: "get_manager",
+				DynamicParams: map[string]string{"id": "steps.get_user.manager.id"},
+			},
+			{ID: "get_user", ServiceName: "users", ActionName: "get"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+	_, err = executor.ExecuteWorkflow("early_reference_workflow", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "no result for step 'get_user'") {
+		t.Errorf("Expected a reference to a step that hasn't run to fail, got %v", err)
+	}
+}
+
+func TestPartialVariablesOnAbort(t *testing.T) {
+	mockService := &failingMockService{MockAPIService: NewMockAPIService(), failAction: "broken"}
+	mockService.AddMockResponse("users", "get", map[string]interface{}{"name": "Ada"})
+	executor := workflow.NewWorkflowExecutor(mockService)
+
+	err := executor.RegisterWorkflow(workflow.Workflow{
+		Name:       "aborting_workflow",
+		Variables:  map[string]interface{}{"region": "eu"},
+		Aggregator: map[string]string{"user": "name"},
+		Steps: []workflow.WorkflowStep{
+			{ID: "get", ServiceName: "users", ActionName: "get", ResultMapping: map[string]string{"name": "name"}},
+			{ID: "broken", ServiceName: "users", ActionName: "broken"},
+			{ID: "never", ServiceName: "users", ActionName: "get", ResultMapping: map[string]string{"name": "never_name"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register workflow: %v", err)
+	}
+
+	var result map[string]interface{}
+	variables, err := executor.ExecuteWorkflow("aborting_workflow", nil, &result)
+	if err == nil {
+		t.Fatal("Expected the workflow to abort")
+	}
+	if variables["region"] != "eu" || variables["name"] != "Ada" {
+		t.Errorf("Expected the variables of the completed steps, got %v", variables)
+	}
+	if _, exists := variables["never_name"]; exists {
+		t.Errorf("Expected no variable from steps after the failure, got %v", variables)
+	}
+	if result != nil {
+		t.Errorf("Expected the aggregator to be skipped, got %v", result)
+	}
+
+	if variables, err := executor.ExecuteWorkflow("unknown", nil, nil); err == nil || variables != nil {
+		t.Errorf("Expected an unknown workflow to return no variables, got %v, %v", variables, err)
+	}
+}
@@ -1,6 +1,7 @@
 package modularapi
 
 import (
+	"regexp"
 	"strings"
 
 	"github.com/rrodriguez06/modular_api/pkg/modularapi/workflow"
@@ -16,11 +17,30 @@ type WorkflowStepTemplate struct {
 	DynamicParams map[string]string
 	ResultMapping map[string]string
 	Condition     *workflow.StepCondition
+	ConditionExpr string // Boolean expression, takes precedence over Condition
 	ParallelWith  []string
+	DependsOn     []string // Steps to finish before this one, scheduling the workflow as a graph
 	ErrorHandling workflow.ErrorHandlingStrategy
 	MaxRetries    int
+	RetryDelayMs  int    // Delay between retries in milliseconds
+	TimeoutMs     int    // Timeout of each request of the step in milliseconds
 	LoopOver      string // Name of variable containing array to iterate over
 	LoopAs        string // Name of the variable to store current item in the loop
+	Paginate      *workflow.PaginationSpec
+	Priority      int  // Start order among parallel steps when concurrency is limited
+	RawResult     bool // Keep the raw response body instead of decoding it
+	Streaming     bool // Forward the response to the execution's stream writer
+	// PreserveLoopAlignment collects nil for loop iterations missing a mapped field
+	PreserveLoopAlignment bool
+	AcceptStatusCodes     []int  // Error status codes treated as a successful empty result
+	MergeInto             string // Object variable the result is deep-merged into
+	GroupCondition        bool   // The step's condition skips its whole parallel group when not met
+	DelayBeforeMs         int    // Wait before running the step in milliseconds
+	DelayAfterMs          int    // Wait after running the step in milliseconds
+	MaxLoopIterations     int    // Cap on the iterations of a loop step, 0 for the workflow's
+	TruncateLoop          bool   // Process only the first MaxLoopIterations items instead of failing
+	// Retryable decides which errors are retried with RetryOnError
+	Retryable workflow.RetryableFunc
 }
 
 // NewStepTemplate creates a new workflow step template
@@ -36,14 +56,24 @@ func NewWorkflowStepTemplate(id, description string, serviceName, actionName str
 	}
 }
 
+// variableNamePattern matches the content of a placeholder naming a variable, such as "user.id"
+// or "order-id", rather than computing an expression
+var variableNamePattern = regexp.MustCompile(`^\s*[A-Za-z_][A-Za-z0-9_.\-]*\s*$`)
+
 // WithParam adds a parameter to the step template.
-// If the value is a string like "{{variable}}", it will be treated as a reference to a workflow variable
+// If the value is a string like "{{variable}}", it will be treated as a reference to a workflow variable,
+// and a string like "{{price * qty}}" as an expression evaluated when the step runs
 func (t *WorkflowStepTemplate) WithParam(name string, value interface{}) *WorkflowStepTemplate {
 	// If value is a string and looks like a template variable
 	if strValue, isString := value.(string); isString && strings.HasPrefix(strValue, "{{") && strings.HasSuffix(strValue, "}}") {
 		// Extract the variable name without the braces
 		varName := strings.TrimPrefix(strings.TrimSuffix(strValue, "}}"), "{{")
 
+		// An expression keeps its braces, so it is evaluated rather than looked up
+		if !variableNamePattern.MatchString(varName) {
+			varName = strValue
+		}
+
 		// Add it as a dynamic parameter instead
 		t.DynamicParams[name] = varName
 	} else {
@@ -75,12 +105,34 @@ func (t *WorkflowStepTemplate) WithCondition(condType workflow.StepConditionType
 	return t
 }
 
+// WithConditionExpr makes the step run only when a boolean expression holds, e.g.
+// `{{status == "active" && age > 18}}`. It takes precedence over WithCondition.
+func (t *WorkflowStepTemplate) WithConditionExpr(expr string) *WorkflowStepTemplate {
+	t.ConditionExpr = expr
+	return t
+}
+
 // WithParallel specifies that this step runs in parallel with another step
 func (t *WorkflowStepTemplate) WithParallel(parallelStepIDs ...string) *WorkflowStepTemplate {
 	t.ParallelWith = append(t.ParallelWith, parallelStepIDs...)
 	return t
 }
 
+// WithGroupCondition makes the condition of the step gate its whole parallel group: when it
+// isn't met, the steps running in parallel with it are skipped too, without evaluating their own
+// conditions.
+func (t *WorkflowStepTemplate) WithGroupCondition() *WorkflowStepTemplate {
+	t.GroupCondition = true
+	return t
+}
+
+// WithDependsOn makes the step start only once the given steps have finished. Steps of a
+// workflow using dependencies run as soon as theirs allow, and can't use WithParallel.
+func (t *WorkflowStepTemplate) WithDependsOn(stepIDs ...string) *WorkflowStepTemplate {
+	t.DependsOn = append(t.DependsOn, stepIDs...)
+	return t
+}
+
 // WithErrorHandling sets the error handling strategy for the step template
 func (t *WorkflowStepTemplate) WithErrorHandling(strategy workflow.ErrorHandlingStrategy, maxRetries int) *WorkflowStepTemplate {
 	t.ErrorHandling = strategy
@@ -88,6 +140,35 @@ func (t *WorkflowStepTemplate) WithErrorHandling(strategy workflow.ErrorHandling
 	return t
 }
 
+// WithRetryable sets the function deciding which errors of the step are retried with
+// RetryOnError, instead of workflow.DefaultRetryable or the one set on the service builder
+func (t *WorkflowStepTemplate) WithRetryable(retryable workflow.RetryableFunc) *WorkflowStepTemplate {
+	t.Retryable = retryable
+	return t
+}
+
+// WithRetryDelay sets the delay in milliseconds between the retries of a RetryOnError step
+func (t *WorkflowStepTemplate) WithRetryDelay(delayMs int) *WorkflowStepTemplate {
+	t.RetryDelayMs = delayMs
+	return t
+}
+
+// WithTimeout sets the timeout in milliseconds of each request of the step, taking precedence
+// over the WithStepTimeout option of the execution
+func (t *WorkflowStepTemplate) WithTimeout(timeoutMs int) *WorkflowStepTemplate {
+	t.TimeoutMs = timeoutMs
+	return t
+}
+
+// WithDelay makes the step wait beforeMs milliseconds before running and afterMs milliseconds
+// after, for example to cool off a rate limit or wait for eventual consistency between polls.
+// Canceling the execution interrupts the wait.
+func (t *WorkflowStepTemplate) WithDelay(beforeMs, afterMs int) *WorkflowStepTemplate {
+	t.DelayBeforeMs = beforeMs
+	t.DelayAfterMs = afterMs
+	return t
+}
+
 // WithLoopOver configures a step to be executed multiple times, once for each element in the specified array variable.
 // The current element will be available in the workflow variables using the itemVariable name.
 // The results of all iterations will be collected in an array stored in the workflow variables using the step's result mapping.
@@ -97,6 +178,69 @@ func (t *WorkflowStepTemplate) WithLoopOver(arrayVariable, itemVariable string)
 	return t
 }
 
+// WithMaxLoopIterations caps the iterations of a loop step, as a safety valve against an
+// unexpectedly large array. A larger array fails the step with an error wrapping
+// workflow.ErrMaxLoopIterations, or with truncate only its first maxIterations items are processed.
+func (t *WorkflowStepTemplate) WithMaxLoopIterations(maxIterations int, truncate bool) *WorkflowStepTemplate {
+	t.MaxLoopIterations = maxIterations
+	t.TruncateLoop = truncate
+	return t
+}
+
+// WithPreserveLoopAlignment makes a loop step collect nil for iterations whose result lacks a
+// mapped field, or that failed with ContinueOnError, so every collected array has one entry per
+// iteration and entries at the same index belong to the same item.
+func (t *WorkflowStepTemplate) WithPreserveLoopAlignment() *WorkflowStepTemplate {
+	t.PreserveLoopAlignment = true
+	return t
+}
+
+// WithPagination configures a step to follow a paginated endpoint until all pages are fetched.
+// The items of every page are combined into a single array at spec.ItemsField, so mapping that
+// field to a variable makes all records available to a subsequent loop step.
+func (t *WorkflowStepTemplate) WithPagination(spec workflow.PaginationSpec) *WorkflowStepTemplate {
+	t.Paginate = &spec
+	return t
+}
+
+// WithPriority sets the priority of a step within its parallel group.
+// When the workflow limits concurrency, higher priority steps are started first.
+func (t *WorkflowStepTemplate) WithPriority(priority int) *WorkflowStepTemplate {
+	t.Priority = priority
+	return t
+}
+
+// WithRawResult keeps the step's response body undecoded. The raw JSON is available under the
+// workflow.RawResultField ("_raw") field, so it can be mapped to a variable and forwarded as-is,
+// which also preserves array and scalar responses.
+func (t *WorkflowStepTemplate) WithRawResult() *WorkflowStepTemplate {
+	t.RawResult = true
+	return t
+}
+
+// WithAcceptStatusCodes treats error responses with these status codes (e.g. 404) as a successful
+// empty result. The status code is available under the workflow.StatusResultField ("_status") field.
+func (t *WorkflowStepTemplate) WithAcceptStatusCodes(codes ...int) *WorkflowStepTemplate {
+	t.AcceptStatusCodes = append(t.AcceptStatusCodes, codes...)
+	return t
+}
+
+// WithMergeInto deep-merges the step's result into an object variable, creating it if absent:
+// nested objects are merged while scalars and arrays are overwritten. With result mappings, only
+// the mapped fields are merged, keyed by their variable names. It cannot be used on loop steps.
+func (t *WorkflowStepTemplate) WithMergeInto(variableName string) *WorkflowStepTemplate {
+	t.MergeInto = variableName
+	return t
+}
+
+// WithStreaming forwards the step's response to the writer passed with WithStreamWriter when
+// executing the workflow. The buffered response is available under the workflow.StreamResultField
+// ("_stream") field for result mappings.
+func (t *WorkflowStepTemplate) WithStreaming() *WorkflowStepTemplate {
+	t.Streaming = true
+	return t
+}
+
 // toWorkflowStep converts the template to a workflow.WorkflowStep
 func (t *WorkflowStepTemplate) toWorkflowStep() workflow.WorkflowStep {
 	return workflow.WorkflowStep{
@@ -108,11 +252,29 @@ func (t *WorkflowStepTemplate) toWorkflowStep() workflow.WorkflowStep {
 		DynamicParams: t.DynamicParams,
 		ResultMapping: t.ResultMapping,
 		Condition:     t.Condition,
+		ConditionExpr: t.ConditionExpr,
 		ParallelWith:  t.ParallelWith,
+		DependsOn:     t.DependsOn,
 		ErrorHandling: t.ErrorHandling,
 		MaxRetries:    t.MaxRetries,
+		RetryDelayMs:  t.RetryDelayMs,
+		TimeoutMs:     t.TimeoutMs,
 		LoopOver:      t.LoopOver,
 		LoopAs:        t.LoopAs,
+		Paginate:      t.Paginate,
+		Priority:      t.Priority,
+		RawResult:     t.RawResult,
+		Streaming:     t.Streaming,
+
+		PreserveLoopAlignment: t.PreserveLoopAlignment,
+		AcceptStatusCodes:     t.AcceptStatusCodes,
+		MergeInto:             t.MergeInto,
+		GroupCondition:        t.GroupCondition,
+		DelayBeforeMs:         t.DelayBeforeMs,
+		DelayAfterMs:          t.DelayAfterMs,
+		MaxLoopIterations:     t.MaxLoopIterations,
+		TruncateLoop:          t.TruncateLoop,
+		Retryable:             t.Retryable,
 	}
 }
 
@@ -166,6 +328,65 @@ func (wb *WorkflowBuilder) WithAggregator(mapping map[string]string) *WorkflowBu
 	return wb
 }
 
+// WithComputedDefaults computes the variables absent from the initial parameters when the
+// workflow starts. Each value is a template expression, e.g. {"end_date": "{{today()}}"}, and
+// entries can reference each other.
+func (wb *WorkflowBuilder) WithComputedDefaults(defaults map[string]string) *WorkflowBuilder {
+	if wb.workflow.ComputedDefaults == nil {
+		wb.workflow.ComputedDefaults = make(map[string]string)
+	}
+
+	for k, v := range defaults {
+		wb.workflow.ComputedDefaults[k] = v
+	}
+
+	return wb
+}
+
+// WithPostProcess computes variables once all steps have run, before the aggregator is applied,
+// so the aggregator can use them. Each value is an aggregator expression, e.g.
+// {"order_count": "orders.length"}, and entries can reference each other.
+func (wb *WorkflowBuilder) WithPostProcess(mapping map[string]string) *WorkflowBuilder {
+	if wb.workflow.PostProcess == nil {
+		wb.workflow.PostProcess = make(map[string]string)
+	}
+
+	for k, v := range mapping {
+		wb.workflow.PostProcess[k] = v
+	}
+
+	return wb
+}
+
+// WithMaxConcurrency limits how many parallel steps of the workflow run at once.
+// A value of 0 means no limit.
+func (wb *WorkflowBuilder) WithMaxConcurrency(maxConcurrency int) *WorkflowBuilder {
+	wb.workflow.MaxConcurrency = maxConcurrency
+	return wb
+}
+
+// WithFailFast makes a parallel group stop as soon as one of its steps fails with AbortOnError:
+// the requests of the other steps are canceled and the workflow returns the failure right away.
+func (wb *WorkflowBuilder) WithFailFast() *WorkflowBuilder {
+	wb.workflow.FailFast = true
+	return wb
+}
+
+// WithMaxTotalRetries caps the retries of all RetryOnError steps of an execution. Once a step
+// needs a retry after the budget is used up, any failing step aborts the workflow with an error
+// wrapping workflow.ErrRetryBudgetExhausted. A value of 0 means no limit.
+func (wb *WorkflowBuilder) WithMaxTotalRetries(maxTotalRetries int) *WorkflowBuilder {
+	wb.workflow.MaxTotalRetries = maxTotalRetries
+	return wb
+}
+
+// WithMaxLoopIterations caps the iterations of the loop steps of the workflow without their
+// own cap, failing them when their array is larger. A value of 0 means no limit.
+func (wb *WorkflowBuilder) WithMaxLoopIterations(maxIterations int) *WorkflowBuilder {
+	wb.workflow.MaxLoopIterations = maxIterations
+	return wb
+}
+
 // Build completes the workflow definition and returns to the service builder
 func (wb *WorkflowBuilder) Build() *ServiceBuilder {
 	if wb.serviceBuilder.workflows == nil {